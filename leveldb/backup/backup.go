@@ -0,0 +1,609 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+// Package backup provides incremental backups of a leveldb DB.
+//
+// A backup directory has the following layout:
+//
+//	meta/<id>                     file set and checksums of backup <id>
+//	private/<id>/MANIFEST-<num>   manifest of backup <id>
+//	shared/<file>_<crc>_<size>    tables and blob files
+//
+// Tables and blob files are immutable, so a file already present in
+// shared is not copied again and is shared by all backups referencing it.
+// A file recorded by the latest backup with the same number and size is
+// taken over from it without being read, only new files are copied; a
+// backup directory therefore holds the backups of a single DB. The shared
+// file name includes its checksum and size.
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rev3z/ledger_base/leveldb"
+	"github.com/rev3z/ledger_base/leveldb/errors"
+	"github.com/rev3z/ledger_base/leveldb/storage"
+)
+
+// Common errors.
+var (
+	ErrNotFound        = errors.New("leveldb/backup: backup not found")
+	ErrRestoreNotEmpty = errors.New("leveldb/backup: restore destination is not empty")
+	ErrClosed          = errors.New("leveldb/backup: closed")
+)
+
+const (
+	metaDir    = "meta"
+	privateDir = "private"
+	sharedDir  = "shared"
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Info holds information about a backup.
+type Info struct {
+	ID        int
+	Timestamp time.Time
+	Size      int64 // total size of the backup files
+	NumFiles  int
+}
+
+type backupFile struct {
+	path string // relative to the backup directory
+	fd   storage.FileDesc
+	size int64
+	crc  uint32
+}
+
+type backupMeta struct {
+	Info
+	manifest storage.FileDesc
+	files    []backupFile
+}
+
+// Engine creates and manages the backups within a backup directory.
+// An Engine is safe for concurrent use, but a backup directory must not be
+// used by multiple engines at a time.
+type Engine struct {
+	mu     sync.Mutex
+	dir    string
+	closed bool
+}
+
+// Open opens the backup directory at dir, creating it if it doesn't exist.
+func Open(dir string) (*Engine, error) {
+	for _, sub := range []string{metaDir, privateDir, sharedDir} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return nil, err
+		}
+	}
+	return &Engine{dir: dir}, nil
+}
+
+// Copies r to the file at path, returning the size and checksum of the
+// copied content.
+func copyFile(path string, r io.Reader) (size int64, crc uint32, err error) {
+	w, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return
+	}
+	h := crc32.New(crcTable)
+	size, err = io.Copy(io.MultiWriter(w, h), r)
+	if err == nil {
+		err = w.Sync()
+	}
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+		return
+	}
+	return size, h.Sum32(), nil
+}
+
+// Reads the file at path, returning its size and checksum.
+func checksumFile(path string) (size int64, crc uint32, err error) {
+	r, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer r.Close()
+	h := crc32.New(crcTable)
+	if size, err = io.Copy(h, r); err != nil {
+		return
+	}
+	return size, h.Sum32(), nil
+}
+
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+func (e *Engine) path(rel string) string {
+	return filepath.Join(e.dir, rel)
+}
+
+func (e *Engine) metaPath(id int) string {
+	return filepath.Join(e.dir, metaDir, strconv.Itoa(id))
+}
+
+func (e *Engine) privatePath(id int) string {
+	return filepath.Join(e.dir, privateDir, strconv.Itoa(id))
+}
+
+func (e *Engine) ids() ([]int, error) {
+	names, err := ioutil.ReadDir(filepath.Join(e.dir, metaDir))
+	if err != nil {
+		return nil, err
+	}
+	var ids []int
+	for _, fi := range names {
+		if id, err := strconv.Atoi(fi.Name()); err == nil && id > 0 {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+func (e *Engine) readMeta(id int) (*backupMeta, error) {
+	f, err := os.Open(e.metaPath(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	m := &backupMeta{Info: Info{ID: id}}
+	corrupted := func(line string) error {
+		return fmt.Errorf("leveldb/backup: backup %d: corrupted meta line %q", id, line)
+	}
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := s.Text()
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "timestamp":
+			if len(fields) != 2 {
+				return nil, corrupted(line)
+			}
+			ns, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return nil, corrupted(line)
+			}
+			m.Timestamp = time.Unix(0, ns)
+		case "manifest":
+			if len(fields) != 2 {
+				return nil, corrupted(line)
+			}
+			num, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return nil, corrupted(line)
+			}
+			m.manifest = storage.FileDesc{Type: storage.TypeManifest, Num: num}
+		case "file":
+			// file <path> <type> <num> <size> <crc>
+			if len(fields) != 6 {
+				return nil, corrupted(line)
+			}
+			var (
+				bf  = backupFile{path: fields[1]}
+				err error
+				t   uint64
+				crc uint64
+			)
+			if t, err = strconv.ParseUint(fields[2], 10, 32); err == nil {
+				bf.fd.Type = storage.FileType(t)
+				if bf.fd.Num, err = strconv.ParseInt(fields[3], 10, 64); err == nil {
+					if bf.size, err = strconv.ParseInt(fields[4], 10, 64); err == nil {
+						crc, err = strconv.ParseUint(fields[5], 16, 32)
+						bf.crc = uint32(crc)
+					}
+				}
+			}
+			if err != nil || !storage.FileDescOk(bf.fd) {
+				return nil, corrupted(line)
+			}
+			m.files = append(m.files, bf)
+			m.Size += bf.size
+		default:
+			return nil, corrupted(line)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if m.manifest.Zero() {
+		return nil, fmt.Errorf("leveldb/backup: backup %d: missing manifest", id)
+	}
+	m.NumFiles = len(m.files)
+	return m, nil
+}
+
+// Writes the meta of a backup; the meta is renamed into place so a backup
+// either exists completely or not at all.
+func (e *Engine) writeMeta(m *backupMeta) error {
+	path := e.metaPath(m.ID)
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	fmt.Fprintf(w, "timestamp %d\n", m.Timestamp.UnixNano())
+	fmt.Fprintf(w, "manifest %d\n", m.manifest.Num)
+	for _, bf := range m.files {
+		fmt.Fprintf(w, "file %s %d %d %d %08x\n", bf.path, bf.fd.Type, bf.fd.Num, bf.size, bf.crc)
+	}
+	err = w.Flush()
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// Returns the size of the file, without reading it.
+func fileSize(r io.Seeker) (int64, error) {
+	return r.Seek(0, io.SeekEnd)
+}
+
+// Create creates a new backup of db. Only the files not in the latest backup
+// are copied, see the package documentation. The memdbs of db are flushed
+// first, see leveldb.DB.LiveFiles.
+func (e *Engine) Create(db *leveldb.DB) (info Info, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return info, ErrClosed
+	}
+
+	ids, err := e.ids()
+	if err != nil {
+		return
+	}
+	id := 1
+	prev := make(map[storage.FileDesc]backupFile)
+	if len(ids) > 0 {
+		id = ids[len(ids)-1] + 1
+		var pm *backupMeta
+		if pm, err = e.readMeta(ids[len(ids)-1]); err != nil {
+			return
+		}
+		for _, bf := range pm.files {
+			if bf.fd.Type != storage.TypeManifest {
+				prev[bf.fd] = bf
+			}
+		}
+	}
+
+	lf, err := db.LiveFiles()
+	if err != nil {
+		return
+	}
+	defer lf.Release()
+
+	m := &backupMeta{
+		Info:     Info{ID: id, Timestamp: time.Now()},
+		manifest: lf.ManifestFd,
+	}
+	private := e.privatePath(id)
+	defer func() {
+		if err != nil {
+			os.RemoveAll(private)
+			if gerr := e.gc(); gerr != nil {
+				err = fmt.Errorf("%w (cleanup: %v)", err, gerr)
+			}
+		}
+	}()
+	if err = os.MkdirAll(private, 0755); err != nil {
+		return
+	}
+
+	for _, fd := range lf.Files {
+		var (
+			r    storage.Reader
+			size int64
+		)
+		if r, err = lf.Open(fd); err != nil {
+			return
+		}
+		if size, err = fileSize(r); err != nil {
+			r.Close()
+			return
+		}
+		if bf, ok := prev[fd]; ok && bf.size == size {
+			if fi, serr := os.Stat(e.path(bf.path)); serr == nil && fi.Size() == size {
+				// Unchanged since the latest backup.
+				r.Close()
+				m.files = append(m.files, bf)
+				continue
+			}
+		}
+		if _, err = r.Seek(0, io.SeekStart); err != nil {
+			r.Close()
+			return
+		}
+		tmp := e.path(filepath.Join(sharedDir, fmt.Sprintf("%s.%d.tmp", fd, id)))
+		bf := backupFile{fd: fd}
+		bf.size, bf.crc, err = copyFile(tmp, r)
+		r.Close()
+		if err != nil {
+			return
+		}
+		bf.path = filepath.ToSlash(filepath.Join(sharedDir, fmt.Sprintf("%s_%08x_%d", fd, bf.crc, bf.size)))
+		if _, serr := os.Stat(e.path(bf.path)); serr == nil {
+			// Shared with an earlier backup.
+			os.Remove(tmp)
+		} else if err = os.Rename(tmp, e.path(bf.path)); err != nil {
+			os.Remove(tmp)
+			return
+		}
+		m.files = append(m.files, bf)
+	}
+	if err = syncDir(e.path(sharedDir)); err != nil {
+		return
+	}
+
+	bf := backupFile{
+		path: filepath.ToSlash(filepath.Join(privateDir, strconv.Itoa(id), lf.ManifestFd.String())),
+		fd:   lf.ManifestFd,
+	}
+	if bf.size, bf.crc, err = copyFile(e.path(bf.path), bytes.NewReader(lf.Manifest)); err != nil {
+		return
+	}
+	m.files = append(m.files, bf)
+	if err = syncDir(private); err != nil {
+		return
+	}
+
+	for _, bf := range m.files {
+		m.Size += bf.size
+	}
+	m.NumFiles = len(m.files)
+	if err = e.writeMeta(m); err != nil {
+		return
+	}
+	return m.Info, nil
+}
+
+// List returns the backups, oldest first.
+func (e *Engine) List() ([]Info, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return nil, ErrClosed
+	}
+
+	ids, err := e.ids()
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]Info, 0, len(ids))
+	for _, id := range ids {
+		m, err := e.readMeta(id)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, m.Info)
+	}
+	return infos, nil
+}
+
+// Verify checks that all files of the given backup exist and match the
+// recorded sizes and checksums.
+func (e *Engine) Verify(id int) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return ErrClosed
+	}
+
+	m, err := e.readMeta(id)
+	if err != nil {
+		return err
+	}
+	for _, bf := range m.files {
+		size, crc, err := checksumFile(e.path(bf.path))
+		if err != nil {
+			return err
+		}
+		if size != bf.size || crc != bf.crc {
+			return errors.NewErrCorrupted(bf.fd, fmt.Errorf("leveldb/backup: backup %d: %s mismatch: size=%d crc=%08x, want size=%d crc=%08x",
+				id, bf.path, size, crc, bf.size, bf.crc))
+		}
+	}
+	return nil
+}
+
+// Removes the shared files no backup refers to anymore, along with the
+// leftovers of failed backups. Must be called with e.mu held.
+func (e *Engine) gc() error {
+	ids, err := e.ids()
+	if err != nil {
+		return err
+	}
+	live := make(map[string]bool)
+	for _, id := range ids {
+		m, err := e.readMeta(id)
+		if err != nil {
+			return err
+		}
+		for _, bf := range m.files {
+			live[bf.path] = true
+		}
+	}
+	isLive := make(map[int]bool)
+	for _, id := range ids {
+		isLive[id] = true
+	}
+
+	shared, err := ioutil.ReadDir(e.path(sharedDir))
+	if err != nil {
+		return err
+	}
+	for _, fi := range shared {
+		if path := filepath.ToSlash(filepath.Join(sharedDir, fi.Name())); !live[path] {
+			os.Remove(e.path(path))
+		}
+	}
+	private, err := ioutil.ReadDir(e.path(privateDir))
+	if err != nil {
+		return err
+	}
+	for _, fi := range private {
+		if id, err := strconv.Atoi(fi.Name()); err != nil || !isLive[id] {
+			os.RemoveAll(e.path(filepath.Join(privateDir, fi.Name())))
+		}
+	}
+	return nil
+}
+
+// Delete deletes the given backup. Shared files still referred to by other
+// backups are kept.
+func (e *Engine) Delete(id int) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return ErrClosed
+	}
+
+	if err := os.Remove(e.metaPath(id)); err != nil {
+		if os.IsNotExist(err) {
+			return ErrNotFound
+		}
+		return err
+	}
+	return e.gc()
+}
+
+// PurgeOld deletes all but the newest keep backups.
+func (e *Engine) PurgeOld(keep int) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return ErrClosed
+	}
+
+	ids, err := e.ids()
+	if err != nil {
+		return err
+	}
+	if keep < 0 {
+		keep = 0
+	}
+	for len(ids) > keep {
+		if err := os.Remove(e.metaPath(ids[0])); err != nil {
+			return err
+		}
+		ids = ids[1:]
+	}
+	return e.gc()
+}
+
+// Restore restores the given backup into dst, which must be empty. The
+// checksums of the files are verified while copying. Once Restore
+// succeeded dst can be opened with leveldb.Open.
+func (e *Engine) Restore(id int, dst storage.Storage) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return ErrClosed
+	}
+
+	m, err := e.readMeta(id)
+	if err != nil {
+		return err
+	}
+	fds, err := dst.List(storage.TypeAll)
+	if err != nil {
+		return err
+	}
+	if len(fds) > 0 {
+		return ErrRestoreNotEmpty
+	}
+
+	for _, bf := range m.files {
+		if err := e.restoreFile(dst, bf); err != nil {
+			return err
+		}
+	}
+	return dst.SetMeta(m.manifest)
+}
+
+func (e *Engine) restoreFile(dst storage.Storage, bf backupFile) (err error) {
+	r, err := os.Open(e.path(bf.path))
+	if err != nil {
+		return
+	}
+	defer r.Close()
+	w, err := dst.Create(bf.fd)
+	if err != nil {
+		return
+	}
+	defer w.Close()
+	h := crc32.New(crcTable)
+	size, err := io.Copy(io.MultiWriter(w, h), r)
+	if err != nil {
+		return
+	}
+	if size != bf.size || h.Sum32() != bf.crc {
+		return errors.NewErrCorrupted(bf.fd, fmt.Errorf("leveldb/backup: %s mismatch: size=%d crc=%08x, want size=%d crc=%08x",
+			bf.path, size, h.Sum32(), bf.size, bf.crc))
+	}
+	return w.Sync()
+}
+
+// RestoreToDir restores the given backup into the directory at dir, see
+// Restore. The directory is created if it doesn't exist.
+func (e *Engine) RestoreToDir(id int, dir string) error {
+	stor, err := storage.OpenFile(dir, false)
+	if err != nil {
+		return err
+	}
+	defer stor.Close()
+	return e.Restore(id, stor)
+}
+
+// Close closes the engine. Close waits for the running operation to finish.
+func (e *Engine) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.closed = true
+	return nil
+}
@@ -0,0 +1,307 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package backup
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/rev3z/ledger_base/leveldb"
+	"github.com/rev3z/ledger_base/leveldb/errors"
+	"github.com/rev3z/ledger_base/leveldb/opt"
+	"github.com/rev3z/ledger_base/leveldb/storage"
+	"github.com/rev3z/ledger_base/leveldb/util"
+)
+
+type kv map[string]string
+
+func dump(t *testing.T, db *leveldb.DB) (kv, kv) {
+	p, s := kv{}, kv{}
+	iter := db.NewIterator(nil, nil)
+	for iter.Next() {
+		p[string(iter.Key())] = string(iter.Value())
+	}
+	iter.Release()
+	iter = db.NewIterator_s(nil, nil)
+	for iter.Next() {
+		s[string(iter.Key())] = string(iter.Value())
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		t.Fatal(err)
+	}
+	return p, s
+}
+
+func check(t *testing.T, name string, stor storage.Storage, wantP, wantS kv) {
+	db, err := leveldb.Open(stor, nil)
+	if err != nil {
+		t.Fatalf("%s: open: %v", name, err)
+	}
+	defer db.Close()
+	p, s := dump(t, db)
+	if fmt.Sprint(p) != fmt.Sprint(wantP) {
+		t.Errorf("%s: primary tree mismatch: got %d entries, want %d", name, len(p), len(wantP))
+	}
+	if fmt.Sprint(s) != fmt.Sprint(wantS) {
+		t.Errorf("%s: secondary tree mismatch: got %d entries, want %d", name, len(s), len(wantS))
+	}
+}
+
+func sharedFiles(t *testing.T, dir string) []string {
+	fis, err := ioutil.ReadDir(filepath.Join(dir, sharedDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, fi := range fis {
+		names = append(names, fi.Name())
+	}
+	return names
+}
+
+func TestEngine(t *testing.T) {
+	dbDir, dir := t.TempDir(), t.TempDir()
+	db, err := leveldb.OpenFile(dbDir, &opt.Options{
+		WriteBuffer:   4 * opt.KiB,
+		BlobThreshold: 100,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	write := func(from, to int) {
+		for i := from; i < to; i++ {
+			k := []byte(fmt.Sprintf("key%05d", i))
+			if err := db.Put(k, []byte(fmt.Sprintf("value%d", i)), nil); err != nil {
+				t.Fatal(err)
+			}
+			v := make([]byte, 50+i%100)
+			for j := range v {
+				v[j] = byte('a' + i%26)
+			}
+			if err := db.Put_s(k, v, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	e, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+
+	write(0, 300)
+	info1, err := e.Create(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p1, s1 := dump(t, db)
+	n1 := len(sharedFiles(t, dir))
+	if n1 == 0 {
+		t.Fatal("no shared files after first backup")
+	}
+
+	write(300, 400)
+	for i := 0; i < 50; i++ {
+		k := []byte(fmt.Sprintf("key%05d", i))
+		db.Delete(k, nil)
+		db.Delete_s(k, nil)
+	}
+	info2, err := e.Create(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p2, s2 := dump(t, db)
+	if info2.ID != info1.ID+1 {
+		t.Fatalf("backup ids: %d, %d", info1.ID, info2.ID)
+	}
+	if n2 := len(sharedFiles(t, dir)); n2 >= n1+info2.NumFiles-1 {
+		t.Errorf("no shared files reused: %d files after first backup, %d after second backup of %d files", n1, n2, info2.NumFiles)
+	}
+
+	infos, err := e.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 2 || infos[0].ID != info1.ID || infos[1].ID != info2.ID {
+		t.Fatalf("list: %v", infos)
+	}
+	for _, info := range infos {
+		if err := e.Verify(info.ID); err != nil {
+			t.Fatalf("verify %d: %v", info.ID, err)
+		}
+	}
+
+	// Restore into memory and into a directory.
+	mem := storage.NewMemStorage()
+	if err := e.Restore(info1.ID, mem); err != nil {
+		t.Fatal(err)
+	}
+	check(t, "restore 1", mem, p1, s1)
+	if err := e.Restore(info1.ID, mem); err != ErrRestoreNotEmpty {
+		t.Fatalf("restore into non-empty storage: %v", err)
+	}
+	restoreDir := filepath.Join(t.TempDir(), "restore")
+	if err := e.RestoreToDir(info2.ID, restoreDir); err != nil {
+		t.Fatal(err)
+	}
+	stor, err := storage.OpenFile(restoreDir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(t, "restore 2", stor, p2, s2)
+	stor.Close()
+
+	// Purge keeps the newest backup restorable.
+	if err := e.PurgeOld(1); err != nil {
+		t.Fatal(err)
+	}
+	if infos, err = e.List(); err != nil || len(infos) != 1 || infos[0].ID != info2.ID {
+		t.Fatalf("list after purge: %v %v", infos, err)
+	}
+	if err := e.Verify(info1.ID); err != ErrNotFound {
+		t.Fatalf("verify purged backup: %v", err)
+	}
+	if n := len(sharedFiles(t, dir)); n >= info2.NumFiles {
+		t.Errorf("shared files: got %d, want %d", n, info2.NumFiles-1)
+	}
+	if err := e.Verify(info2.ID); err != nil {
+		t.Fatal(err)
+	}
+	mem = storage.NewMemStorage()
+	if err := e.Restore(info2.ID, mem); err != nil {
+		t.Fatal(err)
+	}
+	check(t, "restore 2 after purge", mem, p2, s2)
+
+	// Corruption is detected.
+	name := sharedFiles(t, dir)[0]
+	path := filepath.Join(dir, sharedDir, name)
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b[len(b)/2] ^= 0xff
+	if err := ioutil.WriteFile(path, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Verify(info2.ID); !errors.IsCorrupted(err) {
+		t.Fatalf("verify corrupted backup: %v", err)
+	}
+	if err := e.Restore(info2.ID, storage.NewMemStorage()); !errors.IsCorrupted(err) {
+		t.Fatalf("restore corrupted backup: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, privateDir, fmt.Sprint(info1.ID))); !os.IsNotExist(err) {
+		t.Fatalf("private dir of purged backup: %v", err)
+	}
+}
+
+// Counts the bytes read from the files of a storage.
+type countingStorage struct {
+	storage.Storage
+	read int64
+}
+
+type countingReader struct {
+	storage.Reader
+	s *countingStorage
+}
+
+func (r countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	atomic.AddInt64(&r.s.read, int64(n))
+	return n, err
+}
+
+func (r countingReader) ReadAt(p []byte, off int64) (int, error) {
+	n, err := r.Reader.ReadAt(p, off)
+	atomic.AddInt64(&r.s.read, int64(n))
+	return n, err
+}
+
+func (s *countingStorage) Open(fd storage.FileDesc) (storage.Reader, error) {
+	r, err := s.Storage.Open(fd)
+	if err != nil {
+		return nil, err
+	}
+	return countingReader{r, s}, nil
+}
+
+func TestEngine_Incremental(t *testing.T) {
+	fstor, err := storage.OpenFile(t.TempDir(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stor := &countingStorage{Storage: fstor}
+	db, err := leveldb.Open(stor, &opt.Options{WriteBuffer: 4 * opt.KiB})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	write := func(from, to int) {
+		for i := from; i < to; i++ {
+			k := []byte(fmt.Sprintf("key%05d", i))
+			if err := db.Put(k, []byte(fmt.Sprintf("value%d", i)), nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	e, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+
+	write(0, 300)
+	info1, err := e.Create(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing changed, no file is read.
+	atomic.StoreInt64(&stor.read, 0)
+	info2, err := e.Create(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt64(&stor.read); n != 0 {
+		t.Errorf("unchanged backup read %d bytes", n)
+	}
+	if info2.NumFiles != info1.NumFiles || info2.Size != info1.Size {
+		t.Errorf("unchanged backup: %+v, want as %+v", info2, info1)
+	}
+
+	// Only the new files are read.
+	write(300, 310)
+	if err := db.CompactRange(util.Range{}); err != nil {
+		t.Fatal(err)
+	}
+	write(1000, 1100)
+	atomic.StoreInt64(&stor.read, 0)
+	info3, err := e.Create(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt64(&stor.read); n == 0 || n >= info3.Size {
+		t.Errorf("backup read %d bytes of %d", n, info3.Size)
+	}
+	if err := e.Verify(info3.ID); err != nil {
+		t.Fatal(err)
+	}
+	p, s := dump(t, db)
+	mem := storage.NewMemStorage()
+	if err := e.Restore(info3.ID, mem); err != nil {
+		t.Fatal(err)
+	}
+	check(t, "restore 3", mem, p, s)
+}
@@ -42,8 +42,27 @@ type BatchReplay interface {
 	Delete(key []byte)
 }
 
+// BatchRangeReplay is implemented by a BatchReplay that also wants range
+// deletions replayed, see Batch.DeleteRange. Replay skips range deletions
+// for replayers not implementing it.
+type BatchRangeReplay interface {
+	BatchReplay
+	DeleteRange(start, limit []byte)
+}
+
+// Records of a Batch may be tagged with the tree they target, see
+// Batch.Put_s. The tag is kept in the upper bits of the record type byte;
+// untagged records target the tree the batch is written to.
+const (
+	batchTreeDefault   byte = 0
+	batchTreePrimary   byte = 1 << 6
+	batchTreeSecondary byte = 2 << 6
+	batchTreeMask           = batchTreePrimary | batchTreeSecondary
+)
+
 type batchIndex struct {
 	keyType            keyType //插入还是删除
+	tree               byte    // target tree tag
 	keyPos, keyLen     int //K长度和内容
 	valuePos, valueLen int //V长度和内容
 }
@@ -91,21 +110,25 @@ func (b *Batch) grow(n int) {
 }
 
 func (b *Batch) appendRec(kt keyType, key, value []byte) {
+	b.appendRecTree(batchTreeDefault, kt, key, value)
+}
+
+func (b *Batch) appendRecTree(tree byte, kt keyType, key, value []byte) {
 	n := 1 + binary.MaxVarintLen32 + len(key)
-	if kt == keyTypeVal {
+	if kt != keyTypeDel {
 		n += binary.MaxVarintLen32 + len(value)
 	}
 	b.grow(n)
-	index := batchIndex{keyType: kt}
+	index := batchIndex{keyType: kt, tree: tree}
 	o := len(b.data)
 	data := b.data[:o+n]
-	data[o] = byte(kt)
+	data[o] = tree | byte(kt)
 	o++
 	o += binary.PutUvarint(data[o:], uint64(len(key)))
 	index.keyPos = o
 	index.keyLen = len(key)
 	o += copy(data[o:], key)
-	if kt == keyTypeVal {
+	if kt != keyTypeDel {
 		o += binary.PutUvarint(data[o:], uint64(len(value)))
 		index.valuePos = o
 		index.valueLen = len(value)
@@ -130,6 +153,69 @@ func (b *Batch) Delete(key []byte) {
 	b.appendRec(keyTypeDel, key, nil)
 }
 
+// DeleteRange appends 'range delete operation' of the keys within
+// [start, limit) to the batch. The range is empty, and the record has no
+// effect, if limit is not greater than start.
+// It is safe to modify the contents of the arguments after DeleteRange
+// returns but not before.
+func (b *Batch) DeleteRange(start, limit []byte) {
+	b.appendRec(keyTypeRangeDel, start, limit)
+}
+
+// Put_s appends 'put operation' of the given key/value pair targeting the
+// secondary tree. A batch holding such records is a cross-tree batch, its
+// other records target the primary tree; it is committed atomically to both
+// trees by DB.Write or DB.Write_s.
+// It is safe to modify the contents of the argument after Put_s returns but
+// not before.
+func (b *Batch) Put_s(key, value []byte) {
+	b.appendRecTree(batchTreeSecondary, keyTypeVal, key, value)
+}
+
+// Delete_s appends 'delete operation' of the given key targeting the
+// secondary tree. See Put_s.
+// It is safe to modify the contents of the argument after Delete_s returns
+// but not before.
+func (b *Batch) Delete_s(key []byte) {
+	b.appendRecTree(batchTreeSecondary, keyTypeDel, key, nil)
+}
+
+// DeleteRange_s appends 'range delete operation' of the keys within
+// [start, limit) targeting the secondary tree. See DeleteRange and Put_s.
+// It is safe to modify the contents of the arguments after DeleteRange_s
+// returns but not before.
+func (b *Batch) DeleteRange_s(start, limit []byte) {
+	b.appendRecTree(batchTreeSecondary, keyTypeRangeDel, start, limit)
+}
+
+// Whether the batch holds records targeting the secondary tree.
+func (b *Batch) crossTree() bool {
+	for _, index := range b.index {
+		if index.tree == batchTreeSecondary {
+			return true
+		}
+	}
+	return false
+}
+
+// Splits a cross-tree batch into the records of the primary tree and those
+// of the secondary tree. If tag is true the records are tagged with their
+// tree, which marks them as parts of a cross-tree batch in the journals.
+func (b *Batch) splitTrees(tag bool) (pb, sb *Batch) {
+	pb, sb = new(Batch), new(Batch)
+	for _, index := range b.index {
+		tb, tree := pb, batchTreePrimary
+		if index.tree == batchTreeSecondary {
+			tb, tree = sb, batchTreeSecondary
+		}
+		if !tag {
+			tree = batchTreeDefault
+		}
+		tb.appendRecTree(tree, index.keyType, index.k(b.data), index.v(b.data))
+	}
+	return
+}
+
 // Dump dumps batch contents. The returned slice can be loaded into the
 // batch using Load method.
 // The returned slice is not its own copy, so the contents should not be
@@ -146,14 +232,20 @@ func (b *Batch) Load(data []byte) error {
 	return b.decode(data, -1)
 }
 
-// Replay replays batch contents.
+// Replay replays batch contents. Target trees of records are not reported.
+// Range deletions are only replayed if r implements BatchRangeReplay.
 func (b *Batch) Replay(r BatchReplay) error {
+	rr, _ := r.(BatchRangeReplay)
 	for _, index := range b.index {
 		switch index.keyType {
 		case keyTypeVal:
 			r.Put(index.k(b.data), index.v(b.data))
 		case keyTypeDel:
 			r.Delete(index.k(b.data))
+		case keyTypeRangeDel:
+			if rr != nil {
+				rr.DeleteRange(index.k(b.data), index.v(b.data))
+			}
 		}
 	}
 	return nil
@@ -181,6 +273,21 @@ func (b *Batch) replayInternal(fn func(i int, kt keyType, k, v []byte) error) er
 	return nil
 }
 
+// Like replayInternal, but records added with Put_s, Delete_s or
+// DeleteRange_s are passed to put_s.
+func (b *Batch) replayTrees(put, put_s func(kt keyType, k, v []byte) error) error {
+	for _, index := range b.index {
+		fn := put
+		if index.tree == batchTreeSecondary {
+			fn = put_s
+		}
+		if err := fn(index.keyType, index.k(b.data), index.v(b.data)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (b *Batch) append(p *Batch) {
 	ob := len(b.data)
 	oi := len(b.index)
@@ -223,7 +330,7 @@ func (b *Batch) putMem(seq uint64, mdb *memdb.DB) error {
 	for i, index := range b.index {
 		ik = makeInternalKey(ik, index.k(b.data), seq+uint64(i), index.keyType)
 		//mdb *memdb.DB调用memdb中定义的public Put方法
-		if err := mdb.Put(ik, index.v(b.data)); err != nil {
+		if err := memPut(mdb, index.keyType, ik, index.v(b.data)); err != nil {
 			return err
 		}
 	}
@@ -237,7 +344,7 @@ func (b *Batch) putMem_s(seq uint64, mdb *memdb.DBs) error {
 		ik = makeInternalKey(ik, index.k(b.data), seq+uint64(i), index.keyType)
 		//mdb *memdb.DB调用memdb中定义的public Put方法
 		//fmt.Println("每Add一个后缀，就Put进mem中")
-		if err := mdb.Put_s(ik, index.v(b.data)); err != nil {
+		if err := memPut_s(mdb, index.keyType, ik, index.v(b.data)); err != nil {
 			return err
 		}
 	}
@@ -265,6 +372,21 @@ func (b *Batch) revertMem(seq uint64, mdb *memdb.DB) error {
 	return nil
 }
 
+// Puts a record into mdb, range deletions are indexed as such.
+func memPut(mdb *memdb.DB, kt keyType, ik, value []byte) error {
+	if kt == keyTypeRangeDel {
+		return mdb.PutRangeDel(ik, value)
+	}
+	return mdb.Put(ik, value)
+}
+
+func memPut_s(mdb *memdb.DBs, kt keyType, ik, value []byte) error {
+	if kt == keyTypeRangeDel {
+		return mdb.PutRangeDel_s(ik, value)
+	}
+	return mdb.Put_s(ik, value)
+}
+
 func newBatch() interface{} {
 	return &Batch{}
 }
@@ -278,9 +400,10 @@ func decodeBatch(data []byte, fn func(i int, index batchIndex) error) error {
 	var index batchIndex
 	for i, o := 0, 0; o < len(data); i++ {
 		// Key type.
-		index.keyType = keyType(data[o])
-		if index.keyType > keyTypeVal {
-			return newErrBatchCorrupted(fmt.Sprintf("bad record: invalid type %#x", uint(index.keyType)))
+		index.tree = data[o] & batchTreeMask
+		index.keyType = keyType(data[o] &^ batchTreeMask)
+		if index.keyType > keyTypeRangeDel || index.tree == batchTreeMask {
+			return newErrBatchCorrupted(fmt.Sprintf("bad record: invalid type %#x", uint(data[o])))
 		}
 		o++
 
@@ -294,8 +417,8 @@ func decodeBatch(data []byte, fn func(i int, index batchIndex) error) error {
 		index.keyLen = int(x)
 		o += index.keyLen
 
-		// Value.
-		if index.keyType == keyTypeVal {
+		// Value, or limit of a range deletion.
+		if index.keyType != keyTypeDel {
 			x, n = binary.Uvarint(data[o:])
 			o += n
 			if n <= 0 || o+int(x) > len(data) {
@@ -316,53 +439,57 @@ func decodeBatch(data []byte, fn func(i int, index batchIndex) error) error {
 	return nil
 }
 
+// Decodes a journal record of the primary tree into mdb. The record is
+// validated as a whole before any of it is applied; its sequence number
+// must not be below expectSeq, the end of the previous record of the
+// journal.
 func decodeBatchToMem(data []byte, expectSeq uint64, mdb *memdb.DB) (seq uint64, batchLen int, err error) {
-	seq, batchLen, err = decodeBatchHeader(data)
+	seq, batchLen, err = decodeJournalBatch(data, expectSeq, batchTreePrimary)
 	if err != nil {
-		fmt.Println("err != nil")
 		return 0, 0, err
 	}
-	/*if seq < expectSeq {
-		fmt.Println("seq < expectSeq")
-		return 0, 0, newErrBatchCorrupted("invalid sequence number")
-	}*/
 	data = data[batchHeaderLen:]
 	var ik []byte
-	var decodedLen int
 	err = decodeBatch(data, func(i int, index batchIndex) error {
-		if i >= batchLen {
-			return newErrBatchCorrupted("invalid records length")
-		}
 		ik = makeInternalKey(ik, index.k(data), seq+uint64(i), index.keyType)
-		if err := mdb.Put(ik, index.v(data)); err != nil {
-			return err
-		}
-		decodedLen++
-		return nil
+		return memPut(mdb, index.keyType, ik, index.v(data))
 	})
-	if err == nil && decodedLen != batchLen {
-		err = newErrBatchCorrupted(fmt.Sprintf("invalid records length: %d vs %d", batchLen, decodedLen))
+	return
+}
+
+// Decodes a journal record of the secondary tree into mdbs, see
+// decodeBatchToMem.
+func decodeBatchToMem_s(data []byte, expectSeq uint64, mdbs *memdb.DBs) (seq uint64, batchLen int, err error) {
+	seq, batchLen, err = decodeJournalBatch(data, expectSeq, batchTreeSecondary)
+	if err != nil {
+		return 0, 0, err
 	}
+	data = data[batchHeaderLen:]
+	var ik []byte
+	err = decodeBatch(data, func(i int, index batchIndex) error {
+		ik = makeInternalKey(ik, index.k(data), seq+uint64(i), index.keyType)
+		return memPut_s(mdbs, index.keyType, ik, index.v(data))
+	})
 	return
 }
-func decodeBatchToMem_s(data []byte, expectSeq uint64, mdb *memdb.DBs) (seq uint64, batchLen int, err error) {
+
+// Validates a journal record of the given tree: its records must be either
+// untagged or, for a part of a cross-tree batch, tagged with the tree.
+func decodeJournalBatch(data []byte, expectSeq uint64, tree byte) (seq uint64, batchLen int, err error) {
 	seq, batchLen, err = decodeBatchHeader(data)
 	if err != nil {
 		return 0, 0, err
 	}
-	/*if seq < expectSeq {
+	if seq < expectSeq {
 		return 0, 0, newErrBatchCorrupted("invalid sequence number")
-	}*/
-	data = data[batchHeaderLen:]
-	var ik []byte
+	}
 	var decodedLen int
-	err = decodeBatch(data, func(i int, index batchIndex) error {
+	err = decodeBatch(data[batchHeaderLen:], func(i int, index batchIndex) error {
 		if i >= batchLen {
 			return newErrBatchCorrupted("invalid records length")
 		}
-		ik = makeInternalKey(ik, index.k(data), seq+uint64(i), index.keyType)
-		if err := mdb.Put_s(ik, index.v(data)); err != nil {
-			return err
+		if index.tree != batchTreeDefault && index.tree != tree {
+			return newErrBatchCorrupted("record of the other tree")
 		}
 		decodedLen++
 		return nil
@@ -372,6 +499,82 @@ func decodeBatchToMem_s(data []byte, expectSeq uint64, mdb *memdb.DBs) (seq uint
 	}
 	return
 }
+
+// Whether the journal record is a part of a cross-tree batch, i.e. its
+// records are tagged with their tree.
+func isCrossTreePart(data []byte) bool {
+	return len(data) > batchHeaderLen && data[batchHeaderLen]&batchTreeMask != 0
+}
+
+// Whether the secondary journal record is the commit record of a cross-tree
+// batch, i.e. a header without records.
+func isCrossTreeCommit(data []byte) bool {
+	return len(data) == batchHeaderLen
+}
+
+// Parts of cross-tree batches. A cross-tree batch is journaled as three
+// records: the secondary part first, then the primary part with the
+// sequence numbers just below it, then an empty commit record with the
+// sequence number of the secondary part, right after it in the secondary
+// journal. The secondary part is only committed if it is followed by its
+// commit record, or if the primary part is found in the primary journals,
+// which are scanned before any journal is recovered.
+type crossTreeParts struct {
+	ends    map[uint64]struct{} // seqs following the primary parts
+	pending []byte              // secondary part waiting for its commit record
+	dropped int                 // uncommitted secondary parts
+}
+
+func newCrossTreeParts() *crossTreeParts {
+	return &crossTreeParts{ends: make(map[uint64]struct{})}
+}
+
+// Records a primary journal record.
+func (p *crossTreeParts) add(data []byte, seq uint64, batchLen int) {
+	if isCrossTreePart(data) {
+		p.ends[seq+uint64(batchLen)] = struct{}{}
+	}
+}
+
+// Returns the committed secondary journal records, in order, once the
+// secondary journal record data is read. A secondary part is held back
+// until the next record, the returned records are only valid until then.
+func (p *crossTreeParts) next(data []byte) [][]byte {
+	if isCrossTreeCommit(data) {
+		if p.pending != nil {
+			pseq, _, _ := decodeBatchHeader(p.pending)
+			if seq, _, _ := decodeBatchHeader(data); seq == pseq {
+				recs := [][]byte{p.pending}
+				p.pending = nil
+				return recs
+			}
+		}
+		return p.end()
+	}
+	recs := p.end()
+	if isCrossTreePart(data) {
+		p.pending = append(p.pending[:0:0], data...)
+		return recs
+	}
+	return append(recs, data)
+}
+
+// Returns the held back secondary part at the end of a secondary journal
+// if its primary part was journaled.
+func (p *crossTreeParts) end() [][]byte {
+	data := p.pending
+	if data == nil {
+		return nil
+	}
+	p.pending = nil
+	seq, _, _ := decodeBatchHeader(data)
+	if _, ok := p.ends[seq]; ok {
+		return [][]byte{data}
+	}
+	p.dropped++
+	return nil
+}
+
 func encodeBatchHeader(dst []byte, seq uint64, batchLen int) []byte {
 	dst = ensureBuffer(dst, batchHeaderLen)
 	binary.LittleEndian.PutUint64(dst, seq)
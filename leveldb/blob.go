@@ -0,0 +1,267 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/rev3z/ledger_base/leveldb/cache"
+	"github.com/rev3z/ledger_base/leveldb/errors"
+	"github.com/rev3z/ledger_base/leveldb/ratelimit"
+	"github.com/rev3z/ledger_base/leveldb/storage"
+	"github.com/rev3z/ledger_base/leveldb/util"
+)
+
+// Values of the secondary tree of at least Options.BlobThreshold bytes are
+// written by flushes and compactions to append-only blob files; the tables
+// hold a keyTypeBlobIndex entry whose value is the encoded blobIndex of the
+// record. A blob record is laid out as:
+//
+//	checksum (4 bytes, over the rest) | key length (uvarint) | key | value
+//
+// Blob files are tracked by the manifest like tables. Compactions account the
+// records whose reference they drop as garbage; a blob file is dropped once
+// all its records are garbage, and the values still live in a file whose live
+// ratio is under Options.BlobGCRatio are rewritten by compactions.
+
+// Compactions rotate the table they write once its blob file reaches this
+// many times the table size.
+const blobFileSizeFactor = 32
+
+// Namespace of the blob readers in the open files cache of the secondary
+// tree, tables use namespace 0.
+const blobCacheNS = 1
+
+// ErrBlobCorrupted records a blob record or blob reference corruption.
+type ErrBlobCorrupted struct {
+	Pos    int64
+	Reason string
+}
+
+func (e *ErrBlobCorrupted) Error() string {
+	return fmt.Sprintf("leveldb: blob corrupted at %d: %s", e.Pos, e.Reason)
+}
+
+func newErrBlobCorrupted(fd storage.FileDesc, pos int64, reason string) error {
+	return errors.NewErrCorrupted(fd, &ErrBlobCorrupted{pos, reason})
+}
+
+// blobIndex locates a blob record.
+type blobIndex struct {
+	num    int64
+	offset int64
+	size   int64
+}
+
+func (bi blobIndex) encode(dst []byte) []byte {
+	dst = ensureBuffer(dst, 3*binary.MaxVarintLen64)
+	n := binary.PutUvarint(dst, uint64(bi.num))
+	n += binary.PutUvarint(dst[n:], uint64(bi.offset))
+	n += binary.PutUvarint(dst[n:], uint64(bi.size))
+	return dst[:n]
+}
+
+func decodeBlobIndex(b []byte) (bi blobIndex, err error) {
+	var x [3]uint64
+	for i := range x {
+		var n int
+		if x[i], n = binary.Uvarint(b); n <= 0 {
+			return bi, newErrBlobCorrupted(storage.FileDesc{}, 0, "invalid blob index")
+		}
+		b = b[n:]
+	}
+	if len(b) != 0 || int64(x[0]) < 0 || int64(x[1]) < 0 || int64(x[2]) < 0 {
+		return bi, newErrBlobCorrupted(storage.FileDesc{}, 0, "invalid blob index")
+	}
+	return blobIndex{int64(x[0]), int64(x[1]), int64(x[2])}, nil
+}
+
+// bFile holds basic information about a blob file. It is immutable, a
+// version referencing an updated one gets a copy.
+type bFile struct {
+	fd         storage.FileDesc
+	size       int64  // bytes of records
+	garbage    int64  // bytes of records no longer referenced
+	umin, umax []byte // user key range of the records
+}
+
+// Creates new bFile.
+func newBlobFile(fd storage.FileDesc, size int64, umin, umax []byte) *bFile {
+	return &bFile{fd: fd, size: size, umin: umin, umax: umax}
+}
+
+func blobFileFromRecord(r abRecord) *bFile {
+	return newBlobFile(storage.FileDesc{Type: storage.TypeBlob, Num: r.num}, r.size, r.umin, r.umax)
+}
+
+// Returns the ratio of bytes still referenced.
+func (f *bFile) live() float64 {
+	if f.size <= 0 {
+		return 0
+	}
+	return float64(f.size-f.garbage) / float64(f.size)
+}
+
+// blobWriter writes the records of a blob file.
+type blobWriter struct {
+	t *tOps
+
+	fd  storage.FileDesc
+	w   storage.Writer
+	bw  *bufio.Writer
+	buf []byte
+
+	offset     int64
+	umin, umax []byte
+}
+
+// Creates an empty blob file and returns its writer. Writes are throttled by
+// the rate limiter at the given priority.
+func (t *tOps) createBlob(pri ratelimit.Priority) (*blobWriter, error) {
+	fd := storage.FileDesc{Type: storage.TypeBlob, Num: t.s.allocFileNum()}
+	fw, err := t.s.stor.Create_s(fd)
+	if err != nil {
+		return nil, err
+	}
+	return &blobWriter{
+		t:  t,
+		fd: fd,
+		w:  fw,
+		bw: bufio.NewWriter(t.limitWriter(fw, pri)),
+	}, nil
+}
+
+// Appends a record, keys must be appended in order.
+func (w *blobWriter) append(ukey, value []byte) (blobIndex, error) {
+	n := 4 + binary.MaxVarintLen64 + len(ukey) + len(value)
+	w.buf = ensureBuffer(w.buf, n)
+	n = 4 + binary.PutUvarint(w.buf[4:], uint64(len(ukey)))
+	n += copy(w.buf[n:], ukey)
+	n += copy(w.buf[n:], value)
+	binary.LittleEndian.PutUint32(w.buf, util.NewCRC(w.buf[4:n]).Value())
+	if _, err := w.bw.Write(w.buf[:n]); err != nil {
+		return blobIndex{}, err
+	}
+	if w.umin == nil {
+		w.umin = append([]byte{}, ukey...)
+	}
+	w.umax = append(w.umax[:0], ukey...)
+	bi := blobIndex{w.fd.Num, w.offset, int64(n)}
+	w.offset += int64(n)
+	return bi, nil
+}
+
+// Closes the storage.Writer.
+func (w *blobWriter) close() {
+	if w.w != nil {
+		w.w.Close()
+		w.w = nil
+	}
+}
+
+// Finalizes the blob file and returns it.
+func (w *blobWriter) finish() (f *bFile, err error) {
+	defer w.close()
+	if err = w.bw.Flush(); err != nil {
+		return
+	}
+	if !w.t.noSync {
+		if err = w.w.Sync(); err != nil {
+			return
+		}
+	}
+	return newBlobFile(w.fd, w.offset, w.umin, w.umax), nil
+}
+
+// Drops the blob file.
+func (w *blobWriter) drop() {
+	w.close()
+	w.t.s.stor.Remove(w.fd)
+	w.t.s.reuseFileNum(w.fd.Num)
+}
+
+// Decodes a blob record, returning its key and value.
+func decodeBlobRecord(fd storage.FileDesc, pos int64, b []byte) (ukey, value []byte, err error) {
+	if len(b) < 5 {
+		return nil, nil, newErrBlobCorrupted(fd, pos, "short record")
+	}
+	if util.NewCRC(b[4:]).Value() != binary.LittleEndian.Uint32(b) {
+		return nil, nil, newErrBlobCorrupted(fd, pos, "checksum mismatch")
+	}
+	klen, n := binary.Uvarint(b[4:])
+	if n <= 0 || uint64(len(b)-4-n) < klen {
+		return nil, nil, newErrBlobCorrupted(fd, pos, "invalid key length")
+	}
+	b = b[4+n:]
+	return b[:klen], b[klen:], nil
+}
+
+// blobReader is a blob file held by the open files cache.
+type blobReader struct {
+	storage.Reader
+}
+
+func (r *blobReader) Release() {
+	r.Close()
+}
+
+// Opens blob file. It returns a cache handle, which should be released after
+// use.
+func (t *tOps) openBlob(num int64) (ch *cache.Handle, err error) {
+	ch = t.cache_s.Get(blobCacheNS, uint64(num), func() (size int, value cache.Value) {
+		var r storage.Reader
+		r, err = t.s.stor.Open(storage.FileDesc{Type: storage.TypeBlob, Num: num})
+		if err != nil {
+			return 0, nil
+		}
+		return 1, &blobReader{r}
+	})
+	if ch == nil && err == nil {
+		err = ErrClosed
+	}
+	return
+}
+
+// Reads the value referenced by the given encoded blobIndex. The returned
+// slice is its own copy.
+func (t *tOps) getBlob(index []byte) ([]byte, error) {
+	bi, err := decodeBlobIndex(index)
+	if err != nil {
+		return nil, err
+	}
+	ch, err := t.openBlob(bi.num)
+	if err != nil {
+		return nil, err
+	}
+	defer ch.Release()
+	fd := storage.FileDesc{Type: storage.TypeBlob, Num: bi.num}
+	b := make([]byte, bi.size)
+	if _, err := ch.Value().(*blobReader).ReadAt(b, bi.offset); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, newErrBlobCorrupted(fd, bi.offset, "short read")
+		}
+		return nil, err
+	}
+	_, value, err := decodeBlobRecord(fd, bi.offset, b)
+	return value, err
+}
+
+// Removes blob file from persistent storage. It waits until no one use the
+// file.
+func (t *tOps) removeBlob(fd storage.FileDesc) {
+	t.cache_s.Delete(blobCacheNS, uint64(fd.Num), func() {
+		if err := t.s.stor.Remove(fd); err != nil {
+			t.s.logf("blob@remove removing @%d %q", fd.Num, err)
+		} else {
+			t.s.logf("blob@remove removed @%d", fd.Num)
+		}
+		t.s.reuseFileNum(fd.Num)
+	})
+}
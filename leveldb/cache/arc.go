@@ -7,24 +7,22 @@
 package cache
 
 import (
-	"fmt"
-	_ "os"
 	"sync"
-	"time"
+	"sync/atomic"
 	"unsafe"
 )
 
 // lru双向循环链表
-type lruNode struct {
+type arcNode struct {
 	n   *Node
 	h   *Handle
 	ban bool
-	ty  int8 // 新增ty，0代表r，1代表f，2代表r1，3代表f1
+	ty  int8
 
-	next, prev *lruNode //队尾和队头指针？
+	next, prev *arcNode //队尾和队头指针？
 }
 
-func (r *lruNode) Length() int {
+func (r *arcNode) Length() int {
 	var length = 0
 	N := r.prev.n // 尾部节点
 	for r.n != N {
@@ -34,41 +32,40 @@ func (r *lruNode) Length() int {
 	return length
 }
 
-// todo: 不是真正的contain判断，只是判断了类型
-func (r *lruNode) ContainNode0(N *lruNode) bool {
+func (r *arcNode) ContainNode0(N *arcNode) bool {
 	if N.ty == 0 {
 		return true
 	}
 	return false
 }
-func (r *lruNode) ContainNode1(N *lruNode) bool {
+func (r *arcNode) ContainNode1(N *arcNode) bool {
 	if N.ty == 1 {
 		return true
 	}
 	return false
 }
-func (r *lruNode) ContainNode2(N *lruNode) bool {
+func (r *arcNode) ContainNode2(N *arcNode) bool {
 	if N.ty == 2 {
 		return true
 	}
 	return false
 }
-func (r *lruNode) ContainNode3(N *lruNode) bool {
+func (r *arcNode) ContainNode3(N *arcNode) bool {
 	if N.ty == 3 {
 		return true
 	}
 	return false
 }
 
-// 循环链标插入操作，把lruNode n 插入到 at之后
-func (n *lruNode) insert(at *lruNode) {
+// 循环链标插入操作，把arcNode n 插入到 at之后
+func (n *arcNode) insert(at *arcNode) {
 	x := at.next
 	at.next = n
 	n.prev = at
 	n.next = x
 	x.prev = n
 }
-func (n *lruNode) Equalinsert(at *lruNode) {
+func (n *arcNode) Equalinsert(at *arcNode) {
 	n.prev = at
 	n.next = at.next
 	at.next = n
@@ -76,7 +73,7 @@ func (n *lruNode) Equalinsert(at *lruNode) {
 }
 
 // 循环链表删除操作，删除n
-func (n *lruNode) remove() *Node {
+func (n *arcNode) remove() *Node {
 	if n.prev != nil {
 		n.prev.next = n.next
 		n.next.prev = n.prev
@@ -87,27 +84,31 @@ func (n *lruNode) remove() *Node {
 	}
 	return n.n
 }
-func (n *lruNode) Len() int {
+func (n *arcNode) Len() int {
 	return n.n.Size()
 }
 
-// 锁、总容量、使用的容量、lruNode
-type lru struct {
+// 锁、总容量、使用的容量、arcNode
+type arc struct {
+	// Stats. Need 64-bit alignment.
+	hits, misses, evictions, ghostHits int64
+
 	mu             sync.Mutex
 	capacity       int // 表示缓存的总容量
+	slack          int // 容量的余量，见NewSGC
 	trriger        int
 	rused, fused   int // 分别表示两个链表capacity的使用情况
 	r1used, f1used int
-	recent         lruNode // 双向循环链表的head
-	frequent       lruNode
-	r1             lruNode
-	f1             lruNode
+	recent         arcNode // 双向循环链表的head
+	frequent       arcNode
+	r1             arcNode
+	f1             arcNode
 }
 
 // 判断长度
 
 // 重置lru链表，使用为0
-func (r *lru) reset() {
+func (r *arc) reset() {
 	// 初始化四个链表，阈值，使用值
 	r.recent.next = &r.recent
 	r.recent.prev = &r.recent
@@ -129,18 +130,17 @@ func (r *lru) reset() {
 }
 
 // 返回容量
-func (r *lru) Capacity() int {
+func (r *arc) Capacity() int {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	return r.capacity
+	return r.capacity - r.slack
 }
 
 // 设置容量
-func (r *lru) SetCapacity(capacity int) {
-	var evicted []*lruNode
+func (r *arc) SetCapacity(capacity int) {
+	var evicted []*arcNode
 	r.mu.Lock()
-	// todo: 为什么要加1？
-	r.capacity = capacity + 1
+	r.capacity = capacity + r.slack
 	// 如果容量超出使用，先回收recent和frequent，然后回收r1和f1
 	for r.rused+r.fused >= r.capacity {
 		r.replace(false)
@@ -177,20 +177,21 @@ func (r *lru) SetCapacity(capacity int) {
 	//}
 	r.mu.Unlock()
 
+	atomic.AddInt64(&r.evictions, int64(len(evicted)))
 	for _, rn := range evicted {
-		rn.h.Release() // 将evicted的所有lruNode释放Handle
+		rn.h.Release() // 将evicted的所有arcNode释放Handle
 	}
 }
 
 // used和fused容量超标时，判断缩减哪一列，并且移动到ghost中
-func (r *lru) replace(b2ContainsKey bool) {
+func (r *arc) replace(b2ContainsKey bool) {
 	t1Len := r.rused
 	// 如果t1容量超标，则从t1移走一个元素
 	//if t1Len > 0 && (t1Len > r.trriger || (t1Len == r.trriger && b2ContainsKey)) {
 	if t1Len > 0 && (t1Len >= r.trriger) {
 		n := r.recent.prev.remove()
 		r.rused -= n.Size()
-		rn := (*lruNode)(n.CacheData)
+		rn := (*arcNode)(n.CacheData)
 		//rn.n.value = nil
 		// value置空，并插入r1中
 		rn.ty = 2
@@ -202,7 +203,7 @@ func (r *lru) replace(b2ContainsKey bool) {
 		// 因此，在t1容量未超标的情况下，需要从t2移走一个元素
 		n := r.frequent.prev.remove()
 		r.fused -= n.Size()
-		rn := (*lruNode)(n.CacheData)
+		rn := (*arcNode)(n.CacheData)
 		//rn.n.value = nil
 		rn.ty = 3
 		rn.insert(&r.f1)
@@ -215,40 +216,22 @@ func (r *lru) replace(b2ContainsKey bool) {
 // 2、如果是从缓存读出来的数据，则通过rn.insert将数据从队中提出来放到队尾，保证队尾放的数据都是最新读取的缓存。
 // 目的：将缓存放入buckets
 // 重点在此！
-var (
-	t float64
-)
-
-func (c *Cache) Time() {
-	fmt.Println(t)
-}
-
-var (
-	MissNumber  int64
-	HitNumber   int64
-	MissNumber2 int64
-	HitNumber2  int64
-)
-
 // 0代表r，1代表f，2代表r1，3代表f1
-func (r *lru) Promote(n *Node) {
-	var evicted []*lruNode
+func (r *arc) Promote(n *Node) {
+	var evicted []*arcNode
 	r.mu.Lock()
-	// CacheData为nil，说明不在lru中，即缓存未命中，生成新的Node，则Node、Handle就会新建一个lruNode插入到recent之后
+	// CacheData为nil，说明不在lru中，即缓存未命中，生成新的Node，则Node、Handle就会新建一个arcNode插入到recent之后
 	if n.CacheData == nil {
-		MissNumber++
-		//MissNumber2++
+		atomic.AddInt64(&r.misses, 1)
 		if n.Size() <= r.capacity { // 必须得<最大容量，否则根本写不进去 // 赋值Node和Handle，然后插入到lru链表中，h指向node【return &Handle{unsafe.Pointer(n)}】
-			rn := &lruNode{n: n, ty: 0, h: n.GetHandle()}
+			rn := &arcNode{n: n, ty: 0, h: n.GetHandle()}
 			rn.insert(&r.recent)             // 插入到头节点之后
-			n.CacheData = unsafe.Pointer(rn) // 任意类型且可寻址的指针值，CacheData为lruNode的指针
+			n.CacheData = unsafe.Pointer(rn) // 任意类型且可寻址的指针值，CacheData为arcNode的指针
 			// 容量变化
 			r.rused += n.Size()
-			// 插入新节点后，判断是否超出容量，超出则移除并调整
 			for r.rused+r.fused >= r.capacity {
 				r.replace(false)
 			}
-			// 调整r和f后，r1和f1也要调整
 			for r.r1used > r.capacity-r.trriger {
 				m := r.r1.prev
 				if m == nil {
@@ -269,8 +252,9 @@ func (r *lru) Promote(n *Node) {
 				r.f1used -= m.n.Size()
 				evicted = append(evicted, m)
 			}
+			atomic.AddInt64(&r.evictions, int64(len(evicted)))
 			for _, rn := range evicted {
-				rn.h.Release() // 将evicted的所有lruNode释放Handle
+				rn.h.Release() // 将evicted的所有arcNode释放Handle
 			}
 			// 超出容量就继续remove
 			//for r.rused > r.capacity {
@@ -287,12 +271,10 @@ func (r *lru) Promote(n *Node) {
 		// 否则就是从缓存中读的，已经被插入到lru中，应先删除掉，然后再插入
 		// 只要读到，就往frequent里写。
 	} else {
-		HitNumber++
-		//HitNumber2++
-		rn := (*lruNode)(n.CacheData) // 取出rn来，为lruNode的指针类型
+		atomic.AddInt64(&r.hits, 1)
+		rn := (*arcNode)(n.CacheData) // 取出rn来，为arcNode的指针类型
 		if !rn.ban {
 			// remove，插入到frequent，为ARC中add方法的核心内容
-			t1 := time.Now()
 			if r.recent.ContainNode0(rn) {
 				rn.remove()
 				r.rused -= rn.n.Size()
@@ -305,8 +287,9 @@ func (r *lru) Promote(n *Node) {
 				rn.insert(&r.frequent) // 重新插入,插入到frequent
 				r.fused += rn.n.Size()
 			} else if r.r1.ContainNode2(rn) { // r1中含有
-				HitNumber--
-				MissNumber++
+				atomic.AddInt64(&r.hits, -1)
+				atomic.AddInt64(&r.misses, 1)
+				atomic.AddInt64(&r.ghostHits, 1)
 				delta := 1
 				//rlen:=r.r1used+1
 				//flen:=r.f1used+1
@@ -318,7 +301,6 @@ func (r *lru) Promote(n *Node) {
 				} else {
 					r.trriger += delta
 				}
-				// Todo: 移到后面：从r1中移除，插入到r中 进行调整
 				if r.rused+r.fused >= r.capacity {
 					r.replace(false)
 				}
@@ -328,8 +310,9 @@ func (r *lru) Promote(n *Node) {
 				rn.insert(&r.frequent)
 				r.fused += rn.n.Size()
 			} else if r.f1.ContainNode3(rn) { // r2中含有
-				HitNumber--
-				MissNumber++
+				atomic.AddInt64(&r.hits, -1)
+				atomic.AddInt64(&r.misses, 1)
+				atomic.AddInt64(&r.ghostHits, 1)
 				delta := 1
 				//rlen:=r.r1used+1
 				//flen:=r.f1used+1
@@ -341,7 +324,6 @@ func (r *lru) Promote(n *Node) {
 				} else {
 					r.trriger -= delta
 				}
-				// Todo: 移到后面：从f1中移除，插入到f中 进行调整
 				if r.rused+r.fused >= r.capacity {
 					r.replace(true)
 				}
@@ -354,8 +336,6 @@ func (r *lru) Promote(n *Node) {
 			//else {
 			//	panic("Should be in there,but!")
 			//}
-			t2 := time.Now()
-			t += t2.Sub(t1).Seconds()
 			for r.rused+r.fused >= r.capacity {
 				r.replace(false)
 			}
@@ -379,25 +359,24 @@ func (r *lru) Promote(n *Node) {
 				r.f1used -= m.n.Size()
 				evicted = append(evicted, m)
 			}
-			// todo: 释放操作能不能放在unlock之后？
+			atomic.AddInt64(&r.evictions, int64(len(evicted)))
 			for _, rn := range evicted {
-				rn.h.Release() // 将evicted的所有lruNode释放Handle
+				rn.h.Release() // 将evicted的所有arcNode释放Handle
 			}
 			//r.recent.insert(rn)
 		}
 	}
 	r.mu.Unlock()
-	// 将evicted中的lruNode释放掉，以此释放内存
+	// 将evicted中的arcNode释放掉，以此释放内存
 }
 
 // 为ban赋值true或者false，何意？
-func (r *lru) Ban(n *Node) {
+func (r *arc) Ban(n *Node) {
 	r.mu.Lock()
 	if n.CacheData == nil {
-		// todo: LRUNode没有加入到lru链表中
-		n.CacheData = unsafe.Pointer(&lruNode{n: n, ban: true})
+		n.CacheData = unsafe.Pointer(&arcNode{n: n, ban: true})
 	} else {
-		rn := (*lruNode)(n.CacheData)
+		rn := (*arcNode)(n.CacheData)
 		if !rn.ban {
 			// remove
 			rn.remove()
@@ -425,9 +404,9 @@ func (r *lru) Ban(n *Node) {
 }
 
 // 置空CacheData
-func (r *lru) Evict(n *Node) {
+func (r *arc) Evict(n *Node) {
 	r.mu.Lock()
-	rn := (*lruNode)(n.CacheData)
+	rn := (*arcNode)(n.CacheData)
 	if rn == nil || rn.ban {
 		r.mu.Unlock()
 		return
@@ -438,8 +417,8 @@ func (r *lru) Evict(n *Node) {
 	rn.h.Release()
 }
 
-func (r *lru) EvictNS(ns uint64) {
-	var evicted []*lruNode
+func (r *arc) EvictNS(ns uint64) {
+	var evicted []*arcNode
 
 	r.mu.Lock()
 	for e := r.recent.prev; e != &r.recent; {
@@ -489,53 +468,65 @@ func (r *lru) EvictNS(ns uint64) {
 	}
 }
 
-func (r *lru) EvictAll() {
+// todo
+func (r *arc) EvictAll() {
 	r.mu.Lock()
-	// todo: 出错
-	back := r.recent.prev
-	for rn := back; rn != &r.recent; rn = rn.prev {
+	back1 := r.recent.prev
+	for rn := back1; rn != &r.recent; rn = rn.prev {
 		rn.n.CacheData = nil
 	}
-	back = r.frequent.prev
-	for rn := back; rn != &r.frequent; rn = rn.prev {
+	back2 := r.frequent.prev
+	for rn := back2; rn != &r.frequent; rn = rn.prev {
 		rn.n.CacheData = nil
 	}
-	back = r.r1.prev
-	for rn := back; rn != &r.r1; rn = rn.prev {
+	back3 := r.r1.prev
+	for rn := back3; rn != &r.r1; rn = rn.prev {
 		rn.n.CacheData = nil
 	}
-	back = r.f1.prev
-	for rn := back; rn != &r.f1; rn = rn.prev {
+	back4 := r.f1.prev
+	for rn := back4; rn != &r.f1; rn = rn.prev {
 		rn.n.CacheData = nil
 	}
 	r.reset()
 	r.mu.Unlock()
-	back = r.recent.prev
-	for rn := back; rn != &r.recent; rn = rn.prev {
+	for rn := back1; rn != &r.recent; rn = rn.prev {
 		rn.h.Release()
 	}
-	back = r.frequent.prev
-	for rn := back; rn != &r.frequent; rn = rn.prev {
+	for rn := back2; rn != &r.frequent; rn = rn.prev {
 		rn.h.Release()
 	}
-	back = r.r1.prev
-	for rn := back; rn != &r.r1; rn = rn.prev {
+	for rn := back3; rn != &r.r1; rn = rn.prev {
 		rn.h.Release()
 	}
-	back = r.f1.prev
-	for rn := back; rn != &r.f1; rn = rn.prev {
+	for rn := back4; rn != &r.f1; rn = rn.prev {
 		rn.h.Release()
 	}
 }
 
-func (r *lru) Close() error {
+func (r *arc) Close() error {
 	return nil
 }
 
-// NewLRU create a new LRU-cache.
-// 创建一个新的LRU-cache
-func NewLRU(capacity int) Cacher {
-	r := &lru{capacity: capacity}
+func (r *arc) Stats(s *Stats) {
+	s.Hits = atomic.LoadInt64(&r.hits)
+	s.Misses = atomic.LoadInt64(&r.misses)
+	s.Evictions = atomic.LoadInt64(&r.evictions)
+	s.GhostHits = atomic.LoadInt64(&r.ghostHits)
+	r.mu.Lock()
+	s.RecentSize = r.rused
+	s.FrequentSize = r.fused
+	s.RecentGhostSize = r.r1used
+	s.FrequentGhostSize = r.f1used
+	s.Target = r.trriger
+	r.mu.Unlock()
+}
+
+// NewARC creates a new ARC-cache. Entries hit once live in the recent list
+// and move to the frequent list when hit again; entries evicted from either
+// list are remembered in the ghost lists r1 and f1, whose hits adapt the
+// target size of the recent list.
+func NewARC(capacity int) Cacher {
+	r := &arc{capacity: capacity}
 	r.reset()
 	return r
 }
@@ -44,6 +44,27 @@ type Cacher interface {
 
 	// Close closes the 'cache tree'
 	Close() error
+
+	// Stats fills the policy fields of s, see Stats.
+	Stats(s *Stats)
+}
+
+// Stats is statistics of a cache. Sizes are in the unit of the charges
+// given to Cache.Get, that is bytes for the block cache.
+type Stats struct {
+	Nodes int // Number of 'cache node' in the map, including ghost entries
+	Size  int // Sum of 'cache node' size in the map
+
+	Hits      int64 // Promotions of a resident 'cache node'
+	Misses    int64 // Promotions of a new or ghost 'cache node'
+	Evictions int64 // 'cache node' dropped by the policy to make room
+	GhostHits int64 // Promotions of a ghost 'cache node', also counted in Misses
+
+	RecentSize        int // Size of the recent list
+	FrequentSize      int // Size of the frequent list
+	RecentGhostSize   int // Size of the r1 ghost list
+	FrequentGhostSize int // Size of the f1 ghost list
+	Target            int // Adaptive target size of the recent list
 }
 
 // Value is a 'cacheable object'. It may implements util.Releaser, if
@@ -367,6 +388,15 @@ func (r *Cache) Size() int {
 	return int(atomic.LoadInt32(&r.size))
 }
 
+// Stats returns statistics of the cache.
+func (r *Cache) Stats() Stats {
+	s := Stats{Nodes: r.Nodes(), Size: r.Size()}
+	if r.cacher != nil {
+		r.cacher.Stats(&s)
+	}
+	return s
+}
+
 // Capacity returns cache capacity.
 func (r *Cache) Capacity() int {
 	if r.cacher == nil {
@@ -164,36 +164,36 @@ func TestCacheMap(t *testing.T) {
 }
 
 func TestMyCache(t *testing.T){
-	c := NewCache(NewLRU(10))
+	c := NewCache(NewARC(10))
 	if c.Capacity() != 10 {
 		t.Errorf("invalid capacity: want=%d got=%d", 10, c.Capacity())
 	}
 	// charge表示大小，占用capacity
 	set(c, 0, 1, 1, 1, nil).Release() // 被t到r1
-	fmt.Println(c.Nodes(),c.size,c.cacher.(*lru).rused)
+	fmt.Println(c.Nodes(),c.size,c.cacher.(*arc).rused)
 	set(c, 0, 2, 2, 2, nil).Release() // 被t到r1
-	fmt.Println(c.Nodes(),c.size,c.cacher.(*lru).rused)
+	fmt.Println(c.Nodes(),c.size,c.cacher.(*arc).rused)
 	set(c, 0, 5,  3, 2,nil).Release() // 被t到r1
-	fmt.Println(c.Nodes(),c.size,c.cacher.(*lru).rused)
+	fmt.Println(c.Nodes(),c.size,c.cacher.(*arc).rused)
 	set(c, 1, 1, 3, 3, nil).Release()
-	fmt.Println(c.Nodes(),c.Size(),c.cacher.(*lru).rused)
+	fmt.Println(c.Nodes(),c.Size(),c.cacher.(*arc).rused)
 	set(c, 2, 1, 4, 1, nil).Release()
-	fmt.Println(c.Nodes(),c.Size(),c.cacher.(*lru).rused) // 5-9
+	fmt.Println(c.Nodes(),c.Size(),c.cacher.(*arc).rused) // 5-9
 	set(c, 2, 2, 5, 1, nil).Release()
-	fmt.Println(c.Nodes(),c.Size(),c.cacher.(*lru).rused) // 6-10,但是rused为9，r1used为1
+	fmt.Println(c.Nodes(),c.Size(),c.cacher.(*arc).rused) // 6-10,但是rused为9，r1used为1
 	set(c, 2, 3, 6, 1, nil).Release()
-	fmt.Println(c.Nodes(),c.Size(),c.cacher.(*lru).rused) // 11,此时rused为8，r1uesd为3
+	fmt.Println(c.Nodes(),c.Size(),c.cacher.(*arc).rused) // 11,此时rused为8，r1uesd为3
 	set(c, 2, 4, 7, 1, nil).Release()
-	fmt.Println(c.Nodes(),c.Size(),c.cacher.(*lru).rused) // 12，此时rused为9，r1used为3
+	fmt.Println(c.Nodes(),c.Size(),c.cacher.(*arc).rused) // 12，此时rused为9，r1used为3
 	set(c, 2, 5, 8, 1, nil).Release() // 此时，rused为8，r1used为5，trigger为0
 	p:=c.Get(0,5,nil) //5，5，2，0，6
 	fmt.Println(p.Value())
-	fmt.Println(c.cacher.(*lru).r1used,c.cacher.(*lru).f1used,c.cacher.(*lru).rused,c.cacher.(*lru).fused)
-	fmt.Println(c.cacher.(*lru).trriger,c.cacher.(*lru).capacity)
+	fmt.Println(c.cacher.(*arc).r1used,c.cacher.(*arc).f1used,c.cacher.(*arc).rused,c.cacher.(*arc).fused)
+	fmt.Println(c.cacher.(*arc).trriger,c.cacher.(*arc).capacity)
 }
 
 func TestARC_HitMiss(t *testing.T){
-	c := NewCache(NewLRU(1000))
+	c := NewCache(NewARC(1000))
 	if c.Capacity() != 1000 {
 		t.Errorf("invalid capacity: want=%d got=%d", 10, c.Capacity())
 	}
@@ -227,14 +227,93 @@ func TestARC_HitMiss(t *testing.T){
 	}
 //	time.Sleep(10e9)
 	fmt.Println("-----------------------------------------")
-	fmt.Printf("r1used:%d, f1used:%d, rused:%d, fused:%d\n",c.cacher.(*lru).r1used,c.cacher.(*lru).f1used,c.cacher.(*lru).rused,c.cacher.(*lru).fused)
-	fmt.Printf("trriger:%d, capacity:%d\n",c.cacher.(*lru).trriger,c.cacher.(*lru).capacity)
-	c.Time()
-	fmt.Println(HitNumber,MissNumber)
-	fmt.Println((float64(HitNumber))/float64(HitNumber+MissNumber))
+	fmt.Printf("r1used:%d, f1used:%d, rused:%d, fused:%d\n",c.cacher.(*arc).r1used,c.cacher.(*arc).f1used,c.cacher.(*arc).rused,c.cacher.(*arc).fused)
+	fmt.Printf("trriger:%d, capacity:%d\n",c.cacher.(*arc).trriger,c.cacher.(*arc).capacity)
+	st := c.Stats()
+	fmt.Println(st.Hits, st.Misses, st.GhostHits)
+	fmt.Println((float64(st.Hits))/float64(st.Hits+st.Misses))
 	fmt.Println("-----------------------------------------")
 }
 
+func TestCachePolicies_Capacity(t *testing.T) {
+	for _, x := range []struct {
+		name  string
+		new   func(int) Cacher
+		nodes int // resident nodes of size 1 at capacity 10
+	}{
+		{"LRU", NewLRU, 10},
+		{"ARC", NewARC, 9},
+		{"SGC", NewSGC, 10},
+	} {
+		c := NewCache(x.new(10))
+		if c.Capacity() != 10 {
+			t.Errorf("%s: invalid capacity: want=%d got=%d", x.name, 10, c.Capacity())
+		}
+		for i := 0; i < 20; i++ {
+			set(c, 0, uint64(i), i, 1, nil).Release()
+		}
+		// Ghost entries of the ARC lists are not resident.
+		size := c.Size()
+		if r, ok := c.cacher.(*arc); ok {
+			size = r.rused + r.fused
+		}
+		if size != x.nodes {
+			t.Errorf("%s: invalid size counter: want=%d got=%d", x.name, x.nodes, size)
+		}
+		c.SetCapacity(5)
+		if c.Capacity() != 5 {
+			t.Errorf("%s: invalid capacity: want=%d got=%d", x.name, 5, c.Capacity())
+		}
+		c.Close()
+	}
+}
+
+func TestCache_Stats(t *testing.T) {
+	for _, x := range []struct {
+		name string
+		new  func(int) Cacher
+	}{
+		{"LRU", NewLRU},
+		{"ARC", NewARC},
+		{"SGC", NewSGC},
+	} {
+		c1, c2 := NewCache(x.new(3)), NewCache(x.new(3))
+		for i := 0; i < 5; i++ {
+			set(c1, 0, uint64(i), i, 1, nil).Release()
+		}
+		set(c1, 0, 4, 4, 1, nil).Release()
+		set(c2, 0, 0, 0, 1, nil).Release()
+
+		s1, s2 := c1.Stats(), c2.Stats()
+		if s1.Hits != 1 || s1.Misses != 5 {
+			t.Errorf("%s: want 1 hit and 5 misses, got %d and %d", x.name, s1.Hits, s1.Misses)
+		}
+		if s2.Hits != 0 || s2.Misses != 1 {
+			t.Errorf("%s: want 0 hits and 1 miss, got %d and %d", x.name, s2.Hits, s2.Misses)
+		}
+		if resident := s1.RecentSize + s1.FrequentSize; resident > 3 {
+			t.Errorf("%s: resident size %d exceeds capacity", x.name, resident)
+		}
+		if s1.Nodes != c1.Nodes() || s1.Size != c1.Size() {
+			t.Errorf("%s: got %d nodes of size %d, want %d of size %d", x.name, s1.Nodes, s1.Size, c1.Nodes(), c1.Size())
+		}
+		c1.Close()
+		c2.Close()
+	}
+
+	// A ghost hit is a miss that grows the target of the recent list.
+	c := NewCache(NewARC(3))
+	for i := 0; i < 5; i++ {
+		set(c, 0, uint64(i), i, 1, nil).Release()
+	}
+	set(c, 0, 0, 0, 1, nil).Release()
+	s := c.Stats()
+	if s.GhostHits != 1 || s.Hits != 0 || s.Misses != 6 || s.Target != 1 {
+		t.Errorf("ARC: got %d ghost hits, %d hits, %d misses and target %d", s.GhostHits, s.Hits, s.Misses, s.Target)
+	}
+	c.Close()
+}
+
 func TestLRUCache_GetLatency(t *testing.T) {
 	runtime.GOMAXPROCS(runtime.NumCPU())
 
@@ -0,0 +1,16 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package cache
+
+// NewSGC creates a new SGC-cache. It is the ARC-cache with one unit of
+// slack on top of the capacity, so that the cache may be filled up to the
+// whole capacity instead of replacing as soon as it is reached.
+func NewSGC(capacity int) Cacher {
+	r := &arc{capacity: capacity + 1, slack: 1}
+	r.reset()
+	return r
+}
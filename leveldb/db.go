@@ -13,11 +13,13 @@ import (
 	"io"
 	"os"
 	"runtime"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/rev3z/ledger_base/leveldb/cache"
 	"github.com/rev3z/ledger_base/leveldb/errors"
 	"github.com/rev3z/ledger_base/leveldb/iterator"
 	"github.com/rev3z/ledger_base/leveldb/journal"
@@ -112,6 +114,13 @@ type DB struct {
 	compStats, comStatss cStats
 	memdbMaxLevel        int // For testing.
 
+	// Live ratio of blob files at their last garbage collection, owned by
+	// tCompaction_s.
+	blobGCTried map[int64]float64
+
+	// Secondary, see OpenSecondary.
+	secondary *secondary
+
 	// Close.关闭
 	closeW   sync.WaitGroup
 	closeC   chan struct{}
@@ -169,23 +178,18 @@ func openDB(s *session) (*DB, error) {
 			return nil, err
 		}
 	} else { //必走这一条，从两个log中恢复，这里会有问题
-		// Recover journals.
-		if db.WhichLogFirst(){
-			//fmt.Println("HHHH")
-			if err := db.recoverJournal(); err != nil {
-				return nil, err
-			}
-			if err := db.recoverJournal_s(); err != nil {
-				return nil, err
-			}
-		}else{
-			//fmt.Println("GGGG")
-			if err := db.recoverJournal_s(); err != nil {
-				return nil, err
-			}
-			if err := db.recoverJournal(); err != nil {
-				return nil, err
-			}
+		// Recover journals. The secondary journals go first, the primary
+		// journals tell which parts of cross-tree batches in them committed
+		// and are only removed once those are recovered.
+		parts, err := db.scanCrossTreeParts()
+		if err != nil {
+			return nil, err
+		}
+		if err := db.recoverJournal_s(parts); err != nil {
+			return nil, err
+		}
+		if err := db.recoverJournal(); err != nil {
+			return nil, err
 		}
 		/*if err := db.RJ(); err != nil {
 			return nil, err
@@ -370,7 +374,7 @@ func recoverTable(s *session, o *opt.Options) error {
 		rec   = &sessionRecord{}
 		bpool = util.NewBufferPool(o.GetBlockSize() + 5)
 	)
-	buildTable := func(iter iterator.Iterator) (tmpFd storage.FileDesc, size int64, err error) {
+	buildTable := func(iter, rdIter iterator.Iterator) (tmpFd storage.FileDesc, size int64, err error) {
 		tmpFd = s.newTemp()
 		writer, err := s.stor.Create(tmpFd)
 		if err != nil {
@@ -399,6 +403,15 @@ func recoverTable(s *session, o *opt.Options) error {
 		if err != nil && !errors.IsCorrupted(err) {
 			return
 		}
+		for rdIter.Next() {
+			key := rdIter.Key()
+			if validInternalKey(key) {
+				err = tw.AppendRangeDel(key, rdIter.Value())
+				if err != nil {
+					return
+				}
+			}
+		}
 		err = tw.Close()
 		if err != nil {
 			return
@@ -473,6 +486,28 @@ func recoverTable(s *session, o *opt.Options) error {
 		}
 		iter.Release()
 
+		// Range deletions widen the table range up to their limits.
+		rdIter := tr.NewRangeDelIterator()
+		for rdIter.Next() {
+			key := rdIter.Key()
+			_, seq, _, kerr := parseInternalKey(key)
+			if kerr != nil {
+				tcorruptedKey++
+				continue
+			}
+			tgoodKey++
+			if seq > tSeq {
+				tSeq = seq
+			}
+			if imin == nil || s.icmp.Compare(key, imin) < 0 {
+				imin = append(imin[:0], key...)
+			}
+			if limit := makeInternalKey(nil, rdIter.Value(), keyMaxSeq, keyTypeSeek); imax == nil || s.icmp.Compare(limit, imax) > 0 {
+				imax = limit
+			}
+		}
+		rdIter.Release()
+
 		goodKey += tgoodKey
 		corruptedKey += tcorruptedKey
 		corruptedBlock += tcorruptedBlock
@@ -488,8 +523,10 @@ func recoverTable(s *session, o *opt.Options) error {
 				// Rebuild the table.
 				s.logf("table@recovery rebuilding @%d", fd.Num)
 				iter := tr.NewIterator(nil, nil)
-				tmpFd, newSize, err := buildTable(iter)
+				rdIter := tr.NewRangeDelIterator()
+				tmpFd, newSize, err := buildTable(iter, rdIter)
 				iter.Release()
+				rdIter.Release()
 				if err != nil {
 					return err
 				}
@@ -561,26 +598,6 @@ func (db *DB) Getnonlevel0Comp() (i uint32,j uint32) {
 	return i,j
 }
 
-//如果logs大于log那么返回true
-//以此来决定log的先后recover顺序
-func (db *DB) WhichLogFirst() bool{
-	rawFds,_ := db.s.stor.List(storage.TypeJournal)
-	sortFds(rawFds)
-	var num1,num2 int64
-	for _,fd:=range rawFds{
-		if fd.Num>num1{
-			num1=fd.Num
-		}
-	}
-	rawFds2,_ := db.s.stor.List(storage.TypeJournals)
-	sortFds(rawFds2)
-	for _,fd:=range rawFds2{
-		if fd.Num>num2{
-			num2=fd.Num
-		}
-	}
-	return num2>num1
-}
 func (db *DB) recoverJournal() error {
 	// Get all journals and sort it by file number.
 	rawFds, err := db.s.stor.List(storage.TypeJournal) //返回值为[]FileDesc{Type FileType，num}, error
@@ -619,6 +636,9 @@ func (db *DB) recoverJournal() error {
 			buf      = &util.Buffer{}
 			batchSeq uint64
 			batchLen int
+			// End of the previous record, sequence numbers of a journal
+			// only grow.
+			expectSeq uint64
 		)
 
 		for _, fd := range fds {
@@ -647,6 +667,7 @@ func (db *DB) recoverJournal() error {
 				}
 				rec.setJournalNum(fd.Num)
 				rec.setSeqNum(db.seq)
+				rec.setMemSeqNum(db.seq)
 				if err := db.s.commit(rec, false); err != nil {
 					fr.Close()
 					return err
@@ -680,7 +701,7 @@ func (db *DB) recoverJournal() error {
 					fr.Close()
 					return errors.SetFd(err, fd)
 				}
-				batchSeq, batchLen, err = decodeBatchToMem(buf.Bytes(), db.seq, mdb)
+				batchSeq, batchLen, err = decodeBatchToMem(buf.Bytes(), expectSeq, mdb)
 				if err != nil {
 					//fmt.Println("22222")
 					if !strict && errors.IsCorrupted(err) {
@@ -693,8 +714,12 @@ func (db *DB) recoverJournal() error {
 					return errors.SetFd(err, fd)
 				}
 				//fmt.Println("mdb的容量：",mdb.Size())
+
 				// Save sequence number.
-				db.seq = batchSeq + uint64(batchLen)
+				expectSeq = batchSeq + uint64(batchLen)
+				if expectSeq > db.seq {
+					db.seq = expectSeq
+				}
 
 				// Flush it if large enough.
 				if mdb.Size() >= writeBuffer {
@@ -727,6 +752,7 @@ func (db *DB) recoverJournal() error {
 		rec.setJournalNum(db.journalFd.Num)
 	}
 	rec.setSeqNum(db.seq)
+	rec.setMemSeqNum(db.seq)
 	if err := db.s.commit(rec, false); err != nil {
 		// Close journal on error.
 		if db.journal != nil {
@@ -743,7 +769,7 @@ func (db *DB) recoverJournal() error {
 
 	return nil
 }
-func (db *DB) recoverJournal_s() error {
+func (db *DB) recoverJournal_s(parts *crossTreeParts) error {
 	// Get all journals and sort it by file number.
 	rawFds, err := db.s.stor.List(storage.TypeJournals)
 	if err != nil {
@@ -782,6 +808,9 @@ func (db *DB) recoverJournal_s() error {
 			buf      = &util.Buffer{}
 			batchSeq uint64
 			batchLen int
+			// End of the previous record, sequence numbers of a journal
+			// only grow.
+			expectSeq uint64
 		)
 
 		for _, fd := range fds {
@@ -810,11 +839,13 @@ func (db *DB) recoverJournal_s() error {
 
 				rec.setJournalNum(fd.Num)
 				rec.setSeqNum(db.seq)
+				rec.setMemSeqNum_s(db.seq)
 				if err := db.s.commit(rec, false); err != nil {
 					fr.Close()
 					return err
 				}
 				rec.resetAddedTables_s()
+				rec.resetBlobs()
 
 				db.s.stor.Remove(ofd)
 				ofd = storage.FileDesc{}
@@ -822,6 +853,31 @@ func (db *DB) recoverJournal_s() error {
 			//fmt.Println("ASDASDASDASADADADAD3333")
 			// Replay journal to memdb.
 			mdbs.Reset_s()
+			apply := func(data []byte) (err error) {
+				batchSeq, batchLen, err = decodeBatchToMem_s(data, expectSeq, mdbs)
+				if err != nil {
+					if !strict && errors.IsCorrupted(err) {
+						db.s.logf("journal error: %v (skipped)", err)
+						// We won't apply sequence number as it might be corrupted.
+						return nil
+					}
+					return errors.SetFd(err, fd)
+				}
+				// Save sequence number.
+				expectSeq = batchSeq + uint64(batchLen)
+				if expectSeq > db.seq {
+					db.seq = expectSeq
+				}
+				// Flush it if large enough.
+				if mdbs.Size_s() >= writeBuffer {
+					if _, err := db.s.flushMemdb_s(rec, mdbs, 0); err != nil {
+						return err
+					}
+
+					mdbs.Reset_s()
+				}
+				return nil
+			}
 			for {
 				r, err := jr.Next()
 				if err != nil {
@@ -842,32 +898,26 @@ func (db *DB) recoverJournal_s() error {
 					fr.Close()
 					return errors.SetFd(err, fd)
 				}
-				batchSeq, batchLen, err = decodeBatchToMem_s(buf.Bytes(), db.seq, mdbs)
-				//此住没有执行？
-				if err != nil {
-					if !strict && errors.IsCorrupted(err) {
-						db.s.logf("journal error: %v (skipped)", err)
-						// We won't apply sequence number as it might be corrupted.
-						continue
-					}
-
-					fr.Close()
-					return errors.SetFd(err, fd)
-				}
-				//fmt.Println("12345")
-				// Save sequence number.
-				db.seq = batchSeq + uint64(batchLen)
-				//fmt.Println("mdbs的容量：",mdbs.Size_s())
-				// Flush it if large enough.
-				if mdbs.Size_s() >= writeBuffer {
-					if _, err := db.s.flushMemdb_s(rec, mdbs, 0); err != nil {
+				for _, data := range parts.next(buf.Bytes()) {
+					if err := apply(data); err != nil {
 						fr.Close()
 						return err
 					}
-
-					mdbs.Reset_s()
 				}
 			}
+			// The commit record of a cross-tree batch part is in the same
+			// journal, settle the last part before the journal is removed.
+			for _, data := range parts.end() {
+				if err := apply(data); err != nil {
+					fr.Close()
+					return err
+				}
+			}
+			if parts.dropped > 0 {
+				// The write of the batch didn't complete.
+				db.logf("journal@recovery dropped %d uncommitted cross-tree batches", parts.dropped)
+				parts.dropped = 0
+			}
 
 			fr.Close()
 			ofd = fd
@@ -891,6 +941,7 @@ func (db *DB) recoverJournal_s() error {
 		rec.setJournalNum(db.journalFd2.Num)
 	}
 	rec.setSeqNum(db.seq)
+	rec.setMemSeqNum_s(db.seq)
 	if err := db.s.commit(rec, false); err != nil {
 		// Close journal on error.
 		if db.journal2 != nil {
@@ -908,29 +959,94 @@ func (db *DB) recoverJournal_s() error {
 	return nil
 } //写日志的时候有问题，List，如果要改应该写入两个日志之中；
 func (db *DB) recoverJournalRO() error {
-	// Get all journals and sort it by file number.
-	rawFds, err := db.s.stor.List(storage.TypeJournal)
+	mdb, mdbs, seq, err := db.replayJournalsRO()
 	if err != nil {
 		return err
 	}
-	sortFds(rawFds)
+	if seq > db.seq {
+		db.seq = seq
+	}
 
-	// Journals that will be recovered.
-	var fds []storage.FileDesc
-	for _, fd := range rawFds {
-		if fd.Num >= db.s.stJournalNum || fd.Num == db.s.stPrevJournalNum {
-			fds = append(fds, fd)
+	// Set memDB.
+	//db.mem为memDB类型，
+	db.mem = &memDB{db: db, DB: mdb, ref: 1}
+	db.mems = &memDB{db: db, DBs: mdbs, refs: 1}
+	return nil
+}
+
+// Replays the journals of both trees into new memdbs, without modifying
+// the storage. Records the session state marks as flushed are skipped.
+// Returns the last sequence number replayed.
+func (db *DB) replayJournalsRO() (mdb *memdb.DB, mdbs *memdb.DBs, seq uint64, err error) {
+	writeBuffer := db.s.o.GetWriteBuffer()
+	//创建一个初始化的mdb，是只添加
+	mdb = memdb.New(db.s.icmp, writeBuffer)
+	mdbs = memdb.New_s(db.s.icmp, writeBuffer)
+	seq, err = db.replayJournalRO(storage.TypeJournal, db.s.stMemSeqNum, func(b []byte, expectSeq uint64) (uint64, int, error) {
+		return decodeBatchToMem(b, expectSeq, mdb)
+	})
+	if err != nil {
+		return
+	}
+	parts, err := db.scanCrossTreeParts()
+	if err != nil {
+		return
+	}
+	apply := func(recs [][]byte, expectSeq uint64) (seq uint64, n int, err error) {
+		for _, data := range recs {
+			if seq, n, err = decodeBatchToMem_s(data, expectSeq, mdbs); err != nil {
+				return
+			}
+			expectSeq = seq + uint64(n)
+		}
+		return
+	}
+	seq2, err := db.replayJournalRO(storage.TypeJournals, db.s.stMemSeqNum2, func(b []byte, expectSeq uint64) (uint64, int, error) {
+		return apply(parts.next(b), expectSeq)
+	})
+	if err != nil {
+		return
+	}
+	// A part still waiting for its commit record ends the last journal.
+	last, n, err := apply(parts.end(), seq2)
+	if err != nil {
+		return
+	}
+	if last+uint64(n) > seq2 {
+		seq2 = last + uint64(n)
+	}
+	if seq2 > seq {
+		seq = seq2
+	}
+	return
+}
+
+// Collects the primary parts of cross-tree batches in the primary journals,
+// without modifying the storage.
+func (db *DB) scanCrossTreeParts() (*crossTreeParts, error) {
+	parts := newCrossTreeParts()
+	_, err := db.replayJournalRO(storage.TypeJournal, 0, func(b []byte, expectSeq uint64) (uint64, int, error) {
+		batchSeq, batchLen, err := decodeJournalBatch(b, expectSeq, batchTreePrimary)
+		if err == nil {
+			parts.add(b, batchSeq, batchLen)
 		}
+		return batchSeq, batchLen, err
+	})
+	return parts, err
+}
+
+func (db *DB) replayJournalRO(ft storage.FileType, memSeq uint64, decode func(data []byte, expectSeq uint64) (uint64, int, error)) (seq uint64, err error) {
+	// Get all journals and sort it by file number.
+	fds, err := db.s.stor.List(ft)
+	if err != nil {
+		return
 	}
+	sortFds(fds)
 
 	var (
 		// Options.
-		strict      = db.s.o.GetStrict(opt.StrictJournal)
-		checksum    = db.s.o.GetStrict(opt.StrictJournalChecksum)
-		writeBuffer = db.s.o.GetWriteBuffer()
-		//创建一个初始化的mdb，是只添加
-		mdb = memdb.New(db.s.icmp, writeBuffer)
-		mdbs = memdb.New_s(db.s.icmp, writeBuffer)
+		strict   = db.s.o.GetStrict(opt.StrictJournal)
+		checksum = db.s.o.GetStrict(opt.StrictJournalChecksum)
 	)
 
 	// Recover journals.
@@ -949,7 +1065,7 @@ func (db *DB) recoverJournalRO() error {
 
 			fr, err := db.s.stor.Open(fd)
 			if err != nil {
-				return err
+				return 0, err
 			}
 
 			// Create or reset journal reader instance.
@@ -968,7 +1084,7 @@ func (db *DB) recoverJournalRO() error {
 					}
 
 					fr.Close()
-					return errors.SetFd(err, fd)
+					return 0, errors.SetFd(err, fd)
 				}
 
 				buf.Reset()
@@ -979,9 +1095,13 @@ func (db *DB) recoverJournalRO() error {
 					}
 
 					fr.Close()
-					return errors.SetFd(err, fd)
+					return 0, errors.SetFd(err, fd)
+				}
+				// Already flushed.
+				if batchSeq, _, err = decodeBatchHeader(buf.Bytes()); err == nil && batchSeq <= memSeq {
+					continue
 				}
-				batchSeq, batchLen, err = decodeBatchToMem(buf.Bytes(), db.seq, mdb)
+				batchSeq, batchLen, err = decode(buf.Bytes(), seq)
 				if err != nil {
 					if !strict && errors.IsCorrupted(err) {
 						db.s.logf("journal error: %v (skipped)", err)
@@ -990,63 +1110,110 @@ func (db *DB) recoverJournalRO() error {
 					}
 
 					fr.Close()
-					return errors.SetFd(err, fd)
+					return 0, errors.SetFd(err, fd)
 				}
 
 				// Save sequence number.
-				db.seq = batchSeq + uint64(batchLen)
+				if s := batchSeq + uint64(batchLen); s > seq {
+					seq = s
+				}
 			}
 
 			fr.Close()
 		}
 	}
-
-	// Set memDB.
-	//db.mem为memDB类型，
-	db.mem = &memDB{db: db, DB: mdb, ref: 1}
-	db.mems = &memDB{db: db, DBs: mdbs, refs: 1}
-	return nil
+	return
 }
 
 func memGet(mdb *memdb.DB, ikey internalKey, icmp *iComparer) (ok bool, mv []byte, err error) {
-	mk, mv, err := mdb.Find(ikey)
-	if err == nil {
-		ukey, _, kt, kerr := parseInternalKey(mk)
+	// Range tombstones covering the key shadow older entries.
+	seq, _ := ikey.parseNum()
+	rdSeq, err := rangeDelSeq(icmp, mdb.NewRangeDelIterator(), ikey.ukey(), seq)
+	if err != nil {
+		return true, nil, err
+	}
+	for {
+		var mk []byte
+		mk, mv, err = mdb.Find(ikey)
+		if err != nil {
+			break
+		}
+		ukey, fseq, kt, kerr := parseInternalKey(mk)
 		if kerr != nil {
 			// Shouldn't have had happen.
 			panic(kerr)
 		}
-		if icmp.uCompare(ukey, ikey.ukey()) == 0 {
+		if icmp.uCompare(ukey, ikey.ukey()) != 0 {
+			break
+		}
+		if kt == keyTypeRangeDel {
+			// Skip tombstones starting at the key, they're accounted above.
+			if fseq == 0 {
+				break
+			}
+			ikey = makeInternalKey(nil, ukey, fseq-1, keyTypeSeek)
+			continue
+		}
+		if fseq > rdSeq {
 			if kt == keyTypeDel {
 				return true, nil, ErrNotFound
 			}
 			return true, mv, nil
-
 		}
-	} else if err != ErrNotFound {
+		break
+	}
+	if err != nil && err != ErrNotFound {
 		return true, nil, err
 	}
-	return
+	if rdSeq > 0 {
+		return true, nil, ErrNotFound
+	}
+	return false, nil, nil
 }
 func memGet_s(mdb *memdb.DBs, ikey internalKey, icmp *iComparer) (ok bool, mv []byte, err error) {
-	mk, mv, err := mdb.Find_s(ikey)
-	if err == nil {
-		ukey, _, kt, kerr := parseInternalKey(mk)
+	// Range tombstones covering the key shadow older entries.
+	seq, _ := ikey.parseNum()
+	rdSeq, err := rangeDelSeq(icmp, mdb.NewRangeDelIterator_s(), ikey.ukey(), seq)
+	if err != nil {
+		return true, nil, err
+	}
+	for {
+		var mk []byte
+		mk, mv, err = mdb.Find_s(ikey)
+		if err != nil {
+			break
+		}
+		ukey, fseq, kt, kerr := parseInternalKey(mk)
 		if kerr != nil {
 			// Shouldn't have had happen.
 			panic(kerr)
 		}
-		if icmp.uCompare(ukey, ikey.ukey()) == 0 {
+		if icmp.uCompare(ukey, ikey.ukey()) != 0 {
+			break
+		}
+		if kt == keyTypeRangeDel {
+			// Skip tombstones starting at the key, they're accounted above.
+			if fseq == 0 {
+				break
+			}
+			ikey = makeInternalKey(nil, ukey, fseq-1, keyTypeSeek)
+			continue
+		}
+		if fseq > rdSeq {
 			if kt == keyTypeDel {
 				return true, nil, ErrNotFound
 			}
 			return true, mv, nil
-
 		}
-	} else if err != ErrNotFound {
+		break
+	}
+	if err != nil && err != ErrNotFound {
 		return true, nil, err
 	}
-	return
+	if rdSeq > 0 {
+		return true, nil, ErrNotFound
+	}
+	return false, nil, nil
 }
 
 func (db *DB) get(auxm *memdb.DB, auxt tFiles, key []byte, seq uint64, ro *opt.ReadOptions) (value []byte, err error) {
@@ -1164,6 +1331,42 @@ func (db *DB) has(auxm *memdb.DB, auxt tFiles, key []byte, seq uint64, ro *opt.R
 	return
 }
 
+func (db *DB) has_s(auxm *memdb.DBs, auxt sFiles, key []byte, seq uint64, ro *opt.ReadOptions) (ret bool, err error) {
+	ikey := makeInternalKey(nil, key, seq, keyTypeSeek)
+
+	if auxm != nil {
+		if ok, _, me := memGet_s(auxm, ikey, db.s.icmp); ok {
+			return me == nil, nilIfNotFound(me)
+		}
+	}
+
+	em, fm := db.getMems_s()
+	for _, m := range [...]*memDB{em, fm} {
+		if m == nil {
+			continue
+		}
+		defer m.decref_s()
+
+		if ok, _, me := memGet_s(m.DBs, ikey, db.s.icmp); ok {
+			return me == nil, nilIfNotFound(me)
+		}
+	}
+
+	v := db.s.version()
+	_, cSched, err := v.get_s(auxt, ikey, ro, true)
+	v.release()
+	if cSched {
+		// Trigger table compaction.
+		db.compTrigger(db.tcompCmdCs)
+	}
+	if err == nil {
+		ret = true
+	} else if err == ErrNotFound {
+		err = nil
+	}
+	return
+}
+
 // Get gets the value for the given key. It returns ErrNotFound if the
 // DB does not contains the key.
 //
@@ -1192,6 +1395,153 @@ func (db *DB) Get_s(key []byte, ro *opt.ReadOptions) (value []byte, err error) {
 	return db.get_s(nil, nil, key, se.seq, ro)//然后调用get函数
 }
 
+// Returns the indexes of keys in the order of the keys.
+func (db *DB) sortedKeys(keys [][]byte) []int {
+	order := make([]int, len(keys))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return db.s.icmp.uCompare(keys[order[i]], keys[order[j]]) < 0
+	})
+	return order
+}
+
+// Batched db.get, the memdbs and the version are shared by all the keys.
+func (db *DB) multiGet(keys [][]byte, seq uint64, ro *opt.ReadOptions) (values [][]byte, errs []error) {
+	values = make([][]byte, len(keys))
+	errs = make([]error, len(keys))
+
+	em, fm := db.getMems()
+	for _, m := range [...]*memDB{em, fm} {
+		if m != nil {
+			defer m.decref()
+		}
+	}
+
+	// Keys not in the memdbs, sorted.
+	var (
+		pending []int
+		ikeys   []internalKey
+	)
+	for _, i := range db.sortedKeys(keys) {
+		ikey := makeInternalKey(nil, keys[i], seq, keyTypeSeek)
+		found := false
+		for _, m := range [...]*memDB{em, fm} {
+			if m == nil {
+				continue
+			}
+			if ok, mv, me := memGet(m.DB, ikey, db.s.icmp); ok {
+				values[i], errs[i] = append([]byte{}, mv...), me
+				found = true
+				break
+			}
+		}
+		if !found {
+			pending = append(pending, i)
+			ikeys = append(ikeys, ikey)
+		}
+	}
+	if len(pending) == 0 {
+		return
+	}
+
+	v := db.s.version()
+	tvalues, terrs, cSched := v.multiGet(nil, ikeys, ro)
+	v.release()
+	if cSched {
+		// Trigger table compaction.
+		db.compTrigger(db.tcompCmdC)
+	}
+	for j, i := range pending {
+		values[i], errs[i] = tvalues[j], terrs[j]
+	}
+	return
+}
+func (db *DB) multiGet_s(keys [][]byte, seq uint64, ro *opt.ReadOptions) (values [][]byte, errs []error) {
+	values = make([][]byte, len(keys))
+	errs = make([]error, len(keys))
+
+	em, fm := db.getMems_s()
+	for _, m := range [...]*memDB{em, fm} {
+		if m != nil {
+			defer m.decref_s()
+		}
+	}
+
+	// Keys not in the memdbs, sorted.
+	var (
+		pending []int
+		ikeys   []internalKey
+	)
+	for _, i := range db.sortedKeys(keys) {
+		ikey := makeInternalKey(nil, keys[i], seq, keyTypeSeek)
+		found := false
+		for _, m := range [...]*memDB{em, fm} {
+			if m == nil {
+				continue
+			}
+			if ok, mv, me := memGet_s(m.DBs, ikey, db.s.icmp); ok {
+				values[i], errs[i] = append([]byte{}, mv...), me
+				found = true
+				break
+			}
+		}
+		if !found {
+			pending = append(pending, i)
+			ikeys = append(ikeys, ikey)
+		}
+	}
+	if len(pending) == 0 {
+		return
+	}
+
+	v := db.s.version()
+	tvalues, terrs, cSched := v.multiGet_s(nil, ikeys, ro)
+	v.release()
+	if cSched {
+		// Trigger table compaction.
+		db.compTrigger(db.tcompCmdCs)
+	}
+	for j, i := range pending {
+		values[i], errs[i] = tvalues[j], terrs[j]
+	}
+	return
+}
+
+// MultiGet gets the values for the given keys, as Get would one at a time
+// but from a single snapshot. The keys are looked up in sorted order: each
+// table is opened once for all the keys which may be in it, its filter is
+// probed for all of them at once, and keys that land in the same data block
+// share a single read of it.
+//
+// The values and errors are in the order of the keys; the error of a key
+// is ErrNotFound if the DB does not contain it.
+//
+// The returned slices are their own copy, it is safe to modify their
+// contents.
+// It is safe to modify the contents of the argument after MultiGet returns.
+func (db *DB) MultiGet(keys [][]byte, ro *opt.ReadOptions) (values [][]byte, errs []error) {
+	if err := db.ok(); err != nil {
+		return make([][]byte, len(keys)), fillErrors(len(keys), err)
+	}
+
+	se := db.acquireSnapshot()
+	defer db.releaseSnapshot(se)
+	return db.multiGet(keys, se.seq, ro)
+}
+
+// MultiGet_s is MultiGet for the secondary tree.
+func (db *DB) MultiGet_s(keys [][]byte, ro *opt.ReadOptions) (values [][]byte, errs []error) {
+	if err := db.ok(); err != nil {
+		return make([][]byte, len(keys)), fillErrors(len(keys), err)
+	}
+
+	se := db.acquireSnapshot()
+	defer db.releaseSnapshot(se)
+	return db.multiGet_s(keys, se.seq, ro)
+}
+
 // Has returns true if the DB does contains the given key.
 //
 // It is safe to modify the contents of the argument after Has returns.
@@ -1206,6 +1556,20 @@ func (db *DB) Has(key []byte, ro *opt.ReadOptions) (ret bool, err error) {
 	return db.has(nil, nil, key, se.seq, ro)
 }
 
+// Has_s returns true if the secondary tree does contains the given key.
+//
+// It is safe to modify the contents of the argument after Has_s returns.
+func (db *DB) Has_s(key []byte, ro *opt.ReadOptions) (ret bool, err error) {
+	err = db.ok()
+	if err != nil {
+		return
+	}
+
+	se := db.acquireSnapshot()
+	defer db.releaseSnapshot(se)
+	return db.has_s(nil, nil, key, se.seq, ro)
+}
+
 // NewIterator returns an iterator for the latest snapshot of the
 // underlying DB.
 // The returned iterator is not safe for concurrent use, but it is safe to use
@@ -1239,6 +1603,23 @@ func (db *DB) NewIterator(slice *util.Range, ro *opt.ReadOptions) iterator.Itera
 	return db.newIterator(nil, nil, se.seq, slice, ro)
 }
 
+// NewIterator_s returns an iterator for the latest snapshot of the
+// secondary tree, i.e. the data written by Put_s and Write_s. It offers
+// the same consistency guarantees as NewIterator.
+//
+// The iterator must be released after use, by calling Release method.
+func (db *DB) NewIterator_s(slice *util.Range, ro *opt.ReadOptions) iterator.Iterator {
+	if err := db.ok(); err != nil {
+		return iterator.NewEmptyIterator(err)
+	}
+
+	se := db.acquireSnapshot()
+	defer db.releaseSnapshot(se)
+	// Iterator holds 'version' lock, 'version' is immutable so snapshot
+	// can be released after iterator created.
+	return db.newIterator_s(nil, nil, se.seq, slice, ro)
+}
+
 // GetSnapshot returns a latest snapshot of the underlying DB. A snapshot
 // is a frozen snapshot of a DB state at a particular point in time. The
 // content of snapshot are guaranteed to be consistent.
@@ -1269,12 +1650,18 @@ func (db *DB) GetSnapshot() (*Snapshot, error) {
 //		Returns block pool stats.
 //	leveldb.cachedblock
 //		Returns size of cached block.
+//	leveldb.blockcache
+//		Returns block cache stats, see cache.Stats.
 //	leveldb.openedtables
 //		Returns number of opened tables.
 //	leveldb.alivesnaps
 //		Returns number of alive snapshots.
 //	leveldb.aliveiters
 //		Returns number of alive iterators.
+//
+// The tree specific properties num-files-at-level{n}, stats, compcount,
+// sstables, cachedblock, blockcache and openedtables are also available for the secondary tree under the leveldb.s.
+// prefix, e.g. leveldb.s.stats.
 func (db *DB) GetProperty(name string) (value string, err error) {
 	err = db.ok()
 	if err != nil {
@@ -1290,6 +1677,11 @@ func (db *DB) GetProperty(name string) (value string, err error) {
 	v := db.s.version()
 	defer v.release()
 
+	const prefix_s = "s."
+	if strings.HasPrefix(p, prefix_s) {
+		return db.getProperty_s(v, p[len(prefix_s):])
+	}
+
 	numFilesPrefix := "num-files-at-level"
 	switch {
 	case strings.HasPrefix(p, numFilesPrefix):
@@ -1302,30 +1694,13 @@ func (db *DB) GetProperty(name string) (value string, err error) {
 			value = fmt.Sprint(v.tLen(int(level)))
 		}
 	case p == "stats":
-		value = "Compactions\n" +
-			" Level |   Tables   |    Size(MB)   |    Time(sec)  |    Read(MB)   |   Write(MB)\n" +
-			"-------+------------+---------------+---------------+---------------+---------------\n"
-		var totalTables int
-		var totalSize, totalRead, totalWrite int64
-		var totalDuration time.Duration
-		for level, tables := range v.levels {
-			duration, read, write := db.compStats.getStat(level)
-			if len(tables) == 0 && duration == 0 {
-				continue
-			}
-			totalTables += len(tables)
-			totalSize += tables.size()
-			totalRead += read
-			totalWrite += write
-			totalDuration += duration
-			value += fmt.Sprintf(" %3d   | %10d | %13.5f | %13.5f | %13.5f | %13.5f\n",
-				level, len(tables), float64(tables.size())/1048576.0, duration.Seconds(),
-				float64(read)/1048576.0, float64(write)/1048576.0)
-		}
-		value += "-------+------------+---------------+---------------+---------------+---------------\n"
-		value += fmt.Sprintf(" Total | %10d | %13.5f | %13.5f | %13.5f | %13.5f\n",
-			totalTables, float64(totalSize)/1048576.0, totalDuration.Seconds(),
-			float64(totalRead)/1048576.0, float64(totalWrite)/1048576.0)
+		tables := make([]int, len(v.levels))
+		sizes := make(Sizes, len(v.levels))
+		for level, t := range v.levels {
+			tables[level] = len(t)
+			sizes[level] = t.size()
+		}
+		value = formatCompStats(tables, sizes, &db.compStats)
 	case p == "compcount":
 		value = fmt.Sprintf("MemComp:%d Level0Comp:%d NonLevel0Comp:%d SeekComp:%d", atomic.LoadUint32(&db.memComp), atomic.LoadUint32(&db.level0Comp), atomic.LoadUint32(&db.nonLevel0Comp), atomic.LoadUint32(&db.seekComp))
 	case p == "iostats":
@@ -1351,6 +1726,8 @@ func (db *DB) GetProperty(name string) (value string, err error) {
 		} else {
 			value = "<nil>"
 		}
+	case p == "blockcache":
+		value = formatCacheStats(db.s.tops.bcache)
 	case p == "openedtables":
 		value = fmt.Sprintf("%d", db.s.tops.cache.Size())
 	case p == "alivesnaps":
@@ -1364,7 +1741,103 @@ func (db *DB) GetProperty(name string) (value string, err error) {
 	return
 }
 
-// DBStats is database statistics.
+// Returns value of the given property of the secondary tree, the name is
+// stripped of the leveldb.s. prefix.
+func (db *DB) getProperty_s(v *version, p string) (value string, err error) {
+	numFilesPrefix := "num-files-at-level"
+	switch {
+	case strings.HasPrefix(p, numFilesPrefix):
+		var level uint
+		var rest string
+		n, _ := fmt.Sscanf(p[len(numFilesPrefix):], "%d%s", &level, &rest)
+		if n != 1 {
+			err = ErrNotFound
+		} else {
+			value = fmt.Sprint(v.tLen_s(int(level)))
+		}
+	case p == "stats":
+		tables := make([]int, len(v.level_s))
+		sizes := make(Sizes, len(v.level_s))
+		for level, t := range v.level_s {
+			tables[level] = len(t)
+			sizes[level] = t.size()
+		}
+		value = formatCompStats(tables, sizes, &db.comStatss)
+	case p == "compcount":
+		value = fmt.Sprintf("MemComp:%d Level0Comp:%d NonLevel0Comp:%d", atomic.LoadUint32(&db.memComps), atomic.LoadUint32(&db.level0Comps), atomic.LoadUint32(&db.nonLevel0Comps))
+	case p == "sstables":
+		for level, tables := range v.level_s {
+			value += fmt.Sprintf("--- level %d ---\n", level)
+			for _, t := range tables {
+				value += fmt.Sprintf("%d:%d[%q .. %q]\n", t.fd.Num, t.size, t.imin, t.imax)
+			}
+		}
+	case p == "cachedblock":
+		if db.s.tops.bcache_s != nil {
+			value = fmt.Sprintf("%d", db.s.tops.bcache_s.Size())
+		} else {
+			value = "<nil>"
+		}
+	case p == "blockcache":
+		value = formatCacheStats(db.s.tops.bcache_s)
+	case p == "openedtables":
+		value = fmt.Sprintf("%d", db.s.tops.cache_s.Size())
+	default:
+		err = ErrNotFound
+	}
+
+	return
+}
+
+// Formats the stats of a block cache for leveldb.blockcache.
+func formatCacheStats(c *cache.Cache) string {
+	if c == nil {
+		return "<nil>"
+	}
+	cs := c.Stats()
+	return fmt.Sprintf("Nodes:%d Size:%d Hits:%d Misses:%d Evictions:%d GhostHits:%d Recent:%d Frequent:%d RecentGhost:%d FrequentGhost:%d Target:%d",
+		cs.Nodes, cs.Size, cs.Hits, cs.Misses, cs.Evictions, cs.GhostHits,
+		cs.RecentSize, cs.FrequentSize, cs.RecentGhostSize, cs.FrequentGhostSize, cs.Target)
+}
+
+// Formats the compaction table of leveldb.stats from the per-level table
+// counts and sizes of a tree and its compaction stats.
+func formatCompStats(tables []int, sizes Sizes, stats *cStats) string {
+	value := "Compactions\n" +
+		" Level |   Tables   |    Size(MB)   |    Time(sec)  |    Read(MB)   |   Write(MB)\n" +
+		"-------+------------+---------------+---------------+---------------+---------------\n"
+	var totalTables int
+	var totalSize, totalRead, totalWrite, totalRemoved, totalChanged int64
+	var totalDuration time.Duration
+	for level := range tables {
+		removed, changed := stats.getFilterStat(level)
+		totalRemoved += removed
+		totalChanged += changed
+		duration, read, write := stats.getStat(level)
+		if tables[level] == 0 && duration == 0 {
+			continue
+		}
+		totalTables += tables[level]
+		totalSize += sizes[level]
+		totalRead += read
+		totalWrite += write
+		totalDuration += duration
+		value += fmt.Sprintf(" %3d   | %10d | %13.5f | %13.5f | %13.5f | %13.5f\n",
+			level, tables[level], float64(sizes[level])/1048576.0, duration.Seconds(),
+			float64(read)/1048576.0, float64(write)/1048576.0)
+	}
+	value += "-------+------------+---------------+---------------+---------------+---------------\n"
+	value += fmt.Sprintf(" Total | %10d | %13.5f | %13.5f | %13.5f | %13.5f\n",
+		totalTables, float64(totalSize)/1048576.0, totalDuration.Seconds(),
+		float64(totalRead)/1048576.0, float64(totalWrite)/1048576.0)
+	if totalRemoved > 0 || totalChanged > 0 {
+		value += fmt.Sprintf("Compaction filter: %d removed, %d changed\n", totalRemoved, totalChanged)
+	}
+	return value
+}
+
+// DBStats is database statistics. Fields suffixed with _s describe the
+// secondary tree.
 type DBStats struct {
 	WriteDelayCount    int32
 	WriteDelayDuration time.Duration
@@ -1376,19 +1849,61 @@ type DBStats struct {
 	IOWrite uint64
 	IORead  uint64
 
+	// Flush and compaction I/O requests throttled by opt.Options.RateLimiter,
+	// and the time they spent waiting.
+	IOThrottleCount    int64
+	IOThrottleDuration time.Duration
+
 	BlockCacheSize    int
+	BlockCacheHits    int64
+	BlockCacheMisses  int64
 	OpenedTablesCount int
 
+	BlockCacheSize_s    int
+	BlockCacheHits_s    int64
+	BlockCacheMisses_s  int64
+	OpenedTablesCount_s int
+
+	MemdbSize   int // Size of the effective and frozen memdb
+	MemdbSize_s int
+
 	LevelSizes        Sizes
 	LevelTablesCounts []int
 	LevelRead         Sizes
 	LevelWrite        Sizes
 	LevelDurations    []time.Duration
 
+	// Entries removed and changed by the compaction filter, see
+	// opt.CompactionFilter.
+	LevelFilterRemoved []int64
+	LevelFilterChanged []int64
+
+	LevelSizes_s        Sizes
+	LevelTablesCounts_s []int
+	LevelRead_s         Sizes
+	LevelWrite_s        Sizes
+	LevelDurations_s    []time.Duration
+
+	LevelFilterRemoved_s []int64
+	LevelFilterChanged_s []int64
+
 	MemComp       uint32
 	Level0Comp    uint32
 	NonLevel0Comp uint32
 	SeekComp      uint32
+
+	MemComp_s       uint32
+	Level0Comp_s    uint32
+	NonLevel0Comp_s uint32
+}
+
+// Returns size, hits and misses of a block cache, zero if it is nil.
+func blockCacheStats(c *cache.Cache) (size int, hits, misses int64) {
+	if c == nil {
+		return
+	}
+	cs := c.Stats()
+	return c.Size(), cs.Hits, cs.Misses
 }
 
 // Stats populates s with database statistics.
@@ -1400,30 +1915,60 @@ func (db *DB) Stats(s *DBStats) error {
 
 	s.IORead = db.s.stor.reads()
 	s.IOWrite = db.s.stor.writes()
+	s.IOThrottleCount, s.IOThrottleDuration = db.s.tops.throttleStats()
 	s.WriteDelayCount = atomic.LoadInt32(&db.cWriteDelayN)
 	s.WriteDelayDuration = time.Duration(atomic.LoadInt64(&db.cWriteDelay))
 	s.WritePaused = atomic.LoadInt32(&db.inWritePaused) == 1
 
 	s.OpenedTablesCount = db.s.tops.cache.Size()
-	if db.s.tops.bcache != nil {
-		s.BlockCacheSize = db.s.tops.bcache.Size()
-	} else {
-		s.BlockCacheSize = 0
-	}
+	s.BlockCacheSize, s.BlockCacheHits, s.BlockCacheMisses = blockCacheStats(db.s.tops.bcache)
+	s.OpenedTablesCount_s = db.s.tops.cache_s.Size()
+	s.BlockCacheSize_s, s.BlockCacheHits_s, s.BlockCacheMisses_s = blockCacheStats(db.s.tops.bcache_s)
 
 	s.AliveIterators = atomic.LoadInt32(&db.aliveIters)
 	s.AliveSnapshots = atomic.LoadInt32(&db.aliveSnaps)
 
+	s.MemdbSize = 0
+	em, fm := db.getMems()
+	if em != nil {
+		s.MemdbSize += em.Size()
+		em.decref()
+	}
+	if fm != nil {
+		s.MemdbSize += fm.Size()
+		fm.decref()
+	}
+	s.MemdbSize_s = 0
+	ems, fms := db.getMems_s()
+	if ems != nil {
+		s.MemdbSize_s += ems.Size_s()
+		ems.decref_s()
+	}
+	if fms != nil {
+		s.MemdbSize_s += fms.Size_s()
+		fms.decref_s()
+	}
+
 	s.LevelDurations = s.LevelDurations[:0]
 	s.LevelRead = s.LevelRead[:0]
 	s.LevelWrite = s.LevelWrite[:0]
 	s.LevelSizes = s.LevelSizes[:0]
 	s.LevelTablesCounts = s.LevelTablesCounts[:0]
+	s.LevelFilterRemoved = s.LevelFilterRemoved[:0]
+	s.LevelFilterChanged = s.LevelFilterChanged[:0]
+
+	s.LevelDurations_s = s.LevelDurations_s[:0]
+	s.LevelRead_s = s.LevelRead_s[:0]
+	s.LevelWrite_s = s.LevelWrite_s[:0]
+	s.LevelSizes_s = s.LevelSizes_s[:0]
+	s.LevelTablesCounts_s = s.LevelTablesCounts_s[:0]
+	s.LevelFilterRemoved_s = s.LevelFilterRemoved_s[:0]
+	s.LevelFilterChanged_s = s.LevelFilterChanged_s[:0]
 
 	v := db.s.version()
 	defer v.release()
 
-	for level, tables := range v.levels { //出现了v.levels！
+	for level, tables := range v.levels {
 		duration, read, write := db.compStats.getStat(level)
 
 		s.LevelDurations = append(s.LevelDurations, duration)
@@ -1431,11 +1976,31 @@ func (db *DB) Stats(s *DBStats) error {
 		s.LevelWrite = append(s.LevelWrite, write)
 		s.LevelSizes = append(s.LevelSizes, tables.size())
 		s.LevelTablesCounts = append(s.LevelTablesCounts, len(tables))
+
+		removed, changed := db.compStats.getFilterStat(level)
+		s.LevelFilterRemoved = append(s.LevelFilterRemoved, removed)
+		s.LevelFilterChanged = append(s.LevelFilterChanged, changed)
+	}
+	for level, tables := range v.level_s {
+		duration, read, write := db.comStatss.getStat(level)
+
+		s.LevelDurations_s = append(s.LevelDurations_s, duration)
+		s.LevelRead_s = append(s.LevelRead_s, read)
+		s.LevelWrite_s = append(s.LevelWrite_s, write)
+		s.LevelSizes_s = append(s.LevelSizes_s, tables.size())
+		s.LevelTablesCounts_s = append(s.LevelTablesCounts_s, len(tables))
+
+		removed, changed := db.comStatss.getFilterStat(level)
+		s.LevelFilterRemoved_s = append(s.LevelFilterRemoved_s, removed)
+		s.LevelFilterChanged_s = append(s.LevelFilterChanged_s, changed)
 	}
 	s.MemComp = atomic.LoadUint32(&db.memComp)
 	s.Level0Comp = atomic.LoadUint32(&db.level0Comp)
 	s.NonLevel0Comp = atomic.LoadUint32(&db.nonLevel0Comp)
 	s.SeekComp = atomic.LoadUint32(&db.seekComp)
+	s.MemComp_s = atomic.LoadUint32(&db.memComps)
+	s.Level0Comp_s = atomic.LoadUint32(&db.level0Comps)
+	s.NonLevel0Comp_s = atomic.LoadUint32(&db.nonLevel0Comps)
 	return nil
 }
 
@@ -1475,6 +2040,38 @@ func (db *DB) SizeOf(ranges []util.Range) (Sizes, error) {
 	return sizes, nil
 }
 
+// SizeOf_s calculates approximate sizes of the given key ranges in the
+// secondary tree. See SizeOf.
+func (db *DB) SizeOf_s(ranges []util.Range) (Sizes, error) {
+	if err := db.ok(); err != nil {
+		return nil, err
+	}
+
+	v := db.s.version()
+	defer v.release()
+
+	sizes := make(Sizes, 0, len(ranges))
+	for _, r := range ranges {
+		imin := makeInternalKey(nil, r.Start, keyMaxSeq, keyTypeSeek)
+		imax := makeInternalKey(nil, r.Limit, keyMaxSeq, keyTypeSeek)
+		start, err := v.offsetOf_s(imin)
+		if err != nil {
+			return nil, err
+		}
+		limit, err := v.offsetOf_s(imax)
+		if err != nil {
+			return nil, err
+		}
+		var size int64
+		if limit >= start {
+			size = limit - start
+		}
+		sizes = append(sizes, size)
+	}
+
+	return sizes, nil
+}
+
 // Close closes the DB. This will also releases any outstanding snapshot,
 // abort any in-flight compaction and discard open transaction.
 //
@@ -1516,6 +2113,12 @@ func (db *DB) Close() error {
 	// Wait for all gorotines to exit.
 	db.closeW.Wait()
 
+	// Wait for a running catch-up.
+	if db.secondary != nil {
+		db.secondary.mu.Lock()
+		defer db.secondary.mu.Unlock()
+	}
+
 	// Closes journal.
 	if db.journal != nil {
 		db.journal.Close()
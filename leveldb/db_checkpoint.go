@@ -0,0 +1,267 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"bytes"
+	"io"
+	"os"
+
+	"github.com/rev3z/ledger_base/leveldb/errors"
+	"github.com/rev3z/ledger_base/leveldb/journal"
+	"github.com/rev3z/ledger_base/leveldb/storage"
+	"github.com/rev3z/ledger_base/leveldb/util"
+)
+
+// Checkpoint errors.
+var (
+	ErrCheckpointExists      = errors.New("leveldb: checkpoint directory already exists")
+	ErrCheckpointUnsupported = errors.New("leveldb: checkpoint requires a file-system backed storage")
+)
+
+// Copies the file with the given 'file descriptor' to dst.
+func (db *DB) copyFile(dst storage.Storage, fd storage.FileDesc) (err error) {
+	r, err := db.s.stor.Open(fd)
+	if err != nil {
+		return
+	}
+	defer r.Close()
+	w, err := dst.Create(fd)
+	if err != nil {
+		return
+	}
+	defer w.Close()
+	if _, err = io.Copy(w, r); err != nil {
+		return
+	}
+	return w.Sync()
+}
+
+// Links the file with the given 'file descriptor' into dst, or copies it if
+// it can't be linked, e.g. dst being on another file-system.
+func (db *DB) linkFile(linker storage.Linker, dst storage.Storage, dir string, fd storage.FileDesc) error {
+	err := linker.Link(fd, dir)
+	if err == nil {
+		return nil
+	}
+	db.logf("checkpoint@link linking %s %q, copying", fd, err)
+	return db.copyFile(dst, fd)
+}
+
+// Checkpoint writes a consistent copy of the DB, as of the time of the
+// call, to the directory at dir, which must not exist yet. The copy can be
+// opened with OpenFile.
+//
+// The live tables and blob files of both trees are hard linked, or copied if
+// they can't be; the journals holding the memdbs of both trees are copied,
+// and a fresh manifest is written. Writes are paused while the journals are
+// copied.
+//
+// Checkpoint requires the DB to be backed by the file-system storage.
+func (db *DB) Checkpoint(dir string) (err error) {
+	if err = db.ok(); err != nil {
+		return
+	}
+	linker, ok := db.s.stor.Storage.(storage.Linker)
+	if !ok {
+		return ErrCheckpointUnsupported
+	}
+	if _, err = os.Stat(dir); err == nil {
+		return ErrCheckpointExists
+	} else if !os.IsNotExist(err) {
+		return
+	}
+	dst, err := storage.OpenFile(dir, false)
+	if err != nil {
+		return
+	}
+	defer func() {
+		dst.Close()
+		if err != nil {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	// Lock writer.
+	select {
+	case db.writeLockC <- struct{}{}:
+	case err = <-db.compPerErrC:
+		return
+	case <-db.closeC:
+		return ErrClosed
+	}
+
+	// Pin the current version, the journals then hold exactly the entries
+	// missing from its tables.
+	db.compCommitLk.Lock()
+	v := db.s.version()
+	defer v.release()
+	rec := &sessionRecord{}
+	db.s.fillRecord(rec, true)
+	v.fillRecord(rec)
+	v.fillRecord_s(rec)
+	fds, err := db.s.stor.List(storage.TypeJournal | storage.TypeJournals)
+	if err == nil {
+		for _, fd := range fds {
+			if err = db.copyFile(dst, fd); err != nil {
+				break
+			}
+		}
+	}
+	db.compCommitLk.Unlock()
+	<-db.writeLockC
+	if err != nil {
+		return
+	}
+
+	// The pinned version keeps its files from being removed.
+	for _, r := range rec.addedTables {
+		if err = db.linkFile(linker, dst, dir, storage.FileDesc{Type: storage.TypeTable, Num: r.num}); err != nil {
+			return
+		}
+	}
+	for _, r := range rec.addedTabless {
+		if err = db.linkFile(linker, dst, dir, storage.FileDesc{Type: storage.TypeTable, Num: r.num}); err != nil {
+			return
+		}
+	}
+	for _, r := range rec.addedBlobs {
+		if err = db.linkFile(linker, dst, dir, storage.FileDesc{Type: storage.TypeBlob, Num: r.num}); err != nil {
+			return
+		}
+	}
+
+	// Write the manifest.
+	fd := storage.FileDesc{Type: storage.TypeManifest, Num: rec.nextFileNum}
+	rec.setNextFileNum(fd.Num + 1)
+	w, err := dst.Create(fd)
+	if err != nil {
+		return
+	}
+	defer w.Close()
+	jw := journal.NewWriter(w)
+	mw, err := jw.Next()
+	if err != nil {
+		return
+	}
+	if err = rec.encode(mw); err != nil {
+		return
+	}
+	if err = jw.Flush(); err != nil {
+		return
+	}
+	if err = w.Sync(); err != nil {
+		return
+	}
+	if err = dst.SetMeta(fd); err != nil {
+		return
+	}
+	db.logf("checkpoint@done %s T·%d B·%d J·%d", dir, len(rec.addedTables)+len(rec.addedTabless), len(rec.addedBlobs), len(fds))
+	return
+}
+
+// LiveFiles is a consistent set of the DB files, as returned by
+// DB.LiveFiles. The files are kept from being removed until it is released.
+type LiveFiles struct {
+	// ManifestFd is the file descriptor the manifest is meant to be
+	// stored under.
+	ManifestFd storage.FileDesc
+
+	// Manifest is the content of a manifest describing exactly Files.
+	Manifest []byte
+
+	// Files are the tables of both trees and the blob files. They are
+	// immutable.
+	Files []storage.FileDesc
+
+	db *DB
+	v  *version
+}
+
+// Open opens one of the files for reading.
+func (lf *LiveFiles) Open(fd storage.FileDesc) (storage.Reader, error) {
+	if lf.v == nil {
+		return nil, util.ErrReleased
+	}
+	return lf.db.s.stor.Open(fd)
+}
+
+// Release releases the files. It is safe to call Release multiple times.
+func (lf *LiveFiles) Release() {
+	if lf.v != nil {
+		lf.v.release()
+		lf.v = nil
+	}
+}
+
+// LiveFiles flushes the memdbs of both trees and returns the files the DB
+// then consists of; together with the manifest they make a complete copy of
+// the DB, no journal is needed. Writes are paused while the memdbs are
+// flushed.
+//
+// The caller should call Release on the returned LiveFiles when done.
+func (db *DB) LiveFiles() (lf *LiveFiles, err error) {
+	if err = db.ok(); err != nil {
+		return
+	}
+
+	// Lock writer.
+	select {
+	case db.writeLockC <- struct{}{}:
+	case err = <-db.compPerErrC:
+		return
+	case <-db.closeC:
+		return nil, ErrClosed
+	}
+	defer func() { <-db.writeLockC }()
+
+	if _, err = db.rotateMem(0, true); err != nil {
+		return
+	}
+	if _, err = db.rotateMem_s(0, true); err != nil {
+		return
+	}
+
+	db.compCommitLk.Lock()
+	v := db.s.version()
+	rec := &sessionRecord{}
+	db.s.fillRecord(rec, true)
+	v.fillRecord(rec)
+	v.fillRecord_s(rec)
+	db.compCommitLk.Unlock()
+
+	lf = &LiveFiles{
+		ManifestFd: storage.FileDesc{Type: storage.TypeManifest, Num: rec.nextFileNum},
+		db:         db,
+		v:          v,
+	}
+	rec.setNextFileNum(lf.ManifestFd.Num + 1)
+	for _, r := range rec.addedTables {
+		lf.Files = append(lf.Files, storage.FileDesc{Type: storage.TypeTable, Num: r.num})
+	}
+	for _, r := range rec.addedTabless {
+		lf.Files = append(lf.Files, storage.FileDesc{Type: storage.TypeTable, Num: r.num})
+	}
+	for _, r := range rec.addedBlobs {
+		lf.Files = append(lf.Files, storage.FileDesc{Type: storage.TypeBlob, Num: r.num})
+	}
+
+	buf := new(bytes.Buffer)
+	jw := journal.NewWriter(buf)
+	mw, err := jw.Next()
+	if err == nil {
+		if err = rec.encode(mw); err == nil {
+			err = jw.Close()
+		}
+	}
+	if err != nil {
+		lf.Release()
+		return nil, err
+	}
+	lf.Manifest = buf.Bytes()
+	return
+}
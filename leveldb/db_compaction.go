@@ -7,12 +7,14 @@
 package leveldb
 
 import (
+	"fmt"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/rev3z/ledger_base/leveldb/errors"
 	"github.com/rev3z/ledger_base/leveldb/opt"
+	"github.com/rev3z/ledger_base/leveldb/ratelimit"
 	"github.com/rev3z/ledger_base/leveldb/storage"
 )
 
@@ -24,12 +26,18 @@ type cStat struct {
 	duration time.Duration
 	read     int64
 	write    int64
+
+	// Entries removed and changed by the compaction filter.
+	filterRemoved int64
+	filterChanged int64
 }
 
 func (p *cStat) add(n *cStatStaging) {
 	p.duration += n.duration
 	p.read += n.read
 	p.write += n.write
+	p.filterRemoved += n.filterRemoved
+	p.filterChanged += n.filterChanged
 }
 
 func (p *cStat) get() (duration time.Duration, read, write int64) {
@@ -42,6 +50,9 @@ type cStatStaging struct {
 	on       bool
 	read     int64
 	write    int64
+
+	filterRemoved int64
+	filterChanged int64
 }
 
 func (p *cStatStaging) startTimer() {
@@ -83,6 +94,17 @@ func (p *cStats) getStat(level int) (duration time.Duration, read, write int64)
 	return
 }
 
+// Returns the number of entries removed and changed by the compaction filter
+// while compacting into the given level.
+func (p *cStats) getFilterStat(level int) (removed, changed int64) {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+	if level < len(p.stats) {
+		return p.stats[level].filterRemoved, p.stats[level].filterChanged
+	}
+	return
+}
+
 func (db *DB) compactionError() {
 	var err error
 noerr:
@@ -429,6 +451,7 @@ func (db *DB) memCompaction() {
 
 	rec.setJournalNum(db.journalFd.Num)
 	rec.setSeqNum(db.frozenSeq)
+	rec.setMemSeqNum(db.frozenSeq)
 	//将fulshmemdb的结果进行提交，并记录log，提交的过程主要是为了将新生成的表信息写入到MANIFEST文件中，同时生成新的version
 	stats.startTimer()
 	db.compactionCommit("memdb", rec)
@@ -517,6 +540,7 @@ func (db *DB) memCompaction_s() {
 
 	rec.setJournalNum(db.journalFd2.Num)
 	rec.setSeqNum(db.frozenSeq2)
+	rec.setMemSeqNum_s(db.frozenSeq2)
 	//将fulshmemdb的结果进行提交，并记录log，提交的过程主要是为了将新生成的表信息写入到MANIFEST文件中，同时生成新的version
 	stats.startTimer()
 	db.compactionCommit_s("memdb", rec)
@@ -528,7 +552,7 @@ func (db *DB) memCompaction_s() {
 	for _, r := range rec.addedTabless {
 		stats.write += r.size
 	}
-	db.compStats.addStat(flushLevel, stats)
+	db.comStatss.addStat(flushLevel, stats)
 	atomic.AddUint32(&db.memComps, 1) //记录合并次数
 
 	// Drop frozen memdb.
@@ -562,13 +586,25 @@ type tableCompactionBuilder struct {
 	snapIter        int
 	snapKerrCnt     int
 	snapDropCnt     int
+	snapRangeDel    int
+	snapFilterCnt   [2]int
 
 	kerrCnt int
 	dropCnt int
 
+	// Entries removed and changed by filter.
+	filterRemoveCnt int
+	filterChangeCnt int
+
+	// Bytes of blob records whose reference was dropped, by blob file.
+	blobGarbage     map[int64]int64
+	snapBlobGarbage map[int64]int64
+	blobGCRatio     float64
+
 	minSeq    uint64
 	strict    bool
 	tableSize int
+	filter    opt.CompactionFilter
 
 	tw *tWriter
 }
@@ -589,7 +625,7 @@ func (b *tableCompactionBuilder) appendKV(key, value []byte) error {
 
 		// Create new table.
 		var err error
-		b.tw, err = b.s.tops.create()
+		b.tw, err = b.s.tops.create(ratelimit.Low)
 		if err != nil {
 			return err
 		}
@@ -612,9 +648,10 @@ func (b *tableCompactionBuilder) appendKV_s(key, value []byte) error {
 			}
 		}
 
-		// Create new table.
+		// Create new table. It must be blob-aware, so that the values moved
+		// out of a garbage collected blob file land in a new one.
 		var err error
-		b.tw, err = b.s.tops.create()
+		b.tw, err = b.s.tops.create_s(ratelimit.Low)
 		if err != nil {
 			return err
 		}
@@ -623,8 +660,161 @@ func (b *tableCompactionBuilder) appendKV_s(key, value []byte) error {
 	// Write key/value into table.
 	return b.tw.append(key, value)
 }
+// Appends the range tombstones ts[*i:] ordered before ikey, or all of them if
+// ikey is nil. Tombstones visible to every snapshot are dropped when no deeper
+// level holds keys they may cover.
+func (b *tableCompactionBuilder) appendRangeDels(ts []rangeTombstone, i *int, ikey internalKey) error {
+	for ; *i < len(ts); *i++ {
+		t := ts[*i]
+		tkey := t.ikey()
+		if ikey != nil && b.s.icmp.Compare(tkey, ikey) >= 0 {
+			break
+		}
+		if t.seq <= b.minSeq && b.c.baseLevelForRange(t.start, t.limit) {
+			b.dropCnt++
+			continue
+		}
+		if err := b.appendKV(tkey, t.limit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+func (b *tableCompactionBuilder) appendRangeDels_s(ts []rangeTombstone, i *int, ikey internalKey) error {
+	for ; *i < len(ts); *i++ {
+		t := ts[*i]
+		tkey := t.ikey()
+		if ikey != nil && b.s.icmp.Compare(tkey, ikey) >= 0 {
+			break
+		}
+		if t.seq <= b.minSeq && b.c.baseLevelForRange_s(t.start, t.limit) {
+			b.dropCnt++
+			continue
+		}
+		if err := b.appendKV_s(tkey, t.limit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Consults the compaction filter on the newest value of ukey. A removed value
+// becomes a deletion marker, unless no deeper level may hold older ones.
+func (b *tableCompactionBuilder) filterKV(ikey, ukey []byte, seq uint64, value []byte, baseLevelForKey func([]byte) bool) (nkey, nvalue []byte, keep bool) {
+	decision, newValue := b.filter.Filter(b.c.sourceLevel+1, ukey, value)
+	switch decision {
+	case opt.CompactionFilterRemove:
+		b.filterRemoveCnt++
+		if baseLevelForKey(ukey) {
+			return nil, nil, false
+		}
+		return makeInternalKey(nil, ukey, seq, keyTypeDel), nil, true
+	case opt.CompactionFilterChangeValue:
+		b.filterChangeCnt++
+		return ikey, newValue, true
+	}
+	return ikey, value, true
+}
+
+// Accounts the blob record referenced by a dropped blob index as garbage.
+func (b *tableCompactionBuilder) dropBlob(index []byte) {
+	bi, err := decodeBlobIndex(index)
+	if err != nil {
+		return
+	}
+	if b.blobGarbage == nil {
+		b.blobGarbage = make(map[int64]int64)
+	}
+	b.blobGarbage[bi.num] += bi.size
+}
+
+// Returns true if the blob file of the given blob index is garbage collected,
+// i.e. its live ratio is under Options.BlobGCRatio.
+func (b *tableCompactionBuilder) relocateBlob(index []byte) bool {
+	bi, err := decodeBlobIndex(index)
+	if err != nil {
+		return false
+	}
+	f, ok := b.c.v.blobs[bi.num]
+	return ok && f.live() < b.blobGCRatio
+}
+
+// Reads the value of a blob index entry, to hand it to the compaction filter
+// or to move it out of a garbage collected blob file. The entry is kept as is
+// if the filter leaves it untouched and the blob file isn't collected.
+func (b *tableCompactionBuilder) resolveBlobKV(ikey, ukey []byte, seq uint64, index []byte, filter bool, baseLevelForKey func([]byte) bool) (nkey, nvalue []byte, keep bool, err error) {
+	value, err := b.s.tops.getBlob(index)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	b.s.tops.requestIO(len(value), ratelimit.Low)
+	relocate := b.relocateBlob(index)
+	nkey, nvalue, keep = makeInternalKey(nil, ukey, seq, keyTypeVal), value, true
+	if filter {
+		n := b.filterRemoveCnt + b.filterChangeCnt
+		nkey, nvalue, keep = b.filterKV(nkey, ukey, seq, value, baseLevelForKey)
+		if !relocate && b.filterRemoveCnt+b.filterChangeCnt == n {
+			return ikey, index, true, nil
+		}
+	}
+	b.dropBlob(index)
+	return
+}
+
+// Returns a builder per subcompaction, with the settings of b but their own
+// record and stats, see merge.
+func (b *tableCompactionBuilder) split(subs []*compaction) []*tableCompactionBuilder {
+	bs := make([]*tableCompactionBuilder, len(subs))
+	for i, c := range subs {
+		bs[i] = &tableCompactionBuilder{
+			db:        b.db,
+			s:         b.s,
+			c:         c,
+			rec:       &sessionRecord{},
+			stat0:     &cStatStaging{},
+			stat1:     &cStatStaging{},
+			minSeq:    b.minSeq,
+			strict:    b.strict,
+			tableSize: b.tableSize,
+			filter:    b.filter,
+
+			blobGCRatio: b.blobGCRatio,
+		}
+	}
+	return bs
+}
+
+// Adds the tables created by the subcompaction builders to the record of b,
+// along with their stats and counts.
+func (b *tableCompactionBuilder) merge(bs []*tableCompactionBuilder) {
+	for _, sb := range bs {
+		for _, at := range sb.rec.addedTables {
+			b.rec.addTable(at.level, at.num, at.size, at.imin, at.imax)
+		}
+		for _, at := range sb.rec.addedTabless {
+			b.rec.addTable_s(at.level, at.num, at.size, at.imin, at.imax)
+		}
+		for _, ab := range sb.rec.addedBlobs {
+			b.rec.addBlob(ab.num, ab.size, ab.umin, ab.umax)
+		}
+		for _, bg := range sb.rec.blobGarbage {
+			b.rec.addBlobGarbage(bg.num, bg.garbage)
+		}
+		if b.stat0 != nil {
+			b.stat0.write += sb.stat0.write
+		}
+		if b.stat1 != nil {
+			b.stat1.write += sb.stat1.write
+		}
+		b.kerrCnt += sb.kerrCnt
+		b.dropCnt += sb.dropCnt
+		b.filterRemoveCnt += sb.filterRemoveCnt
+		b.filterChangeCnt += sb.filterChangeCnt
+	}
+}
+
 func (b *tableCompactionBuilder) needFlush() bool {
-	return b.tw.tw.BytesLen() >= b.tableSize
+	return b.tw.tw.BytesLen() >= b.tableSize || b.tw.blobSize() >= blobFileSizeFactor*int64(b.tableSize)
 }
 
 func (b *tableCompactionBuilder) flush() error { //run逻辑
@@ -646,6 +836,11 @@ func (b *tableCompactionBuilder) flush_s() error { //run_s逻辑
 	b.rec.addTableFile_s(b.c.sourceLevel+1, t) //记录合并出来的新的sst，以及写在了哪一层level
 	b.stat0.write += t.size
 	b.s.logf("table@build created L%d@%d N·%d S·%s %q:%q", b.c.sourceLevel+1, t.fd.Num, b.tw.tw.EntriesLen(), shortenb(int(t.size)), t.imin, t.imax)
+	if bf := b.tw.blob; bf != nil {
+		b.rec.addBlobFile(bf)
+		b.stat0.write += bf.size
+		b.s.logf("table@build created blob@%d S·%s", bf.fd.Num, shortenb(int(bf.size)))
+	}
 	b.tw = nil
 	return nil
 } //应该用以另一个compaction中
@@ -665,11 +860,21 @@ func (b *tableCompactionBuilder) run(cnt *compactionTransactCounter) error {
 	lastSeq := b.snapLastSeq
 	b.kerrCnt = b.snapKerrCnt
 	b.dropCnt = b.snapDropCnt
+	b.filterRemoveCnt, b.filterChangeCnt = b.snapFilterCnt[0], b.snapFilterCnt[1]
 	// Restore compaction state.
 	b.c.restore()
 
 	defer b.cleanup()
 
+	// Range tombstones of the inputs are written out along the entries they
+	// precede; those visible to every snapshot drop the entries they cover.
+	rangeDels, err := b.c.getRangeTombstones(false)
+	if err != nil {
+		return err
+	}
+	dropDels := fragmentRangeTombstones(b.s.icmp, filterRangeTombstones(append([]rangeTombstone{}, rangeDels...), b.minSeq))
+	rdi := b.snapRangeDel
+
 	b.stat1.startTimer()
 	defer b.stat1.stopTimer()
 	//read
@@ -691,7 +896,7 @@ func (b *tableCompactionBuilder) run(cnt *compactionTransactCounter) error {
 			snapResumed = false
 		}
 
-		ikey := iter.Key()
+		ikey, value := iter.Key(), iter.Value()
 		ukey, seq, kt, kerr := parseInternalKey(ikey)
 
 		if kerr == nil {
@@ -700,8 +905,9 @@ func (b *tableCompactionBuilder) run(cnt *compactionTransactCounter) error {
 			if !hasLastUkey || b.s.icmp.uCompare(lastUkey, ukey) != 0 {
 				// First occurrence of this user key.
 
-				// Only rotate tables if ukey doesn't hop across.
-				if b.tw != nil && (shouldStop || b.needFlush()) {
+				// Only rotate tables if ukey doesn't hop across, nor does a
+				// range tombstone.
+				if b.tw != nil && (shouldStop || b.needFlush()) && b.tw.afterRangeDels(ukey) {
 					if err := b.flush(); err != nil {
 						return err
 					}
@@ -714,6 +920,8 @@ func (b *tableCompactionBuilder) run(cnt *compactionTransactCounter) error {
 					b.snapIter = i
 					b.snapKerrCnt = b.kerrCnt
 					b.snapDropCnt = b.dropCnt
+					b.snapRangeDel = rdi
+					b.snapFilterCnt = [2]int{b.filterRemoveCnt, b.filterChangeCnt}
 				}
 
 				hasLastUkey = true
@@ -721,10 +929,17 @@ func (b *tableCompactionBuilder) run(cnt *compactionTransactCounter) error {
 				lastSeq = keyMaxSeq
 			}
 
+			if err := b.appendRangeDels(rangeDels, &rdi, ikey); err != nil {
+				return err
+			}
+
 			switch {
 			case lastSeq <= b.minSeq:
 				// Dropped because newer entry for same user key exist
 				fallthrough // (A)
+			case seq < dropDels.seqAt(b.s.icmp, ukey):
+				// Covered by a range deletion visible to every snapshot.
+				fallthrough // (B)
 			case kt == keyTypeDel && seq <= b.minSeq && b.c.baseLevelForKey(lastUkey):
 				// For this user key:
 				// (1) there is no data in higher levels
@@ -737,7 +952,14 @@ func (b *tableCompactionBuilder) run(cnt *compactionTransactCounter) error {
 				b.dropCnt++
 				continue
 			default:
+				newest := lastSeq == keyMaxSeq
 				lastSeq = seq
+				if newest && kt == keyTypeVal && seq <= b.minSeq && b.filter != nil {
+					var keep bool
+					if ikey, value, keep = b.filterKV(ikey, ukey, seq, value, b.c.baseLevelForKey); !keep {
+						continue
+					}
+				}
 			}
 		} else {
 			if b.strict {
@@ -751,7 +973,7 @@ func (b *tableCompactionBuilder) run(cnt *compactionTransactCounter) error {
 			b.kerrCnt++
 		}
 		//write写操作
-		if err := b.appendKV(ikey, iter.Value()); err != nil {
+		if err := b.appendKV(ikey, value); err != nil {
 			return err
 		}
 	}
@@ -759,6 +981,9 @@ func (b *tableCompactionBuilder) run(cnt *compactionTransactCounter) error {
 	if err := iter.Error(); err != nil {
 		return err
 	}
+	if err := b.appendRangeDels(rangeDels, &rdi, nil); err != nil {
+		return err
+	}
 
 	// Finish last table.
 	if b.tw != nil && !b.tw.empty() {
@@ -774,11 +999,22 @@ func (b *tableCompactionBuilder) run_s(cnt *compactionTransactCounter) error {
 	lastSeq := b.snapLastSeq
 	b.kerrCnt = b.snapKerrCnt
 	b.dropCnt = b.snapDropCnt
+	b.filterRemoveCnt, b.filterChangeCnt = b.snapFilterCnt[0], b.snapFilterCnt[1]
+	b.blobGarbage = cloneBlobGarbage(b.snapBlobGarbage)
 	// Restore compaction state.
 	b.c.restore() //ref--
 
 	defer b.cleanup()
 
+	// Range tombstones of the inputs are written out along the entries they
+	// precede; those visible to every snapshot drop the entries they cover.
+	rangeDels, err := b.c.getRangeTombstones_s(false)
+	if err != nil {
+		return err
+	}
+	dropDels := fragmentRangeTombstones(b.s.icmp, filterRangeTombstones(append([]rangeTombstone{}, rangeDels...), b.minSeq))
+	rdi := b.snapRangeDel
+
 	b.stat0.startTimer()
 	defer b.stat0.stopTimer()
 	//read
@@ -800,7 +1036,7 @@ func (b *tableCompactionBuilder) run_s(cnt *compactionTransactCounter) error {
 			snapResumed = false
 		}
 
-		ikey := iter.Key()
+		ikey, value := iter.Key(), iter.Value()
 		ukey, seq, kt, kerr := parseInternalKey(ikey)
 
 		if kerr == nil {
@@ -809,8 +1045,9 @@ func (b *tableCompactionBuilder) run_s(cnt *compactionTransactCounter) error {
 			if !hasLastUkey || b.s.icmp.uCompare(lastUkey, ukey) != 0 {
 				// First occurrence of this user key.
 
-				// Only rotate tables if ukey doesn't hop across.
-				if b.tw != nil && (shouldStop || b.needFlush()) {
+				// Only rotate tables if ukey doesn't hop across, nor does a
+				// range tombstone.
+				if b.tw != nil && (shouldStop || b.needFlush()) && b.tw.afterRangeDels(ukey) {
 					if err := b.flush_s(); err != nil {
 						return err
 					}
@@ -823,6 +1060,9 @@ func (b *tableCompactionBuilder) run_s(cnt *compactionTransactCounter) error {
 					b.snapIter = i
 					b.snapKerrCnt = b.kerrCnt
 					b.snapDropCnt = b.dropCnt
+					b.snapRangeDel = rdi
+					b.snapFilterCnt = [2]int{b.filterRemoveCnt, b.filterChangeCnt}
+					b.snapBlobGarbage = cloneBlobGarbage(b.blobGarbage)
 				}
 
 				hasLastUkey = true
@@ -830,10 +1070,17 @@ func (b *tableCompactionBuilder) run_s(cnt *compactionTransactCounter) error {
 				lastSeq = keyMaxSeq
 			}
 
+			if err := b.appendRangeDels_s(rangeDels, &rdi, ikey); err != nil {
+				return err
+			}
+
 			switch {
 			case lastSeq <= b.minSeq:
 				// Dropped because newer entry for same user key exist
 				fallthrough // (A)
+			case seq < dropDels.seqAt(b.s.icmp, ukey):
+				// Covered by a range deletion visible to every snapshot.
+				fallthrough // (B)
 			case kt == keyTypeDel && seq <= b.minSeq && b.c.baseLevelForKey_s(lastUkey):
 				// For this user key:
 				// (1) there is no data in higher levels
@@ -844,9 +1091,27 @@ func (b *tableCompactionBuilder) run_s(cnt *compactionTransactCounter) error {
 				// Therefore this deletion marker is obsolete and can be dropped.
 				lastSeq = seq
 				b.dropCnt++
+				if kt == keyTypeBlobIndex {
+					b.dropBlob(value)
+				}
 				continue
 			default:
+				newest := lastSeq == keyMaxSeq
 				lastSeq = seq
+				filter := newest && seq <= b.minSeq && b.filter != nil
+				if kt == keyTypeBlobIndex && (filter || b.relocateBlob(value)) {
+					var keep bool
+					if ikey, value, keep, err = b.resolveBlobKV(ikey, ukey, seq, value, filter, b.c.baseLevelForKey_s); err != nil {
+						return err
+					} else if !keep {
+						continue
+					}
+				} else if filter && kt == keyTypeVal {
+					var keep bool
+					if ikey, value, keep = b.filterKV(ikey, ukey, seq, value, b.c.baseLevelForKey_s); !keep {
+						continue
+					}
+				}
 			}
 		} else {
 			if b.strict {
@@ -860,7 +1125,7 @@ func (b *tableCompactionBuilder) run_s(cnt *compactionTransactCounter) error {
 			b.kerrCnt++
 		}
 		//write写操作
-		if err := b.appendKV_s(ikey, iter.Value()); err != nil {
+		if err := b.appendKV_s(ikey, value); err != nil {
 			return err
 		}
 	}
@@ -868,13 +1133,33 @@ func (b *tableCompactionBuilder) run_s(cnt *compactionTransactCounter) error {
 	if err := iter.Error(); err != nil {
 		return err
 	}
+	if err := b.appendRangeDels_s(rangeDels, &rdi, nil); err != nil {
+		return err
+	}
 
 	// Finish last table.
 	if b.tw != nil && !b.tw.empty() {
-		return b.flush_s()
+		if err := b.flush_s(); err != nil {
+			return err
+		}
+	}
+	for num, garbage := range b.blobGarbage {
+		b.rec.addBlobGarbage(num, garbage)
 	}
 	return nil
 }
+
+func cloneBlobGarbage(m map[int64]int64) map[int64]int64 {
+	if m == nil {
+		return nil
+	}
+	c := make(map[int64]int64, len(m))
+	for num, garbage := range m {
+		c[num] = garbage
+	}
+	return c
+}
+
 func (b *tableCompactionBuilder) revert() error {
 	for _, at := range b.rec.addedTables {
 		b.s.logf("table@build revert @%d", at.num)
@@ -891,8 +1176,50 @@ func (b *tableCompactionBuilder) revert_s() error {
 			return err
 		}
 	}
+	for _, ab := range b.rec.addedBlobs {
+		b.s.logf("table@build revert blob@%d", ab.num)
+		if err := b.s.stor.Remove(storage.FileDesc{Type: storage.TypeBlob, Num: ab.num}); err != nil {
+			return err
+		}
+	}
 	return nil
 }
+
+// Runs the subcompaction builders concurrently, each within its own
+// transaction. If one exits, the tables created by the others are removed.
+func (db *DB) subcompactionTransact(name string, bs []*tableCompactionBuilder, transact func(string, compactionTransactInterface), revert func(*tableCompactionBuilder) error) {
+	var (
+		wg    sync.WaitGroup
+		exits = make([]interface{}, len(bs))
+	)
+	for i, b := range bs {
+		wg.Add(1)
+		go func(i int, b *tableCompactionBuilder) {
+			defer wg.Done()
+			defer func() {
+				exits[i] = recover()
+			}()
+			transact(fmt.Sprintf("%s#%d", name, i), b)
+		}(i, b)
+	}
+	wg.Wait()
+
+	for _, x := range exits {
+		if x == nil {
+			continue
+		}
+		if x == errCompactionTransactExiting {
+			for i, b := range bs {
+				if exits[i] == nil {
+					if err := revert(b); err != nil {
+						db.logf("%s revert error %q", name, err)
+					}
+				}
+			}
+		}
+		panic(x)
+	}
+}
 //tablecompaction的核心只有2步，build && commit。 其中build的过程db.compactionTransact(“table@build”, b)是将
 // 需要合并的表读出来，排序，写到新表，即read,sort,write 3个步骤。compactionTransact的核心在于run()，其他的都是变量定义和异常处理
 //c包含了要合并的表的信息
@@ -905,8 +1232,8 @@ func (db *DB) tableCompaction(c *compaction, noTrivial bool) {
 
 	if !noTrivial && c.trivial() {
 		t := c.levels[0][0]
-		db.logf("table@move L%d@%d -> L%d", c.sourceLevel, t.fd.Num, c.sourceLevel+1)
-		rec.delTable(c.sourceLevel, t.fd.Num)
+		db.logf("table@move L%d@%d -> L%d", c.inputLevel(0, 0), t.fd.Num, c.sourceLevel+1)
+		rec.delTable(c.inputLevel(0, 0), t.fd.Num)
 		rec.addTableFile(c.sourceLevel+1, t)
 		db.compactionCommit("table-move", rec)
 		return
@@ -914,14 +1241,17 @@ func (db *DB) tableCompaction(c *compaction, noTrivial bool) {
 
 	var stats [2]cStatStaging
 	for i, tables := range c.levels { //遍历levels中所有的tfile得到第一层的tfile和第二层的tfile？？？？
-		for _, t := range tables {
+		for j, t := range tables {
 			stats[i].read += t.size
 			// Insert deleted tables into record
-			rec.delTable(c.sourceLevel+i, t.fd.Num)
+			rec.delTable(c.inputLevel(i, j), t.fd.Num)
 		}
 	}
 	sourceSize := int(stats[0].read + stats[1].read)
 	minSeq := db.minSeq()
+	for _, t := range c.dropCoveredTables(minSeq) {
+		db.logf("table@compaction L%d@%d covered by range deletion", c.sourceLevel+1, t.fd.Num)
+	}
 	db.logf("table@compaction L%d·%d -> L%d·%d S·%s Q·%d", c.sourceLevel, len(c.levels[0]), c.sourceLevel+1, len(c.levels[1]), shortenb(sourceSize), minSeq)
 
 	b := &tableCompactionBuilder{
@@ -933,9 +1263,23 @@ func (db *DB) tableCompaction(c *compaction, noTrivial bool) {
 		minSeq:    minSeq,
 		strict:    db.s.o.GetStrict(opt.StrictCompaction),
 		tableSize: db.s.o.GetCompactionTableSize(c.sourceLevel + 1),
+		filter:    db.s.o.GetCompactionFilter(opt.PrimaryTree),
 	}
 	//将需要合并的表读出来，排序，写到新表
-	db.compactionTransact("table@build", b)
+	if subs := c.split(db.s.o.GetMaxSubcompactions()); subs != nil {
+		db.logf("table@compaction split into %d subcompactions", len(subs))
+		bs := b.split(subs)
+		stats[1].startTimer()
+		db.subcompactionTransact("table@build", bs, db.compactionTransact, (*tableCompactionBuilder).revert)
+		stats[1].stopTimer()
+		b.merge(bs)
+	} else {
+		db.compactionTransact("table@build", b)
+	}
+	stats[1].filterRemoved, stats[1].filterChanged = int64(b.filterRemoveCnt), int64(b.filterChangeCnt)
+	if b.filter != nil {
+		db.logf("table@compaction filter %s removed·%d changed·%d", b.filter.Name(), b.filterRemoveCnt, b.filterChangeCnt)
+	}
 
 	// Commit.提交，主要是写入version和manifest
 	stats[1].startTimer()
@@ -962,12 +1306,14 @@ func (db *DB) tableCompaction_s(c *compaction, noTrivial bool) {
 	defer c.release()
 	//fmt.Println("执行tableCompaction_s")
 	rec := &sessionRecord{}
-	rec.addCompPtr_s(c.sourceLevel, c.imax) //这里是每次合并的断点？
+	if c.imax != nil {
+		rec.addCompPtr_s(c.sourceLevel, c.imax) //这里是每次合并的断点？
+	}
 
 	if !noTrivial && c.trivial_s() {
 		t := c.level_s[0][0]//合并的那一层的第一个sfile？
-		db.logf("table@move L%d@%d -> L%d", c.sourceLevel, t.fd.Num, c.sourceLevel+1)
-		rec.delTable_s(c.sourceLevel, t.fd.Num)
+		db.logf("table@move L%d@%d -> L%d", c.inputLevel(0, 0), t.fd.Num, c.sourceLevel+1)
+		rec.delTable_s(c.inputLevel(0, 0), t.fd.Num)
 		rec.addTableFile_s(c.sourceLevel+1, t)
 		db.compactionCommit_s("table-move", rec)
 		return
@@ -975,14 +1321,20 @@ func (db *DB) tableCompaction_s(c *compaction, noTrivial bool) {
 
 	var stats [2]cStatStaging
 	for i, tables := range c.level_s {
-		for _, t := range tables {
+		for j, t := range tables {
 			stats[i].read += t.size
 			// Insert deleted tables into record,~~~~i取值0、1,把要删除的两层的文件记录，放入deletedtabless中
-			rec.delTable_s(c.sourceLevel+i, t.fd.Num)
+			rec.delTable_s(c.inputLevel(i, j), t.fd.Num)
 		}
 	}
 	sourceSize := int(stats[0].read + stats[1].read)
 	minSeq := db.minSeq()
+	for _, t := range c.dropCoveredTables_s(minSeq) {
+		db.logf("table@compaction L%d@%d covered by range deletion", c.sourceLevel+1, t.fd.Num)
+		if len(c.v.blobs) > 0 {
+			db.dropTableBlobs_s(rec, t)
+		}
+	}
 	db.logf("table@compaction L%d·%d -> L%d·%d S·%s Q·%d", c.sourceLevel, len(c.level_s[0]), c.sourceLevel+1, len(c.level_s[1]), shortenb(sourceSize), minSeq)
 
 	b := &tableCompactionBuilder{
@@ -994,9 +1346,25 @@ func (db *DB) tableCompaction_s(c *compaction, noTrivial bool) {
 		minSeq:    minSeq,
 		strict:    db.s.o.GetStrict(opt.StrictCompaction),
 		tableSize: db.s.o.GetCompactionTableSize(c.sourceLevel + 1),
+		filter:    db.s.o.GetCompactionFilter(opt.SecondaryTree),
+
+		blobGCRatio: db.s.o.GetBlobGCRatio(),
 	}
 	//将需要合并的表读出来，排序，写到新表,这是build的重点
-	db.compactionTransact_s("table@build", b)//addedtabless应该是记录新的sfiles了
+	if subs := c.split_s(db.s.o.GetMaxSubcompactions()); subs != nil {
+		db.logf("table@compaction split into %d subcompactions", len(subs))
+		bs := b.split(subs)
+		stats[1].startTimer()
+		db.subcompactionTransact("table@build", bs, db.compactionTransact_s, (*tableCompactionBuilder).revert_s)
+		stats[1].stopTimer()
+		b.merge(bs)
+	} else {
+		db.compactionTransact_s("table@build", b) //addedtabless应该是记录新的sfiles了
+	}
+	stats[1].filterRemoved, stats[1].filterChanged = int64(b.filterRemoveCnt), int64(b.filterChangeCnt)
+	if b.filter != nil {
+		db.logf("table@compaction filter %s removed·%d changed·%d", b.filter.Name(), b.filterRemoveCnt, b.filterChangeCnt)
+	}
 
 	// Commit.提交
 	stats[1].startTimer()
@@ -1008,7 +1376,7 @@ func (db *DB) tableCompaction_s(c *compaction, noTrivial bool) {
 
 	// Save compaction stats
 	for i := range stats {
-		db.compStats.addStat(c.sourceLevel+1, &stats[i])
+		db.comStatss.addStat(c.sourceLevel+1, &stats[i])
 	}
 	switch c.typ {
 	case level0Compaction:
@@ -1020,6 +1388,80 @@ func (db *DB) tableCompaction_s(c *compaction, noTrivial bool) {
 	}
 }
 
+// Records as garbage the blob records referenced by a table dropped by a
+// compaction without being read, see compaction.dropCoveredTables_s. A
+// failure to read the table is logged, its blob records are then kept.
+func (db *DB) dropTableBlobs_s(rec *sessionRecord, t *sFile) {
+	garbage := make(map[int64]int64)
+	iter := db.s.tops.newIterator_s(t, nil, &opt.ReadOptions{DontFillCache: true})
+	for iter.Next() {
+		if _, _, kt, kerr := parseInternalKey(iter.Key()); kerr == nil && kt == keyTypeBlobIndex {
+			if bi, err := decodeBlobIndex(iter.Value()); err == nil {
+				garbage[bi.num] += bi.size
+			}
+		}
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		db.logf("table@compaction reading blob references of @%d %q", t.fd.Num, err)
+		return
+	}
+	for num, g := range garbage {
+		rec.addBlobGarbage(num, g)
+	}
+}
+
+// Garbage collects the blob file with the lowest live ratio under
+// Options.BlobGCRatio: the tables overlapping its key range are compacted,
+// in place below level-0, so that the values it still holds are moved to new
+// blob files and it gets dropped. A blob file that survives a collection,
+// e.g. because its values are still pinned by a snapshot, is retried once its
+// live ratio drops below the one seen at the last attempt.
+func (db *DB) blobGC_s() {
+	ratio := db.s.o.GetBlobGCRatio()
+	if ratio <= 0 {
+		return
+	}
+	v := db.s.version()
+	var f *bFile
+	// Only blob files still in the version are carried over.
+	tried := make(map[int64]float64, len(db.blobGCTried))
+	for _, b := range v.blobs {
+		live := b.live()
+		if last, ok := db.blobGCTried[b.fd.Num]; ok {
+			tried[b.fd.Num] = last
+			if live >= last {
+				continue
+			}
+		}
+		if live >= ratio {
+			continue
+		}
+		if f == nil || live < f.live() {
+			f = b
+		}
+	}
+	v.release()
+	db.blobGCTried = tried
+	if f == nil {
+		return
+	}
+	db.blobGCTried[f.fd.Num] = f.live()
+
+	db.logf("blob@gc @%d L·%.2f %q:%q", f.fd.Num, f.live(), f.umin, f.umax)
+	if c := db.s.getCompactionRange_s(0, f.umin, f.umax, true); c != nil {
+		db.tableCompaction_s(c, true)
+	}
+	v = db.s.version()
+	numLevel := len(v.level_s)
+	v.release()
+	for level := 1; level < numLevel; level++ {
+		if c := db.s.getBlobCompaction_s(level, f.umin, f.umax); c != nil {
+			db.tableCompaction_s(c, true)
+		}
+	}
+}
+
 func (db *DB) tableRangeCompaction(level int, umin, umax []byte) error {
 	db.logf("table@compaction range L%d %q:%q", level, umin, umax)
 	if level >= 0 {
@@ -1105,6 +1547,8 @@ func (db *DB) tableAutoCompaction_s() {
 	//fmt.Println("This is tableAutoCompaction_s")
 	if c := db.s.pickCompaction_s(); c != nil {
 		db.tableCompaction_s(c, false)
+	} else {
+		db.blobGC_s()
 	}
 }
 
@@ -1386,6 +1830,8 @@ func (db *DB) tCompaction() { //一定会执行tableAutocompaction
 				}
 			case cRange:
 				x.ack(db.tableRangeCompaction(cmd.level, cmd.min, cmd.max))
+			case cIngest:
+				x.ack(db.ingestTables(cmd.files, cmd.seq))
 			default:
 				panic("leveldb: unknown command")
 			}
@@ -1463,6 +1909,8 @@ func (db *DB) tCompaction_s() {
 				}
 			case cRange:
 				x.ack(db.tableRangeCompaction_s(cmd.level, cmd.min, cmd.max))
+			case cIngest:
+				x.ack(db.ingestTables_s(cmd.files, cmd.seq))
 			default:
 				panic("leveldb: unknown command")
 			}
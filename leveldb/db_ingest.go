@@ -0,0 +1,437 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/rev3z/ledger_base/leveldb/errors"
+	"github.com/rev3z/ledger_base/leveldb/iterator"
+	"github.com/rev3z/ledger_base/leveldb/opt"
+	"github.com/rev3z/ledger_base/leveldb/storage"
+	"github.com/rev3z/ledger_base/leveldb/table"
+)
+
+// External table errors.
+var (
+	ErrSSTWriterOrder  = errors.New("leveldb: SSTWriter: keys must be added in strictly increasing order")
+	ErrSSTWriterClosed = errors.New("leveldb: SSTWriter: already closed")
+	ErrIngestOverlap   = errors.New("leveldb: ingested files overlap each other")
+	ErrIngestInvalid   = errors.New("leveldb: ingested file is not an external table")
+)
+
+// Ingested tables are placed at the lowest level, not below this one, whose
+// tables and the ones of the levels above don't overlap with them.
+const ingestMaxLevel = optCachedLevel - 1
+
+// SSTWriter builds a table file outside of a DB, to be loaded with
+// DB.IngestExternalFiles. The keys must be added in strictly increasing
+// order, according to the comparer of the options; the options should match
+// the ones of the DB the file is ingested into.
+//
+// The entries are written with a zero sequence number, ingestion assigns
+// them a global one.
+//
+// SSTWriter is not safe for concurrent use.
+type SSTWriter struct {
+	tw        *table.Writer
+	icmp      *iComparer
+	ukey      []byte
+	ikScratch []byte
+	closed    bool
+}
+
+// NewSSTWriter creates a new SSTWriter writing the table to w. The w is not
+// closed by the SSTWriter.
+func NewSSTWriter(w io.Writer, o *opt.Options) *SSTWriter {
+	no := internalOptions(o)
+	return &SSTWriter{
+		tw:   table.NewWriter(w, no),
+		icmp: no.Comparer.(*iComparer),
+	}
+}
+
+func (w *SSTWriter) append(kt keyType, key, value []byte) error {
+	if w.closed {
+		return ErrSSTWriterClosed
+	}
+	if w.ukey != nil && w.icmp.uCompare(key, w.ukey) <= 0 {
+		return ErrSSTWriterOrder
+	}
+	w.ikScratch = makeInternalKey(w.ikScratch, key, 0, kt)
+	if err := w.tw.Append(w.ikScratch, value); err != nil {
+		return err
+	}
+	w.ukey = append(w.ukey[:0], key...)
+	return nil
+}
+
+// Put appends the given key/value pair to the table.
+//
+// It is safe to modify the contents of the arguments after Put returns.
+func (w *SSTWriter) Put(key, value []byte) error {
+	return w.append(keyTypeVal, key, value)
+}
+
+// Delete appends a deletion marker of the given key to the table, it
+// deletes the older entries of the key when ingested.
+//
+// It is safe to modify the contents of the arguments after Delete returns.
+func (w *SSTWriter) Delete(key []byte) error {
+	return w.append(keyTypeDel, key, nil)
+}
+
+// EntriesLen returns number of entries added so far.
+func (w *SSTWriter) EntriesLen() int {
+	return w.tw.EntriesLen()
+}
+
+// BytesLen returns number of bytes written so far.
+func (w *SSTWriter) BytesLen() int {
+	return w.tw.BytesLen()
+}
+
+// Close finalizes the table. A table without entries can't be ingested.
+func (w *SSTWriter) Close() error {
+	if w.closed {
+		return ErrSSTWriterClosed
+	}
+	w.closed = true
+	return w.tw.Close()
+}
+
+// Returns a copy of the internal key ikey with the sequence number replaced.
+func withSeq(dst, ikey []byte, seq uint64) []byte {
+	dst = append(dst[:0], ikey...)
+	if n := len(dst) - 8; n >= 0 {
+		_, kt := internalKey(ikey).parseNum()
+		binary.LittleEndian.PutUint64(dst[n:], (seq<<8)|uint64(kt))
+	}
+	return dst
+}
+
+// seqIterator reads the entries of an ingested table as having its global
+// sequence number.
+type seqIterator struct {
+	iterator.Iterator
+	seq uint64
+	buf []byte
+}
+
+func (i *seqIterator) Key() []byte {
+	key := i.Iterator.Key()
+	if key == nil {
+		return nil
+	}
+	i.buf = withSeq(i.buf, key, i.seq)
+	return i.buf
+}
+
+func (i *seqIterator) Seek(key []byte) bool {
+	if !i.Iterator.Seek(key) {
+		return false
+	}
+	// The entry of the sought user key sorts before key if its global
+	// sequence number is newer.
+	if ukey, seq, _, err := parseInternalKey(key); err == nil && seq < i.seq {
+		if fkey := internalKey(i.Iterator.Key()); len(fkey) >= 8 && bytes.Equal(fkey.ukey(), ukey) {
+			return i.Iterator.Next()
+		}
+	}
+	return true
+}
+
+// ingestFile is an external table copied into the DB.
+type ingestFile struct {
+	fd         storage.FileDesc
+	size       int64
+	umin, umax []byte
+	kmin, kmax keyType
+}
+
+// cIngest asks the table compaction goroutine to add ingested tables.
+type cIngest struct {
+	files []ingestFile
+	seq   uint64
+	ackC  chan<- error
+}
+
+func (r cIngest) ack(err error) {
+	if r.ackC != nil {
+		defer func() {
+			recover()
+		}()
+		r.ackC <- err
+	}
+}
+
+// Send ingestion request.
+func (db *DB) compTriggerIngest(compC chan<- cCmd, files []ingestFile, seq uint64) (err error) {
+	ch := make(chan error)
+	defer close(ch)
+	// Send cmd.
+	select {
+	case compC <- cIngest{files, seq, ch}:
+	case err := <-db.compErrC:
+		return err
+	case <-db.closeC:
+		return ErrClosed
+	}
+	// Wait cmd.
+	select {
+	case err = <-ch:
+	case err = <-db.compErrC:
+	case <-db.closeC:
+		return ErrClosed
+	}
+	return err
+}
+
+// Copies the external table at path into the DB and reads its key range.
+func (db *DB) importTable(path string, tree opt.Tree) (f ingestFile, err error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer src.Close()
+
+	f.fd = storage.FileDesc{Type: storage.TypeTable, Num: db.s.allocFileNum()}
+	var w storage.Writer
+	if tree == opt.SecondaryTree {
+		w, err = db.s.stor.Create_s(f.fd)
+	} else {
+		w, err = db.s.stor.Create(f.fd)
+	}
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			db.s.stor.Remove(f.fd)
+			db.s.reuseFileNum(f.fd.Num)
+		}
+	}()
+	f.size, err = io.Copy(w, src)
+	if err == nil && !db.s.o.GetNoSync() {
+		err = w.Sync()
+	}
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return
+	}
+
+	r, err := db.s.stor.Open(f.fd)
+	if err != nil {
+		return
+	}
+	tr, err := table.NewReader(r, f.size, f.fd, nil, db.s.tops.bpool, db.s.o.Options)
+	if err != nil {
+		r.Close()
+		return
+	}
+	defer tr.Release() // Closes r.
+	iter := tr.NewIterator(nil, &opt.ReadOptions{DontFillCache: true, Strict: opt.StrictAll})
+	defer iter.Release()
+	if !iter.First() {
+		if err = iter.Error(); err == nil {
+			err = ErrIngestInvalid
+		}
+		return
+	}
+	if f.umin, f.kmin, err = parseExternalKey(iter.Key()); err != nil {
+		return
+	}
+	if !iter.Last() {
+		if err = iter.Error(); err == nil {
+			err = ErrIngestInvalid
+		}
+		return
+	}
+	f.umax, f.kmax, err = parseExternalKey(iter.Key())
+	return
+}
+
+// Parses a key written by SSTWriter, returning a copy of its user key.
+func parseExternalKey(ik []byte) (ukey []byte, kt keyType, err error) {
+	ukey, seq, kt, err := parseInternalKey(ik)
+	if err != nil || seq != 0 || (kt != keyTypeVal && kt != keyTypeDel) {
+		return nil, 0, ErrIngestInvalid
+	}
+	return append([]byte{}, ukey...), kt, nil
+}
+
+// Reports whether any of the range deletions of a memdb overlaps with the
+// given user key range.
+func isRangeDelOverlaps(icmp *iComparer, iter iterator.Iterator, umin, umax []byte) bool {
+	defer iter.Release()
+	for iter.Next() {
+		if icmp.uCompare(internalKey(iter.Key()).ukey(), umax) <= 0 && icmp.uCompare(iter.Value(), umin) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// IngestExternalFiles loads the tables built by SSTWriter at the given paths
+// into the given tree of the DB. The files are copied, they're left in place.
+//
+// The entries of all the files get a single new sequence number, so they
+// shadow the older entries of their keys, and the files must not overlap
+// each other. Each table is placed at the lowest level whose tables and the
+// ones of the levels above don't overlap with it; the memdb is flushed
+// first if it holds entries within the ingested key range.
+//
+// Ingestion is atomic: either all the files are added to the DB or none.
+func (db *DB) IngestExternalFiles(paths []string, tree opt.Tree) (err error) {
+	if err = db.ok(); err != nil {
+		return
+	}
+	if len(paths) == 0 {
+		return
+	}
+
+	files := make([]ingestFile, 0, len(paths))
+	defer func() {
+		if err != nil {
+			for _, f := range files {
+				db.s.stor.Remove(f.fd)
+				db.s.reuseFileNum(f.fd.Num)
+			}
+		}
+	}()
+	for _, path := range paths {
+		var f ingestFile
+		if f, err = db.importTable(path, tree); err != nil {
+			return
+		}
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return db.s.icmp.uCompare(files[i].umin, files[j].umin) < 0
+	})
+	for i := 1; i < len(files); i++ {
+		if db.s.icmp.uCompare(files[i-1].umax, files[i].umin) >= 0 {
+			return ErrIngestOverlap
+		}
+	}
+	umin, umax := files[0].umin, files[len(files)-1].umax
+
+	// Lock writer.
+	select {
+	case db.writeLockC <- struct{}{}:
+	case err = <-db.compPerErrC:
+		return
+	case <-db.closeC:
+		return ErrClosed
+	}
+	defer func() { <-db.writeLockC }()
+
+	// The memdb entries within the key range are older than the ingested
+	// ones, but would shadow them.
+	mcompC, tcompC := db.mcompCmdC, db.tcompCmdC
+	if tree == opt.SecondaryTree {
+		mcompC, tcompC = db.mcompCmdCs, db.tcompCmdCs
+	}
+	if err = db.compTriggerWait(mcompC); err != nil {
+		return
+	}
+	var shadowing bool
+	if tree == opt.SecondaryTree {
+		mdb := db.getEffectiveMem_s()
+		if mdb == nil {
+			return ErrClosed
+		}
+		shadowing = isMemOverlaps_s(db.s.icmp, mdb.DBs, umin, umax) ||
+			isRangeDelOverlaps(db.s.icmp, mdb.DBs.NewRangeDelIterator_s(), umin, umax)
+		mdb.decref_s()
+		if shadowing {
+			_, err = db.rotateMem_s(0, true)
+		}
+	} else {
+		mdb := db.getEffectiveMem()
+		if mdb == nil {
+			return ErrClosed
+		}
+		shadowing = isMemOverlaps(db.s.icmp, mdb.DB, umin, umax) ||
+			isRangeDelOverlaps(db.s.icmp, mdb.DB.NewRangeDelIterator(), umin, umax)
+		mdb.decref()
+		if shadowing {
+			_, err = db.rotateMem(0, true)
+		}
+	}
+	if err != nil {
+		return
+	}
+
+	// The tables are added by the table compaction goroutine, so no running
+	// compaction commits tables overlapping them.
+	seq := db.seq + 1
+	if err = db.compTriggerIngest(tcompC, files, seq); err != nil {
+		return
+	}
+	db.setSeq(seq)
+	return
+}
+
+// Adds the ingested tables to the primary tree; must be called by the table
+// compaction goroutine.
+func (db *DB) ingestTables(files []ingestFile, seq uint64) error {
+	rec := &sessionRecord{}
+	v := db.s.version()
+	for _, f := range files {
+		level := v.pickMemdbLevel(f.umin, f.umax, ingestMaxLevel)
+		imin := makeInternalKey(nil, f.umin, seq, f.kmin)
+		imax := makeInternalKey(nil, f.umax, seq, f.kmax)
+		rec.addTableSeq(level, f.fd.Num, f.size, seq, imin, imax)
+		db.logf("table@ingest L%d@%d S·%s Q·%d %q:%q", level, f.fd.Num, shortenb(int(f.size)), seq, f.umin, f.umax)
+	}
+	v.release()
+	rec.setSeqNum(seq)
+
+	db.compCommitLk.Lock()
+	defer db.compCommitLk.Unlock()
+	if err := db.s.commit(rec, false); err != nil {
+		db.logf("table@ingest commit error %q", err)
+		return err
+	}
+	for _, r := range rec.addedTables {
+		db.compStats.addStat(r.level, &cStatStaging{write: r.size})
+	}
+	return nil
+}
+
+// Adds the ingested tables to the secondary tree, see ingestTables.
+func (db *DB) ingestTables_s(files []ingestFile, seq uint64) error {
+	rec := &sessionRecord{}
+	v := db.s.version()
+	for _, f := range files {
+		level := v.pickMemdbLevel_s(f.umin, f.umax, ingestMaxLevel)
+		imin := makeInternalKey(nil, f.umin, seq, f.kmin)
+		imax := makeInternalKey(nil, f.umax, seq, f.kmax)
+		rec.addTableSeq_s(level, f.fd.Num, f.size, seq, imin, imax)
+		db.logf("table@ingest L%d@%d S·%s Q·%d %q:%q", level, f.fd.Num, shortenb(int(f.size)), seq, f.umin, f.umax)
+	}
+	v.release()
+	rec.setSeqNum(seq)
+
+	db.compCommitLk.Lock()
+	defer db.compCommitLk.Unlock()
+	if err := db.s.commit(rec, false); err != nil {
+		db.logf("table@ingest commit error %q", err)
+		return err
+	}
+	for _, r := range rec.addedTabless {
+		db.comStatss.addStat(r.level, &cStatStaging{write: r.size})
+	}
+	return nil
+}
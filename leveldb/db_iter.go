@@ -7,6 +7,7 @@
 package leveldb
 
 import (
+	"bytes"
 	"errors"
 	"math/rand"
 	"runtime"
@@ -33,12 +34,23 @@ func (mr *memdbReleaser) Release() {
 	})
 }
 
-func (db *DB) newRawIterator(auxm *memDB, auxt tFiles, slice *util.Range, ro *opt.ReadOptions) iterator.Iterator {
+type memdbReleaser_s struct {
+	once sync.Once
+	m    *memDB
+}
+
+func (mr *memdbReleaser_s) Release() {
+	mr.once.Do(func() {
+		mr.m.decref_s()
+	})
+}
+
+func (db *DB) newRawIterator(auxm *memDB, auxt tFiles, slice *util.Range, prefix []byte, ro *opt.ReadOptions) iterator.Iterator {
 	strict := opt.GetStrict(db.s.o.Options, ro, opt.StrictReader)
 	em, fm := db.getMems()
 	v := db.s.version()
 
-	tableIts := v.getIterators(slice, ro)
+	tableIts := v.getIterators(slice, prefix, ro)
 	n := len(tableIts) + len(auxt) + 3
 	its := make([]iterator.Iterator, 0, n)
 
@@ -65,6 +77,131 @@ func (db *DB) newRawIterator(auxm *memDB, auxt tFiles, slice *util.Range, ro *op
 	return mi
 }
 
+func (db *DB) newRawIterator_s(auxm *memDB, auxt sFiles, slice *util.Range, prefix []byte, ro *opt.ReadOptions) iterator.Iterator {
+	strict := opt.GetStrict(db.s.o.Options, ro, opt.StrictReader)
+	em, fm := db.getMems_s()
+	v := db.s.version()
+
+	tableIts := v.getIterators_s(slice, prefix, ro)
+	n := len(tableIts) + len(auxt) + 3
+	its := make([]iterator.Iterator, 0, n)
+
+	if auxm != nil {
+		ami := auxm.NewIterator_s(slice)
+		ami.SetReleaser(&memdbReleaser_s{m: auxm})
+		its = append(its, ami)
+	}
+	for _, t := range auxt {
+		its = append(its, v.s.tops.newIterator_s(t, slice, ro))
+	}
+
+	emi := em.NewIterator_s(slice)
+	emi.SetReleaser(&memdbReleaser_s{m: em})
+	its = append(its, emi)
+	if fm != nil {
+		fmi := fm.NewIterator_s(slice)
+		fmi.SetReleaser(&memdbReleaser_s{m: fm})
+		its = append(its, fmi)
+	}
+	its = append(its, tableIts...)
+	mi := iterator.NewMergedIterator(its, db.s.icmp, strict)
+	mi.SetReleaser(&versionReleaser{v: v})
+	return mi
+}
+
+// Returns the range tombstones visible at seq which may cover keys of the
+// given user key range, fragmented for lookup by dbIter.
+func (db *DB) getRangeTombstones(auxm *memDB, auxt tFiles, slice *util.Range, seq uint64) (rangeTombstones, error) {
+	var (
+		ts  []rangeTombstone
+		err error
+	)
+	if auxm != nil {
+		if ts, err = readRangeTombstones(ts, auxm.NewRangeDelIterator(), seq); err != nil {
+			return nil, err
+		}
+	}
+	for _, t := range auxt {
+		if ts, err = readRangeTombstones(ts, db.s.tops.newRangeDelIterator(t), seq); err != nil {
+			return nil, err
+		}
+	}
+	em, fm := db.getMems()
+	for _, m := range [...]*memDB{em, fm} {
+		if m == nil {
+			continue
+		}
+		defer m.decref()
+		if ts, err = readRangeTombstones(ts, m.NewRangeDelIterator(), seq); err != nil {
+			return nil, err
+		}
+	}
+	v := db.s.version()
+	defer v.release()
+	var umin, umax []byte
+	if slice != nil {
+		umin, umax = slice.Start, slice.Limit
+	}
+	if ts, err = v.getRangeTombstones(ts, umin, umax, seq); err != nil {
+		return nil, err
+	}
+	return fragmentRangeTombstones(db.s.icmp, ts), nil
+}
+
+func (db *DB) getRangeTombstones_s(auxm *memDB, auxt sFiles, slice *util.Range, seq uint64) (rangeTombstones, error) {
+	var (
+		ts  []rangeTombstone
+		err error
+	)
+	if auxm != nil {
+		if ts, err = readRangeTombstones(ts, auxm.NewRangeDelIterator_s(), seq); err != nil {
+			return nil, err
+		}
+	}
+	for _, t := range auxt {
+		if ts, err = readRangeTombstones(ts, db.s.tops.newRangeDelIterator_s(t), seq); err != nil {
+			return nil, err
+		}
+	}
+	em, fm := db.getMems_s()
+	for _, m := range [...]*memDB{em, fm} {
+		if m == nil {
+			continue
+		}
+		defer m.decref_s()
+		if ts, err = readRangeTombstones(ts, m.NewRangeDelIterator_s(), seq); err != nil {
+			return nil, err
+		}
+	}
+	v := db.s.version()
+	defer v.release()
+	var umin, umax []byte
+	if slice != nil {
+		umin, umax = slice.Start, slice.Limit
+	}
+	if ts, err = v.getRangeTombstones_s(ts, umin, umax, seq); err != nil {
+		return nil, err
+	}
+	return fragmentRangeTombstones(db.s.icmp, ts), nil
+}
+
+// Returns the prefix all the keys of slice share if slice is a prefix range,
+// as built by util.BytesPrefix, and prefix filters are enabled; nil
+// otherwise.
+func (db *DB) slicePrefix(slice *util.Range) []byte {
+	pe := db.s.o.GetPrefixExtractor()
+	if pe == nil || db.s.o.GetFilter() == nil || slice == nil || slice.Start == nil {
+		return nil
+	}
+	if !bytes.Equal(util.BytesPrefix(slice.Start).Limit, slice.Limit) {
+		return nil
+	}
+	if prefix, ok := pe.Prefix(slice.Start); ok {
+		return prefix
+	}
+	return nil
+}
+
 func (db *DB) newIterator(auxm *memDB, auxt tFiles, seq uint64, slice *util.Range, ro *opt.ReadOptions) *dbIter {
 	var islice *util.Range
 	if slice != nil {
@@ -76,20 +213,65 @@ func (db *DB) newIterator(auxm *memDB, auxt tFiles, seq uint64, slice *util.Rang
 			islice.Limit = makeInternalKey(nil, slice.Limit, keyMaxSeq, keyTypeSeek)
 		}
 	}
-	rawIter := db.newRawIterator(auxm, auxt, islice, ro)
+	// Tombstones are collected before the raw iterator pins its version;
+	// one compacted away in between takes the entries it covers along.
+	rangeDels, rderr := db.getRangeTombstones(auxm, auxt, slice, seq)
+	rawIter := db.newRawIterator(auxm, auxt, islice, db.slicePrefix(slice), ro)
+	iter := &dbIter{
+		db:              db,
+		icmp:            db.s.icmp,
+		iter:            rawIter,
+		seq:             seq,
+		rangeDels:       rangeDels,
+		strict:          opt.GetStrict(db.s.o.Options, ro, opt.StrictReader),
+		disableSampling: db.s.o.GetDisableSeeksCompaction() || db.s.o.GetIteratorSamplingRate() <= 0,
+		key:             make([]byte, 0),
+		value:           make([]byte, 0),
+	}
+	if !iter.disableSampling {
+		iter.samplingGap = db.iterSamplingRate()
+	}
+	if rderr != nil {
+		iter.setErr(rderr)
+	}
+	atomic.AddInt32(&db.aliveIters, 1)
+	runtime.SetFinalizer(iter, (*dbIter).Release)
+	return iter
+}
+
+func (db *DB) newIterator_s(auxm *memDB, auxt sFiles, seq uint64, slice *util.Range, ro *opt.ReadOptions) *dbIter {
+	var islice *util.Range
+	if slice != nil {
+		islice = &util.Range{}
+		if slice.Start != nil {
+			islice.Start = makeInternalKey(nil, slice.Start, keyMaxSeq, keyTypeSeek)
+		}
+		if slice.Limit != nil {
+			islice.Limit = makeInternalKey(nil, slice.Limit, keyMaxSeq, keyTypeSeek)
+		}
+	}
+	// Tombstones are collected before the raw iterator pins its version;
+	// one compacted away in between takes the entries it covers along.
+	rangeDels, rderr := db.getRangeTombstones_s(auxm, auxt, slice, seq)
+	rawIter := db.newRawIterator_s(auxm, auxt, islice, db.slicePrefix(slice), ro)
 	iter := &dbIter{
 		db:              db,
 		icmp:            db.s.icmp,
 		iter:            rawIter,
 		seq:             seq,
+		rangeDels:       rangeDels,
 		strict:          opt.GetStrict(db.s.o.Options, ro, opt.StrictReader),
 		disableSampling: db.s.o.GetDisableSeeksCompaction() || db.s.o.GetIteratorSamplingRate() <= 0,
+		secondary:       true,
 		key:             make([]byte, 0),
 		value:           make([]byte, 0),
 	}
 	if !iter.disableSampling {
 		iter.samplingGap = db.iterSamplingRate()
 	}
+	if rderr != nil {
+		iter.setErr(rderr)
+	}
 	atomic.AddInt32(&db.aliveIters, 1)
 	runtime.SetFinalizer(iter, (*dbIter).Release)
 	return iter
@@ -115,8 +297,10 @@ type dbIter struct {
 	icmp            *iComparer
 	iter            iterator.Iterator
 	seq             uint64
+	rangeDels       rangeTombstones // range deletions visible at seq
 	strict          bool
 	disableSampling bool
+	secondary       bool // iterates the secondary (_s) tree
 
 	samplingGap int
 	dir         dir
@@ -135,7 +319,11 @@ func (i *dbIter) sampleSeek() {
 	i.samplingGap -= len(ikey) + len(i.iter.Value())
 	for i.samplingGap < 0 {
 		i.samplingGap += i.db.iterSamplingRate()
-		i.db.sampleSeek(ikey)
+		if i.secondary {
+			i.db.sampleSeek_s(ikey)
+		} else {
+			i.db.sampleSeek(ikey)
+		}
 	}
 }
 
@@ -216,12 +404,18 @@ func (i *dbIter) next() bool {
 					// Skip deleted key.
 					i.key = append(i.key[:0], ukey...)
 					i.dir = dirForward
-				case keyTypeVal:
+				case keyTypeVal, keyTypeBlobIndex:
 					if i.dir == dirSOI || i.icmp.uCompare(ukey, i.key) > 0 {
+						if seq < i.rangeDels.seqAt(i.icmp, ukey) {
+							// Skip key deleted by a range deletion.
+							i.key = append(i.key[:0], ukey...)
+							i.dir = dirForward
+							break
+						}
 						i.key = append(i.key[:0], ukey...)
 						i.value = append(i.value[:0], i.iter.Value()...)
 						i.dir = dirForward
-						return true
+						return i.resolveValue(kt)
 					}
 				}
 			}
@@ -257,18 +451,21 @@ func (i *dbIter) Next() bool {
 func (i *dbIter) prev() bool {
 	i.dir = dirBackward
 	del := true
+	var vkt keyType
 	if i.iter.Valid() {
 		for {
 			if ukey, seq, kt, kerr := parseInternalKey(i.iter.Key()); kerr == nil {
 				i.sampleSeek()
-				if seq <= i.seq {
+				// Range deletions are accounted for by rangeDels.
+				if seq <= i.seq && kt != keyTypeRangeDel {
 					if !del && i.icmp.uCompare(ukey, i.key) < 0 {
-						return true
+						return i.resolveValue(vkt)
 					}
-					del = (kt == keyTypeDel)
+					del = (kt == keyTypeDel) || seq < i.rangeDels.seqAt(i.icmp, ukey)
 					if !del {
 						i.key = append(i.key[:0], ukey...)
 						i.value = append(i.value[:0], i.iter.Value()...)
+						vkt = kt
 					}
 				}
 			} else if i.strict {
@@ -285,6 +482,20 @@ func (i *dbIter) prev() bool {
 		i.iterErr()
 		return false
 	}
+	return i.resolveValue(vkt)
+}
+
+// Replaces the current value by the one it references if it is a blob index.
+func (i *dbIter) resolveValue(kt keyType) bool {
+	if kt != keyTypeBlobIndex {
+		return true
+	}
+	value, err := i.db.s.tops.getBlob(i.value)
+	if err != nil {
+		i.setErr(err)
+		return false
+	}
+	i.value = value
 	return true
 }
 
@@ -0,0 +1,188 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"io"
+	"os"
+	"sync"
+
+	"github.com/rev3z/ledger_base/leveldb/errors"
+	"github.com/rev3z/ledger_base/leveldb/opt"
+	"github.com/rev3z/ledger_base/leveldb/storage"
+)
+
+// ErrNotSecondary is returned by TryCatchUpWithPrimary if the DB wasn't
+// opened with OpenSecondary.
+var ErrNotSecondary = errors.New("leveldb: not a secondary DB")
+
+// Number of times a catch-up is retried while the primary keeps flushing
+// memdbs under it.
+const secondaryCatchUpRetry = 4
+
+type journalSize struct {
+	fd   storage.FileDesc
+	size int64
+}
+
+// State of a secondary DB, see OpenSecondary.
+type secondary struct {
+	mu       sync.Mutex
+	journals []journalSize // journals replayed by the last catch-up
+}
+
+// OpenSecondary opens the DB at primaryPath as a read-only secondary,
+// while the primary may have it open for writing. The secondary keeps its
+// LOG file at secondaryPath, which is created if it doesn't exist and may
+// be used by a single secondary at a time.
+//
+// The secondary sees the DB as of the time it was opened, or of the last
+// call to TryCatchUpWithPrimary. Writes fail with ErrReadOnly.
+//
+// The primary removes tables and journals once obsolete, regardless of
+// the secondary; reads of a secondary which didn't catch up for long may
+// then fail due to missing files. Catching up restores it.
+//
+// The returned DB instance is safe for concurrent use.
+// The DB must be closed after use, by calling Close method.
+func OpenSecondary(primaryPath, secondaryPath string, o *opt.Options) (db *DB, err error) {
+	stor, err := storage.OpenFileSecondary(primaryPath, secondaryPath)
+	if err != nil {
+		return
+	}
+	no := dupOptions(o)
+	no.ReadOnly = true
+	db, err = Open(stor, no)
+	if err != nil {
+		stor.Close()
+		return
+	}
+	db.closer = stor
+	db.secondary = &secondary{}
+
+	// The journals might have been flushed between reading the manifest and
+	// the journals.
+	if err = db.TryCatchUpWithPrimary(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return
+}
+
+// Returns the journals of both trees with their sizes.
+func (db *DB) journalSizes() ([]journalSize, error) {
+	fds, err := db.s.stor.List(storage.TypeJournal | storage.TypeJournals)
+	if err != nil {
+		return nil, err
+	}
+	sortFds(fds)
+	js := make([]journalSize, 0, len(fds))
+	for _, fd := range fds {
+		r, err := db.s.stor.Open(fd)
+		if err != nil {
+			return nil, err
+		}
+		size, err := r.Seek(0, io.SeekEnd)
+		r.Close()
+		if err != nil {
+			return nil, err
+		}
+		js = append(js, journalSize{fd, size})
+	}
+	return js, nil
+}
+
+func journalSizesEqual(a, b []journalSize) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Replaces the memdbs with ones replayed from the journals.
+func (db *DB) reloadMemsRO() error {
+	mdb, mdbs, seq, err := db.replayJournalsRO()
+	if err != nil {
+		return err
+	}
+	db.memMu.Lock()
+	mem, mems := db.mem, db.mems
+	db.mem = &memDB{db: db, DB: mdb, ref: 1}
+	db.mems = &memDB{db: db, DBs: mdbs, refs: 1}
+	db.memMu.Unlock()
+	mem.decref()
+	mems.decref_s()
+	if seq > db.getSeq() {
+		db.setSeq(seq)
+	}
+	return nil
+}
+
+// TryCatchUpWithPrimary brings a DB opened with OpenSecondary up to date
+// with the primary: new manifest records are applied to the version, and
+// the journals of both trees are replayed into new memdbs. A record the
+// primary is still writing is picked up by the next call.
+//
+// Snapshots and iterators don't keep the primary from compacting away the
+// data they refer to, so after a catch-up they may miss entries or fail due
+// to removed files. Reads running concurrently may see either state.
+func (db *DB) TryCatchUpWithPrimary() error {
+	if db.secondary == nil {
+		return ErrNotSecondary
+	}
+	db.secondary.mu.Lock()
+	defer db.secondary.mu.Unlock()
+	if db.isClosed() {
+		return ErrClosed
+	}
+
+	reloaded := false
+	for i := 0; ; i++ {
+		changed, err := db.s.catchUp()
+		if err != nil {
+			return err
+		}
+		if changed {
+			if seq := db.s.stSeqNum; seq > db.getSeq() {
+				db.setSeq(seq)
+			}
+		}
+		// The memdbs match the version unless the primary flushed them
+		// meanwhile.
+		if reloaded && !changed {
+			return nil
+		}
+
+		js, err := db.journalSizes()
+		if err == nil {
+			if !changed && journalSizesEqual(js, db.secondary.journals) {
+				return nil
+			}
+			// The memdbs are rebuilt, the part of them already flushed
+			// changes with the version.
+			err = db.reloadMemsRO()
+		}
+		if err != nil {
+			// A journal was removed once flushed, the manifest then
+			// tells where to continue.
+			if os.IsNotExist(err) && i < secondaryCatchUpRetry {
+				continue
+			}
+			return err
+		}
+		db.secondary.journals = js
+		reloaded = true
+		if i >= secondaryCatchUpRetry {
+			return nil
+		}
+	}
+}
@@ -59,7 +59,9 @@ func (db *DB) releaseSnapshot(se *snapshotElement) {
 	}
 }
 
-// Gets minimum sequence that not being snapshotted.
+// Gets minimum sequence that not being snapshotted. Both trees share the
+// same sequence space, so this also bounds what compaction of the secondary
+// tree may drop.
 func (db *DB) minSeq() uint64 {
 	db.snapsMu.Lock()
 	defer db.snapsMu.Unlock()
@@ -130,6 +132,42 @@ func (snap *Snapshot) Has(key []byte, ro *opt.ReadOptions) (ret bool, err error)
 	return snap.db.has(nil, nil, key, snap.elem.seq, ro)
 }
 
+// Get_s gets the value for the given key from the secondary tree. It
+// returns ErrNotFound if the secondary tree does not contains the key.
+//
+// The snapshot covers both trees, so Get and Get_s on the same snapshot
+// observe the same point in time.
+func (snap *Snapshot) Get_s(key []byte, ro *opt.ReadOptions) (value []byte, err error) {
+	err = snap.db.ok()
+	if err != nil {
+		return
+	}
+	snap.mu.RLock()
+	defer snap.mu.RUnlock()
+	if snap.released {
+		err = ErrSnapshotReleased
+		return
+	}
+	return snap.db.get_s(nil, nil, key, snap.elem.seq, ro)
+}
+
+// Has_s returns true if the secondary tree does contains the given key.
+//
+// It is safe to modify the contents of the argument after Has_s returns.
+func (snap *Snapshot) Has_s(key []byte, ro *opt.ReadOptions) (ret bool, err error) {
+	err = snap.db.ok()
+	if err != nil {
+		return
+	}
+	snap.mu.RLock()
+	defer snap.mu.RUnlock()
+	if snap.released {
+		err = ErrSnapshotReleased
+		return
+	}
+	return snap.db.has_s(nil, nil, key, snap.elem.seq, ro)
+}
+
 // NewIterator returns an iterator for the snapshot of the underlying DB.
 // The returned iterator is not safe for concurrent use, but it is safe to use
 // multiple iterators concurrently, with each in a dedicated goroutine.
@@ -165,6 +203,22 @@ func (snap *Snapshot) NewIterator(slice *util.Range, ro *opt.ReadOptions) iterat
 	return snap.db.newIterator(nil, nil, snap.elem.seq, slice, ro)
 }
 
+// NewIterator_s returns an iterator for the snapshot of the secondary tree.
+// See NewIterator for the iterator semantics.
+func (snap *Snapshot) NewIterator_s(slice *util.Range, ro *opt.ReadOptions) iterator.Iterator {
+	if err := snap.db.ok(); err != nil {
+		return iterator.NewEmptyIterator(err)
+	}
+	snap.mu.Lock()
+	defer snap.mu.Unlock()
+	if snap.released {
+		return iterator.NewEmptyIterator(ErrSnapshotReleased)
+	}
+	// Since iterator already hold version ref, it doesn't need to
+	// hold snapshot ref.
+	return snap.db.newIterator_s(nil, nil, snap.elem.seq, slice, ro)
+}
+
 // Release releases the snapshot. This will not release any returned
 // iterators, the iterators would still be valid until released or the
 // underlying DB is closed.
@@ -16,6 +16,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -30,6 +31,7 @@ import (
 	"github.com/rev3z/ledger_base/leveldb/filter"
 	"github.com/rev3z/ledger_base/leveldb/iterator"
 	"github.com/rev3z/ledger_base/leveldb/opt"
+	"github.com/rev3z/ledger_base/leveldb/ratelimit"
 	"github.com/rev3z/ledger_base/leveldb/storage"
 	"github.com/rev3z/ledger_base/leveldb/testutil"
 	"github.com/rev3z/ledger_base/leveldb/util"
@@ -277,7 +279,7 @@ func (h *dbHarness) allEntriesFor(key, want string) {
 	s := db.s
 
 	ikey := makeInternalKey(nil, []byte(key), keyMaxSeq, keyTypeVal)
-	iter := db.newRawIterator(nil, nil, nil, nil)
+	iter := db.newRawIterator(nil, nil, nil, nil, nil)
 	if !iter.Seek(ikey) && iter.Error() != nil {
 		t.Error("AllEntries: error during seek, err: ", iter.Error())
 		return
@@ -379,6 +381,28 @@ func (h *dbHarness) compactMem() {
 	t.Log("memdb compaction done")
 }
 
+func (h *dbHarness) compactMem_s() {
+	t := h.t
+	db := h.db
+
+	t.Log("starting secondary memdb compaction")
+
+	db.writeLockC <- struct{}{}
+	defer func() {
+		<-db.writeLockC
+	}()
+
+	if _, err := db.rotateMem_s(0, true); err != nil {
+		t.Error("compaction error: ", err)
+	}
+
+	if h.totalTables_s() == 0 {
+		t.Error("zero secondary tables after mem compaction")
+	}
+
+	t.Log("secondary memdb compaction done")
+}
+
 func (h *dbHarness) compactRangeAtErr(level int, min, max string, wanterr bool) {
 	t := h.t
 	db := h.db
@@ -489,6 +513,15 @@ func (h *dbHarness) totalTables() (n int) {
 	return
 }
 
+func (h *dbHarness) totalTables_s() (n int) {
+	v := h.db.s.version()
+	for _, tables := range v.level_s {
+		n += len(tables)
+	}
+	v.release()
+	return
+}
+
 type keyValue interface {
 	Key() []byte
 	Value() []byte
@@ -614,6 +647,66 @@ func TestDB_PutDeleteGet(t *testing.T) {
 	})
 }
 
+func TestDB_SecondaryHasDeleteIterator(t *testing.T) {
+	trun(t, func(h *dbHarness) {
+		db := h.db
+		for _, k := range []string{"a", "b", "c", "d"} {
+			if err := db.Put_s([]byte(k), []byte("v"+k), h.wo); err != nil {
+				t.Fatal("Put_s: got error: ", err)
+			}
+		}
+		if err := db.Put([]byte("b"), []byte("primary"), h.wo); err != nil {
+			t.Fatal("Put: got error: ", err)
+		}
+		if err := db.Delete_s([]byte("b"), h.wo); err != nil {
+			t.Fatal("Delete_s: got error: ", err)
+		}
+
+		check := func(stage string) {
+			for k, want := range map[string]bool{"a": true, "b": false, "c": true, "x": false} {
+				if got, err := h.db.Has_s([]byte(k), h.ro); err != nil {
+					t.Errorf("%s: Has_s(%q): got error: %v", stage, k, err)
+				} else if got != want {
+					t.Errorf("%s: Has_s(%q): want=%v got=%v", stage, k, want, got)
+				}
+			}
+			if _, err := h.db.Get_s([]byte("b"), h.ro); err != ErrNotFound {
+				t.Errorf("%s: Get_s deleted key: want ErrNotFound got %v", stage, err)
+			}
+			h.getVal("b", "primary")
+
+			iter := h.db.NewIterator_s(nil, h.ro)
+			var res []string
+			for iter.Next() {
+				res = append(res, string(iter.Key())+"="+string(iter.Value()))
+			}
+			if err := iter.Error(); err != nil {
+				t.Errorf("%s: iterator error: %v", stage, err)
+			}
+			iter.Release()
+			if got, want := strings.Join(res, ","), "a=va,c=vc,d=vd"; got != want {
+				t.Errorf("%s: NewIterator_s: want %q got %q", stage, want, got)
+			}
+
+			iter = h.db.NewIterator_s(&util.Range{Start: []byte("b"), Limit: []byte("d")}, h.ro)
+			res = res[:0]
+			for ok := iter.Last(); ok; ok = iter.Prev() {
+				res = append(res, string(iter.Key()))
+			}
+			iter.Release()
+			if got, want := strings.Join(res, ","), "c"; got != want {
+				t.Errorf("%s: NewIterator_s slice: want %q got %q", stage, want, got)
+			}
+		}
+
+		check("memdb")
+		h.compactMem_s()
+		check("table")
+		h.reopenDB()
+		check("reopen")
+	})
+}
+
 func TestDB_EmptyBatch(t *testing.T) {
 	h := newDbHarness(t)
 	defer h.close()
@@ -1080,127 +1173,1578 @@ func TestDB_SparseMerge(t *testing.T) {
 	h.maxNextLevelOverlappingBytes(20 * 1048576)
 }
 
-func TestDB_SizeOf(t *testing.T) {
-	h := newDbHarnessWopt(t, &opt.Options{
+func TestDB_SizeOf(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		Compression:                  opt.NoCompression,
+		WriteBuffer:                  10000000,
+	})
+	defer h.close()
+
+	h.sizeAssert("", "xyz", 0, 0)
+	h.reopenDB()
+	h.sizeAssert("", "xyz", 0, 0)
+
+	// Write 8MB (80 values, each 100K)
+	n := 80
+	s1 := 100000
+	s2 := 105000
+
+	for i := 0; i < n; i++ {
+		h.put(numKey(i), strings.Repeat(fmt.Sprintf("v%09d", i), s1/10))
+	}
+
+	// 0 because SizeOf() does not account for memtable space
+	h.sizeAssert("", numKey(50), 0, 0)
+
+	for r := 0; r < 3; r++ {
+		h.reopenDB()
+
+		for cs := 0; cs < n; cs += 10 {
+			for i := 0; i < n; i += 10 {
+				h.sizeAssert("", numKey(i), int64(s1*i), int64(s2*i))
+				h.sizeAssert("", numKey(i)+".suffix", int64(s1*(i+1)), int64(s2*(i+1)))
+				h.sizeAssert(numKey(i), numKey(i+10), int64(s1*10), int64(s2*10))
+			}
+
+			h.sizeAssert("", numKey(50), int64(s1*50), int64(s2*50))
+			h.sizeAssert("", numKey(50)+".suffix", int64(s1*50), int64(s2*50))
+
+			h.compactRangeAt(0, numKey(cs), numKey(cs+9))
+		}
+
+		v := h.db.s.version()
+		if v.tLen(0) != 0 {
+			t.Errorf("level-0 tables was not zero, got %d", v.tLen(0))
+		}
+		if v.tLen(1) == 0 {
+			t.Error("level-1 tables was zero")
+		}
+		v.release()
+	}
+}
+
+func TestDB_SizeOf_MixOfSmallAndLarge(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		Compression:                  opt.NoCompression,
+	})
+	defer h.close()
+
+	sizes := []int64{
+		10000,
+		10000,
+		100000,
+		10000,
+		100000,
+		10000,
+		300000,
+		10000,
+	}
+
+	for i, n := range sizes {
+		h.put(numKey(i), strings.Repeat(fmt.Sprintf("v%09d", i), int(n)/10))
+	}
+
+	for r := 0; r < 3; r++ {
+		h.reopenDB()
+
+		var x int64
+		for i, n := range sizes {
+			y := x
+			if i > 0 {
+				y += 1000
+			}
+			h.sizeAssert("", numKey(i), x, y)
+			x += n
+		}
+
+		h.sizeAssert(numKey(3), numKey(5), 110000, 111000)
+
+		h.compactRangeAt(0, "", "")
+	}
+}
+
+func TestDB_Snapshot(t *testing.T) {
+	trun(t, func(h *dbHarness) {
+		h.put("foo", "v1")
+		s1 := h.getSnapshot()
+		h.put("foo", "v2")
+		s2 := h.getSnapshot()
+		h.put("foo", "v3")
+		s3 := h.getSnapshot()
+		h.put("foo", "v4")
+
+		h.getValr(s1, "foo", "v1")
+		h.getValr(s2, "foo", "v2")
+		h.getValr(s3, "foo", "v3")
+		h.getVal("foo", "v4")
+
+		s3.Release()
+		h.getValr(s1, "foo", "v1")
+		h.getValr(s2, "foo", "v2")
+		h.getVal("foo", "v4")
+
+		s1.Release()
+		h.getValr(s2, "foo", "v2")
+		h.getVal("foo", "v4")
+
+		s2.Release()
+		h.getVal("foo", "v4")
+	})
+}
+
+func TestDB_SnapshotBothTrees(t *testing.T) {
+	trun(t, func(h *dbHarness) {
+		db := h.db
+		putBoth := func(k, v string) {
+			if err := db.Put([]byte(k), []byte(v), h.wo); err != nil {
+				t.Fatal("Put: got error: ", err)
+			}
+			if err := db.Put_s([]byte(k), []byte(v+"_s"), h.wo); err != nil {
+				t.Fatal("Put_s: got error: ", err)
+			}
+		}
+		putBoth("foo", "v1")
+		putBoth("bar", "v1")
+		snap := h.getSnapshot()
+		defer snap.Release()
+
+		putBoth("foo", "v2")
+		if err := db.Delete_s([]byte("bar"), h.wo); err != nil {
+			t.Fatal("Delete_s: got error: ", err)
+		}
+
+		check := func(stage string) {
+			h.getValr(snap, "foo", "v1")
+			if v, err := snap.Get_s([]byte("foo"), h.ro); err != nil || string(v) != "v1_s" {
+				t.Errorf("%s: snapshot Get_s: want v1_s got %q, err=%v", stage, v, err)
+			}
+			if ok, err := snap.Has_s([]byte("bar"), h.ro); err != nil || !ok {
+				t.Errorf("%s: snapshot Has_s: want true got %v, err=%v", stage, ok, err)
+			}
+			if v, err := db.Get_s([]byte("foo"), h.ro); err != nil || string(v) != "v2_s" {
+				t.Errorf("%s: Get_s: want v2_s got %q, err=%v", stage, v, err)
+			}
+			if ok, err := db.Has_s([]byte("bar"), h.ro); err != nil || ok {
+				t.Errorf("%s: Has_s: want false got %v, err=%v", stage, ok, err)
+			}
+
+			iter := snap.NewIterator_s(nil, h.ro)
+			var res []string
+			for iter.Next() {
+				res = append(res, string(iter.Key())+"="+string(iter.Value()))
+			}
+			iter.Release()
+			if got, want := strings.Join(res, ","), "bar=v1_s,foo=v1_s"; got != want {
+				t.Errorf("%s: snapshot NewIterator_s: want %q got %q", stage, want, got)
+			}
+		}
+
+		check("memdb")
+		h.compactMem_s()
+		if err := db.CompactRange_s(util.Range{}); err != nil {
+			t.Fatal("CompactRange_s: got error: ", err)
+		}
+		check("compacted")
+	})
+}
+
+func TestDB_CrossTreeBatch(t *testing.T) {
+	trun(t, func(h *dbHarness) {
+		b := new(Batch)
+		b.Put([]byte("a"), []byte("v1"))
+		b.Put_s([]byte("b"), []byte("v1_s"))
+		b.Put_s([]byte("c"), []byte("v1_s"))
+		if err := h.db.Write(b, h.wo); err != nil {
+			t.Fatal("Write: got error: ", err)
+		}
+
+		check := func(stage string) {
+			h.getVal("a", "v1")
+			if v, err := h.db.Get_s([]byte("b"), h.ro); err != nil || string(v) != "v1_s" {
+				t.Errorf("%s: Get_s: want v1_s got %q, err=%v", stage, v, err)
+			}
+			if ok, err := h.db.Has([]byte("b"), h.ro); err != nil || ok {
+				t.Errorf("%s: Has: want false got %v, err=%v", stage, ok, err)
+			}
+			if ok, err := h.db.Has_s([]byte("a"), h.ro); err != nil || ok {
+				t.Errorf("%s: Has_s: want false got %v, err=%v", stage, ok, err)
+			}
+			if ok, err := h.db.Has_s([]byte("c"), h.ro); err != nil || ok {
+				t.Errorf("%s: Has_s(c): want false got %v, err=%v", stage, ok, err)
+			}
+		}
+
+		// A deletion of a flushed cross-tree record must not be undone by
+		// replaying the record from the primary journal.
+		h.compactMem_s()
+		if err := h.db.Delete_s([]byte("c"), h.wo); err != nil {
+			t.Fatal("Delete_s: got error: ", err)
+		}
+		h.compactMem_s()
+		if err := h.db.CompactRange_s(util.Range{}); err != nil {
+			t.Fatal("CompactRange_s: got error: ", err)
+		}
+		check("memdb")
+		h.reopenDB()
+		check("reopen")
+
+		// The secondary part of a cross-tree batch is only committed with
+		// its primary part, even once the primary tree is flushed past it.
+		h.stor.EmulateErrorOnce(testutil.ModeWrite, storage.TypeJournal, errors.New("journal write error"))
+		b.Reset()
+		b.Put_s([]byte("d"), []byte("v1_s"))
+		b.Put([]byte("d"), []byte("v1"))
+		if err := h.db.Write(b, h.wo); err == nil {
+			t.Fatal("Write: want journal write error")
+		}
+		// The broken journal is replaced, then the primary tree is flushed
+		// past the sequence numbers of the batch.
+		h.compactMem()
+		h.put("d0", "v1")
+		h.compactMem()
+		h.reopenDB()
+		check("failed primary journal write")
+		h.get("d", false)
+		if ok, err := h.db.Has_s([]byte("d"), h.ro); err != nil || ok {
+			t.Errorf("failed primary journal write: Has_s(d): want false got %v, err=%v", ok, err)
+		}
+
+		// Write_s commits cross-tree batches the same way.
+		b.Reset()
+		b.Put([]byte("e"), []byte("v1"))
+		b.Put_s([]byte("e"), []byte("v1_s"))
+		if err := h.db.Write_s(b, h.wo); err != nil {
+			t.Fatal("Write_s: got error: ", err)
+		}
+		// A batch with records for the secondary tree only.
+		b.Reset()
+		b.Put_s([]byte("f"), []byte("v1_s"))
+		if err := h.db.Write(b, h.wo); err != nil {
+			t.Fatal("Write: got error: ", err)
+		}
+		checkE := func(stage string) {
+			h.getVal("e", "v1")
+			h.get("f", false)
+			for _, k := range []string{"e", "f"} {
+				if v, err := h.db.Get_s([]byte(k), h.ro); err != nil || string(v) != "v1_s" {
+					t.Errorf("%s: Get_s(%s): want v1_s got %q, err=%v", stage, k, v, err)
+				}
+			}
+		}
+		checkE("memdb")
+		h.reopenDB()
+		checkE("reopen")
+
+		// Transactions route records by tree as well.
+		tr, err := h.db.OpenTransaction()
+		if err != nil {
+			t.Fatal("OpenTransaction: got error: ", err)
+		}
+		b.Reset()
+		b.Put([]byte("g"), []byte("v1"))
+		b.Put_s([]byte("h"), []byte("v1_s"))
+		if err := tr.Write(b, h.wo); err != nil {
+			t.Fatal("Transaction.Write: got error: ", err)
+		}
+		if err := tr.Commit(); err != nil {
+			t.Fatal("Transaction.Commit: got error: ", err)
+		}
+		checkG := func(stage string) {
+			h.getVal("g", "v1")
+			h.get("h", false)
+			if v, err := h.db.Get_s([]byte("h"), h.ro); err != nil || string(v) != "v1_s" {
+				t.Errorf("%s: Get_s(h): want v1_s got %q, err=%v", stage, v, err)
+			}
+			if ok, err := h.db.Has_s([]byte("g"), h.ro); err != nil || ok {
+				t.Errorf("%s: Has_s(g): want false got %v, err=%v", stage, ok, err)
+			}
+		}
+		checkG("transaction")
+		h.reopenDB()
+		checkG("reopen")
+	})
+}
+
+func TestDB_DeleteRange(t *testing.T) {
+	trun(t, func(h *dbHarness) {
+		db := h.db
+		for _, k := range []string{"a", "b", "c", "d", "e", "f", "g"} {
+			h.put(k, "v1")
+			if err := db.Put_s([]byte(k), []byte("v1_s"), h.wo); err != nil {
+				t.Fatal("Put_s: got error: ", err)
+			}
+		}
+		snap := h.getSnapshot()
+
+		if err := db.DeleteRange([]byte("b"), []byte("e"), h.wo); err != nil {
+			t.Fatal("DeleteRange: got error: ", err)
+		}
+		// Empty ranges are no-ops.
+		if err := db.DeleteRange([]byte("g"), []byte("a"), h.wo); err != nil {
+			t.Fatal("DeleteRange: got error: ", err)
+		}
+		h.put("c", "v2")
+		b := new(Batch)
+		b.DeleteRange_s([]byte("d"), []byte("g"))
+		b.Put_s([]byte("e"), []byte("v2_s"))
+		h.write(b)
+
+		iterKeys := func(iter iterator.Iterator) string {
+			var res []string
+			for iter.Next() {
+				res = append(res, string(iter.Key())+"="+string(iter.Value()))
+			}
+			iter.Release()
+			return strings.Join(res, ",")
+		}
+		check := func(stage string) {
+			h.getVal("a", "v1")
+			h.get("b", false)
+			h.getVal("c", "v2")
+			h.get("d", false)
+			h.getVal("e", "v1")
+			for k, want := range map[string]string{"c": "v1_s", "e": "v2_s", "g": "v1_s"} {
+				if v, err := db.Get_s([]byte(k), h.ro); err != nil || string(v) != want {
+					t.Errorf("%s: Get_s(%s): want %s got %q, err=%v", stage, k, want, v, err)
+				}
+			}
+			for _, k := range []string{"d", "f"} {
+				if ok, err := db.Has_s([]byte(k), h.ro); err != nil || ok {
+					t.Errorf("%s: Has_s(%s): want false got %v, err=%v", stage, k, ok, err)
+				}
+			}
+
+			if got, want := iterKeys(db.NewIterator(nil, h.ro)), "a=v1,c=v2,e=v1,f=v1,g=v1"; got != want {
+				t.Errorf("%s: NewIterator: want %q got %q", stage, want, got)
+			}
+			iter := db.NewIterator(nil, h.ro)
+			var rev []string
+			for ok := iter.Last(); ok; ok = iter.Prev() {
+				rev = append(rev, string(iter.Key()))
+			}
+			iter.Release()
+			if got, want := strings.Join(rev, ","), "g,f,e,c,a"; got != want {
+				t.Errorf("%s: reverse NewIterator: want %q got %q", stage, want, got)
+			}
+			if got, want := iterKeys(db.NewIterator_s(&util.Range{Start: []byte("e")}, h.ro)), "e=v2_s,g=v1_s"; got != want {
+				t.Errorf("%s: NewIterator_s: want %q got %q", stage, want, got)
+			}
+			if snap == nil {
+				return
+			}
+			h.getValr(snap, "b", "v1")
+			if got, want := iterKeys(snap.NewIterator(nil, h.ro)), "a=v1,b=v1,c=v1,d=v1,e=v1,f=v1,g=v1"; got != want {
+				t.Errorf("%s: snapshot NewIterator: want %q got %q", stage, want, got)
+			}
+		}
+
+		check("memdb")
+		h.compactMem()
+		h.compactMem_s()
+		check("table")
+		h.compactRange("", "")
+		if err := db.CompactRange_s(util.Range{}); err != nil {
+			t.Error("CompactRange_s: got error: ", err)
+		}
+		check("compacted")
+		snap.Release()
+		snap = nil
+		h.reopenDB()
+		db = h.db
+		check("reopen")
+	})
+}
+
+type testingCompactionFilter struct{}
+
+func (testingCompactionFilter) Name() string { return "testing" }
+
+func (testingCompactionFilter) Filter(level int, key, value []byte) (opt.CompactionFilterDecision, []byte) {
+	switch {
+	case bytes.HasPrefix(key, []byte("expired")):
+		return opt.CompactionFilterRemove, nil
+	case bytes.HasPrefix(key, []byte("upper")):
+		return opt.CompactionFilterChangeValue, bytes.ToUpper(value)
+	}
+	return opt.CompactionFilterKeep, nil
+}
+
+func TestDB_CompactionFilter(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		CompactionFilter:             testingCompactionFilter{},
+		SecondaryCompactionFilter:    testingCompactionFilter{},
+	})
+	defer h.close()
+
+	// Entries newer than a snapshot are left alone, so an older value makes
+	// it to a deeper level. It must not resurface once the newest one is
+	// removed.
+	snap := h.getSnapshot()
+	h.put("expired1", "old")
+	h.compactMem()
+	h.compactRangeAt(0, "", "")
+	h.compactRangeAt(1, "", "")
+	h.compactRangeAt(2, "", "")
+	h.tablesPerLevel("0,0,0,1")
+	h.put("expired1", "v1")
+	h.put("expired2", "v1")
+	h.put("upper", "v1")
+	h.put("kept", "v1")
+	for _, k := range []string{"expired1", "upper", "kept"} {
+		if err := h.db.Put_s([]byte(k), []byte("v1_s"), h.wo); err != nil {
+			t.Fatal("Put_s: got error: ", err)
+		}
+	}
+	h.compactMem()
+	h.compactRangeAt(0, "", "")
+	h.getVal("expired1", "v1")
+	h.getVal("upper", "v1")
+	snap.Release()
+
+	h.compactRangeAt(1, "", "")
+	h.get("expired1", false)
+	h.get("expired2", false)
+	h.getVal("upper", "V1")
+	h.getVal("kept", "v1")
+
+	h.compactMem_s()
+	if err := h.db.CompactRange_s(util.Range{}); err != nil {
+		t.Fatal("CompactRange_s: got error: ", err)
+	}
+	for k, want := range map[string]string{"upper": "V1_S", "kept": "v1_s"} {
+		if v, err := h.db.Get_s([]byte(k), h.ro); err != nil || string(v) != want {
+			t.Errorf("Get_s(%s): want %s got %q, err=%v", k, want, v, err)
+		}
+	}
+	if ok, err := h.db.Has_s([]byte("expired1"), h.ro); err != nil || ok {
+		t.Errorf("Has_s(expired1): want false got %v, err=%v", ok, err)
+	}
+
+	s := new(DBStats)
+	if err := h.db.Stats(s); err != nil {
+		t.Fatal("Stats: got error: ", err)
+	}
+	if s.LevelFilterRemoved[2] != 2 || s.LevelFilterChanged[2] != 1 {
+		t.Errorf("got L2 filter stats %d/%d, want 2/1", s.LevelFilterRemoved[2], s.LevelFilterChanged[2])
+	}
+	var removed_s, changed_s int64
+	for level := range s.LevelFilterRemoved_s {
+		removed_s += s.LevelFilterRemoved_s[level]
+		changed_s += s.LevelFilterChanged_s[level]
+	}
+	if removed_s == 0 || changed_s == 0 {
+		t.Errorf("got secondary filter stats %d/%d, want non-zero", removed_s, changed_s)
+	}
+}
+
+// Records requested bytes, table compactions pretend to be throttled.
+type testingRateLimiter struct {
+	bytes [2]int64
+}
+
+func (l *testingRateLimiter) Request(n int, pri ratelimit.Priority) time.Duration {
+	atomic.AddInt64(&l.bytes[pri], int64(n))
+	if pri == ratelimit.Low {
+		return time.Millisecond
+	}
+	return 0
+}
+
+func TestDB_RateLimiter(t *testing.T) {
+	l := new(testingRateLimiter)
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		Compression:                  opt.NoCompression,
+		RateLimiter:                  l,
+	})
+	defer h.close()
+
+	// Two overlapping tables per tree, so that they are compacted rather
+	// than moved.
+	value := strings.Repeat("v", 1000)
+	for n := 0; n < 2; n++ {
+		for i := 0; i < 200; i++ {
+			k := fmt.Sprintf("k%03d", i)
+			h.put(k, value)
+			if err := h.db.Put_s([]byte(k), []byte(value), h.wo); err != nil {
+				t.Fatal("Put_s: got error: ", err)
+			}
+		}
+		h.compactMem()
+		h.compactMem_s()
+	}
+	if high := atomic.LoadInt64(&l.bytes[ratelimit.High]); high < 2*2*200*1000 {
+		t.Errorf("got %d flushed bytes requested, want at least %d", high, 2*2*200*1000)
+	}
+	if low := atomic.LoadInt64(&l.bytes[ratelimit.Low]); low != 0 {
+		t.Errorf("got %d compaction bytes requested before compaction", low)
+	}
+
+	h.compactRangeAt(0, "", "")
+	if err := h.db.CompactRange_s(util.Range{}); err != nil {
+		t.Fatal("CompactRange_s: got error: ", err)
+	}
+	// Both the blocks read and the bytes written by the compactions are
+	// requested, the last chunk of reads once the iterator is released.
+	if low := atomic.LoadInt64(&l.bytes[ratelimit.Low]); low < 2*3*200*1000 {
+		t.Errorf("got %d compaction bytes requested, want at least %d", low, 2*3*200*1000)
+	}
+	h.getVal("k100", value)
+
+	s := new(DBStats)
+	if err := h.db.Stats(s); err != nil {
+		t.Fatal("Stats: got error: ", err)
+	}
+	if s.IOThrottleCount == 0 || s.IOThrottleDuration < time.Duration(s.IOThrottleCount)*time.Millisecond {
+		t.Errorf("got throttle stats %d/%v", s.IOThrottleCount, s.IOThrottleDuration)
+	}
+}
+
+func TestDB_Subcompactions(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		Compression:                  opt.NoCompression,
+		CompactionTableSize:          4 * opt.KiB,
+		MaxSubcompactions:            4,
+	})
+	defer h.close()
+
+	key := func(i int) string { return fmt.Sprintf("k%04d", i) }
+	value := strings.Repeat("v", 100)
+	put := func(k, v string) {
+		h.put(k, v)
+		if err := h.db.Put_s([]byte(k), []byte(v), h.wo); err != nil {
+			t.Fatal("Put_s: got error: ", err)
+		}
+	}
+	compactAt := func(level int) {
+		h.compactRangeAt(level, "", "")
+		if err := h.db.compTriggerRange(h.db.tcompCmdCs, level, nil, nil); err != nil {
+			t.Fatal("compTriggerRange: got error: ", err)
+		}
+	}
+	for i := 0; i < 500; i++ {
+		put(key(i), value)
+	}
+	h.compactMem()
+	h.compactMem_s()
+	compactAt(0)
+	compactAt(1)
+
+	// Overwrite every other key, and delete a range likely crossing a
+	// table edge.
+	for i := 0; i < 500; i += 2 {
+		put(key(i), "x")
+	}
+	for _, del := range []func([]byte, []byte, *opt.WriteOptions) error{h.db.DeleteRange, h.db.DeleteRange_s} {
+		if err := del([]byte(key(100)), []byte(key(150)), h.wo); err != nil {
+			t.Fatal("DeleteRange: got error: ", err)
+		}
+	}
+	h.compactMem()
+	h.compactMem_s()
+	compactAt(0)
+
+	for _, c := range []*compaction{
+		h.db.s.getCompactionRange(1, nil, nil, true),
+		h.db.s.getCompactionRange_s(1, nil, nil, true),
+	} {
+		if c == nil {
+			t.Fatal("no compaction at level-1")
+		}
+		subs := c.split(4)
+		if c.level_s[1] != nil {
+			subs = c.split_s(4)
+		}
+		c.release()
+		if len(subs) < 2 {
+			t.Fatalf("got %d subcompactions, want at least 2", len(subs))
+		}
+		for i := 1; i < len(subs); i++ {
+			if !bytes.Equal(subs[i].start, subs[i-1].limit) {
+				t.Errorf("subcompaction %d starts at %q, want %q", i, subs[i].start, subs[i-1].limit)
+			}
+		}
+	}
+	compactAt(1)
+
+	v := h.db.s.version()
+	defer v.release()
+	for level := 1; level < len(v.levels); level++ {
+		tables := v.levels[level]
+		for i := 1; i < len(tables); i++ {
+			if h.db.s.icmp.uCompare(tables[i-1].imax.ukey(), tables[i].imin.ukey()) >= 0 {
+				t.Errorf("L%d tables %d and %d overlap", level, i-1, i)
+			}
+		}
+	}
+	for level := 1; level < len(v.level_s); level++ {
+		tables := v.level_s[level]
+		for i := 1; i < len(tables); i++ {
+			if h.db.s.icmp.uCompare(tables[i-1].imax.ukey(), tables[i].imin.ukey()) >= 0 {
+				t.Errorf("secondary L%d tables %d and %d overlap", level, i-1, i)
+			}
+		}
+	}
+	for i := 0; i < 500; i++ {
+		want := value
+		if i%2 == 0 {
+			want = "x"
+		}
+		if i >= 100 && i < 150 {
+			h.get(key(i), false)
+			if ok, err := h.db.Has_s([]byte(key(i)), h.ro); err != nil || ok {
+				t.Errorf("Has_s(%s): want false got %v, err=%v", key(i), ok, err)
+			}
+			continue
+		}
+		h.getVal(key(i), want)
+		if v, err := h.db.Get_s([]byte(key(i)), h.ro); err != nil || string(v) != want {
+			t.Errorf("Get_s(%s): want %s got %q, err=%v", key(i), want, v, err)
+		}
+	}
+}
+
+func TestDB_TieredCompaction(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		SecondaryCompactionStyle:     opt.TieredCompaction,
+	})
+	defer h.close()
+
+	key := func(r, i int) string { return fmt.Sprintf("k%02d%03d", r, i) }
+	for r := 0; r < 12; r++ {
+		for i := 0; i < 50; i++ {
+			h.put(key(r, i), "v")
+			if err := h.db.Put_s([]byte(key(r, i)), []byte("v"), h.wo); err != nil {
+				t.Fatal("Put_s: got error: ", err)
+			}
+		}
+		// Overwritten and deleted keys must not resurface.
+		if err := h.db.Put_s([]byte("last"), []byte(fmt.Sprint(r)), h.wo); err != nil {
+			t.Fatal("Put_s: got error: ", err)
+		}
+		if r > 0 {
+			if err := h.db.Delete_s([]byte(key(r-1, 0)), h.wo); err != nil {
+				t.Fatal("Delete_s: got error: ", err)
+			}
+		}
+		h.compactMem()
+		h.compactMem_s()
+		h.waitCompaction()
+		if err := h.db.compTriggerWait(h.db.tcompCmdCs); err != nil {
+			t.Fatal("compaction error: ", err)
+		}
+	}
+
+	// Waiting only ensures writes aren't paused.
+	for i := 0; h.db.tableNeedCompaction_s() && i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	v := h.db.s.version()
+	sizes := make([]int64, len(v.level_s))
+	for level, tables := range v.level_s {
+		sizes[level] = tables.size()
+		for i := 1; level > 0 && i < len(tables); i++ {
+			if h.db.s.icmp.uCompare(tables[i-1].imax.ukey(), tables[i].imin.ukey()) >= 0 {
+				t.Errorf("secondary L%d tables %d and %d overlap", level, i-1, i)
+			}
+		}
+	}
+	if runs := tieredRuns(sizes, len(v.level_s[0])); runs >= h.o.GetCompactionL0Trigger() {
+		t.Errorf("got %d secondary sorted runs after compaction", runs)
+	}
+	if len(v.level_s) <= tieredBottomLevel || len(v.level_s[tieredBottomLevel]) == 0 {
+		t.Errorf("no secondary table at L%d", tieredBottomLevel)
+	}
+	if len(v.levels) > tieredBottomLevel && len(v.levels[tieredBottomLevel]) > 0 {
+		t.Errorf("primary tree compacted to L%d", tieredBottomLevel)
+	}
+	v.release()
+
+	for r := 0; r < 12; r++ {
+		for i := 0; i < 50; i++ {
+			h.getVal(key(r, i), "v")
+			v, err := h.db.Get_s([]byte(key(r, i)), h.ro)
+			if i == 0 && r < 11 {
+				if err != ErrNotFound {
+					t.Errorf("Get_s(%s): want not found got %q, err=%v", key(r, i), v, err)
+				}
+			} else if err != nil || string(v) != "v" {
+				t.Errorf("Get_s(%s): want v got %q, err=%v", key(r, i), v, err)
+			}
+		}
+	}
+	if v, err := h.db.Get_s([]byte("last"), h.ro); err != nil || string(v) != "11" {
+		t.Errorf("Get_s(last): want 11 got %q, err=%v", v, err)
+	}
+}
+
+func TestDB_BlobFiles(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		BlobThreshold:                100,
+		BlobGCRatio:                  0.8,
+	})
+	defer h.close()
+
+	key := func(i int) string { return fmt.Sprintf("k%03d", i) }
+	value := func(i, r int) string { return strings.Repeat(fmt.Sprintf("%03d:%d|", i, r), 50) }
+	put := func(k, v string) {
+		if err := h.db.Put_s([]byte(k), []byte(v), h.wo); err != nil {
+			t.Fatal("Put_s: got error: ", err)
+		}
+	}
+	check := func(r int) {
+		for i := 0; i < 100; i++ {
+			if v, err := h.db.Get_s([]byte(key(i)), h.ro); err != nil || string(v) != value(i, r) {
+				t.Fatalf("Get_s(%s): want %q got %q, err=%v", key(i), value(i, r), v, err)
+			}
+		}
+		if v, err := h.db.Get_s([]byte("a"), h.ro); err != nil || string(v) != "v" {
+			t.Fatalf("Get_s(a): want v got %q, err=%v", v, err)
+		}
+		iter := h.db.NewIterator_s(nil, h.ro)
+		n := 0
+		for iter.Next() {
+			if string(iter.Key()) == "a" {
+				continue
+			}
+			if want := value(n, r); string(iter.Value()) != want {
+				t.Fatalf("iterator at %s: want %q got %q", iter.Key(), want, iter.Value())
+			}
+			n++
+		}
+		if err := iter.Error(); err != nil {
+			t.Fatal("iterator: got error: ", err)
+		}
+		iter.Release()
+		if n != 100 {
+			t.Fatalf("iterator: want 100 large values got %d", n)
+		}
+		for iter := h.db.NewIterator_s(nil, h.ro); iter.Last(); iter.Release() {
+			if want := value(99, r); string(iter.Value()) != want {
+				t.Fatalf("reverse iterator at %s: want %q got %q", iter.Key(), want, iter.Value())
+			}
+			break
+		}
+	}
+	blobs := func() int {
+		fds, err := h.stor.List(storage.TypeBlob)
+		if err != nil {
+			t.Fatal("List: got error: ", err)
+		}
+		return len(fds)
+	}
+
+	put("a", "v")
+	for i := 0; i < 100; i++ {
+		put(key(i), value(i, 0))
+	}
+	h.compactMem_s()
+	if n := blobs(); n != 1 {
+		t.Fatalf("want 1 blob file after flush got %d", n)
+	}
+	v := h.db.s.version()
+	if len(v.blobs) != 1 {
+		t.Errorf("want 1 blob file in version got %d", len(v.blobs))
+	}
+	v.release()
+	check(0)
+
+	h.reopenDB()
+	check(0)
+
+	// Overwriting every value turns the first blob file into garbage.
+	for i := 0; i < 100; i++ {
+		put(key(i), value(i, 1))
+	}
+	h.compactMem_s()
+	if err := h.db.CompactRange_s(util.Range{}); err != nil {
+		t.Fatal("CompactRange_s: got error: ", err)
+	}
+	check(1)
+	v = h.db.s.version()
+	for _, f := range v.blobs {
+		if f.live() == 0 {
+			t.Errorf("blob file @%d without live records is still referenced", f.fd.Num)
+		}
+	}
+	v.release()
+
+	// Overwriting half of the values makes the blob file eligible for GC,
+	// the live values get rewritten.
+	for i := 0; i < 100; i += 2 {
+		put(key(i), value(i, 2))
+	}
+	h.compactMem_s()
+	if err := h.db.CompactRange_s(util.Range{}); err != nil {
+		t.Fatal("CompactRange_s: got error: ", err)
+	}
+	// The collector runs when no other compaction is picked.
+	for i := 0; i < 3; i++ {
+		if err := h.db.compTriggerWait(h.db.tcompCmdCs); err != nil {
+			t.Fatal("compaction error: ", err)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		want := value(i, 1)
+		if i%2 == 0 {
+			want = value(i, 2)
+		}
+		if v, err := h.db.Get_s([]byte(key(i)), h.ro); err != nil || string(v) != want {
+			t.Fatalf("Get_s(%s): want %q got %q, err=%v", key(i), want, v, err)
+		}
+	}
+	v = h.db.s.version()
+	for _, f := range v.blobs {
+		if f.live() < 0.8 {
+			t.Errorf("blob file @%d with live ratio %.2f not collected", f.fd.Num, f.live())
+		}
+	}
+	v.release()
+
+	h.reopenDB()
+	v = h.db.s.version()
+	if n := blobs(); n != len(v.blobs) {
+		t.Errorf("want %d blob files after reopen got %d", len(v.blobs), n)
+	}
+	v.release()
+}
+
+func TestDB_BlobGCRetry(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		BlobThreshold:                100,
+		BlobGCRatio:                  0.8,
+	})
+	defer h.close()
+
+	key := func(i int) string { return fmt.Sprintf("k%03d", i) }
+	value := func(i, r int) string { return strings.Repeat(fmt.Sprintf("%03d:%d|", i, r), 50) }
+	put := func(k, v string) {
+		if err := h.db.Put_s([]byte(k), []byte(v), h.wo); err != nil {
+			t.Fatal("Put_s: got error: ", err)
+		}
+	}
+	compact := func() {
+		h.compactMem_s()
+		if err := h.db.CompactRange_s(util.Range{}); err != nil {
+			t.Fatal("CompactRange_s: got error: ", err)
+		}
+		for i := 0; i < 3; i++ {
+			if err := h.db.compTriggerWait(h.db.tcompCmdCs); err != nil {
+				t.Fatal("compaction error: ", err)
+			}
+		}
+	}
+
+	for i := 0; i < 100; i++ {
+		put(key(i), value(i, 0))
+	}
+	compact()
+	v := h.db.s.version()
+	if len(v.blobs) != 1 {
+		t.Fatalf("want 1 blob file in version got %d", len(v.blobs))
+	}
+	var num int64
+	for num = range v.blobs {
+	}
+	v.release()
+
+	// Pretend the blob file was already collected while fully live, it must
+	// be picked again once its live ratio drops.
+	h.db.blobGCTried = map[int64]float64{num: 1}
+	for i := 0; i < 100; i += 2 {
+		put(key(i), value(i, 1))
+	}
+	compact()
+	v = h.db.s.version()
+	if f, ok := v.blobs[num]; ok {
+		t.Errorf("blob file @%d with live ratio %.2f not retried", num, f.live())
+	}
+	v.release()
+	for i := 0; i < 100; i++ {
+		want := value(i, i%2^1)
+		if v, err := h.db.Get_s([]byte(key(i)), h.ro); err != nil || string(v) != want {
+			t.Fatalf("Get_s(%s): want %q got %q, err=%v", key(i), want, v, err)
+		}
+	}
+}
+
+func TestDB_IngestExternalFiles(t *testing.T) {
+	h := newDbHarness(t)
+	defer h.close()
+
+	dir := t.TempDir()
+	n := 0
+	writeSST := func(fn func(w *SSTWriter)) string {
+		n++
+		path := filepath.Join(dir, fmt.Sprintf("%d.sst", n))
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatal("Create: got error: ", err)
+		}
+		w := NewSSTWriter(f, h.o)
+		fn(w)
+		if err := w.Close(); err != nil {
+			t.Fatal("SSTWriter.Close: got error: ", err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal("Close: got error: ", err)
+		}
+		return path
+	}
+	put := func(w *SSTWriter, k, v string) {
+		if err := w.Put([]byte(k), []byte(v)); err != nil {
+			t.Fatalf("SSTWriter.Put(%s): got error: %v", k, err)
+		}
+	}
+
+	h.put("k05", "old")
+	h.put("k07", "old")
+	h.compactMem()
+	h.put("k06", "mem")
+	snap := h.getSnapshot()
+	defer snap.Release()
+
+	bulk := writeSST(func(w *SSTWriter) {
+		for i := 0; i < 50; i++ {
+			put(w, fmt.Sprintf("a%02d", i), "bulk")
+		}
+		if err := w.Put([]byte("a00"), []byte("again")); err != ErrSSTWriterOrder {
+			t.Errorf("SSTWriter.Put out of order: want ErrSSTWriterOrder got %v", err)
+		}
+	})
+	over := writeSST(func(w *SSTWriter) {
+		put(w, "k05", "new")
+		if err := w.Delete([]byte("k06")); err != nil {
+			t.Fatal("SSTWriter.Delete: got error: ", err)
+		}
+	})
+	if err := h.db.IngestExternalFiles([]string{bulk, over, bulk}, opt.PrimaryTree); err != ErrIngestOverlap {
+		t.Fatalf("IngestExternalFiles overlapping: want ErrIngestOverlap got %v", err)
+	}
+	if err := h.db.IngestExternalFiles([]string{over, bulk}, opt.PrimaryTree); err != nil {
+		t.Fatal("IngestExternalFiles: got error: ", err)
+	}
+
+	// The memdb entries of the ingested key range got flushed.
+	v := h.db.s.version()
+	var ingested []int
+	for level, tables := range v.levels {
+		for _, t := range tables {
+			if t.seq != 0 {
+				ingested = append(ingested, level)
+			}
+		}
+	}
+	v.release()
+	if len(ingested) != 2 {
+		t.Fatalf("want 2 ingested tables got %d", len(ingested))
+	}
+	if ingested[len(ingested)-1] == 0 {
+		t.Errorf("non-overlapping table ingested at L0")
+	}
+
+	check := func() {
+		for i := 0; i < 50; i++ {
+			h.getVal(fmt.Sprintf("a%02d", i), "bulk")
+		}
+		h.getVal("k05", "new")
+		h.get("k06", false)
+		h.getVal("k07", "old")
+	}
+	check()
+	h.getValr(snap, "k05", "old")
+	h.getValr(snap, "k06", "mem")
+	h.getr(snap, "a00", false)
+	want := ""
+	for i := 0; i < 50; i++ {
+		want += fmt.Sprintf("(a%02d->bulk)", i)
+	}
+	h.getKeyVal(want + "(k05->new)(k07->old)")
+	iter := snap.NewIterator(nil, nil)
+	res := ""
+	for iter.Next() {
+		res += fmt.Sprintf("(%s->%s)", iter.Key(), iter.Value())
+	}
+	iter.Release()
+	if want := "(k05->old)(k06->mem)(k07->old)"; res != want {
+		t.Errorf("snapshot iterator: want %q got %q", want, res)
+	}
+
+	// Later writes shadow the ingested entries.
+	h.put("a01", "put")
+	h.getVal("a01", "put")
+	h.put("a01", "bulk")
+
+	h.reopenDB()
+	check()
+	h.compactRange("", "")
+	check()
+
+	// The secondary tree.
+	sec := writeSST(func(w *SSTWriter) {
+		put(w, "s1", "sec")
+	})
+	if err := h.db.IngestExternalFiles([]string{sec}, opt.SecondaryTree); err != nil {
+		t.Fatal("IngestExternalFiles: got error: ", err)
+	}
+	if v, err := h.db.Get_s([]byte("s1"), h.ro); err != nil || string(v) != "sec" {
+		t.Errorf("Get_s(s1): want sec got %q, err=%v", v, err)
+	}
+	h.get("s1", false)
+	h.reopenDB()
+	if v, err := h.db.Get_s([]byte("s1"), h.ro); err != nil || string(v) != "sec" {
+		t.Errorf("Get_s(s1) after reopen: want sec got %q, err=%v", v, err)
+	}
+}
+
+func TestDB_Checkpoint(t *testing.T) {
+	dir := t.TempDir()
+	o := &opt.Options{WriteBuffer: 4 * opt.KiB, BlobThreshold: 100}
+	db, err := OpenFile(filepath.Join(dir, "db"), o)
+	if err != nil {
+		t.Fatal("OpenFile: got error: ", err)
+	}
+	defer db.Close()
+
+	value := func(i int) []byte { return []byte(strings.Repeat(fmt.Sprint(i), 1+i%150)) }
+	for i := 0; i < 500; i++ {
+		key := []byte(fmt.Sprintf("k%03d", i))
+		if err := db.Put(key, value(i), nil); err != nil {
+			t.Fatal("Put: got error: ", err)
+		}
+		if err := db.Put_s(key, value(i), nil); err != nil {
+			t.Fatal("Put_s: got error: ", err)
+		}
+		if i%7 == 0 {
+			if err := db.Delete([]byte(fmt.Sprintf("k%03d", i/2)), nil); err != nil {
+				t.Fatal("Delete: got error: ", err)
+			}
+		}
+	}
+	if err := db.CompactRange(util.Range{Limit: []byte("k200")}); err != nil {
+		t.Fatal("CompactRange: got error: ", err)
+	}
+
+	dump := func(iter iterator.Iterator) string {
+		defer iter.Release()
+		var buf bytes.Buffer
+		for iter.Next() {
+			fmt.Fprintf(&buf, "%s=%s,", iter.Key(), iter.Value())
+		}
+		if err := iter.Error(); err != nil {
+			t.Fatal("iterator: got error: ", err)
+		}
+		return buf.String()
+	}
+	snap, err := db.GetSnapshot()
+	if err != nil {
+		t.Fatal("GetSnapshot: got error: ", err)
+	}
+	want, want2 := dump(snap.NewIterator(nil, nil)), dump(snap.NewIterator_s(nil, nil))
+	snap.Release()
+
+	cp := filepath.Join(dir, "checkpoint")
+	if err := db.Checkpoint(cp); err != nil {
+		t.Fatal("Checkpoint: got error: ", err)
+	}
+	if err := db.Checkpoint(cp); err != ErrCheckpointExists {
+		t.Errorf("Checkpoint to existing dir: want ErrCheckpointExists got %v", err)
+	}
+
+	// Later writes don't reach the checkpoint.
+	if err := db.Put([]byte("after"), []byte("v"), nil); err != nil {
+		t.Fatal("Put: got error: ", err)
+	}
+	if err := db.Put_s([]byte("after"), []byte("v"), nil); err != nil {
+		t.Fatal("Put_s: got error: ", err)
+	}
+	if err := db.CompactRange(util.Range{}); err != nil {
+		t.Fatal("CompactRange: got error: ", err)
+	}
+	if err := db.CompactRange_s(util.Range{}); err != nil {
+		t.Fatal("CompactRange_s: got error: ", err)
+	}
+
+	cdb, err := OpenFile(cp, o)
+	if err != nil {
+		t.Fatal("OpenFile(checkpoint): got error: ", err)
+	}
+	defer cdb.Close()
+	if got := dump(cdb.NewIterator(nil, nil)); got != want {
+		t.Errorf("checkpoint primary tree differs:\nwant %s\ngot  %s", want, got)
+	}
+	if got := dump(cdb.NewIterator_s(nil, nil)); got != want2 {
+		t.Errorf("checkpoint secondary tree differs:\nwant %s\ngot  %s", want2, got)
+	}
+}
+
+func TestDB_OpenSecondary(t *testing.T) {
+	dir := t.TempDir()
+	o := &opt.Options{WriteBuffer: 4 * opt.KiB, BlobThreshold: 100}
+	db, err := OpenFile(filepath.Join(dir, "db"), o)
+	if err != nil {
+		t.Fatal("OpenFile: got error: ", err)
+	}
+	defer func() { db.Close() }()
+
+	value := func(i, round int) []byte { return []byte(strings.Repeat(fmt.Sprint(i+round), 1+i%150)) }
+	write := func(round int) {
+		for i := 0; i < 300; i++ {
+			key := []byte(fmt.Sprintf("k%03d", i))
+			if err := db.Put(key, value(i, round), nil); err != nil {
+				t.Fatal("Put: got error: ", err)
+			}
+			if err := db.Put_s(key, value(i, round), nil); err != nil {
+				t.Fatal("Put_s: got error: ", err)
+			}
+			if i%7 == round {
+				if err := db.Delete([]byte(fmt.Sprintf("k%03d", i/2)), nil); err != nil {
+					t.Fatal("Delete: got error: ", err)
+				}
+			}
+		}
+	}
+	dump := func(iter iterator.Iterator) string {
+		defer iter.Release()
+		var buf bytes.Buffer
+		for iter.Next() {
+			fmt.Fprintf(&buf, "%s=%s,", iter.Key(), iter.Value())
+		}
+		if err := iter.Error(); err != nil {
+			t.Fatal("iterator: got error: ", err)
+		}
+		return buf.String()
+	}
+	check := func(sdb *DB, name string) {
+		if want, got := dump(db.NewIterator(nil, nil)), dump(sdb.NewIterator(nil, nil)); got != want {
+			t.Errorf("%s: primary tree differs:\nwant %s\ngot  %s", name, want, got)
+		}
+		if want, got := dump(db.NewIterator_s(nil, nil)), dump(sdb.NewIterator_s(nil, nil)); got != want {
+			t.Errorf("%s: secondary tree differs:\nwant %s\ngot  %s", name, want, got)
+		}
+	}
+
+	write(0)
+	if err := db.CompactRange(util.Range{Limit: []byte("k100")}); err != nil {
+		t.Fatal("CompactRange: got error: ", err)
+	}
+	if err := db.Put([]byte("unflushed"), []byte("v"), nil); err != nil {
+		t.Fatal("Put: got error: ", err)
+	}
+	sdb, err := OpenSecondary(filepath.Join(dir, "db"), filepath.Join(dir, "secondary"), o)
+	if err != nil {
+		t.Fatal("OpenSecondary: got error: ", err)
+	}
+	defer sdb.Close()
+	check(sdb, "open")
+	if _, err := OpenSecondary(filepath.Join(dir, "db"), filepath.Join(dir, "secondary"), o); err == nil {
+		t.Error("OpenSecondary with a secondary path in use: expect error")
+	}
+	if err := sdb.Put([]byte("k"), []byte("v"), nil); err != ErrReadOnly {
+		t.Errorf("Put: want ErrReadOnly got %v", err)
+	}
+	if err := db.TryCatchUpWithPrimary(); err != ErrNotSecondary {
+		t.Errorf("TryCatchUpWithPrimary of the primary: want ErrNotSecondary got %v", err)
+	}
+
+	// The secondary stays at its state until it catches up.
+	for round := 1; round < 3; round++ {
+		key := []byte(fmt.Sprintf("round%d", round))
+		if err := db.Put_s(key, []byte("v"), nil); err != nil {
+			t.Fatal("Put_s: got error: ", err)
+		}
+		write(round)
+		if err := db.CompactRange_s(util.Range{Start: []byte("k100")}); err != nil {
+			t.Fatal("CompactRange_s: got error: ", err)
+		}
+		write(round + 2)
+		if _, err := sdb.Get_s(key, nil); err != ErrNotFound {
+			t.Errorf("Get_s before catching up: want ErrNotFound got %v", err)
+		}
+		if err := sdb.TryCatchUpWithPrimary(); err != nil {
+			t.Fatal("TryCatchUpWithPrimary: got error: ", err)
+		}
+		check(sdb, fmt.Sprintf("round %d", round))
+		if err := sdb.TryCatchUpWithPrimary(); err != nil {
+			t.Fatal("TryCatchUpWithPrimary: got error: ", err)
+		}
+	}
+
+	// The reopened primary writes a new manifest.
+	db.Close()
+	if db, err = OpenFile(filepath.Join(dir, "db"), o); err != nil {
+		t.Fatal("OpenFile: got error: ", err)
+	}
+	write(5)
+	if err := sdb.TryCatchUpWithPrimary(); err != nil {
+		t.Fatal("TryCatchUpWithPrimary: got error: ", err)
+	}
+	check(sdb, "reopen")
+}
+
+func TestDB_PrefixFilter(t *testing.T) {
+	o := &opt.Options{
+		DisableLargeBatchTransaction: true,
+		DisableSeeksCompaction:       true,
+		CompactionL0Trigger:          100,
+		WriteL0PauseTrigger:          100,
+		WriteL0SlowdownTrigger:       100,
+		Filter:                       filter.NewBloomFilter(10),
+		PrefixExtractor:              filter.NewFixedPrefix(2),
+	}
+	h := newDbHarnessWopt(t, o)
+	defer h.close()
+
+	// Every table spans the whole key space, with keys of its own prefix.
+	const n = 6
+	for i := 0; i < n; i++ {
+		h.put(fmt.Sprintf("00-%d", i), "v")
+		h.put(fmt.Sprintf("zz-%d", i), "v")
+		for j := 0; j < 50; j++ {
+			h.put(fmt.Sprintf("m%c-%03d", 'a'+i, j), fmt.Sprint(i, j))
+		}
+		h.compactMem()
+	}
+
+	// Returns the number of tables the iterator over the prefix range reads.
+	numTables := func(prefix string) (num int) {
+		slice := util.BytesPrefix([]byte(prefix))
+		p := h.db.slicePrefix(slice)
+		if p == nil {
+			t.Fatalf("%q: no prefix filtering", prefix)
+		}
+		islice := &util.Range{
+			Start: makeInternalKey(nil, slice.Start, keyMaxSeq, keyTypeSeek),
+			Limit: makeInternalKey(nil, slice.Limit, keyMaxSeq, keyTypeSeek),
+		}
+		v := h.db.s.version()
+		defer v.release()
+		for _, tables := range v.levels {
+			num += len(tables.prefixMatches(h.db.s.tops, h.db.s.icmp, islice, p, nil))
+		}
+		return
+	}
+	count := func(prefix string) (num int) {
+		iter := h.db.NewIterator(util.BytesPrefix([]byte(prefix)), nil)
+		defer iter.Release()
+		for iter.Next() {
+			if !bytes.HasPrefix(iter.Key(), []byte(prefix)) {
+				t.Fatalf("%q: got key %q", prefix, iter.Key())
+			}
+			num++
+		}
+		if err := iter.Error(); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	if got := h.totalTables(); got != n {
+		t.Fatalf("got %d tables, want %d", got, n)
+	}
+	// Without filtering every table would be read; the bloom filter still
+	// allows for the odd false positive.
+	read := 0
+	for i := 0; i < n; i++ {
+		prefix := fmt.Sprintf("m%c", 'a'+i)
+		// Longer prefixes are filtered by their first bytes.
+		for _, p := range []string{prefix, prefix + "-01"} {
+			got := numTables(p)
+			if got < 1 {
+				t.Errorf("%q: table holding the prefix skipped", p)
+			}
+			read += got
+		}
+		if got := count(prefix); got != 50 {
+			t.Errorf("%q: got %d keys, want 50", prefix, got)
+		}
+		if got := count(prefix + "-01"); got != 10 {
+			t.Errorf("%q: got %d keys, want 10", prefix+"-01", got)
+		}
+	}
+	read += numTables("mz")
+	if read > 2*n+2 {
+		t.Errorf("%d tables read by %d prefix iterators", read, 2*n+1)
+	}
+	if got := count("mz"); got != 0 {
+		t.Errorf("%q: got %d keys, want 0", "mz", got)
+	}
+	if h.db.slicePrefix(util.BytesPrefix([]byte("m"))) != nil {
+		t.Error("prefix shorter than the extracted one is filtered")
+	}
+	if h.db.slicePrefix(&util.Range{Start: []byte("ma"), Limit: []byte("mc")}) != nil {
+		t.Error("non-prefix range is filtered")
+	}
+	h.getVal("mc-007", "2 7")
+	h.get("mc-100", false)
+
+	// Tables written with the plain filter are read.
+	h.o.PrefixExtractor = nil
+	h.reopenDB()
+	h.put("mz-000", "v")
+	h.compactMem()
+	h.getVal("mc-007", "2 7")
+	h.o.PrefixExtractor = filter.NewFixedPrefix(2)
+	h.reopenDB()
+	if got := numTables("mz"); got < 1 {
+		t.Errorf("%q: table without prefix filter skipped", "mz")
+	}
+	if got := count("mz"); got != 1 {
+		t.Errorf("%q: got %d keys, want 1", "mz", got)
+	}
+	if got := count("mc"); got != 50 {
+		t.Errorf("%q: got %d keys, want 50", "mc", got)
+	}
+}
+
+func TestDB_PartitionedTables(t *testing.T) {
+	o := &opt.Options{
 		DisableLargeBatchTransaction: true,
-		Compression:                  opt.NoCompression,
-		WriteBuffer:                  10000000,
-	})
+		BlockSize:                    256,
+		Filter:                       filter.NewBloomFilter(10),
+		PrefixExtractor:              filter.NewFixedPrefix(3),
+		TableFormat:                  opt.PartitionedTableFormat,
+	}
+	h := newDbHarnessWopt(t, o)
 	defer h.close()
 
-	h.sizeAssert("", "xyz", 0, 0)
-	h.reopenDB()
-	h.sizeAssert("", "xyz", 0, 0)
-
-	// Write 8MB (80 values, each 100K)
-	n := 80
-	s1 := 100000
-	s2 := 105000
-
-	for i := 0; i < n; i++ {
-		h.put(numKey(i), strings.Repeat(fmt.Sprintf("v%09d", i), s1/10))
+	key := func(i int) string { return fmt.Sprintf("k%02d-%05d", i%20, i) }
+	write := func(from, to int) {
+		for i := from; i < to; i++ {
+			h.put(key(i), fmt.Sprint("v", i))
+		}
+	}
+	check := func(to int) {
+		for i := 0; i < to; i++ {
+			h.getVal(key(i), fmt.Sprint("v", i))
+		}
+		h.get("k05-99999", false)
+		iter := h.db.NewIterator(util.BytesPrefix([]byte("k07")), nil)
+		n := 0
+		for iter.Next() {
+			if want := key(7 + 20*n); string(iter.Key()) != want {
+				t.Fatalf("iterator: got key %q, want %q", iter.Key(), want)
+			}
+			n++
+		}
+		iter.Release()
+		if err := iter.Error(); err != nil {
+			t.Fatal(err)
+		}
+		if want := (to + 12) / 20; n != want {
+			t.Errorf("iterator: got %d keys, want %d", n, want)
+		}
 	}
 
-	// 0 because SizeOf() does not account for memtable space
-	h.sizeAssert("", numKey(50), 0, 0)
-
-	for r := 0; r < 3; r++ {
-		h.reopenDB()
+	write(0, 2000)
+	h.compactMem()
+	check(2000)
 
-		for cs := 0; cs < n; cs += 10 {
-			for i := 0; i < n; i += 10 {
-				h.sizeAssert("", numKey(i), int64(s1*i), int64(s2*i))
-				h.sizeAssert("", numKey(i)+".suffix", int64(s1*(i+1)), int64(s2*(i+1)))
-				h.sizeAssert(numKey(i), numKey(i+10), int64(s1*10), int64(s2*10))
-			}
+	// Tables of both formats are read along.
+	h.o.TableFormat = opt.BlockTableFormat
+	h.reopenDB()
+	write(2000, 3000)
+	h.compactMem()
+	check(3000)
+	h.compactRange("", "")
+	check(3000)
+	h.o.TableFormat = opt.PartitionedTableFormat
+	h.reopenDB()
+	check(3000)
+	h.compactRange("", "")
+	check(3000)
+}
 
-			h.sizeAssert("", numKey(50), int64(s1*50), int64(s2*50))
-			h.sizeAssert("", numKey(50)+".suffix", int64(s1*50), int64(s2*50))
+func TestDB_XorFilter(t *testing.T) {
+	bloom, xor := filter.NewBloomFilter(10), filter.NewXorFilter(10)
+	o := &opt.Options{DisableLargeBatchTransaction: true, Filter: xor}
+	h := newDbHarnessWopt(t, o)
+	defer h.close()
 
-			h.compactRangeAt(0, numKey(cs), numKey(cs+9))
-		}
+	for i := 0; i < 200; i++ {
+		h.put(fmt.Sprintf("k%03d", i), fmt.Sprint(i))
+	}
+	h.compactMem()
 
+	// Returns the number of keys missing from the tables that are ruled
+	// out by their filters, out of 199 all before the last key.
+	filtered := func() (n int) {
 		v := h.db.s.version()
-		if v.tLen(0) != 0 {
-			t.Errorf("level-0 tables was not zero, got %d", v.tLen(0))
+		defer v.release()
+		for _, tables := range v.levels {
+			for _, tf := range tables {
+				for i := 0; i < 199; i++ {
+					ikey := makeInternalKey(nil, []byte(fmt.Sprintf("k%03da", i)), keyMaxSeq, keyTypeSeek)
+					if _, _, err := h.db.s.tops.find(tf, ikey, nil); err == ErrNotFound {
+						n++
+					}
+				}
+			}
 		}
-		if v.tLen(1) == 0 {
-			t.Error("level-1 tables was zero")
+		return
+	}
+	check := func() {
+		for i := 0; i < 200; i++ {
+			h.getVal(fmt.Sprintf("k%03d", i), fmt.Sprint(i))
 		}
-		v.release()
+		h.get("k050a", false)
+	}
+
+	if n := filtered(); n < 190 {
+		t.Errorf("xor filter: %d keys ruled out, want about 199", n)
+	}
+	check()
+
+	// Tables written with the xor filter are read with it as an
+	// alternative filter.
+	h.o.Filter, h.o.AltFilters = bloom, []filter.Filter{xor}
+	h.reopenDB()
+	if n := filtered(); n < 190 {
+		t.Errorf("xor alternative filter: %d keys ruled out, want about 199", n)
+	}
+	check()
+	h.o.AltFilters = nil
+	h.reopenDB()
+	if n := filtered(); n != 0 {
+		t.Errorf("no matching filter: %d keys ruled out, want 0", n)
+	}
+	check()
+
+	// And the other way around.
+	h.compactRange("", "")
+	h.o.Filter, h.o.AltFilters = xor, []filter.Filter{bloom}
+	h.reopenDB()
+	if n := filtered(); n < 190 {
+		t.Errorf("bloom alternative filter: %d keys ruled out, want about 199", n)
 	}
+	check()
 }
 
-func TestDB_SizeOf_MixOfSmallAndLarge(t *testing.T) {
+func TestDB_MultiGet(t *testing.T) {
 	h := newDbHarnessWopt(t, &opt.Options{
 		DisableLargeBatchTransaction: true,
-		Compression:                  opt.NoCompression,
+		Filter:                       filter.NewBloomFilter(10),
+		BlockSize:                    256,
 	})
 	defer h.close()
 
-	sizes := []int64{
-		10000,
-		10000,
-		100000,
-		10000,
-		100000,
-		10000,
-		300000,
-		10000,
+	// Keys spread over the deeper levels, level-0 and the memdb, with
+	// deletions and range deletions at each of them.
+	for i := 0; i < 300; i++ {
+		h.put(fmt.Sprintf("k%03d", i), fmt.Sprint(i))
+		h.db.Put_s([]byte(fmt.Sprintf("s%03d", i)), []byte(fmt.Sprint(i)), h.wo)
 	}
-
-	for i, n := range sizes {
-		h.put(numKey(i), strings.Repeat(fmt.Sprintf("v%09d", i), int(n)/10))
+	h.compactMem()
+	h.compactMem_s()
+	h.compactRange("", "")
+	for i := 0; i < 300; i += 3 {
+		h.put(fmt.Sprintf("k%03d", i), fmt.Sprint(i, "b"))
+		h.db.Put_s([]byte(fmt.Sprintf("s%03d", i)), []byte(fmt.Sprint(i, "b")), h.wo)
 	}
-
-	for r := 0; r < 3; r++ {
-		h.reopenDB()
-
-		var x int64
-		for i, n := range sizes {
-			y := x
-			if i > 0 {
-				y += 1000
+	for i := 0; i < 300; i += 5 {
+		h.delete(fmt.Sprintf("k%03d", i))
+		h.db.Delete_s([]byte(fmt.Sprintf("s%03d", i)), h.wo)
+	}
+	h.db.DeleteRange([]byte("k100"), []byte("k120"), h.wo)
+	h.db.DeleteRange_s([]byte("s100"), []byte("s120"), h.wo)
+	h.compactMem()
+	h.compactMem_s()
+	for i := 0; i < 300; i += 7 {
+		h.put(fmt.Sprintf("k%03d", i), fmt.Sprint(i, "c"))
+		h.db.Put_s([]byte(fmt.Sprintf("s%03d", i)), []byte(fmt.Sprint(i, "c")), h.wo)
+	}
+	h.delete("k001")
+	h.db.Delete_s([]byte("s001"), h.wo)
+
+	// Present and absent keys, unsorted and with duplicates.
+	query := func(p string) (keys [][]byte) {
+		for i := 0; i < 300; i++ {
+			keys = append(keys, []byte(fmt.Sprintf("%s%03d", p, (i*7919)%300)))
+			if i%10 == 0 {
+				keys = append(keys, []byte(fmt.Sprintf("%s%03da", p, i)))
+			}
+		}
+		return append(keys, []byte("a"), []byte("z"), []byte(fmt.Sprintf("%s042", p)))
+	}
+	check := func(name string, keys [][]byte, multiGet func([][]byte, *opt.ReadOptions) ([][]byte, []error), get func([]byte, *opt.ReadOptions) ([]byte, error)) {
+		values, errs := multiGet(keys, h.ro)
+		if len(values) != len(keys) || len(errs) != len(keys) {
+			t.Fatalf("%s: got %d values and %d errors for %d keys", name, len(values), len(errs), len(keys))
+		}
+		found := 0
+		for i, key := range keys {
+			value, err := get(key, h.ro)
+			if errs[i] != err || string(values[i]) != string(value) {
+				t.Errorf("%s: key %q: got %q (%v), want %q (%v)", name, key, values[i], errs[i], value, err)
+			}
+			if err == nil {
+				found++
 			}
-			h.sizeAssert("", numKey(i), x, y)
-			x += n
 		}
+		if found == 0 || found == len(keys) {
+			t.Errorf("%s: %d of %d keys found", name, found, len(keys))
+		}
+	}
+	check("MultiGet", query("k"), h.db.MultiGet, h.db.Get)
+	check("MultiGet_s", query("s"), h.db.MultiGet_s, h.db.Get_s)
+	if values, errs := h.db.MultiGet(nil, h.ro); len(values) != 0 || len(errs) != 0 {
+		t.Errorf("MultiGet of no keys: got %d values", len(values))
+	}
 
-		h.sizeAssert(numKey(3), numKey(5), 110000, 111000)
+	// All from the tables.
+	h.compactMem()
+	h.compactMem_s()
+	check("MultiGet after compaction", query("k"), h.db.MultiGet, h.db.Get)
+	check("MultiGet_s after compaction", query("s"), h.db.MultiGet_s, h.db.Get_s)
 
-		h.compactRangeAt(0, "", "")
+	h.closeDB()
+	if _, errs := h.db.MultiGet([][]byte{[]byte("k002")}, h.ro); errs[0] != ErrClosed {
+		t.Errorf("MultiGet on closed DB: got %v, want ErrClosed", errs[0])
 	}
 }
 
-func TestDB_Snapshot(t *testing.T) {
-	trun(t, func(h *dbHarness) {
-		h.put("foo", "v1")
-		s1 := h.getSnapshot()
-		h.put("foo", "v2")
-		s2 := h.getSnapshot()
-		h.put("foo", "v3")
-		s3 := h.getSnapshot()
-		h.put("foo", "v4")
-
-		h.getValr(s1, "foo", "v1")
-		h.getValr(s2, "foo", "v2")
-		h.getValr(s3, "foo", "v3")
-		h.getVal("foo", "v4")
+func TestDB_DeleteRangeCompaction(t *testing.T) {
+	h := newDbHarness(t)
+	defer h.close()
 
-		s3.Release()
-		h.getValr(s1, "foo", "v1")
-		h.getValr(s2, "foo", "v2")
-		h.getVal("foo", "v4")
+	// Deeper tables fully covered by the deletion are dropped unread, the
+	// partially covered ones lose the covered keys.
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 10; j++ {
+			h.put(fmt.Sprintf("k%d%d", i, j), "v")
+		}
+		h.compactMem()
+		h.compactRangeAt(0, "", "")
+		h.compactRangeAt(1, "", "")
+	}
+	h.tablesPerLevel("0,0,3")
+	if err := h.db.DeleteRange([]byte("k05"), []byte("k3"), h.wo); err != nil {
+		t.Fatal("DeleteRange: got error: ", err)
+	}
+	h.compactMem()
+	h.compactRange("", "")
 
-		s1.Release()
-		h.getValr(s2, "foo", "v2")
-		h.getVal("foo", "v4")
+	h.getVal("k04", "v")
+	h.get("k05", false)
+	h.get("k29", false)
+	h.allEntriesFor("k05", "[ ]")
+	h.allEntriesFor("k15", "[ ]")
+	h.assertNumKeys(5)
+	h.tablesPerLevel("0,0,1")
 
-		s2.Release()
-		h.getVal("foo", "v4")
-	})
+	// The tombstone went away along with the keys it covered.
+	h.put("k15", "v2")
+	h.getVal("k15", "v2")
 }
 
+
 func TestDB_SnapshotList(t *testing.T) {
 	db := &DB{snapsList: list.New()}
 	e0a := db.acquireSnapshot()
@@ -2171,6 +3715,218 @@ func TestDB_GetProperties(t *testing.T) {
 	}
 }
 
+func TestDB_GetProperties_s(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		Compression:                  opt.NoCompression,
+	})
+	defer h.close()
+
+	for i := 0; i < 10; i++ {
+		if err := h.db.Put_s([]byte(numKey(i)), bytes.Repeat([]byte{'v'}, 10000), h.wo); err != nil {
+			t.Fatal("Put_s: got error: ", err)
+		}
+	}
+	h.compactMem_s()
+
+	var files, files_s int
+	for level := 0; level < 7; level++ {
+		for _, x := range []struct {
+			name string
+			n    *int
+		}{
+			{fmt.Sprintf("leveldb.num-files-at-level%d", level), &files},
+			{fmt.Sprintf("leveldb.s.num-files-at-level%d", level), &files_s},
+		} {
+			value, err := h.db.GetProperty(x.name)
+			if err != nil {
+				t.Fatalf("GetProperty(%q): got error: %v", x.name, err)
+			}
+			n, _ := strconv.Atoi(value)
+			*x.n += n
+		}
+	}
+	if files != 0 || files_s == 0 {
+		t.Errorf("got %d primary and %d secondary files, want 0 and >0", files, files_s)
+	}
+
+	if value, err := h.db.GetProperty("leveldb.s.stats"); err != nil {
+		t.Error("GetProperty(leveldb.s.stats): got error: ", err)
+	} else if !strings.Contains(value, "Total") {
+		t.Errorf("GetProperty(leveldb.s.stats): got %q", value)
+	}
+	if _, err := h.db.GetProperty("leveldb.s.iostats"); err != ErrNotFound {
+		t.Errorf("GetProperty(leveldb.s.iostats): want ErrNotFound got %v", err)
+	}
+
+	r := []util.Range{{Start: []byte(numKey(0)), Limit: []byte(numKey(10))}}
+	if sz, err := h.db.SizeOf(r); err != nil || sz.Sum() != 0 {
+		t.Errorf("SizeOf: want 0 got %d, err=%v", sz.Sum(), err)
+	}
+	if sz, err := h.db.SizeOf_s(r); err != nil || sz.Sum() < 100000 || sz.Sum() > 110000 {
+		t.Errorf("SizeOf_s: want 100000 - 110000 got %d, err=%v", sz.Sum(), err)
+	}
+}
+
+func TestDB_Stats(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		Compression:                  opt.NoCompression,
+	})
+	defer h.close()
+
+	h.put("foo", "v1")
+	if err := h.db.Put_s([]byte("bar"), []byte("v1"), h.wo); err != nil {
+		t.Fatal("Put_s: got error: ", err)
+	}
+
+	var s DBStats
+	if err := h.db.Stats(&s); err != nil {
+		t.Fatal("Stats: got error: ", err)
+	}
+	if s.MemdbSize == 0 || s.MemdbSize_s == 0 {
+		t.Errorf("got memdb sizes %d and %d, want >0", s.MemdbSize, s.MemdbSize_s)
+	}
+
+	h.compactMem()
+	h.compactMem_s()
+	if err := h.db.Stats(&s); err != nil {
+		t.Fatal("Stats: got error: ", err)
+	}
+	sum := func(counts []int) (n int) {
+		for _, c := range counts {
+			n += c
+		}
+		return
+	}
+	if n := sum(s.LevelTablesCounts); n != 1 {
+		t.Errorf("got %d primary tables, want 1", n)
+	}
+	if n := sum(s.LevelTablesCounts_s); n != 1 {
+		t.Errorf("got %d secondary tables, want 1", n)
+	}
+	if s.LevelWrite.Sum() == 0 || s.LevelWrite_s.Sum() == 0 {
+		t.Errorf("got compaction writes %d and %d, want >0", s.LevelWrite.Sum(), s.LevelWrite_s.Sum())
+	}
+	if s.MemComp != 1 || s.MemComp_s != 1 {
+		t.Errorf("got memdb compactions %d and %d, want 1", s.MemComp, s.MemComp_s)
+	}
+}
+
+func TestDB_BlockCachers(t *testing.T) {
+	for _, cacher := range []opt.Cacher{opt.LRUCacher, opt.ARCCacher, opt.SGCCacher} {
+		h := newDbHarnessWopt(t, &opt.Options{
+			DisableLargeBatchTransaction: true,
+			BlockCacher:                  cacher,
+		})
+		for i := 0; i < 100; i++ {
+			h.put(numKey(i), fmt.Sprintf("v%d", i))
+		}
+		h.compactMem()
+		for r := 0; r < 2; r++ {
+			for i := 0; i < 100; i++ {
+				h.getVal(numKey(i), fmt.Sprintf("v%d", i))
+			}
+		}
+		if h.db.s.tops.bcache.Size() == 0 {
+			t.Error("no cached blocks")
+		}
+		h.close()
+	}
+}
+
+func TestDB_BlockCacheStats(t *testing.T) {
+	h1 := newDbHarness(t)
+	defer h1.close()
+	h2 := newDbHarness(t)
+	defer h2.close()
+
+	for i := 0; i < 10; i++ {
+		h1.put(numKey(i), "v")
+	}
+	h1.compactMem()
+	for r := 0; r < 2; r++ {
+		for i := 0; i < 10; i++ {
+			h1.getVal(numKey(i), "v")
+		}
+	}
+
+	var s1, s2 DBStats
+	if err := h1.db.Stats(&s1); err != nil {
+		t.Fatal("Stats: got error: ", err)
+	}
+	if err := h2.db.Stats(&s2); err != nil {
+		t.Fatal("Stats: got error: ", err)
+	}
+	if s1.BlockCacheHits == 0 || s1.BlockCacheMisses == 0 {
+		t.Errorf("got %d hits and %d misses, want >0", s1.BlockCacheHits, s1.BlockCacheMisses)
+	}
+	if s2.BlockCacheHits != 0 || s2.BlockCacheMisses != 0 {
+		t.Errorf("got %d hits and %d misses on an unused DB, want 0", s2.BlockCacheHits, s2.BlockCacheMisses)
+	}
+
+	value, err := h1.db.GetProperty("leveldb.blockcache")
+	if err != nil {
+		t.Fatal("GetProperty: got error: ", err)
+	}
+	if want := fmt.Sprintf("Hits:%d Misses:%d", s1.BlockCacheHits, s1.BlockCacheMisses); !strings.Contains(value, want) {
+		t.Errorf("GetProperty(leveldb.blockcache): got %q, want it to contain %q", value, want)
+	}
+}
+
+func TestDB_SecondaryBlockCache(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		Compression:                  opt.NoCompression,
+		SecondaryBlockCacheCapacity:  -1,
+	})
+	defer h.close()
+
+	for i := 0; i < 10; i++ {
+		h.put(numKey(i), "v")
+		if err := h.db.Put_s([]byte(numKey(i)), []byte("v_s"), h.wo); err != nil {
+			t.Fatal("Put_s: got error: ", err)
+		}
+	}
+	h.compactMem()
+	h.compactMem_s()
+
+	var before, after DBStats
+	if err := h.db.Stats(&before); err != nil {
+		t.Fatal("Stats: got error: ", err)
+	}
+	for i := 0; i < 10; i++ {
+		if v, err := h.db.Get_s([]byte(numKey(i)), h.ro); err != nil || string(v) != "v_s" {
+			t.Fatalf("Get_s(%s): got %q, %v", numKey(i), v, err)
+		}
+	}
+	if err := h.db.Stats(&after); err != nil {
+		t.Fatal("Stats: got error: ", err)
+	}
+	if after.BlockCacheHits != before.BlockCacheHits || after.BlockCacheMisses != before.BlockCacheMisses {
+		t.Error("secondary reads went through the primary block cache")
+	}
+	if after.BlockCacheSize_s != 0 || after.BlockCacheHits_s != 0 {
+		t.Errorf("got secondary block cache size %d and %d hits, want 0", after.BlockCacheSize_s, after.BlockCacheHits_s)
+	}
+	if after.OpenedTablesCount_s == 0 {
+		t.Error("secondary table is not in the secondary open files cache")
+	}
+
+	for i := 0; i < 10; i++ {
+		h.getVal(numKey(i), "v")
+	}
+	if err := h.db.Stats(&after); err != nil {
+		t.Fatal("Stats: got error: ", err)
+	}
+	if after.BlockCacheSize == 0 {
+		t.Error("primary block cache is empty")
+	}
+	if v, err := h.db.GetProperty("leveldb.s.cachedblock"); err != nil || v != "0" {
+		t.Errorf("GetProperty(leveldb.s.cachedblock): got %q, %v", v, err)
+	}
+}
+
 func TestDB_GoleveldbIssue72and83(t *testing.T) {
 	h := newDbHarnessWopt(t, &opt.Options{
 		DisableLargeBatchTransaction: true,
@@ -2540,7 +4296,7 @@ func TestDB_TableCompactionBuilder(t *testing.T) {
 		value      = bytes.Repeat([]byte{'0'}, 100)
 	)
 	for i := 0; i < 2; i++ {
-		tw, err := s.tops.create()
+		tw, err := s.tops.create(ratelimit.Low)
 		if err != nil {
 			t.Fatal(err)
 		}
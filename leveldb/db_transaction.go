@@ -24,11 +24,14 @@ type Transaction struct {
 	lk        sync.RWMutex
 	seq       uint64
 	mem       *memDB
+	mems      *memDB // secondary tree records
 	tables    tFiles
 	tabless   sFiles
+	blobs     []*bFile
 	ikScratch []byte
 	rec       sessionRecord
 	stats     cStatStaging
+	stats_s   cStatStaging
 	closed    bool
 }
 
@@ -117,25 +120,29 @@ func (tr *Transaction) flush() error {
 }
 func (tr *Transaction) flush_s() error {
 	// Flush memdb.
-	if tr.mem.Len_s() != 0 {
-		tr.stats.startTimer()
-		iter := tr.mem.NewIterator_s(nil)
-		t, n, err := tr.db.s.tops.createFrom_s(iter)
+	if tr.mems.Len_s() != 0 {
+		tr.stats_s.startTimer()
+		iter := tr.mems.NewIterator_s(nil)
+		t, b, n, err := tr.db.s.tops.createFrom_s(iter)
 		iter.Release()
-		tr.stats.stopTimer()
+		tr.stats_s.stopTimer()
 		if err != nil {
 			return err
 		}
-		if tr.mem.getref_s() == 1 {
-			tr.mem.Reset_s()
+		if tr.mems.getref_s() == 1 {
+			tr.mems.Reset_s()
 		} else {
-			tr.mem.decref_s()
-			tr.mem = tr.db.mpoolGet_s(0)
-			tr.mem.incref_s()
+			tr.mems.decref_s()
+			tr.mems = tr.db.mpoolGet_s(0)
+			tr.mems.incref_s()
 		}
 		tr.tabless = append(tr.tabless, t)
 		tr.rec.addTableFile_s(0, t)
-		tr.stats.write += t.size
+		if b != nil {
+			tr.blobs = append(tr.blobs, b)
+			tr.rec.addBlobFile(b)
+		}
+		tr.stats_s.write += t.size
 		tr.db.logf("transaction@flush created L0@%d N·%d S·%s %q:%q", t.fd.Num, n, shortenb(int(t.size)), t.imin, t.imax)
 	}
 	return nil
@@ -147,7 +154,7 @@ func (tr *Transaction) put(kt keyType, key, value []byte) error {
 			return err
 		}
 	}
-	if err := tr.mem.Put(tr.ikScratch, value); err != nil {
+	if err := memPut(tr.mem.DB, kt, tr.ikScratch, value); err != nil {
 		return err
 	}
 	tr.seq++
@@ -155,12 +162,12 @@ func (tr *Transaction) put(kt keyType, key, value []byte) error {
 }
 func (tr *Transaction) put_s(kt keyType, key, value []byte) error {
 	tr.ikScratch = makeInternalKey(tr.ikScratch, key, tr.seq+1, kt)
-	if tr.mem.Free_s() < len(tr.ikScratch)+len(value) {
+	if tr.mems.Free_s() < len(tr.ikScratch)+len(value) {
 		if err := tr.flush_s(); err != nil {
 			return err
 		}
 	}
-	if err := tr.mem.Put_s(tr.ikScratch, value); err != nil {
+	if err := memPut_s(tr.mems.DBs, kt, tr.ikScratch, value); err != nil {
 		return err
 	}
 	tr.seq++
@@ -196,7 +203,8 @@ func (tr *Transaction) Delete(key []byte, wo *opt.WriteOptions) error {
 }
 
 // Write apply the given batch to the transaction. The batch will be applied
-// sequentially.
+// sequentially. Records added with Batch.Put_s or Batch.Delete_s go to the
+// secondary tree.
 // Please note that the transaction is not compacted until committed, so if you
 // writes 10 same keys, then those 10 same keys are in the transaction.
 //
@@ -206,13 +214,27 @@ func (tr *Transaction) Write(b *Batch, wo *opt.WriteOptions) error {
 		return nil
 	}
 
+	tr.lk.Lock()
+	defer tr.lk.Unlock()
+	if tr.closed {
+		return errTransactionDone
+	}
+	return b.replayTrees(tr.put, tr.put_s)
+}
+
+// Like Write, but untagged records of the batch go to the secondary tree.
+func (tr *Transaction) write_s(b *Batch) error {
+	if b == nil || b.Len() == 0 {
+		return nil
+	}
+
 	tr.lk.Lock()
 	defer tr.lk.Unlock()
 	if tr.closed {
 		return errTransactionDone
 	}
 	return b.replayInternal(func(i int, kt keyType, k, v []byte) error {
-		return tr.put(kt, k, v)
+		return tr.put_s(kt, k, v)
 	})
 }
 
@@ -220,6 +242,7 @@ func (tr *Transaction) setDone() {
 	tr.closed = true
 	tr.db.tr = nil
 	tr.mem.decref()
+	tr.mems.decref_s()
 	<-tr.db.writeLockC
 }
 
@@ -242,68 +265,11 @@ func (tr *Transaction) Commit() error {
 		// transaction.
 		return err
 	}
-	if len(tr.tables) != 0 {
-		// Committing transaction.
-		tr.rec.setSeqNum(tr.seq)
-		tr.db.compCommitLk.Lock()
-		tr.stats.startTimer()
-		var cerr error
-		for retry := 0; retry < 3; retry++ {
-			cerr = tr.db.s.commit(&tr.rec, false)
-			if cerr != nil {
-				tr.db.logf("transaction@commit error R·%d %q", retry, cerr)
-				select {
-				case <-time.After(time.Second):
-				case <-tr.db.closeC:
-					tr.db.logf("transaction@commit exiting")
-					tr.db.compCommitLk.Unlock()
-					return cerr
-				}
-			} else {
-				// Success. Set db.seq.
-				tr.db.setSeq(tr.seq)
-				break
-			}
-		}
-		tr.stats.stopTimer()
-		if cerr != nil {
-			// Return error, lets user decide either to retry or discard
-			// transaction.
-			return cerr
-		}
-
-		// Update compaction stats. This is safe as long as we hold compCommitLk.
-		tr.db.compStats.addStat(0, &tr.stats)
-
-		// Trigger table auto-compaction.
-		tr.db.compTrigger(tr.db.tcompCmdC)
-		tr.db.compCommitLk.Unlock()
-
-		// Additionally, wait compaction when certain threshold reached.
-		// Ignore error, returns error only if transaction can't be committed.
-		tr.db.waitCompaction()
-	}
-	// Only mark as done if transaction committed successfully.
-	tr.setDone()
-	return nil
-}
-func (tr *Transaction) Commit_s() error {
-	if err := tr.db.ok(); err != nil {
-		return err
-	}
-
-	tr.lk.Lock()
-	defer tr.lk.Unlock()
-	if tr.closed {
-		return errTransactionDone
-	}
 	if err := tr.flush_s(); err != nil {
-		// Return error, lets user decide either to retry or discard
-		// transaction.
 		return err
 	}
-	if len(tr.tables) != 0 {
-		// Committing transaction.
+	if len(tr.tables) != 0 || len(tr.tabless) != 0 {
+		// Committing transaction, both trees at once.
 		tr.rec.setSeqNum(tr.seq)
 		tr.db.compCommitLk.Lock()
 		tr.stats.startTimer()
@@ -333,10 +299,14 @@ func (tr *Transaction) Commit_s() error {
 		}
 
 		// Update compaction stats. This is safe as long as we hold compCommitLk.
-		tr.db.compStats.addStat(0, &tr.stats)
-
-		// Trigger table auto-compaction.
-		tr.db.compTrigger(tr.db.tcompCmdCs)
+		if len(tr.tables) != 0 {
+			tr.db.compStats.addStat(0, &tr.stats)
+			tr.db.compTrigger(tr.db.tcompCmdC)
+		}
+		if len(tr.tabless) != 0 {
+			tr.db.comStatss.addStat(0, &tr.stats_s)
+			tr.db.compTrigger(tr.db.tcompCmdCs)
+		}
 		tr.db.compCommitLk.Unlock()
 
 		// Additionally, wait compaction when certain threshold reached.
@@ -347,6 +317,12 @@ func (tr *Transaction) Commit_s() error {
 	tr.setDone()
 	return nil
 }
+
+// Commit_s is the same as Commit, a transaction always commits the records
+// of both trees.
+func (tr *Transaction) Commit_s() error {
+	return tr.Commit()
+}
 func (tr *Transaction) discard() {
 	// Discard transaction.
 	for _, t := range tr.tables {
@@ -354,6 +330,14 @@ func (tr *Transaction) discard() {
 		// Iterator may still use the table, so we use tOps.remove here.
 		tr.db.s.tops.remove(t.fd)
 	}
+	for _, t := range tr.tabless {
+		tr.db.logf("transaction@discard @%d", t.fd.Num)
+		tr.db.s.tops.remove_s(t.fd)
+	}
+	for _, b := range tr.blobs {
+		tr.db.logf("transaction@discard blob@%d", b.fd.Num)
+		tr.db.s.tops.removeBlob(b.fd)
+	}
 }
 
 // Discard discards the transaction.
@@ -414,6 +398,11 @@ func (db *DB) OpenTransaction() (*Transaction, error) {
 			return nil, err
 		}
 	}
+	if db.mems != nil && db.mems.Len_s() != 0 {
+		if _, err := db.rotateMem_s(0, true); err != nil {
+			return nil, err
+		}
+	}
 
 	// Wait compaction when certain threshold reached.
 	if err := db.waitCompaction(); err != nil {
@@ -421,11 +410,13 @@ func (db *DB) OpenTransaction() (*Transaction, error) {
 	}
 
 	tr := &Transaction{
-		db:  db,
-		seq: db.seq,
-		mem: db.mpoolGet(0),
+		db:   db,
+		seq:  db.seq,
+		mem:  db.mpoolGet(0),
+		mems: db.mpoolGet_s(0),
 	}
 	tr.mem.incref()
+	tr.mems.incref_s()
 	db.tr = tr
 	return tr, nil
 }
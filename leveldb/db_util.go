@@ -57,7 +57,12 @@ func (db *DB) checkAndCleanFiles() error {
 		return err
 	}
 
-	var nt int
+	bmap := make(map[int64]bool)
+	for num := range v.blobs {
+		bmap[num] = false
+	}
+
+	var nt, nb int
 	var rem []storage.FileDesc
 	for _, fd := range fds {
 		keep := true
@@ -82,6 +87,12 @@ func (db *DB) checkAndCleanFiles() error {
 				tmap[fd.Num] = true
 				nt++
 			}
+		case storage.TypeBlob:
+			_, keep = bmap[fd.Num]
+			if keep {
+				bmap[fd.Num] = true
+				nb++
+			}
 		}
 
 		if !keep {
@@ -89,7 +100,7 @@ func (db *DB) checkAndCleanFiles() error {
 		}
 	}
 
-	if nt != len(tmap) {
+	if nt != len(tmap) || nb != len(bmap) {
 		var mfds []storage.FileDesc
 		for num, present := range tmap {
 			if !present {
@@ -97,6 +108,12 @@ func (db *DB) checkAndCleanFiles() error {
 				db.logf("db@janitor table missing @%d", num)
 			}
 		}
+		for num, present := range bmap {
+			if !present {
+				mfds = append(mfds, storage.FileDesc{Type: storage.TypeBlob, Num: num})
+				db.logf("db@janitor blob missing @%d", num)
+			}
+		}
 		return errors.NewErrCorrupted(storage.FileDesc{}, &errors.ErrMissingFiles{Fds: mfds})
 	}
 
@@ -574,11 +574,74 @@ func (db *DB) writeLocked_s(batch, ourBatch *Batch, merge, sync bool) error {
 	//fmt.Println("  Write Success， return")
 	return nil
 }
+
+// Commits a cross-tree batch. Each tree journals only its own records:
+// the secondary part goes first, then the primary part, which takes the
+// sequence numbers just below the secondary part, then an empty commit
+// record in the secondary journal. Recovery only applies the secondary part
+// if it is followed by its commit record or its primary part is still in
+// the primary journals, so either all of the batch or none of it is
+// applied. It is never merged with other writes.
+func (db *DB) writeLockedBoth(pb, sb *Batch, sync bool) error {
+	mdb, mdbFree, err := db.flush(pb.internalLen)
+	if err != nil {
+		db.unlockWrite(false, 0, err)
+		return err
+	}
+	defer mdb.decref()
+	mdbs, mdbsFree, err := db.flush_s(sb.internalLen)
+	if err != nil {
+		db.unlockWrite(false, 0, err)
+		return err
+	}
+	defer mdbs.decref_s()
+
+	n := uint64(pb.Len() + sb.Len())
+	seq := db.seq + 1
+	sseq := seq + uint64(pb.Len())
+	if err := db.writeJournal_s([]*Batch{sb}, sseq, sync); err != nil {
+		db.unlockWrite(false, 0, err)
+		return err
+	}
+	if err := db.writeJournal([]*Batch{pb}, seq, sync); err != nil {
+		// The secondary part is journaled, don't reuse its sequence
+		// numbers.
+		db.addSeq(n)
+		db.unlockWrite(false, 0, err)
+		return err
+	}
+	if err := db.writeJournal_s(nil, sseq, sync); err != nil {
+		db.addSeq(n)
+		db.unlockWrite(false, 0, err)
+		return err
+	}
+	if err := pb.putMem(seq, mdb.DB); err != nil {
+		panic(err)
+	}
+	if err := sb.putMem_s(sseq, mdbs.DBs); err != nil {
+		panic(err)
+	}
+	db.addSeq(n)
+
+	if pb.internalLen >= mdbFree {
+		db.rotateMem(0, false)
+	}
+	if sb.internalLen >= mdbsFree {
+		db.rotateMem_s(0, false)
+	}
+	db.unlockWrite(false, 0, nil)
+	return nil
+}
+
 //Write apply the given batch to the DB. The batch records will be applied
 //sequentially. Write might be used concurrently, when used concurrently and
 //batch is small enough, write will try to merge the batches. Set NoWriteMerge
 //option to true to disable write merge.
 //
+//Records added with Batch.Put_s or Batch.Delete_s go to the secondary tree;
+//such a batch is committed atomically across both trees, is never merged
+//and never written as a transaction, see DB.writeCrossTree.
+//
 //It is safe to modify the contents of the arguments after Write returns but
 //not before. Write will not modify content of the batch.
 //batch的write的实现，
@@ -586,6 +649,9 @@ func (db *DB) Write(batch *Batch, wo *opt.WriteOptions) error {
 	if err := db.ok(); err != nil || batch == nil || batch.Len() == 0 {
 		return err
 	}
+	if batch.crossTree() {
+		return db.writeCrossTree(batch, wo)
+	}
 	//如果批处理大小大于写缓冲区，则可以使用事务进行写。使用事务将批处理直接写入表中，跳过日志记录。
 	if batch.internalLen > db.s.o.GetWriteBuffer() && !db.s.o.GetDisableLargeBatchTransaction() {
 		tr, err := db.OpenTransaction()
@@ -635,17 +701,52 @@ func (db *DB) Write(batch *Batch, wo *opt.WriteOptions) error {
 
 	return db.writeLocked(batch, nil, merge, sync)
 }
+// Writes a batch holding records added with Batch.Put_s or Batch.Delete_s,
+// its other records go to the primary tree. A batch with records for the
+// secondary tree only is written as a plain secondary tree batch.
+func (db *DB) writeCrossTree(batch *Batch, wo *opt.WriteOptions) error {
+	pb, sb := batch.splitTrees(true)
+	if pb.Len() == 0 {
+		_, sb = batch.splitTrees(false)
+		return db.Write_s(sb, wo)
+	}
+
+	sync := wo.GetSync() && !db.s.o.GetNoSync()
+
+	// Acquire write lock.
+	select {
+	case db.writeLockC <- struct{}{}:
+		// Write lock acquired.
+	case err := <-db.compPerErrC:
+		// Compaction error.
+		return err
+	case <-db.closeC:
+		// Closed
+		return ErrClosed
+	}
+
+	return db.writeLockedBoth(pb, sb, sync)
+}
+
+// Write_s is like Write, but applies the batch to the secondary tree.
+//
+// A batch holding records added with Batch.Put_s or Batch.Delete_s is a
+// cross-tree batch and is written the same way as by Write: its other
+// records go to the primary tree.
 func (db *DB) Write_s(batch *Batch, wo *opt.WriteOptions) error {
 	if err := db.ok(); err != nil || batch == nil || batch.Len() == 0 {
 		return err
 	}
+	if batch.crossTree() {
+		return db.writeCrossTree(batch, wo)
+	}
 	//如果批处理大小大于写缓冲区，则可以使用事务进行写。使用事务将批处理直接写入表中，跳过日志记录。
 	if batch.internalLen > db.s.o.GetWriteBuffer() && !db.s.o.GetDisableLargeBatchTransaction() {
 		tr, err := db.OpenTransaction()
 		if err != nil {
 			return err
 		}
-		if err := tr.Write(batch, wo); err != nil {
+		if err := tr.write_s(batch); err != nil {
 			tr.Discard()
 			return err
 		}
@@ -826,12 +927,48 @@ func (db *DB) Delete(key []byte, wo *opt.WriteOptions) error {
 	return db.putRec(keyTypeDel, key, nil, wo)
 }
 
+// Delete_s deletes the value for the given key from the secondary tree.
+// Like Delete, it will not returns error if key doesn't exist.
+func (db *DB) Delete_s(key []byte, wo *opt.WriteOptions) error {
+	return db.putRec_s(keyTypeDel, key, nil, wo)
+}
+
+// DeleteRange deletes the values of all keys within [start, limit). It writes
+// a single range tombstone rather than one deletion per key; the covered
+// entries are dropped by later compactions. DeleteRange is a no-op if limit
+// is not greater than start. Write merge also applies for DeleteRange, see
+// Write.
+//
+// It is safe to modify the contents of the arguments after DeleteRange
+// returns but not before.
+func (db *DB) DeleteRange(start, limit []byte, wo *opt.WriteOptions) error {
+	if db.s.icmp.uCompare(start, limit) >= 0 {
+		return db.ok()
+	}
+	return db.putRec(keyTypeRangeDel, start, limit, wo)
+}
+
+// DeleteRange_s deletes the values of all keys within [start, limit) from the
+// secondary tree, see DeleteRange.
+func (db *DB) DeleteRange_s(start, limit []byte, wo *opt.WriteOptions) error {
+	if db.s.icmp.uCompare(start, limit) >= 0 {
+		return db.ok()
+	}
+	return db.putRec_s(keyTypeRangeDel, start, limit, wo)
+}
+
 func isMemOverlaps(icmp *iComparer, mem *memdb.DB, min, max []byte) bool {
 	iter := mem.NewIterator(nil)
 	defer iter.Release()
 	return (max == nil || (iter.First() && icmp.uCompare(max, internalKey(iter.Key()).ukey()) >= 0)) &&
 		(min == nil || (iter.Last() && icmp.uCompare(min, internalKey(iter.Key()).ukey()) <= 0))
 }
+func isMemOverlaps_s(icmp *iComparer, mem *memdb.DBs, min, max []byte) bool {
+	iter := mem.NewIterator_s(nil)
+	defer iter.Release()
+	return (max == nil || (iter.First() && icmp.uCompare(max, internalKey(iter.Key()).ukey()) >= 0)) &&
+		(min == nil || (iter.Last() && icmp.uCompare(min, internalKey(iter.Key()).ukey()) <= 0))
+}
 
 // CompactRange compacts the underlying DB for the given key range.
 // In particular, deleted and overwritten versions are discarded,
@@ -898,8 +1035,8 @@ func (db *DB) CompactRange_s(r util.Range) error {
 	if mdb == nil {
 		return ErrClosed
 	}
-	defer mdb.decref()
-	if isMemOverlaps(db.s.icmp, mdb.DB, r.Start, r.Limit) {
+	defer mdb.decref_s()
+	if isMemOverlaps_s(db.s.icmp, mdb.DBs, r.Start, r.Limit) {
 		// Memdb compaction.
 		if _, err := db.rotateMem_s(0, false); err != nil {
 			<-db.writeLockC
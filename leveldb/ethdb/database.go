@@ -1,8 +1,6 @@
 package ethdb
 
 import (
-	"strconv"
-	"strings"
 	"sync"
 	"time"
 
@@ -246,69 +244,43 @@ func (db *LDBDatabase) LDB() *leveldb.DB {
 // Meter configures the database metrics collectors and
 
 // meter periodically retrieves internal leveldb counters and reports them to
-// the metrics subsystem.
-//
-// This is how a stats table look like (currently):
-//
-//	Compactions
-//	 Level |   Tables   |    Size(MB)   |    Time(sec)  |    Read(MB)   |   Write(MB)
-//	-------+------------+---------------+---------------+---------------+---------------
-//	   0   |          0 |       0.00000 |       1.27969 |       0.00000 |      12.31098
-//	   1   |         85 |     109.27913 |      28.09293 |     213.92493 |     214.26294
-//	   2   |        523 |    1000.37159 |       7.26059 |      66.86342 |      66.77884
-//	   3   |        570 |    1113.18458 |       0.00000 |       0.00000 |       0.00000
+// the metrics subsystem. Compaction counters of both trees are summed up.
 func (db *LDBDatabase) meter(refresh time.Duration) {
 	// Create the counters to store current and previous values
-	counters := make([][]float64, 2)
-	for i := 0; i < 2; i++ {
-		counters[i] = make([]float64, 3)
-	}
+	var (
+		stats    leveldb.DBStats
+		counters [2]struct {
+			duration    time.Duration
+			read, write int64
+		}
+	)
 	// Iterate ad infinitum and collect the stats
 	for i := 1; ; i++ {
 		// Retrieve the database stats
-		stats, err := db.db.GetProperty("leveldb.stats")
-		if err != nil {
+		if err := db.db.Stats(&stats); err != nil {
 			db.log.Error("Failed to read database stats", "err", err)
 			return
 		}
-		// Find the compaction table, skip the header
-		lines := strings.Split(stats, "\n")
-		for len(lines) > 0 && strings.TrimSpace(lines[0]) != "Compactions" {
-			lines = lines[1:]
+		cur, prev := &counters[i%2], &counters[(i-1)%2]
+		cur.duration, cur.read, cur.write = 0, 0, 0
+		for _, d := range stats.LevelDurations {
+			cur.duration += d
 		}
-		if len(lines) <= 3 {
-			db.log.Error("Compaction table not found")
-			return
+		for _, d := range stats.LevelDurations_s {
+			cur.duration += d
 		}
-		lines = lines[3:]
+		cur.read = stats.LevelRead.Sum() + stats.LevelRead_s.Sum()
+		cur.write = stats.LevelWrite.Sum() + stats.LevelWrite_s.Sum()
 
-		// Iterate over all the table rows, and accumulate the entries
-		for j := 0; j < len(counters[i%2]); j++ {
-			counters[i%2][j] = 0
-		}
-		for _, line := range lines {
-			parts := strings.Split(line, "|")
-			if len(parts) != 6 {
-				break
-			}
-			for idx, counter := range parts[3:] {
-				value, err := strconv.ParseFloat(strings.TrimSpace(counter), 64)
-				if err != nil {
-					db.log.Error("Compaction entry parsing failed", "err", err)
-					return
-				}
-				counters[i%2][idx] += value
-			}
-		}
 		// Update all the requested meters
 		if db.compTimeMeter != nil {
-			db.compTimeMeter.Mark(int64((counters[i%2][0] - counters[(i-1)%2][0]) * 1000 * 1000 * 1000))
+			db.compTimeMeter.Mark(int64(cur.duration - prev.duration))
 		}
 		if db.compReadMeter != nil {
-			db.compReadMeter.Mark(int64((counters[i%2][1] - counters[(i-1)%2][1]) * 1024 * 1024))
+			db.compReadMeter.Mark(cur.read - prev.read)
 		}
 		if db.compWriteMeter != nil {
-			db.compWriteMeter.Mark(int64((counters[i%2][2] - counters[(i-1)%2][2]) * 1024 * 1024))
+			db.compWriteMeter.Mark(cur.write - prev.write)
 		}
 		// Sleep a bit, then repeat the stats collection
 		select {
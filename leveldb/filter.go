@@ -29,3 +29,12 @@ type iFilterGenerator struct {
 func (g iFilterGenerator) Add(key []byte) {
 	g.FilterGenerator.Add(internalKey(key).ukey())
 }
+
+type iPrefixFilter struct {
+	iFilter
+	pf filter.PrefixFilter
+}
+
+func (f iPrefixFilter) ContainsPrefix(filter, prefix []byte) bool {
+	return f.pf.ContainsPrefix(filter, prefix)
+}
@@ -0,0 +1,118 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package filter
+
+import (
+	"bytes"
+	"strconv"
+)
+
+// PrefixExtractor extracts the prefix of a key, which is then added to the
+// filter along with the key itself.
+type PrefixExtractor interface {
+	// Name returns the name of this extractor. It is part of the name of
+	// the filters it is used with, see NewPrefixFilter.
+	Name() string
+
+	// Prefix returns the prefix of the given key, or false if the key
+	// has none.
+	//
+	// The prefix must be consistent with the byte order of keys: if key
+	// a has prefix p then any key starting with a has prefix p as well.
+	// Prefix may then be called with the prefix of a key range, e.g.
+	// one built by util.BytesPrefix, to find the prefix all the keys of
+	// the range share.
+	Prefix(key []byte) (prefix []byte, ok bool)
+}
+
+type fixedPrefix int
+
+func (p fixedPrefix) Name() string {
+	return "leveldb.FixedPrefix." + strconv.Itoa(int(p))
+}
+
+func (p fixedPrefix) Prefix(key []byte) ([]byte, bool) {
+	if len(key) < int(p) {
+		return nil, false
+	}
+	return key[:p], true
+}
+
+// NewFixedPrefix creates a prefix extractor which prefix is the first n
+// bytes of the key; keys shorter than n have no prefix.
+//
+// Trie nodes are stored under their path followed by their hash, see
+// trie.hasher.store; n bytes of path then group the nodes of a subtrie
+// 2*n nibbles deep.
+func NewFixedPrefix(n int) PrefixExtractor {
+	if n < 1 {
+		n = 1
+	}
+	return fixedPrefix(n)
+}
+
+// PrefixFilter is a filter which also holds the prefixes of the keys.
+type PrefixFilter interface {
+	Filter
+
+	// ContainsPrefix returns true if the filter may contain a key with
+	// the given prefix, as returned by the prefix extractor.
+	ContainsPrefix(filter, prefix []byte) bool
+}
+
+type prefixFilter struct {
+	Filter
+	pe PrefixExtractor
+}
+
+func (f prefixFilter) Name() string {
+	return f.Filter.Name() + ".prefix." + f.pe.Name()
+}
+
+func (f prefixFilter) ContainsPrefix(filter, prefix []byte) bool {
+	return f.Filter.Contains(filter, prefix)
+}
+
+func (f prefixFilter) NewGenerator() FilterGenerator {
+	return &prefixFilterGenerator{
+		FilterGenerator: f.Filter.NewGenerator(),
+		pe:              f.pe,
+	}
+}
+
+type prefixFilterGenerator struct {
+	FilterGenerator
+	pe PrefixExtractor
+
+	last    []byte
+	hasLast bool
+}
+
+func (g *prefixFilterGenerator) Add(key []byte) {
+	g.FilterGenerator.Add(key)
+	// Keys are added sorted, consecutive keys mostly share their prefix.
+	if p, ok := g.pe.Prefix(key); ok && !(g.hasLast && bytes.Equal(p, g.last)) {
+		g.FilterGenerator.Add(p)
+		g.last = append(g.last[:0], p...)
+		g.hasLast = true
+	}
+}
+
+func (g *prefixFilterGenerator) Generate(b Buffer) {
+	g.FilterGenerator.Generate(b)
+	g.hasLast = false
+}
+
+// NewPrefixFilter creates a filter which adds both the keys and their
+// prefixes, as extracted by pe, to the filter f.
+//
+// The name of the filter is derived from the names of f and pe, tables
+// written with another extractor are read as if they had no filter unless
+// it is given as an alternative filter.
+func NewPrefixFilter(f Filter, pe PrefixExtractor) PrefixFilter {
+	return prefixFilter{f, pe}
+}
@@ -0,0 +1,63 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package filter
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/rev3z/ledger_base/leveldb/util"
+)
+
+func TestPrefixFilter(t *testing.T) {
+	pe := NewFixedPrefix(4)
+	if p, ok := pe.Prefix([]byte("abc")); ok {
+		t.Fatalf("prefix of short key: %q", p)
+	}
+	if p, ok := pe.Prefix([]byte("abcdef")); !ok || string(p) != "abcd" {
+		t.Fatalf("prefix: %q %v", p, ok)
+	}
+
+	f := NewPrefixFilter(NewBloomFilter(10), pe)
+	if want := "leveldb.BuiltinBloomFilter.prefix.leveldb.FixedPrefix.4"; f.Name() != want {
+		t.Fatalf("name: got %q, want %q", f.Name(), want)
+	}
+	g := f.NewGenerator()
+	for i := 0; i < 100; i++ {
+		g.Add([]byte(fmt.Sprintf("p%03d%04d", i*2, i)))
+	}
+	b := &util.Buffer{}
+	g.Generate(b)
+	filter := b.Bytes()
+
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("p%03d%04d", i*2, i)
+		if !f.Contains(filter, []byte(key)) {
+			t.Errorf("key %q missing", key)
+		}
+		if !f.ContainsPrefix(filter, []byte(key[:4])) {
+			t.Errorf("prefix %q missing", key[:4])
+		}
+	}
+	fp := 0
+	for i := 0; i < 100; i++ {
+		if f.ContainsPrefix(filter, []byte(fmt.Sprintf("p%03d", i*2+1))) {
+			fp++
+		}
+	}
+	if fp > 5 {
+		t.Errorf("too many false positives: %d%%", fp)
+	}
+
+	// The generator is reset by Generate.
+	g.Add([]byte("p0000000"))
+	b.Reset()
+	g.Generate(b)
+	if !f.ContainsPrefix(b.Bytes(), []byte("p000")) {
+		t.Error("prefix missing after reset")
+	}
+}
@@ -0,0 +1,308 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package filter
+
+import (
+	"sort"
+)
+
+// XOR filter, see "Xor Filters: Faster and Smaller Than Bloom and Cuckoo
+// Filters" [Graf,Lemire 2020].
+//
+// Filter data structure:
+//
+//    +--------------------------+---------------------+-------------------------+
+//    | fingerprints (m-bytes)   | seed index (1-byte) | fingerprint f (1-byte)  |
+//    +--------------------------+---------------------+-------------------------+
+//
+// The fingerprints are f-bit each, packed in little-endian bit order, in
+// three segments of n slots, where n is the largest number of slots fitting
+// in m bytes. A key is in the filter if its fingerprint equals the xor of
+// the fingerprints of its slot in every segment.
+
+const (
+	xorTrailerLen = 2
+	// Number of construction attempts with a fresh seed before the
+	// capacity is grown.
+	xorMaxAttempts = 64
+)
+
+// Returns a 64-bit FNV-1a hash of the key.
+func xorKeyHash(key []byte) uint64 {
+	h := uint64(14695981039346656037)
+	for _, c := range key {
+		h ^= uint64(c)
+		h *= 1099511628211
+	}
+	return h
+}
+
+// Mixes the key hash with the seed, bijective for a given seed.
+func xorMix(h, seed uint64) uint64 {
+	h += seed
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+func xorReduce(h, n uint32) uint32 {
+	return uint32((uint64(h) * uint64(n)) >> 32)
+}
+
+func xorRotl(h uint64, n uint) uint64 {
+	return (h << n) | (h >> (64 - n))
+}
+
+// Returns the slots of the mixed hash in the three segments.
+func xorSlots(h uint64, n uint32) [3]uint32 {
+	return [3]uint32{
+		xorReduce(uint32(h), n),
+		xorReduce(uint32(xorRotl(h, 21)), n) + n,
+		xorReduce(uint32(xorRotl(h, 42)), n) + 2*n,
+	}
+}
+
+// Returns the segment length of a filter with m bytes of f-bit
+// fingerprints.
+func xorSegmentLen(m int, f uint) uint32 {
+	return uint32(uint64(m) * 8 / (3 * uint64(f)))
+}
+
+// Returns the seed of the given construction attempt, splitmix64.
+func xorSeed(attempt uint8) uint64 {
+	z := (uint64(attempt) + 1) * 0x9e3779b97f4a7c15
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+func xorFingerprint(h uint64, f uint) uint32 {
+	return uint32(h^(h>>32)) & (1<<f - 1)
+}
+
+// Returns the f-bit fingerprint at the given slot.
+func xorGet(b []byte, slot uint32, f uint) uint32 {
+	pos := uint64(slot) * uint64(f)
+	i, shift := pos/8, pos%8
+	var v uint64
+	for j := uint64(0); j < 5 && i+j < uint64(len(b)); j++ {
+		v |= uint64(b[i+j]) << (8 * j)
+	}
+	return uint32(v>>shift) & (1<<f - 1)
+}
+
+// Xors the f-bit fingerprint at the given slot with x.
+func xorSet(b []byte, slot uint32, f uint, x uint32) {
+	pos := uint64(slot) * uint64(f)
+	i, shift := pos/8, pos%8
+	v := uint64(x) << shift
+	for j := uint64(0); v != 0; j++ {
+		b[i+j] ^= byte(v)
+		v >>= 8
+	}
+}
+
+type xorFilter int
+
+// Name: The XOR filter serializes its parameters, its name doesn't need to
+// change with them.
+func (xorFilter) Name() string {
+	return "leveldb.BuiltinXorFilter"
+}
+
+func (xorFilter) Contains(filter, key []byte) bool {
+	if len(filter) < xorTrailerLen {
+		return false
+	}
+	m := len(filter) - xorTrailerLen
+	attempt := filter[m]
+	f := uint(filter[m+1])
+	if f < 1 || f > 32 || attempt >= xorMaxAttempts {
+		// Reserved for potentially new encodings.
+		// Consider it a match.
+		return true
+	}
+	n := xorSegmentLen(m, f)
+	if n == 0 {
+		return false
+	}
+	h := xorMix(xorKeyHash(key), xorSeed(attempt))
+	s := xorSlots(h, n)
+	fp := xorGet(filter, s[0], f) ^ xorGet(filter, s[1], f) ^ xorGet(filter, s[2], f)
+	return fp == xorFingerprint(h, f)
+}
+
+func (f xorFilter) NewGenerator() FilterGenerator {
+	// A slot costs 1.23 bits per key and bit of fingerprint.
+	fp := int(float64(f) / 1.23)
+	if fp < 1 {
+		fp = 1
+	} else if fp > 32 {
+		fp = 32
+	}
+	return &xorFilterGenerator{f: uint(fp)}
+}
+
+type xorFilterGenerator struct {
+	f uint
+
+	keyHashes []uint64
+
+	// Construction scratch.
+	counts []uint8
+	xors   []uint64
+	queue  []uint32
+	stack  []xorPeeled
+}
+
+type xorPeeled struct {
+	h    uint64
+	slot uint32
+}
+
+func (g *xorFilterGenerator) Add(key []byte) {
+	g.keyHashes = append(g.keyHashes, xorKeyHash(key))
+}
+
+// Peels the keys, i.e. orders them so that each has a slot no later key
+// maps to. It returns false if the keys can't be peeled with this seed.
+func (g *xorFilterGenerator) peel(hashes []uint64, seed uint64, n uint32) bool {
+	size := int(3 * n)
+	if cap(g.counts) < size {
+		g.counts = make([]uint8, size)
+		g.xors = make([]uint64, size)
+	}
+	counts, xors := g.counts[:size], g.xors[:size]
+	for i := range counts {
+		counts[i] = 0
+		xors[i] = 0
+	}
+	for _, kh := range hashes {
+		h := xorMix(kh, seed)
+		for _, s := range xorSlots(h, n) {
+			// A slot mapped by too many keys can't be peeled anyway.
+			if counts[s] == 0xff {
+				return false
+			}
+			counts[s]++
+			xors[s] ^= h
+		}
+	}
+
+	g.queue, g.stack = g.queue[:0], g.stack[:0]
+	for i, c := range counts {
+		if c == 1 {
+			g.queue = append(g.queue, uint32(i))
+		}
+	}
+	for len(g.queue) > 0 {
+		i := g.queue[len(g.queue)-1]
+		g.queue = g.queue[:len(g.queue)-1]
+		if counts[i] != 1 {
+			continue
+		}
+		h := xors[i]
+		g.stack = append(g.stack, xorPeeled{h, i})
+		for _, s := range xorSlots(h, n) {
+			counts[s]--
+			xors[s] ^= h
+			if counts[s] == 1 {
+				g.queue = append(g.queue, s)
+			}
+		}
+	}
+	return len(g.stack) == len(hashes)
+}
+
+func (g *xorFilterGenerator) Generate(b Buffer) {
+	// The same key may be added more than once, which peeling can't
+	// handle.
+	hashes := g.keyHashes
+	sort.Slice(hashes, func(i, j int) bool { return hashes[i] < hashes[j] })
+	k := 0
+	for i, h := range hashes {
+		if i == 0 || h != hashes[k-1] {
+			hashes[k] = h
+			k++
+		}
+	}
+	hashes = hashes[:k]
+
+	// The segments are sized from the number of keys alone, there is no
+	// fixed overhead: small filters, such as the per-block ones, stay
+	// about as large as bloom filters.
+	var (
+		m       int
+		n       uint32
+		attempt uint8
+	)
+	if len(hashes) > 0 {
+		n = uint32(1.23*float64(len(hashes))+2) / 3
+		for {
+			// Use all the slots the bytes can hold, the reader derives
+			// the segment length from the size.
+			m = int((3*uint64(n)*uint64(g.f) + 7) / 8)
+			n = xorSegmentLen(m, g.f)
+			ok := false
+			for attempt = 0; attempt < xorMaxAttempts; attempt++ {
+				if ok = g.peel(hashes, xorSeed(attempt), n); ok {
+					break
+				}
+			}
+			if ok {
+				break
+			}
+			if grow := n / 10; grow > 0 {
+				n += grow
+			} else {
+				n++
+			}
+		}
+	}
+
+	// Buffer doesn't zero the memory it allocs.
+	data := make([]byte, m+xorTrailerLen)
+	for i := len(g.stack) - 1; i >= 0; i-- {
+		p := g.stack[i]
+		fp := xorFingerprint(p.h, g.f)
+		for _, s := range xorSlots(p.h, n) {
+			if s != p.slot {
+				fp ^= xorGet(data, s, g.f)
+			}
+		}
+		xorSet(data, p.slot, g.f, fp)
+	}
+	data[m] = attempt
+	data[m+1] = byte(g.f)
+	b.Write(data)
+
+	g.keyHashes = g.keyHashes[:0]
+	g.stack = g.stack[:0]
+}
+
+// NewXorFilter creates a new initialized XOR filter for given bitsPerKey.
+//
+// For the same bitsPerKey, the XOR filter has a lower false positive rate
+// than the bloom filter: about 2^-(bitsPerKey/1.23) against 0.6185^bitsPerKey,
+// e.g. 0.4% against 0.9% with 10 bits per key; for the same false positive
+// rate it takes about 15% less space, e.g. 9 bits per key against 10. This
+// holds down to the per-block filters of a few dozen keys, the XOR filter
+// has a 2-byte overhead per filter. Its lookups read 3 fingerprints, it is
+// however slower to build.
+//
+// Since bitsPerKey is persisted individually for each XOR filter
+// serialization, XOR filters are backwards compatible with respect to
+// changing bitsPerKey. The bloom and XOR filters differ in name, a table
+// written with one is read unfiltered with the other unless given as an
+// alternative filter; see documentation for opt.Options.Filter for more
+// information.
+func NewXorFilter(bitsPerKey int) Filter {
+	return xorFilter(bitsPerKey)
+}
@@ -0,0 +1,164 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package filter
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"testing"
+
+	"github.com/rev3z/ledger_base/leveldb/util"
+)
+
+// Returns a 32-byte hash key, as the trie stores its nodes under.
+func hashKey(i int) []byte {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], uint64(i))
+	h := sha256.Sum256(b[:])
+	return h[:]
+}
+
+func build(f Filter, keys [][]byte) []byte {
+	g := f.NewGenerator()
+	for _, key := range keys {
+		g.Add(key)
+	}
+	b := &util.Buffer{}
+	g.Generate(b)
+	return b.Bytes()
+}
+
+// Returns the false positive rate of the filter over n keys not in it.
+func fpRate(f Filter, filter []byte, from, n int) float64 {
+	fp := 0
+	for i := from; i < from+n; i++ {
+		if f.Contains(filter, hashKey(i)) {
+			fp++
+		}
+	}
+	return float64(fp) / float64(n)
+}
+
+func TestXorFilter_NoFalseNegatives(t *testing.T) {
+	for _, bitsPerKey := range []int{1, 5, 10, 20, 40} {
+		f := NewXorFilter(bitsPerKey)
+		for n := 0; n <= 10000; n = n*3 + 1 {
+			keys := make([][]byte, 0, n+n/10)
+			for i := 0; i < n; i++ {
+				keys = append(keys, hashKey(i))
+			}
+			// Keys added more than once.
+			for i := 0; i < n/10; i++ {
+				keys = append(keys, hashKey(i*7%n))
+			}
+			filter := build(f, keys)
+			for _, key := range keys {
+				if !f.Contains(filter, key) {
+					t.Fatalf("bits=%d n=%d: key %x missing", bitsPerKey, n, key)
+				}
+			}
+			if n == 0 && f.Contains(filter, hashKey(0)) {
+				t.Fatalf("bits=%d: empty filter matches", bitsPerKey)
+			}
+		}
+	}
+}
+
+func TestXorFilter_FalsePositiveRate(t *testing.T) {
+	const n, probes = 20000, 200000
+	keys := make([][]byte, n)
+	for i := range keys {
+		keys[i] = hashKey(i)
+	}
+	for _, bitsPerKey := range []int{8, 10, 12, 16} {
+		bloom, xor := NewBloomFilter(bitsPerKey), NewXorFilter(bitsPerKey)
+		bf, xf := build(bloom, keys), build(xor, keys)
+		brate, xrate := fpRate(bloom, bf, n, probes), fpRate(xor, xf, n, probes)
+		t.Logf("bits=%d: bloom %d bytes fp=%.4f%%, xor %d bytes fp=%.4f%%", bitsPerKey, len(bf), brate*100, len(xf), xrate*100)
+		// Allows for the constant overhead of the xor filter.
+		if len(xf) > len(bf)*101/100 {
+			t.Errorf("bits=%d: xor filter larger than bloom filter: %d > %d", bitsPerKey, len(xf), len(bf))
+		}
+		if xrate > brate {
+			t.Errorf("bits=%d: xor filter fp rate above bloom filter: %f > %f", bitsPerKey, xrate, brate)
+		}
+	}
+
+	// At about the same rate the xor filter is smaller.
+	bloom, xor := NewBloomFilter(10), NewXorFilter(9)
+	bf, xf := build(bloom, keys), build(xor, keys)
+	brate, xrate := fpRate(bloom, bf, n, probes), fpRate(xor, xf, n, probes)
+	t.Logf("bloom %d bytes fp=%.4f%%, xor %d bytes fp=%.4f%%", len(bf), brate*100, len(xf), xrate*100)
+	if xrate > brate*1.2 || len(xf) > len(bf)*9/10 {
+		t.Errorf("xor filter: %d bytes at fp=%f, bloom filter: %d bytes at fp=%f", len(xf), xrate, len(bf), brate)
+	}
+}
+
+// Per-block filters hold a few dozen keys, see filterBaseLg.
+func TestXorFilter_SmallFilters(t *testing.T) {
+	const sets, probes = 10, 20000
+	for _, bitsPerKey := range []int{8, 10, 16} {
+		bloom, xor := NewBloomFilter(bitsPerKey), NewXorFilter(bitsPerKey)
+		for _, n := range []int{10, 30, 100} {
+			var bsize, xsize int
+			var brate, xrate float64
+			for set := 0; set < sets; set++ {
+				keys := make([][]byte, n)
+				for i := range keys {
+					keys[i] = hashKey(set*n + i)
+				}
+				bf, xf := build(bloom, keys), build(xor, keys)
+				if len(xf) > len(bf)*11/10 {
+					t.Errorf("bits=%d n=%d: xor filter larger than bloom filter: %d > %d", bitsPerKey, n, len(xf), len(bf))
+				}
+				bsize, xsize = bsize+len(bf), xsize+len(xf)
+				brate += fpRate(bloom, bf, sets*n, probes)
+				xrate += fpRate(xor, xf, sets*n, probes)
+			}
+			t.Logf("bits=%d n=%d: bloom %d bytes fp=%.4f%%, xor %d bytes fp=%.4f%%", bitsPerKey, n, bsize/sets, brate/sets*100, xsize/sets, xrate/sets*100)
+			// Allows for the rounding to whole fingerprints.
+			if xsize > bsize*105/100 {
+				t.Errorf("bits=%d n=%d: xor filters larger than bloom filters: %d > %d", bitsPerKey, n, xsize, bsize)
+			}
+			if xrate > brate {
+				t.Errorf("bits=%d n=%d: xor filter fp rate above bloom filter: %f > %f", bitsPerKey, n, xrate/sets, brate/sets)
+			}
+		}
+	}
+}
+
+func TestXorFilter_Encoding(t *testing.T) {
+	f := NewXorFilter(10)
+	g := f.NewGenerator()
+	g.Add([]byte("a"))
+	b := &util.Buffer{}
+	g.Generate(b)
+	filter := b.Bytes()
+
+	// The generator is reset by Generate.
+	g.Add([]byte("b"))
+	b2 := &util.Buffer{}
+	g.Generate(b2)
+	if !f.Contains(b2.Bytes(), []byte("b")) || b2.Len() != len(filter) {
+		t.Error("generator not reset")
+	}
+
+	// Unknown encodings match every key.
+	bad := append([]byte{}, filter...)
+	bad[len(bad)-1] = 33
+	if !f.Contains(bad, []byte("x")) {
+		t.Error("unknown fingerprint width doesn't match")
+	}
+	bad = append([]byte{}, filter...)
+	bad[len(bad)-2] = xorMaxAttempts
+	if !f.Contains(bad, []byte("x")) {
+		t.Error("unknown seed doesn't match")
+	}
+	if f.Contains(nil, []byte("a")) {
+		t.Error("nil filter matches")
+	}
+}
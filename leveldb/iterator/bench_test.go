@@ -0,0 +1,69 @@
+// Copyright (c) 2014, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package iterator_test
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/rev3z/ledger_base/leveldb/comparer"
+	. "github.com/rev3z/ledger_base/leveldb/iterator"
+	"github.com/rev3z/ledger_base/leveldb/testutil"
+)
+
+// Returns a merged iterator over n children with interleaved keys, as the
+// tables of level-0 overlap each other.
+func newBenchMergedIterator(n int) Iterator {
+	kvs := make([]testutil.KeyValue, n)
+	for i := 0; i < 1<<16; i++ {
+		var key [4]byte
+		binary.BigEndian.PutUint32(key[:], uint32(i))
+		kvs[i%n].Put(key[:], key[:])
+	}
+	iters := make([]Iterator, n)
+	for i := range iters {
+		iters[i] = NewArrayIterator(kvs[i])
+	}
+	return NewMergedIterator(iters, comparer.DefaultComparer, true)
+}
+
+func benchmarkMergedIterator(b *testing.B, step func(iter Iterator) bool, reset func(iter Iterator) bool) {
+	for _, n := range []int{2, 8, 32, 64} {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			iter := newBenchMergedIterator(n)
+			defer iter.Release()
+			reset(iter)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if !step(iter) {
+					reset(iter)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkMergedIteratorNext(b *testing.B) {
+	benchmarkMergedIterator(b, Iterator.Next, Iterator.First)
+}
+
+func BenchmarkMergedIteratorPrev(b *testing.B) {
+	benchmarkMergedIterator(b, Iterator.Prev, Iterator.Last)
+}
+
+func BenchmarkMergedIteratorSeek(b *testing.B) {
+	var key [4]byte
+	i := uint32(0)
+	seek := func(iter Iterator) bool {
+		i = (i + 7919) % (1 << 16)
+		binary.BigEndian.PutUint32(key[:], i)
+		return iter.Seek(key[:])
+	}
+	benchmarkMergedIterator(b, seek, seek)
+}
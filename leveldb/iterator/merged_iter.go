@@ -29,6 +29,8 @@ type mergedIterator struct {
 
 	keys     [][]byte
 	index    int
+	heap     []int // indexes of the iters with a key, see mergedIterator.less
+	reverse  bool  // whether the heap is a max-heap
 	dir      dir
 	err      error
 	errf     func(err error)
@@ -42,6 +44,61 @@ func assertKey(key []byte) []byte {
 	return key
 }
 
+// Orders the iters by their keys in the direction of the heap, so that the
+// current key is at its top. Duplicate keys are ordered by iter.
+func (i *mergedIterator) less(a, b int) bool {
+	switch c := i.cmp.Compare(i.keys[a], i.keys[b]); {
+	case c < 0:
+		return !i.reverse
+	case c > 0:
+		return i.reverse
+	}
+	return a < b
+}
+
+func (i *mergedIterator) down(j int) {
+	h := i.heap
+	for {
+		l := 2*j + 1
+		if l >= len(h) {
+			break
+		}
+		if r := l + 1; r < len(h) && i.less(h[r], h[l]) {
+			l = r
+		}
+		if !i.less(h[l], h[j]) {
+			break
+		}
+		h[j], h[l] = h[l], h[j]
+		j = l
+	}
+}
+
+// Builds the heap from the keys of all the iters.
+func (i *mergedIterator) initHeap(reverse bool) {
+	i.reverse = reverse
+	i.heap = i.heap[:0]
+	for x, key := range i.keys {
+		if key != nil {
+			i.heap = append(i.heap, x)
+		}
+	}
+	for j := len(i.heap)/2 - 1; j >= 0; j-- {
+		i.down(j)
+	}
+}
+
+// Restores the heap after the iter at its top moved on.
+func (i *mergedIterator) fixTop() {
+	if n := len(i.heap) - 1; i.keys[i.heap[0]] == nil {
+		i.heap[0] = i.heap[n]
+		i.heap = i.heap[:n]
+	}
+	if len(i.heap) > 0 {
+		i.down(0)
+	}
+}
+
 func (i *mergedIterator) iterErr(iter Iterator) bool {
 	if err := iter.Error(); err != nil {
 		if i.errf != nil {
@@ -77,6 +134,7 @@ func (i *mergedIterator) First() bool {
 			i.keys[x] = nil
 		}
 	}
+	i.initHeap(false)
 	i.dir = dirSOI
 	return i.next()
 }
@@ -120,6 +178,7 @@ func (i *mergedIterator) Last() bool {
 			i.keys[x] = nil
 		}
 	}
+	i.initHeap(true)
 	i.dir = dirEOI
 	return i.prev()
 }
@@ -163,6 +222,7 @@ func (i *mergedIterator) Seek(key []byte) bool {
 			i.keys[x] = nil
 		}
 	}
+	i.initHeap(false)
 	i.dir = dirSOI
 	return i.next()
 }
@@ -189,20 +249,14 @@ func (i *mergedIterator) Seek(key []byte) bool {
 //}
 
 func (i *mergedIterator) next() bool {
-	var key []byte
 	if i.dir == dirForward {
-		key = i.keys[i.index]
+		i.fixTop()
 	}
-	for x, tkey := range i.keys {
-		if tkey != nil && (key == nil || i.cmp.Compare(tkey, key) < 0) {
-			key = tkey
-			i.index = x
-		}
-	}
-	if key == nil {
+	if len(i.heap) == 0 {
 		i.dir = dirEOI
 		return false
 	}
+	i.index = i.heap[0]
 	i.dir = dirForward
 	return true
 }
@@ -270,20 +324,14 @@ func (i *mergedIterator) Next() bool {
 //	return i.next()
 //}
 func (i *mergedIterator) prev() bool {
-	var key []byte
 	if i.dir == dirBackward {
-		key = i.keys[i.index]
+		i.fixTop()
 	}
-	for x, tkey := range i.keys {
-		if tkey != nil && (key == nil || i.cmp.Compare(tkey, key) > 0) {
-			key = tkey
-			i.index = x
-		}
-	}
-	if key == nil {
+	if len(i.heap) == 0 {
 		i.dir = dirSOI
 		return false
 	}
+	i.index = i.heap[0]
 	i.dir = dirBackward
 	return true
 }
@@ -327,6 +375,11 @@ func (i *mergedIterator) Prev() bool {
 	default:
 		i.keys[x] = nil
 	}
+	if i.dir == dirForward {
+		// The iters were all moved back, the heap is rebuilt backward.
+		i.initHeap(true)
+		i.dir = dirEOI
+	}
 	return i.prev()
 }
 //func (i *mergedIterator) Prev_s() bool {
@@ -392,6 +445,7 @@ func (i *mergedIterator) Release() {
 		}
 		i.iters = nil
 		i.keys = nil
+		i.heap = nil
 		if i.releaser != nil {
 			i.releaser.Release()
 			i.releaser = nil
@@ -427,11 +481,16 @@ func (i *mergedIterator) SetErrorCallback(f func(err error)) {
 // If strict is true the any 'corruption errors' (i.e errors.IsCorrupted(err) == true)
 // won't be ignored and will halt 'merged iterator', otherwise the iterator will
 // continue to the next 'input iterator'.
+//
+// The input iterators are kept in a heap ordered by their current keys, a
+// step costs O(log n) key comparisons; changing direction costs a seek of
+// every input iterator, as it does with Seek, First and Last.
 func NewMergedIterator(iters []Iterator, cmp comparer.Comparer, strict bool) Iterator {
 	return &mergedIterator{
 		iters:  iters,
 		cmp:    cmp,
 		strict: strict,
 		keys:   make([][]byte, len(iters)),
+		heap:   make([]int, 0, len(iters)),
 	}
 }
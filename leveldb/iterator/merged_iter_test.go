@@ -17,7 +17,8 @@ import (
 
 var _ = testutil.Defer(func() {
 	Describe("Merged iterator", func() {
-		Test := func(filled int, empty int) func() {
+		// If spread is true every filled iterator gets at least one key.
+		test := func(filled int, empty int, spread bool) func() {
 			return func() {
 				It("Should iterates and seeks correctly", func(done Done) {
 					rnd := testutil.NewRand()
@@ -26,6 +27,10 @@ var _ = testutil.Defer(func() {
 					filledKV := make([]testutil.KeyValue, filled)
 					kv := testutil.KeyValue_Generate(nil, 100, 1, 1, 10, 4, 4)
 					kv.Iterate(func(i int, key, value []byte) {
+						if spread && i < filled {
+							filledKV[i].Put(key, value)
+							return
+						}
 						filledKV[rnd.Intn(filled)].Put(key, value)
 					})
 
@@ -52,9 +57,13 @@ var _ = testutil.Defer(func() {
 				}, 15.0)
 			}
 		}
+		Test := func(filled int, empty int) func() {
+			return test(filled, empty, false)
+		}
 
 		Describe("with three, all filled iterators", Test(3, 0))
 		Describe("with one filled, one empty iterators", Test(1, 1))
 		Describe("with one filled, two empty iterators", Test(1, 2))
+		Describe("with many filled and empty iterators", test(30, 10, true))
 	})
 })
@@ -36,6 +36,10 @@ func (kt keyType) String() string {
 		return "d"
 	case keyTypeVal:
 		return "v"
+	case keyTypeRangeDel:
+		return "r"
+	case keyTypeBlobIndex:
+		return "b"
 	}
 	return fmt.Sprintf("<invalid:%#x>", uint(kt))
 }
@@ -45,6 +49,12 @@ func (kt keyType) String() string {
 const (
 	keyTypeDel = keyType(0)//删除？
 	keyTypeVal = keyType(1)//插入？
+	// Range deletion, the user key is the start of the deleted range and
+	// the value its exclusive limit.
+	keyTypeRangeDel = keyType(2)
+	// Value of the secondary tree stored in a blob file, the value is the
+	// encoded blobIndex. Only written by flushes and compactions.
+	keyTypeBlobIndex = keyType(3)
 )
 
 // keyTypeSeek defines the keyType that should be passed when constructing an
@@ -52,7 +62,7 @@ const (
 // sort sequence numbers in decreasing order and the value type is
 // embedded as the low 8 bits in the sequence number in internal keys,
 // we need to use the highest-numbered ValueType, not the lowest).
-const keyTypeSeek = keyTypeVal
+const keyTypeSeek = keyTypeBlobIndex
 
 const (
 	// Maximum value possible for sequence number; the 8-bits are
@@ -76,7 +86,7 @@ type internalKey []byte
 func makeInternalKey(dst, ukey []byte, seq uint64, kt keyType) internalKey {
 	if seq > keyMaxSeq {
 		panic("leveldb: invalid sequence number")
-	} else if kt > keyTypeVal {
+	} else if kt > keyTypeBlobIndex {
 		panic("leveldb: invalid type")
 	}
 
@@ -94,7 +104,7 @@ func parseInternalKey(ik []byte) (ukey []byte, seq uint64, kt keyType, err error
 	num := binary.LittleEndian.Uint64(ik[len(ik)-8:])
 	//获取seq N和type
 	seq, kt = uint64(num>>8), keyType(num&0xff)
-	if kt > keyTypeVal {
+	if kt > keyTypeBlobIndex {
 		return nil, 0, 0, newErrInternalKeyCorrupted(ik, "invalid type")
 	}
 	ukey = ik[:len(ik)-8]
@@ -128,7 +138,7 @@ func (ik internalKey) num() uint64 {
 func (ik internalKey) parseNum() (seq uint64, kt keyType) {
 	num := ik.num()
 	seq, kt = uint64(num>>8), keyType(num&0xff)
-	if kt > keyTypeVal {
+	if kt > keyTypeBlobIndex {
 		panic(fmt.Sprintf("leveldb: internal key %q, len=%d: invalid type %#x", []byte(ik), len(ik), kt))
 	}
 	return
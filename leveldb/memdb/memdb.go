@@ -2,6 +2,7 @@ package memdb
 
 import (
 	"math/rand"
+	"sort"
 	"sync"
 
 	"github.com/rev3z/ledger_base/leveldb/comparer"
@@ -81,7 +82,7 @@ func (i *dbIter) Valid() bool {
 
 func (i *dbIter) First() bool {
 	if i.p==nil{
-		return i.First()
+		return i.First_s()
 	}else{
 		if i.Released() {
 			i.err = ErrIterReleased
@@ -230,22 +231,26 @@ func (i *dbIter) Next_s() bool {
 }
 
 func (i *dbIter) Prev() bool {
-	if i.Released() {
-		i.err = ErrIterReleased
-		return false
-	}
+	if i.p == nil {
+		return i.Prev_s()
+	} else {
+		if i.Released() {
+			i.err = ErrIterReleased
+			return false
+		}
 
-	if i.node == 0 {
-		if i.forward {
-			return i.Last()
+		if i.node == 0 {
+			if i.forward {
+				return i.Last()
+			}
+			return false
 		}
-		return false
+		i.forward = false
+		i.p.mu.RLock()
+		defer i.p.mu.RUnlock()
+		i.node = i.p.findLT(i.key)
+		return i.fill(true, false)
 	}
-	i.forward = false
-	i.p.mu.RLock()
-	defer i.p.mu.RUnlock()
-	i.node = i.p.findLT(i.key)
-	return i.fill(true, false)
 }
 func (i *dbIter) Prev_s() bool {
 	if i.Released() {
@@ -279,6 +284,7 @@ func (i *dbIter) Error() error { return i.err }
 func (i *dbIter) Release() {
 	if !i.Released() {
 		i.p = nil
+		i.q = nil
 		i.node = 0
 		i.key = nil
 		i.value = nil
@@ -321,6 +327,7 @@ type DB struct {
 	maxHeight int
 	n         int //kv对的数量
 	kvSize    int //kv对的大小
+	rangeDels []int // nodes added by PutRangeDel, sorted by key
 }
 //写一个结构体继承DB，为is a的关系
 type DBs struct {
@@ -334,6 +341,7 @@ type DBs struct {
 	maxHeight int
 	n         int //kv对的数量
 	kvSize    int //kv对的大小
+	rangeDels []int // nodes added by PutRangeDel_s, sorted by key
 }
 //跳表是否向上一层
 func (p *DB) randHeight() (h int) {
@@ -376,6 +384,11 @@ func (p *DB) findGE(key []byte, prev bool) (int, bool) {
 	}
 }
 
+func (p *DB) nodeKey(node int) []byte {
+	o := p.nodeData[node]
+	return p.kvData[o : o+p.nodeData[node+nKey]]
+}
+
 func (p *DB) findLT(key []byte) int {
 	node := 0
 	h := p.maxHeight - 1
@@ -450,6 +463,11 @@ func (p *DBs) findGE(key []byte, prev bool) (int, bool) {
 	}
 }
 
+func (p *DBs) nodeKey(node int) []byte {
+	o := p.nodeData[node]
+	return p.kvData[o : o+p.nodeData[node+nKey]]
+}
+
 func (p *DBs) findLT(key []byte) int {
 	node := 0
 	h := p.maxHeight - 1
@@ -493,6 +511,35 @@ func (p *DB) Put(key []byte, value []byte) error {
 	p.mu.Lock()
 	defer p.mu.Unlock()//互斥锁
 
+	p.put(key, value)
+	return nil
+}
+
+// PutRangeDel is like Put, but also records the entry so that it is listed
+// by NewRangeDelIterator. Range deletions are kept in the skip list as any
+// other entry, the DB doesn't interpret their contents.
+//
+// It is safe to modify the contents of the arguments after PutRangeDel
+// returns.
+func (p *DB) PutRangeDel(key []byte, value []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	node := p.put(key, value)
+	i := sort.Search(len(p.rangeDels), func(i int) bool {
+		return p.cmp.Compare(p.nodeKey(p.rangeDels[i]), key) >= 0
+	})
+	if i < len(p.rangeDels) && p.rangeDels[i] == node {
+		return nil
+	}
+	p.rangeDels = append(p.rangeDels, 0)
+	copy(p.rangeDels[i+1:], p.rangeDels[i:])
+	p.rangeDels[i] = node
+	return nil
+}
+
+// Must hold W-lock.
+func (p *DB) put(key []byte, value []byte) int {
 	if node, exact := p.findGE(key, true); exact {
 		kvOffset := len(p.kvData) //偏移量
 		p.kvData = append(p.kvData, key...) //存k
@@ -501,7 +548,7 @@ func (p *DB) Put(key []byte, value []byte) error {
 		m := p.nodeData[node+nVal]
 		p.nodeData[node+nVal] = len(value)
 		p.kvSize += len(value) - m
-		return nil
+		return node
 	}
 	//插入新key
 	h := p.randHeight()
@@ -530,12 +577,41 @@ func (p *DB) Put(key []byte, value []byte) error {
 
 	p.kvSize += len(key) + len(value)
 	p.n++
-	return nil
+	return node
 }
 func (p *DBs) Put_s(key []byte, value []byte) error {
 	p.mu.Lock()
 	defer p.mu.Unlock()//互斥锁
 
+	p.put(key, value)
+	return nil
+}
+
+// PutRangeDel_s is like Put_s, but also records the entry so that it is listed
+// by NewRangeDelIterator_s. Range deletions are kept in the skip list as any
+// other entry, the DB doesn't interpret their contents.
+//
+// It is safe to modify the contents of the arguments after PutRangeDel_s
+// returns.
+func (p *DBs) PutRangeDel_s(key []byte, value []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	node := p.put(key, value)
+	i := sort.Search(len(p.rangeDels), func(i int) bool {
+		return p.cmp.Compare(p.nodeKey(p.rangeDels[i]), key) >= 0
+	})
+	if i < len(p.rangeDels) && p.rangeDels[i] == node {
+		return nil
+	}
+	p.rangeDels = append(p.rangeDels, 0)
+	copy(p.rangeDels[i+1:], p.rangeDels[i:])
+	p.rangeDels[i] = node
+	return nil
+}
+
+// Must hold W-lock.
+func (p *DBs) put(key []byte, value []byte) int {
 	if node, exact := p.findGE(key, true); exact {
 		kvOffset := len(p.kvData) //偏移量
 		p.kvData = append(p.kvData, key...) //存k
@@ -544,7 +620,7 @@ func (p *DBs) Put_s(key []byte, value []byte) error {
 		m := p.nodeData[node+nVal]
 		p.nodeData[node+nVal] = len(value)
 		p.kvSize += len(value) - m
-		return nil
+		return node
 	}
 	//插入新key
 	h := p.randHeight()
@@ -573,7 +649,7 @@ func (p *DBs) Put_s(key []byte, value []byte) error {
 
 	p.kvSize += len(key) + len(value)
 	p.n++
-	return nil
+	return node
 }
 // Delete deletes the value for the given key. It returns ErrNotFound if
 // the DB does not contain the key.
@@ -596,6 +672,12 @@ func (p *DB) Delete(key []byte) error {
 
 	p.kvSize -= p.nodeData[node+nKey] + p.nodeData[node+nVal]
 	p.n--
+	for i, n := range p.rangeDels {
+		if n == node {
+			p.rangeDels = append(p.rangeDels[:i], p.rangeDels[i+1:]...)
+			break
+		}
+	}
 	return nil
 }
 func (p *DBs) Delete_s(key []byte) error {
@@ -615,6 +697,12 @@ func (p *DBs) Delete_s(key []byte) error {
 
 	p.kvSize -= p.nodeData[node+nKey] + p.nodeData[node+nVal]
 	p.n--
+	for i, n := range p.rangeDels {
+		if n == node {
+			p.rangeDels = append(p.rangeDels[:i], p.rangeDels[i+1:]...)
+			break
+		}
+	}
 	return nil
 }
 // Contains returns true if the given key are in the DB.
@@ -720,6 +808,53 @@ func (p *DB) NewIterator(slice *util.Range) iterator.Iterator {
 func (q *DBs) NewIterator_s(slice *util.Range) iterator.Iterator {
 	return &dbIter{q: q, slice: slice}
 }
+
+// NewRangeDelIterator returns an iterator over the entries added by
+// PutRangeDel, sorted by key. The iterator holds a point in time copy of the
+// list, so unlike NewIterator it isn't affected by later writes.
+//
+// The iterator must be released after use, by calling Release method.
+func (p *DB) NewRangeDelIterator() iterator.Iterator {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return newRangeDelIterator(p.cmp, p.kvData, p.nodeData, p.rangeDels)
+}
+func (p *DBs) NewRangeDelIterator_s() iterator.Iterator {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return newRangeDelIterator(p.cmp, p.kvData, p.nodeData, p.rangeDels)
+}
+
+// Must hold R-lock.
+func newRangeDelIterator(cmp comparer.BasicComparer, kvData []byte, nodeData []int, nodes []int) iterator.Iterator {
+	if len(nodes) == 0 {
+		return iterator.NewEmptyIterator(nil)
+	}
+	a := &kvArray{cmp: cmp, kv: make([][2][]byte, len(nodes))}
+	for i, node := range nodes {
+		n := nodeData[node]
+		m := n + nodeData[node+nKey]
+		a.kv[i] = [2][]byte{kvData[n:m], kvData[m : m+nodeData[node+nVal]]}
+	}
+	return iterator.NewArrayIterator(a)
+}
+
+// kvArray is a sorted list of key/value pairs, it implements iterator.Array.
+type kvArray struct {
+	cmp comparer.BasicComparer
+	kv  [][2][]byte
+}
+
+func (a *kvArray) Len() int { return len(a.kv) }
+
+func (a *kvArray) Search(key []byte) int {
+	return sort.Search(len(a.kv), func(i int) bool {
+		return a.cmp.Compare(a.kv[i][0], key) >= 0
+	})
+}
+
+func (a *kvArray) Index(i int) (key, value []byte) { return a.kv[i][0], a.kv[i][1] }
+
 // Capacity returns keys/values buffer capacity.
 //返回的是buffer的容量
 func (p *DB) Capacity() int {
@@ -779,6 +914,7 @@ func (p *DB) Reset() {
 	p.maxHeight = 1
 	p.n = 0
 	p.kvSize = 0
+	p.rangeDels = p.rangeDels[:0]
 	p.kvData = p.kvData[:0]
 	p.nodeData = p.nodeData[:nNext+tMaxHeight]
 	p.nodeData[nKV] = 0
@@ -797,6 +933,7 @@ func (p *DBs) Reset_s() {
 	p.maxHeight = 1
 	p.n = 0
 	p.kvSize = 0
+	p.rangeDels = p.rangeDels[:0]
 	p.kvData = p.kvData[:0]
 	p.nodeData = p.nodeData[:nNext+tMaxHeight]
 	p.nodeData[nKV] = 0
@@ -13,6 +13,7 @@ import (
 	"github.com/rev3z/ledger_base/leveldb/cache"
 	"github.com/rev3z/ledger_base/leveldb/comparer"
 	"github.com/rev3z/ledger_base/leveldb/filter"
+	"github.com/rev3z/ledger_base/leveldb/ratelimit"
 )
 
 const (
@@ -22,7 +23,8 @@ const (
 )
 
 var (
-	DefaultBlockCacher                   = LRUCacher
+	DefaultBlobGCRatio                   = 0.5
+	DefaultBlockCacher                   = ARCCacher
 	DefaultBlockCacheCapacity            = 8 * MiB
 	DefaultBlockRestartInterval          = 16
 	DefaultBlockSize                     = 4 * KiB //block的大小
@@ -36,7 +38,8 @@ var (
 	DefaultCompactionTotalSizeMultiplier = 10.0
 	DefaultCompressionType               = SnappyCompression
 	DefaultIteratorSamplingRate          = 1 * MiB
-	DefaultOpenFilesCacher               = LRUCacher
+	DefaultMaxSubcompactions             = 1
+	DefaultOpenFilesCacher               = ARCCacher
 	DefaultOpenFilesCacheCapacity        = 500 //最大缓存/打开500个sst文件
 	DefaultWriteBuffer                   = 4 * MiB //mem的大小
 	DefaultWriteL0PauseTrigger           = 12
@@ -65,10 +68,83 @@ var (
 	// LRUCacher is the LRU-cache algorithm.
 	LRUCacher = &CacherFunc{cache.NewLRU}
 
+	// ARCCacher is the ARC-cache algorithm, see cache.NewARC.
+	ARCCacher = &CacherFunc{cache.NewARC}
+
+	// SGCCacher is the SGC-cache algorithm, see cache.NewSGC.
+	SGCCacher = &CacherFunc{cache.NewSGC}
+
 	// NoCacher is the value to disable caching algorithm.
 	NoCacher = &CacherFunc{}
 )
 
+// CompactionFilterDecision is the verdict of a CompactionFilter on an entry.
+type CompactionFilterDecision int
+
+const (
+	// CompactionFilterKeep keeps the entry as it is.
+	CompactionFilterKeep CompactionFilterDecision = iota
+
+	// CompactionFilterRemove removes the entry, as if it had been deleted.
+	CompactionFilterRemove
+
+	// CompactionFilterChangeValue replaces the value of the entry.
+	CompactionFilterChangeValue
+)
+
+// CompactionFilter allows dropping or rewriting entries in the background
+// while tables are compacted.
+//
+// Filter is called for the newest value of each user key written by a table
+// compaction, provided it is visible to every snapshot; level is the level
+// the compaction writes to. It returns the decision and, for
+// CompactionFilterChangeValue, the new value. The arguments must not be
+// retained nor modified.
+//
+// Filter is called from the compaction goroutine of the tree and must be
+// deterministic, an entry may be offered again once compacted to a deeper
+// level.
+type CompactionFilter interface {
+	Name() string
+	Filter(level int, key, value []byte) (decision CompactionFilterDecision, newValue []byte)
+}
+
+// CompactionStyle is the table compaction strategy of a tree.
+type CompactionStyle int
+
+const (
+	// LeveledCompaction keeps every level above 0 a sorted run about ten
+	// times larger than the previous one, merging a few tables one level
+	// down at a time.
+	LeveledCompaction CompactionStyle = iota
+
+	// TieredCompaction merges whole sorted runs of similar size: the
+	// level-0 tables together, then every level above 0 holding a single
+	// run, newer runs above older ones. It is triggered by the number of
+	// runs reaching CompactionL0Trigger and writes each entry fewer times,
+	// at the cost of more runs to read and more space held by stale
+	// entries. Seeks don't trigger compactions.
+	TieredCompaction
+)
+
+// TableFormat is the layout of the index and filter of 'sorted table'.
+type TableFormat int
+
+const (
+	// BlockTableFormat keeps the data block handles in a single index
+	// block, and the filter in a single filter block holding one filter
+	// per 2KiB of data; both are read whole by lookups.
+	BlockTableFormat TableFormat = iota
+
+	// PartitionedTableFormat splits the index into partitions of about
+	// BlockSize, indexed by a top-level index block, and builds one filter
+	// per index partition, indexed the same way. Lookups read the top-level
+	// blocks and the partitions they touch only. Tables of both formats
+	// can be read, whatever the option; tables of this format can't be read
+	// by versions not aware of it.
+	PartitionedTableFormat
+)
+
 // Compression is the 'sorted table' block compression algorithm to use.
 type Compression uint
 
@@ -137,6 +213,29 @@ const (
 	NoStrict = ^StrictAll
 )
 
+// Tree selects one of the two LSM trees of a DB.
+type Tree uint
+
+func (t Tree) String() string {
+	switch t {
+	case PrimaryTree:
+		return "primary"
+	case SecondaryTree:
+		return "secondary"
+	}
+	return "invalid"
+}
+
+const (
+	// PrimaryTree is the tree accessed by Get, Put, Write and friends.
+	PrimaryTree Tree = iota
+
+	// SecondaryTree is the tree accessed by Get_s, Put_s, Write_s and
+	// friends.
+	SecondaryTree
+	nTree
+)
+
 // Options holds the optional parameters for the DB at large.
 type Options struct {
 	// AltFilters defines one or more 'alternative filters'.
@@ -146,10 +245,29 @@ type Options struct {
 	// The default value is nil
 	AltFilters []filter.Filter
 
+	// BlobGCRatio defines the live ratio under which a blob file is garbage
+	// collected: compactions of the secondary tree rewrite the values it
+	// still holds into new blob files, and the tables referencing it are
+	// compacted so that it can be dropped. Use -1 to disable blob garbage
+	// collection; fully dead blob files are dropped regardless.
+	//
+	// The default value is 0.5.
+	BlobGCRatio float64
+
+	// BlobThreshold defines the minimum size of a value of the secondary tree
+	// to be stored out of the 'sorted table', in an append-only blob file.
+	// Tables then hold a small reference to the value, so that compactions
+	// don't rewrite it. Blob files are written by memdb flushes and table
+	// compactions; values written before are separated once compacted.
+	// Zero disables value separation.
+	//
+	// The default value is 0.
+	BlobThreshold int
+
 	// BlockCacher provides cache algorithm for LevelDB 'sorted table' block caching.
 	// Specify NoCacher to disable caching algorithm.
 	//
-	// The default value is LRUCacher.
+	// The default value is ARCCacher.
 	BlockCacher Cacher
 
 	// BlockCacheCapacity defines the capacity of the 'sorted table' block caching.
@@ -164,6 +282,19 @@ type Options struct {
 	// The default if false.
 	BlockCacheEvictRemoved bool
 
+	// SecondaryCompactionFilter defines the compaction filter of the secondary
+	// tree, see CompactionFilter.
+	//
+	// The default value is nil.
+	SecondaryCompactionFilter CompactionFilter
+
+	// SecondaryBlockCacheCapacity defines the capacity of the block caching of
+	// the secondary tree, so that reads of one tree don't evict the blocks of
+	// the other. It uses the same BlockCacher. Use -1 for zero.
+	//
+	// The default value is 8MiB.
+	SecondaryBlockCacheCapacity int
+
 	// BlockRestartInterval is the number of keys between restart points for
 	// delta encoding of keys.
 	//
@@ -182,6 +313,12 @@ type Options struct {
 	// The default value is 25.
 	CompactionExpandLimitFactor int
 
+	// CompactionFilter defines the compaction filter of the primary tree, see
+	// CompactionFilter.
+	//
+	// The default value is nil.
+	CompactionFilter CompactionFilter
+
 	// CompactionGPOverlapsFactor limits overlaps in grandparent (Level + 2) that a
 	// single 'sorted table' generates.
 	// This will be multiplied by table size limit at grandparent level.
@@ -202,6 +339,19 @@ type Options struct {
 	// The default value is 1.
 	CompactionSourceLimitFactor int
 
+	// CompactionStyle defines the table compaction strategy of the primary
+	// tree, see LeveledCompaction and TieredCompaction. Changing it on an
+	// existing DB is supported.
+	//
+	// The default value is LeveledCompaction.
+	CompactionStyle CompactionStyle
+
+	// SecondaryCompactionStyle defines the table compaction strategy of the
+	// secondary tree, see CompactionStyle.
+	//
+	// The default value is LeveledCompaction.
+	SecondaryCompactionStyle CompactionStyle
+
 	// CompactionTableSize limits size of 'sorted table' that compaction generates.
 	// The limits for each level will be calculated as:
 	//   CompactionTableSize * (CompactionTableSizeMultiplier ^ Level)
@@ -310,6 +460,8 @@ type Options struct {
 	// filter during transition period.
 	//
 	// A filter is used to reduce disk reads when looking for a specific key.
+	// The built-in filters are filter.NewBloomFilter and filter.NewXorFilter,
+	// the latter being smaller at the same false positive rate.
 	//
 	// The default value is nil.
 	Filter filter.Filter
@@ -323,6 +475,16 @@ type Options struct {
 	// The default is 1MiB.
 	IteratorSamplingRate int
 
+	// MaxSubcompactions defines the maximum number of subcompactions a
+	// non level-0 table compaction is split into. Subcompactions cover
+	// disjoint key ranges, bounded by the edges of the tables at the
+	// destination level, and run concurrently; their outputs are committed
+	// at once. If greater than 1 the compaction filters must be safe for
+	// concurrent use.
+	//
+	// The default value is 1, i.e. no split.
+	MaxSubcompactions int
+
 	// NoSync allows completely disable fsync.
 	//
 	// The default is false.
@@ -336,7 +498,7 @@ type Options struct {
 	// OpenFilesCacher provides cache algorithm for open files caching.
 	// Specify NoCacher to disable caching algorithm.
 	//
-	// The default value is LRUCacher.
+	// The default value is ARCCacher.
 	OpenFilesCacher Cacher
 
 	// OpenFilesCacheCapacity defines the capacity of the open files caching.
@@ -345,6 +507,33 @@ type Options struct {
 	// The default value is 500.
 	OpenFilesCacheCapacity int
 
+	// SecondaryOpenFilesCacheCapacity defines the capacity of the open files
+	// caching of the secondary tree, tables of the two trees are cached
+	// separately. Use -1 for zero.
+	//
+	// The default value is 500.
+	SecondaryOpenFilesCacheCapacity int
+
+	// PrefixExtractor defines the prefix extractor the effective filter is
+	// wrapped with, see filter.NewPrefixFilter. The filter block of new
+	// tables then also holds the key prefixes, which allows prefix
+	// iterators, i.e. built with util.BytesPrefix, to skip tables holding no
+	// key with the prefix. The plain effective filter is kept as an
+	// alternative filter for the tables written without it.
+	// It is ignored if Filter is nil.
+	//
+	// The default value is nil.
+	PrefixExtractor filter.PrefixExtractor
+
+	// RateLimiter throttles the bytes written by memdb flushes and table
+	// compactions of both trees, and the bytes read by table compactions.
+	// Flushes are requested at ratelimit.High priority, table compactions at
+	// ratelimit.Low. A single limiter may be shared by several DBs, see
+	// ratelimit.TokenBucket which rate can be adjusted at runtime.
+	//
+	// The default value is nil, i.e. unlimited.
+	RateLimiter ratelimit.Limiter
+
 	// If true then opens DB in read-only mode.
 	//
 	// The default value is false.
@@ -353,6 +542,13 @@ type Options struct {
 	// Strict defines the DB strict level.
 	Strict Strict
 
+	// TableFormat defines the format of the 'sorted table' written, see
+	// BlockTableFormat and PartitionedTableFormat. Changing it on an
+	// existing DB is supported.
+	//
+	// The default value is BlockTableFormat.
+	TableFormat TableFormat
+
 	//WriteBuffer defines maximum size of a 'memdb' before flushed to
 	//'sorted table'. 'memdb' is an in-memory DB backed by an on-disk
 	//unsorted journal.
@@ -382,6 +578,22 @@ func (o *Options) GetAltFilters() []filter.Filter {
 	return o.AltFilters
 }
 
+func (o *Options) GetBlobGCRatio() float64 {
+	if o == nil || o.BlobGCRatio == 0 {
+		return DefaultBlobGCRatio
+	} else if o.BlobGCRatio < 0 {
+		return 0
+	}
+	return o.BlobGCRatio
+}
+
+func (o *Options) GetBlobThreshold() int {
+	if o == nil || o.BlobThreshold < 0 {
+		return 0
+	}
+	return o.BlobThreshold
+}
+
 func (o *Options) GetBlockCacher() Cacher {
 	if o == nil || o.BlockCacher == nil {
 		return DefaultBlockCacher
@@ -400,6 +612,15 @@ func (o *Options) GetBlockCacheCapacity() int {
 	return o.BlockCacheCapacity
 }
 
+func (o *Options) GetSecondaryBlockCacheCapacity() int {
+	if o == nil || o.SecondaryBlockCacheCapacity == 0 {
+		return DefaultBlockCacheCapacity
+	} else if o.SecondaryBlockCacheCapacity < 0 {
+		return 0
+	}
+	return o.SecondaryBlockCacheCapacity
+}
+
 func (o *Options) GetBlockCacheEvictRemoved() bool {
 	if o == nil {
 		return false
@@ -429,6 +650,26 @@ func (o *Options) GetCompactionExpandLimit(level int) int {
 	return o.GetCompactionTableSize(level+1) * factor
 }
 
+// GetRateLimiter returns the rate limiter of flushes and compactions, nil if
+// unlimited.
+func (o *Options) GetRateLimiter() ratelimit.Limiter {
+	if o == nil {
+		return nil
+	}
+	return o.RateLimiter
+}
+
+// GetCompactionFilter returns the compaction filter of the given tree.
+func (o *Options) GetCompactionFilter(t Tree) CompactionFilter {
+	if o == nil {
+		return nil
+	}
+	if t == SecondaryTree {
+		return o.SecondaryCompactionFilter
+	}
+	return o.CompactionFilter
+}
+
 func (o *Options) GetCompactionGPOverlaps(level int) int {
 	factor := DefaultCompactionGPOverlapsFactor
 	if o != nil && o.CompactionGPOverlapsFactor > 0 {
@@ -452,6 +693,16 @@ func (o *Options) GetCompactionSourceLimit(level int) int {
 	return o.GetCompactionTableSize(level+1) * factor
 }
 
+func (o *Options) GetCompactionStyle(t Tree) CompactionStyle {
+	if o == nil {
+		return LeveledCompaction
+	}
+	if t == SecondaryTree {
+		return o.SecondaryCompactionStyle
+	}
+	return o.CompactionStyle
+}
+
 func (o *Options) GetCompactionTableSize(level int) int {
 	var (
 		base = DefaultCompactionTableSize
@@ -573,6 +824,13 @@ func (o *Options) GetIteratorSamplingRate() int {
 	return o.IteratorSamplingRate
 }
 
+func (o *Options) GetMaxSubcompactions() int {
+	if o == nil || o.MaxSubcompactions <= 0 {
+		return DefaultMaxSubcompactions
+	}
+	return o.MaxSubcompactions
+}
+
 func (o *Options) GetNoSync() bool {
 	if o == nil {
 		return false
@@ -606,6 +864,22 @@ func (o *Options) GetOpenFilesCacheCapacity() int {
 	return o.OpenFilesCacheCapacity
 }
 
+func (o *Options) GetSecondaryOpenFilesCacheCapacity() int {
+	if o == nil || o.SecondaryOpenFilesCacheCapacity == 0 {
+		return DefaultOpenFilesCacheCapacity
+	} else if o.SecondaryOpenFilesCacheCapacity < 0 {
+		return 0
+	}
+	return o.SecondaryOpenFilesCacheCapacity
+}
+
+func (o *Options) GetPrefixExtractor() filter.PrefixExtractor {
+	if o == nil {
+		return nil
+	}
+	return o.PrefixExtractor
+}
+
 func (o *Options) GetReadOnly() bool {
 	if o == nil {
 		return false
@@ -620,6 +894,13 @@ func (o *Options) GetStrict(strict Strict) bool {
 	return o.Strict&strict != 0
 }
 
+func (o *Options) GetTableFormat() TableFormat {
+	if o == nil {
+		return BlockTableFormat
+	}
+	return o.TableFormat
+}
+
 func (o *Options) GetWriteBuffer() int {
 	if o == nil || o.WriteBuffer <= 0 {
 		return DefaultWriteBuffer
@@ -22,7 +22,9 @@ func dupOptions(o *opt.Options) *opt.Options {
 	return newo
 }
 
-func (s *session) setOptions(o *opt.Options) {
+// Returns a copy of o whose comparer and filters operate on internal keys,
+// as the tables are written and read with.
+func internalOptions(o *opt.Options) *opt.Options {
 	no := dupOptions(o)
 	// Alternative filters.
 	if filters := o.GetAltFilters(); len(filters) > 0 {
@@ -32,12 +34,24 @@ func (s *session) setOptions(o *opt.Options) {
 		}
 	}
 	// Comparer.
-	s.icmp = &iComparer{o.GetComparer()}
-	no.Comparer = s.icmp
+	no.Comparer = &iComparer{o.GetComparer()}
 	// Filter.
-	if filter := o.GetFilter(); filter != nil {
-		no.Filter = &iFilter{filter}
+	if f := o.GetFilter(); f != nil {
+		if pe := o.GetPrefixExtractor(); pe != nil {
+			// Tables written with the plain filter are still filtered.
+			no.AltFilters = append(no.AltFilters, &iFilter{f})
+			pf := filter.NewPrefixFilter(f, pe)
+			no.Filter = &iPrefixFilter{iFilter{pf}, pf}
+		} else {
+			no.Filter = &iFilter{f}
+		}
 	}
+	return no
+}
+
+func (s *session) setOptions(o *opt.Options) {
+	no := internalOptions(o)
+	s.icmp = no.Comparer.(*iComparer)
 
 	s.o = &cachedOptions{Options: no}
 	s.o.cache()
@@ -0,0 +1,180 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"sort"
+
+	"github.com/rev3z/ledger_base/leveldb/iterator"
+)
+
+// rangeTombstone records deletion of user keys within [start, limit), written
+// with sequence number seq. Range tombstones are stored as internal keys of
+// type keyTypeRangeDel: inline in the memdb and in the range deletion block
+// of tables, see table.Writer.AppendRangeDel.
+type rangeTombstone struct {
+	start, limit []byte
+	seq          uint64
+}
+
+func (t rangeTombstone) ikey() internalKey {
+	return makeInternalKey(nil, t.start, t.seq, keyTypeRangeDel)
+}
+
+// Sorts tombstones by internal key, i.e. by start then by decreasing seq.
+func sortRangeTombstones(icmp *iComparer, ts []rangeTombstone) {
+	sort.Slice(ts, func(i, j int) bool {
+		if c := icmp.uCompare(ts[i].start, ts[j].start); c != 0 {
+			return c < 0
+		}
+		return ts[i].seq > ts[j].seq
+	})
+}
+
+// Appends range tombstones read from iter whose seq is not above seq. The
+// keys and values are copied. The iterator is released.
+func readRangeTombstones(dst []rangeTombstone, iter iterator.Iterator, seq uint64) ([]rangeTombstone, error) {
+	defer iter.Release()
+	for iter.Next() {
+		ukey, tseq, kt, kerr := parseInternalKey(iter.Key())
+		if kerr != nil {
+			return dst, kerr
+		}
+		if kt != keyTypeRangeDel || tseq > seq {
+			continue
+		}
+		dst = append(dst, rangeTombstone{
+			start: append([]byte{}, ukey...),
+			limit: append([]byte{}, iter.Value()...),
+			seq:   tseq,
+		})
+	}
+	return dst, iter.Error()
+}
+
+// Returns the largest seq, not above seq, of the range tombstones read from
+// iter that cover ukey; zero if there is none. The iterator must yield the
+// tombstones sorted by internal key, it is released.
+func rangeDelSeq(icmp *iComparer, iter iterator.Iterator, ukey []byte, seq uint64) (max uint64, err error) {
+	defer iter.Release()
+	for iter.Next() {
+		start, tseq, kt, kerr := parseInternalKey(iter.Key())
+		if kerr != nil {
+			return 0, kerr
+		}
+		if icmp.uCompare(start, ukey) > 0 {
+			break
+		}
+		if kt == keyTypeRangeDel && tseq <= seq && tseq > max && icmp.uCompare(ukey, iter.Value()) < 0 {
+			max = tseq
+		}
+	}
+	return max, iter.Error()
+}
+
+// Returns the tombstones of ts whose seq is not above seq, ts is reused.
+func filterRangeTombstones(ts []rangeTombstone, seq uint64) []rangeTombstone {
+	n := 0
+	for _, t := range ts {
+		if t.seq <= seq {
+			ts[n] = t
+			n++
+		}
+	}
+	return ts[:n]
+}
+
+// rangeTombstones is a set of non-overlapping fragments sorted by start,
+// each carrying the largest seq of the tombstones covering it.
+type rangeTombstones []rangeTombstone
+
+// Splits possibly overlapping tombstones into fragments.
+func fragmentRangeTombstones(icmp *iComparer, ts []rangeTombstone) rangeTombstones {
+	var (
+		sorted = make([]rangeTombstone, 0, len(ts))
+		bounds = make([][]byte, 0, 2*len(ts))
+	)
+	for _, t := range ts {
+		if icmp.uCompare(t.start, t.limit) < 0 {
+			sorted = append(sorted, t)
+			bounds = append(bounds, t.start, t.limit)
+		}
+	}
+	sortRangeTombstones(icmp, sorted)
+	sort.Slice(bounds, func(i, j int) bool {
+		return icmp.uCompare(bounds[i], bounds[j]) < 0
+	})
+
+	var (
+		frags  rangeTombstones
+		active []rangeTombstone
+		next   int
+	)
+	for i := 0; i+1 < len(bounds); i++ {
+		start, limit := bounds[i], bounds[i+1]
+		if icmp.uCompare(start, limit) == 0 {
+			continue
+		}
+		for ; next < len(sorted) && icmp.uCompare(sorted[next].start, start) <= 0; next++ {
+			active = append(active, sorted[next])
+		}
+		// Every active tombstone ending after start spans the whole
+		// fragment, since its limit is one of the bounds.
+		var seq uint64
+		n := 0
+		for _, t := range active {
+			if icmp.uCompare(t.limit, start) > 0 {
+				active[n] = t
+				n++
+				if t.seq > seq {
+					seq = t.seq
+				}
+			}
+		}
+		active = active[:n]
+		if seq == 0 {
+			continue
+		}
+		if n := len(frags); n > 0 && frags[n-1].seq == seq && icmp.uCompare(frags[n-1].limit, start) == 0 {
+			frags[n-1].limit = limit
+			continue
+		}
+		frags = append(frags, rangeTombstone{start: start, limit: limit, seq: seq})
+	}
+	return frags
+}
+
+// Returns the largest seq of the tombstones covering ukey, or zero.
+func (ts rangeTombstones) seqAt(icmp *iComparer, ukey []byte) uint64 {
+	if len(ts) == 0 {
+		return 0
+	}
+	i := sort.Search(len(ts), func(i int) bool {
+		return icmp.uCompare(ts[i].start, ukey) > 0
+	})
+	if i == 0 || icmp.uCompare(ukey, ts[i-1].limit) >= 0 {
+		return 0
+	}
+	return ts[i-1].seq
+}
+
+// Whether the fragments cover every user key within [umin, umax].
+func (ts rangeTombstones) covers(icmp *iComparer, umin, umax []byte) bool {
+	i := sort.Search(len(ts), func(i int) bool {
+		return icmp.uCompare(ts[i].start, umin) > 0
+	})
+	if i == 0 || icmp.uCompare(umin, ts[i-1].limit) >= 0 {
+		return false
+	}
+	for i--; icmp.uCompare(umax, ts[i].limit) >= 0; i++ {
+		// Fragments must be contiguous up to umax.
+		if i+1 == len(ts) || icmp.uCompare(ts[i+1].start, ts[i].limit) != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,147 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+// Package ratelimit provides I/O rate limiting for compactions.
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Priority is the priority of an I/O request.
+type Priority int
+
+const (
+	// Low is the priority of table compactions.
+	Low Priority = iota
+
+	// High is the priority of memdb flushes, which writes wait for.
+	High
+)
+
+// Limiter throttles I/O.
+type Limiter interface {
+	// Request blocks until n bytes may be transferred at the given
+	// priority. It returns the time spent waiting.
+	Request(n int, pri Priority) time.Duration
+}
+
+// Stats is the statistics of a TokenBucket.
+type Stats struct {
+	Bytes     [2]int64 // bytes granted, indexed by priority
+	Throttled [2]int64 // requests that waited, indexed by priority
+
+	ThrottledDuration time.Duration
+}
+
+// The longest a waiting request sleeps before looking again, so that rate
+// changes and higher priority requests are taken into account promptly.
+const maxSleep = 50 * time.Millisecond
+
+// TokenBucket is a Limiter granting up to a given number of bytes per
+// second, with bursts of up to one second worth of bytes. Low priority
+// requests wait while high priority ones do.
+type TokenBucket struct {
+	mu      sync.Mutex
+	rate    int64
+	tokens  float64
+	last    time.Time
+	waiting int // waiting high priority requests
+	stats   Stats
+}
+
+// NewTokenBucket creates a new TokenBucket granting bytesPerSec bytes per
+// second. A non-positive rate disables limiting.
+func NewTokenBucket(bytesPerSec int64) *TokenBucket {
+	return &TokenBucket{
+		rate:   bytesPerSec,
+		tokens: float64(bytesPerSec),
+		last:   time.Now(),
+	}
+}
+
+// SetRate changes the rate, it is safe to call while requests are waiting.
+// A non-positive rate disables limiting.
+func (l *TokenBucket) SetRate(bytesPerSec int64) {
+	l.mu.Lock()
+	l.refill(time.Now())
+	l.rate = bytesPerSec
+	if l.tokens > float64(bytesPerSec) {
+		l.tokens = float64(bytesPerSec)
+	}
+	l.mu.Unlock()
+}
+
+// Rate returns the current rate in bytes per second.
+func (l *TokenBucket) Rate() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate
+}
+
+// Stats returns the statistics of the bucket.
+func (l *TokenBucket) Stats() Stats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.stats
+}
+
+// Must hold lock.
+func (l *TokenBucket) refill(now time.Time) {
+	if l.rate > 0 {
+		l.tokens += now.Sub(l.last).Seconds() * float64(l.rate)
+		if l.tokens > float64(l.rate) {
+			l.tokens = float64(l.rate)
+		}
+	}
+	l.last = now
+}
+
+// Request implements Limiter. Requests larger than the burst are granted
+// once the bucket isn't in debt, and put it in debt.
+func (l *TokenBucket) Request(n int, pri Priority) (waited time.Duration) {
+	if pri != High {
+		pri = Low
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if pri == High {
+		l.waiting++
+		defer func() { l.waiting-- }()
+	}
+	var start time.Time
+	for {
+		now := time.Now()
+		l.refill(now)
+		if l.rate <= 0 || ((pri == High || l.waiting == 0) && l.tokens >= 0) {
+			break
+		}
+		if start.IsZero() {
+			start = now
+		}
+
+		d := maxSleep
+		if l.tokens < 0 && (pri == High || l.waiting == 0) {
+			if need := time.Duration(-l.tokens / float64(l.rate) * float64(time.Second)); need < d {
+				d = need + time.Millisecond
+			}
+		}
+		l.mu.Unlock()
+		time.Sleep(d)
+		l.mu.Lock()
+	}
+	l.tokens -= float64(n)
+	l.stats.Bytes[pri] += int64(n)
+	if !start.IsZero() {
+		waited = time.Since(start)
+		l.stats.Throttled[pri]++
+		l.stats.ThrottledDuration += waited
+	}
+	return
+}
@@ -0,0 +1,68 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	const rate = 10 << 20
+	l := NewTokenBucket(rate)
+
+	// The initial burst and a request putting the bucket in debt pass.
+	if d := l.Request(rate, Low); d != 0 {
+		t.Errorf("burst request waited %v", d)
+	}
+	if d := l.Request(rate/2, Low); d != 0 {
+		t.Errorf("debt request waited %v", d)
+	}
+	if d := l.Request(1, Low); d < 300*time.Millisecond || d > 2*time.Second {
+		t.Errorf("throttled request waited %v, want about 500ms", d)
+	}
+	if s := l.Stats(); s.Throttled[Low] != 1 || s.Bytes[Low] != rate+rate/2+1 {
+		t.Errorf("got stats %+v", s)
+	}
+
+	// Unlimited.
+	l.SetRate(0)
+	if d := l.Request(rate, Low); d != 0 {
+		t.Errorf("unlimited request waited %v", d)
+	}
+}
+
+func TestTokenBucketPriority(t *testing.T) {
+	const rate = 1 << 20
+	l := NewTokenBucket(rate)
+	l.Request(2*rate, High)
+
+	order := make(chan Priority, 2)
+	go func() {
+		l.Request(1, Low)
+		order <- Low
+	}()
+	time.Sleep(10 * time.Millisecond)
+	go func() {
+		l.Request(1, High)
+		order <- High
+	}()
+
+	// Raising the rate is taken into account by waiting requests.
+	l.SetRate(4 * rate)
+	timeout := time.After(5 * time.Second)
+	for _, want := range []Priority{High, Low} {
+		select {
+		case got := <-order:
+			if got != want {
+				t.Fatalf("got priority %d done first, want %d", got, want)
+			}
+		case <-timeout:
+			t.Fatal("timeout waiting for requests")
+		}
+	}
+}
@@ -41,17 +41,19 @@ type session struct {
 	stPrevJournalNum int64 // prev journal file number; no longer used; for compatibility with older version of leveldb
 	stTempFileNum    int64
 	stSeqNum         uint64 // last mem compacted seq; need external synchronization
+	stMemSeqNum      uint64 // last mem compacted seq of the primary tree; need external synchronization
+	stMemSeqNum2     uint64 // last mem compacted seq of the secondary tree; need external synchronization
 
 	stor     *iStorage
 	storLock storage.Locker
 	o        *cachedOptions
 	icmp     *iComparer
-	tops     *tOps // 管理缓存！
-	//tops2    *tOps // 同样管理缓存？
+	tops     *tOps // 管理缓存！两棵树的cache分开
 
 	manifest       *journal.Writer
 	manifestWriter storage.Writer
 	manifestFd     storage.FileDesc
+	manifestRecs   int // records read from the manifest; for a secondary DB
 
 	stCompPtrs   []internalKey // compaction pointers; need external synchronization
 	stCompPtrs2  []internalKey // compaction pointers; need external synchronization
@@ -158,6 +160,8 @@ func (s *session) recover() (err error) {
 		jr      = journal.NewReader(reader, dropper{s, fd}, strict, true) //*Reader
 		rec     = &sessionRecord{} //sessionR
 		staging = s.stVersion.newStaging() //versionStaging,版本的中间阶段？
+		maxSeq  uint64
+		nrec    int
 	)
 	for {
 		var r io.Reader
@@ -169,11 +173,16 @@ func (s *session) recover() (err error) {
 			}
 			return errors.SetFd(err, fd)
 		}
+		nrec++
 		err = rec.decode(r) //对sr的decode？在rec执行此方法之后，SessionRecord中成员被赋值
 		/*fmt.Println("位于recover()中，这里是sessionRecord")
 		fmt.Println(rec.addedTabless,"  ",rec.addedTables)
 		fmt.Println(rec.deletedTabless,"  ",rec.deletedTables)*/
 		if err == nil {
+			// keep the highest seq, see recordCommited
+			if rec.has(recSeqNum) && rec.seqNum > maxSeq {
+				maxSeq = rec.seqNum
+			}
 			// save compact pointers
 			for _, r := range rec.compPtrs {
 				s.setCompPtr(r.level, internalKey(r.ikey))
@@ -196,6 +205,7 @@ func (s *session) recover() (err error) {
 		rec.resetAddedTables_s()
 		rec.resetDeletedTables()
 		rec.resetDeletedTables_s()
+		rec.resetBlobs()
 	}
 
 	switch {
@@ -210,14 +220,97 @@ func (s *session) recover() (err error) {
 	case !rec.has(recSeqNum):
 		return newErrManifestCorrupted(fd, "seq-num", "missing")
 	}
+	rec.setSeqNum(maxSeq)
 	//fmt.Println("recover 2")
 	s.manifestFd = fd
+	s.manifestRecs = nrec
 	s.setVersion(rec, staging.finish(false)) //将add的数据写入levels和level_s
 	s.setNextFileNum(rec.nextFileNum)
 	s.recordCommited(rec)
 	return nil
 }
 
+// Catch up with the manifest of the DB writer, for a secondary DB; need
+// external synchronization. The records following those already read are
+// applied to the current version, or all records if the writer switched to
+// a new manifest. A record still being written ends the catch-up, it is
+// read by the next one. Returns whether the version changed.
+func (s *session) catchUp() (changed bool, err error) {
+	fd, err := s.stor.GetMeta()
+	if err != nil {
+		return
+	}
+	reader, err := s.stor.Open(fd)
+	if err != nil {
+		return
+	}
+	defer reader.Close()
+
+	v := s.version()
+	defer v.release()
+	base, skip := v, s.manifestRecs
+	if fd != s.manifestFd {
+		// A new manifest starts with a snapshot of the DB.
+		base, skip = &version{s: s}, 0
+	}
+	var (
+		jr      = journal.NewReader(reader, dropper{s, fd}, true, true)
+		rec     = &sessionRecord{}
+		staging = base.newStaging()
+		maxSeq  = s.stSeqNum
+		nrec    int
+	)
+	for ; ; nrec++ {
+		r, err := jr.Next()
+		if err != nil {
+			if err != io.EOF {
+				s.logf("secondary@manifest stopped at record %d: %v", nrec, errors.SetFd(err, fd))
+			}
+			break
+		}
+		if nrec < skip {
+			continue
+		}
+		var tr sessionRecord
+		if err := tr.decode(r); err != nil {
+			s.logf("secondary@manifest stopped at record %d: %v", nrec, errors.SetFd(err, fd))
+			break
+		}
+		if tr.has(recSeqNum) && tr.seqNum > maxSeq {
+			maxSeq = tr.seqNum
+		}
+		staging.commit(&tr)
+		if tr.has(recJournalNum) {
+			rec.setJournalNum(tr.journalNum)
+		}
+		if tr.has(recMemSeqNum) {
+			rec.setMemSeqNum(tr.memSeqNum)
+		}
+		if tr.has(recMemSeqNum2) {
+			rec.setMemSeqNum_s(tr.memSeqNum2)
+		}
+		if tr.has(recNextFileNum) {
+			rec.setNextFileNum(tr.nextFileNum)
+		}
+	}
+	if fd == s.manifestFd && nrec <= skip {
+		return false, nil
+	}
+
+	// The files belong to the primary which removes them, so the change
+	// isn't passed to the reference loop.
+	nv := staging.finish(false)
+	rec.setSeqNum(maxSeq)
+	s.manifestFd = fd
+	s.manifestRecs = nrec
+	s.setVersion(nil, nv)
+	if rec.has(recNextFileNum) {
+		s.setNextFileNum(rec.nextFileNum)
+	}
+	s.recordCommited(rec)
+	return true, nil
+}
+
 // Commit session; need external synchronization.
 //通过spawn()生成新的版本信息，同时flushmanifest将新版本信息写入MANIFEST文件，最后有个setversion设置当前版本为最新生成的版本
 func (s *session) commit(r *sessionRecord, trivial bool) (err error) {
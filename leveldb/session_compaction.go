@@ -10,6 +10,7 @@ import (
 	"github.com/rev3z/ledger_base/leveldb/iterator"
 	"github.com/rev3z/ledger_base/leveldb/memdb"
 	"github.com/rev3z/ledger_base/leveldb/opt"
+	"github.com/rev3z/ledger_base/leveldb/util"
 	"sync/atomic"
 )
 
@@ -18,6 +19,7 @@ const (
 	level0Compaction
 	nonLevel0Compaction
 	seekCompaction
+	blobGCCompaction
 )
 
 func (s *session) pickMemdbLevel(umin, umax []byte, maxLevel int) int {
@@ -34,10 +36,14 @@ func (s *session) flushMemdb_s(rec *sessionRecord, mdb *memdb.DBs, maxLevel int)
 	// Create sorted table.
 	iter := mdb.NewIterator_s(nil)
 	defer iter.Release()
-	t, n, err := s.tops.createFrom_s(iter) //这里t是一个sfile
+	t, b, n, err := s.tops.createFrom_s(iter) //这里t是一个sfile
 	if err != nil {
 		return 0, err
 	}
+	if b != nil {
+		rec.addBlobFile(b)
+		s.logf("memdb@flush created blob@%d S·%s", b.fd.Num, shortenb(int(b.size)))
+	}
 	//Pick level other than zero can cause compaction issue with large
 	//bulk insert and delete on strictly incrementing key-space. The
 	//problem is that the small deletion markers trapped at lower level,
@@ -82,6 +88,9 @@ func (s *session) flushMemdb(rec *sessionRecord, mdb *memdb.DB, maxLevel int) (i
 // 得到触发compaction的类型，并得到初步要参与compaction的数据t0，调用new compaction
 func (s *session) pickCompaction() *compaction {
 	v := s.version()//获取当前的版本
+	if s.o.GetCompactionStyle(opt.PrimaryTree) == opt.TieredCompaction {
+		return s.pickTieredCompaction(v)
+	}
 	//声明三个变量
 	var sourceLevel int
 	var t0 tFiles //存放某一层的tfile
@@ -120,6 +129,9 @@ func (s *session) pickCompaction() *compaction {
 }
 func (s *session) pickCompaction_s() *compaction {
 	v := s.version()//获取当前的版本
+	if s.o.GetCompactionStyle(opt.SecondaryTree) == opt.TieredCompaction {
+		return s.pickTieredCompaction_s(v)
+	}
 	//声明三个变量
 	var sourceLevel int
 	var t0 sFiles //存放某一层的tfile
@@ -157,6 +169,179 @@ func (s *session) pickCompaction_s() *compaction {
 
 	return newCompaction_s(s, v, sourceLevel, t0, typ) //return c *compare
 }
+// Tiered compaction, see opt.TieredCompaction.
+const (
+	// The level a merge including the oldest run is written to, at least.
+	// Levels above are left for the newer runs.
+	tieredBottomLevel = 6
+
+	// A run joins the merge of the newer ones if it isn't larger than
+	// their total size by more than this percentage.
+	tieredSizeRatio = 1
+
+	// Every run is merged once the newer ones are larger than this
+	// percentage of the oldest one.
+	tieredMaxSizeAmp = 200
+)
+
+// Counts the sorted runs of a tiered tree: each level-0 table and each
+// non-empty level above 0.
+func tieredRuns(sizes []int64, nL0 int) (n int) {
+	n = nL0
+	for level := 1; level < len(sizes); level++ {
+		if sizes[level] > 0 {
+			n++
+		}
+	}
+	return
+}
+
+// Picks the sorted runs to merge given the size of each level and the number
+// of level-0 tables, the level-0 tables being merged together. Returns the
+// merged levels, newest first, and the level written to; nil if there are
+// less than two runs.
+func pickTieredLevels(sizes []int64, nL0 int) (inputs []int, out int) {
+	var levels []int
+	if nL0 > 0 {
+		levels = append(levels, 0)
+	}
+	for level := 1; level < len(sizes); level++ {
+		if sizes[level] > 0 {
+			levels = append(levels, level)
+		}
+	}
+	if tieredRuns(sizes, nL0) < 2 {
+		return nil, 0
+	}
+
+	n := 1
+	if last := len(levels) - 1; last > 0 {
+		var newer int64
+		for _, level := range levels[:last] {
+			newer += sizes[level]
+		}
+		if newer*100 > sizes[levels[last]]*tieredMaxSizeAmp {
+			n = len(levels)
+		}
+	}
+	for acc := sizes[levels[0]]; n < len(levels) && sizes[levels[n]]*100 <= acc*(100+tieredSizeRatio); n++ {
+		acc += sizes[levels[n]]
+	}
+	if n == 1 && (levels[0] > 0 || nL0 < 2) {
+		// A single run can't be merged, take the next one along.
+		n = 2
+	}
+
+	// The output must go above the next older run, and can't go to
+	// level-0 as older level-0 tables would hide it.
+	for {
+		if n == len(levels) {
+			out = levels[n-1]
+			if out < tieredBottomLevel {
+				out = tieredBottomLevel
+			}
+			break
+		}
+		if out = levels[n] - 1; out > 0 {
+			break
+		}
+		n++
+	}
+	return levels[:n], out
+}
+
+// Creates a tiered compaction; the version is released if there is none.
+func (s *session) pickTieredCompaction(v *version) *compaction {
+	if v.cScore < 1 {
+		v.release()
+		return nil
+	}
+	sizes := make([]int64, len(v.levels))
+	for level, tables := range v.levels {
+		sizes[level] = tables.size()
+	}
+	inputs, out := pickTieredLevels(sizes, len(v.levels[0]))
+	if inputs == nil {
+		v.release()
+		return nil
+	}
+
+	c := &compaction{
+		s:             s,
+		v:             v,
+		typ:           level0Compaction,
+		sourceLevel:   out - 1,
+		maxGPOverlaps: int64(s.o.GetCompactionGPOverlaps(out - 1)),
+		tPtrs:         make([]int, len(v.levels)),
+	}
+	var all tFiles
+	for _, level := range inputs {
+		tables := v.levels[level]
+		all = append(all, tables...)
+		if level == out {
+			c.levels[1] = tables
+			continue
+		}
+		for _, t := range tables {
+			c.levels[0] = append(c.levels[0], t)
+			c.srcLevels = append(c.srcLevels, level)
+		}
+	}
+	c.imin, c.imax = all.getRange(s.icmp)
+	c.save()
+	s.logf("table@compaction tiered L%v -> L%d", inputs, out)
+	return c
+}
+func (s *session) pickTieredCompaction_s(v *version) *compaction {
+	if v.cScores < 1 {
+		v.release()
+		return nil
+	}
+	sizes := make([]int64, len(v.level_s))
+	for level, tables := range v.level_s {
+		sizes[level] = tables.size()
+	}
+	inputs, out := pickTieredLevels(sizes, len(v.level_s[0]))
+	if inputs == nil {
+		v.release()
+		return nil
+	}
+
+	c := &compaction{
+		s:             s,
+		v:             v,
+		typ:           level0Compaction,
+		sourceLevel:   out - 1,
+		maxGPOverlaps: int64(s.o.GetCompactionGPOverlaps(out - 1)),
+		tPtrs:         make([]int, len(v.level_s)),
+	}
+	var all sFiles
+	for _, level := range inputs {
+		tables := v.level_s[level]
+		all = append(all, tables...)
+		if level == out {
+			c.level_s[1] = tables
+			continue
+		}
+		for _, t := range tables {
+			c.level_s[0] = append(c.level_s[0], t)
+			c.srcLevels = append(c.srcLevels, level)
+		}
+	}
+	c.imin, c.imax = all.getRange(s.icmp)
+	c.save()
+	s.logf("table@compaction tiered L%v -> L%d", inputs, out)
+	return c
+}
+
+// Returns the level of the j-th table of c.levels[i], or c.level_s[i].
+func (c *compaction) inputLevel(i, j int) int {
+	if i == 0 && c.srcLevels != nil {
+		return c.srcLevels[j]
+	}
+	return c.sourceLevel + i
+}
+
 // Create compaction from given level and range; need external synchronization.
 // 会在table range compaction中被调用
 func (s *session) getCompactionRange(sourceLevel int, umin, umax []byte, noLimit bool) *compaction {
@@ -233,6 +418,40 @@ func (s *session) getCompactionRange_s(sourceLevel int, umin, umax []byte, noLim
 	}
 	return newCompaction_s(s, v, sourceLevel, t0, typ)
 }
+// Creates a compaction rewriting in place the tables of the given level,
+// which is not level-0, overlapping the given range; see DB.blobGC_s. Need
+// external synchronization.
+func (s *session) getBlobCompaction_s(level int, umin, umax []byte) *compaction {
+	v := s.version()
+
+	if level <= 0 || level >= len(v.level_s) {
+		v.release()
+		return nil
+	}
+
+	t1 := v.level_s[level].getOverlaps(nil, s.icmp, umin, umax, false)
+	if len(t1) == 0 {
+		v.release()
+		return nil
+	}
+
+	c := &compaction{
+		s:             s,
+		v:             v,
+		typ:           blobGCCompaction,
+		sourceLevel:   level - 1,
+		maxGPOverlaps: int64(s.o.GetCompactionGPOverlaps(level - 1)),
+		tPtrs:         make([]int, len(v.level_s)),
+	}
+	c.level_s[1] = t1
+	if l := level + 1; l < len(v.level_s) {
+		amin, amax := t1.getRange(s.icmp)
+		c.gps = v.level_s[l].getOverlaps(nil, s.icmp, amin.ukey(), amax.ukey(), false)
+	}
+	c.save()
+	return c
+}
+
 //调用expand()
 func newCompaction(s *session, v *version, sourceLevel int, t0 tFiles, typ int) *compaction {
 	c := &compaction{
@@ -282,6 +501,13 @@ type compaction struct {
 	imin, imax        internalKey
 	tPtrs             []int
 	released          bool
+
+	// Level of each c.levels[0] table of a tiered compaction, which merges
+	// several levels at once; nil otherwise.
+	srcLevels []int
+
+	// User key range [start, limit) of a subcompaction, nil if unbounded.
+	start, limit []byte
 	//快照？
 	snapGPI               int
 	snapSeenKey           bool
@@ -310,6 +536,135 @@ func (c *compaction) release() {
 	}
 }
 
+// Splits a non level-0 compaction into at most n subcompactions of disjoint
+// user key ranges, bounded by the edges of the sourceLevel+1 tables. Returns
+// nil if it isn't worth splitting. The subcompactions share the version of c.
+func (c *compaction) split(n int) []*compaction {
+	if c.sourceLevel == 0 || n > len(c.levels[1]) {
+		n = len(c.levels[1])
+	}
+	if c.sourceLevel == 0 || n < 2 {
+		return nil
+	}
+	ts, err := c.getRangeTombstones(false)
+	if err != nil {
+		return nil
+	}
+	bounds := make([][]byte, 0, n-1)
+	for i := 1; i < n; i++ {
+		bounds = append(bounds, c.levels[1][i*len(c.levels[1])/n].imin.ukey())
+	}
+	return c.subcompactions(bounds, ts)
+}
+
+// Splits a non level-0 compaction of the secondary tree, see split.
+func (c *compaction) split_s(n int) []*compaction {
+	if c.sourceLevel == 0 || n > len(c.level_s[1]) {
+		n = len(c.level_s[1])
+	}
+	if c.sourceLevel == 0 || n < 2 {
+		return nil
+	}
+	ts, err := c.getRangeTombstones_s(false)
+	if err != nil {
+		return nil
+	}
+	bounds := make([][]byte, 0, n-1)
+	for i := 1; i < n; i++ {
+		bounds = append(bounds, c.level_s[1][i*len(c.level_s[1])/n].imin.ukey())
+	}
+	return c.subcompactions(bounds, ts)
+}
+
+// Creates the subcompactions split at the given ascending bounds. A bound
+// within a range tombstone, or at its limit, is skipped: a table may only
+// end past the limit of its range tombstones, see tWriter.afterRangeDels.
+func (c *compaction) subcompactions(bounds [][]byte, ts []rangeTombstone) []*compaction {
+	var (
+		subs  []*compaction
+		start []byte
+	)
+	for i := 0; i <= len(bounds); i++ {
+		var limit []byte
+		if i < len(bounds) {
+			limit = bounds[i]
+			if start != nil && c.s.icmp.uCompare(limit, start) <= 0 {
+				continue
+			}
+			crossed := false
+			for _, t := range ts {
+				if c.s.icmp.uCompare(t.start, limit) < 0 && c.s.icmp.uCompare(limit, t.limit) <= 0 {
+					crossed = true
+					break
+				}
+			}
+			if crossed {
+				continue
+			}
+		}
+		sub := &compaction{
+			s:             c.s,
+			v:             c.v,
+			typ:           c.typ,
+			sourceLevel:   c.sourceLevel,
+			levels:        c.levels,
+			level_s:       c.level_s,
+			srcLevels:     c.srcLevels,
+			maxGPOverlaps: c.maxGPOverlaps,
+			gp:            c.gp,
+			gps:           c.gps,
+			imin:          c.imin,
+			imax:          c.imax,
+			tPtrs:         make([]int, len(c.tPtrs)),
+			released:      true, // The version is released by c.
+			start:         start,
+			limit:         limit,
+		}
+		sub.save()
+		subs = append(subs, sub)
+		start = limit
+	}
+	if len(subs) < 2 {
+		return nil
+	}
+	return subs
+}
+
+// Returns the internal key range of a subcompaction, nil if unbounded.
+func (c *compaction) islice() *util.Range {
+	if c.start == nil && c.limit == nil {
+		return nil
+	}
+	islice := &util.Range{}
+	if c.start != nil {
+		islice.Start = makeInternalKey(nil, c.start, keyMaxSeq, keyTypeSeek)
+	}
+	if c.limit != nil {
+		islice.Limit = makeInternalKey(nil, c.limit, keyMaxSeq, keyTypeSeek)
+	}
+	return islice
+}
+
+// Keeps the tombstones of ts starting within the key range of a
+// subcompaction, ts is reused. Splitting ensures none crosses a bound.
+func (c *compaction) sliceRangeTombstones(ts []rangeTombstone) []rangeTombstone {
+	if c.start == nil && c.limit == nil {
+		return ts
+	}
+	n := 0
+	for _, t := range ts {
+		if c.start != nil && c.s.icmp.uCompare(t.start, c.start) < 0 {
+			continue
+		}
+		if c.limit != nil && c.s.icmp.uCompare(t.start, c.limit) >= 0 {
+			continue
+		}
+		ts[n] = t
+		n++
+	}
+	return ts[:n]
+}
+
 // Expand compacted tables; need external synchronization.
 func (c *compaction) expand() {
 	limit := int64(c.s.o.GetCompactionExpandLimit(c.sourceLevel))//参与compaction的大小限制？
@@ -454,6 +809,97 @@ func (c *compaction) baseLevelForKey_s(ukey []byte) bool {
 	}
 	return true
 }
+// Whether no table below sourceLevel+1 overlaps the user key range
+// [umin, umax]. Unlike baseLevelForKey it doesn't advance tPtrs.
+func (c *compaction) baseLevelForRange(umin, umax []byte) bool {
+	for level := c.sourceLevel + 2; level < len(c.v.levels); level++ {
+		if c.v.levels[level].overlaps(c.s.icmp, umin, umax, false) {
+			return false
+		}
+	}
+	return true
+}
+func (c *compaction) baseLevelForRange_s(umin, umax []byte) bool {
+	for level := c.sourceLevel + 2; level < len(c.v.level_s); level++ {
+		if c.v.level_s[level].overlaps(c.s.icmp, umin, umax, false) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the range tombstones of the compacted tables, sorted by internal
+// key. If source is set only those of the sourceLevel tables are returned.
+func (c *compaction) getRangeTombstones(source bool) (ts []rangeTombstone, err error) {
+	for i, tables := range c.levels {
+		if source && i > 0 {
+			break
+		}
+		for _, t := range tables {
+			if ts, err = readRangeTombstones(ts, c.s.tops.newRangeDelIterator(t), keyMaxSeq); err != nil {
+				return nil, err
+			}
+		}
+	}
+	sortRangeTombstones(c.s.icmp, ts)
+	return c.sliceRangeTombstones(ts), nil
+}
+func (c *compaction) getRangeTombstones_s(source bool) (ts []rangeTombstone, err error) {
+	for i, tables := range c.level_s {
+		if source && i > 0 {
+			break
+		}
+		for _, t := range tables {
+			if ts, err = readRangeTombstones(ts, c.s.tops.newRangeDelIterator_s(t), keyMaxSeq); err != nil {
+				return nil, err
+			}
+		}
+	}
+	sortRangeTombstones(c.s.icmp, ts)
+	return c.sliceRangeTombstones(ts), nil
+}
+
+// Removes from the sourceLevel+1 inputs the tables entirely covered by range
+// deletions of the sourceLevel tables whose seq is not above minSeq. Their
+// entries are all older than the deletions and invisible to every snapshot,
+// so they needn't be read; the caller still records the tables as deleted.
+// A failure to read the tombstones leaves the inputs untouched, it surfaces
+// again when the compaction reads them.
+func (c *compaction) dropCoveredTables(minSeq uint64) (dropped tFiles) {
+	ts, err := c.getRangeTombstones(true)
+	if err != nil || len(ts) == 0 {
+		return nil
+	}
+	frags := fragmentRangeTombstones(c.s.icmp, filterRangeTombstones(ts, minSeq))
+	kept := c.levels[1][:0:0]
+	for _, t := range c.levels[1] {
+		if frags.covers(c.s.icmp, t.imin.ukey(), t.imax.ukey()) {
+			dropped = append(dropped, t)
+		} else {
+			kept = append(kept, t)
+		}
+	}
+	c.levels[1] = kept
+	return
+}
+func (c *compaction) dropCoveredTables_s(minSeq uint64) (dropped sFiles) {
+	ts, err := c.getRangeTombstones_s(true)
+	if err != nil || len(ts) == 0 {
+		return nil
+	}
+	frags := fragmentRangeTombstones(c.s.icmp, filterRangeTombstones(ts, minSeq))
+	kept := c.level_s[1][:0:0]
+	for _, t := range c.level_s[1] {
+		if frags.covers(c.s.icmp, t.imin.ukey(), t.imax.ukey()) {
+			dropped = append(dropped, t)
+		} else {
+			kept = append(kept, t)
+		}
+	}
+	c.level_s[1] = kept
+	return
+}
+
 func (c *compaction) shouldStopBefore(ikey internalKey) bool {
 	for ; c.gpi < len(c.gp); c.gpi++ {
 		gp := c.gp[c.gpi]
@@ -511,24 +957,32 @@ func (c *compaction) newIterator() iterator.Iterator {
 	if strict {
 		ro.Strict |= opt.StrictReader
 	}
+	limiter := c.s.tops.newRateLimitedIterator()
+	islice := c.islice()
 
 	for i, tables := range c.levels {
 		if len(tables) == 0 {
 			continue
 		}
 
-		// Level-0 is not sorted and may overlaps each other.
-		if c.sourceLevel+i == 0 {
+		// Level-0 is not sorted and may overlaps each other, nor are the
+		// source tables of a tiered compaction.
+		if c.sourceLevel+i == 0 || (i == 0 && c.srcLevels != nil) {
 			for _, t := range tables {
-				its = append(its, c.s.tops.newIterator(t, nil, ro))
+				its = append(its, c.s.tops.newLimitedIterator(t, islice, ro, limiter))
 			}
 		} else {
-			it := iterator.NewIndexedIterator(tables.newIndexIterator(c.s.tops, c.s.icmp, nil, ro), strict)
+			it := iterator.NewIndexedIterator(tables.newIndexIterator(c.s.tops, c.s.icmp, islice, ro, limiter), strict)
 			its = append(its, it)
 		}
 	}
 
-	return iterator.NewMergedIterator(its, c.s.icmp, strict)
+	iter := iterator.NewMergedIterator(its, c.s.icmp, strict)
+	if limiter != nil {
+		limiter.Iterator = iter
+		return limiter
+	}
+	return iter
 }
 func (c *compaction) newIterator_s() iterator.Iterator {
 	// Creates iterator slice.
@@ -548,23 +1002,31 @@ func (c *compaction) newIterator_s() iterator.Iterator {
 	if strict {
 		ro.Strict |= opt.StrictReader
 	}
+	limiter := c.s.tops.newRateLimitedIterator()
+	islice := c.islice()
 
 	for i, tables := range c.level_s {
 		if len(tables) == 0 {
 			continue
 		}
 
-		// Level-0 is not sorted and may overlaps each other.
-		if c.sourceLevel+i == 0 {
+		// Level-0 is not sorted and may overlaps each other, nor are the
+		// source tables of a tiered compaction.
+		if c.sourceLevel+i == 0 || (i == 0 && c.srcLevels != nil) {
 			for _, t := range tables {
-				its = append(its, c.s.tops.newIterator_s(t, nil, ro))
+				its = append(its, c.s.tops.newLimitedIterator_s(t, islice, ro, limiter))
 			}
 		} else {
-			it := iterator.NewIndexedIterator(tables.newIndexIterator(c.s.tops, c.s.icmp, nil, ro), strict)
+			it := iterator.NewIndexedIterator(tables.newIndexIterator(c.s.tops, c.s.icmp, islice, ro, limiter), strict)
 			its = append(its, it)
 		}
 	}
 
-	return iterator.NewMergedIterator(its, c.s.icmp, strict)
+	iter := iterator.NewMergedIterator(its, c.s.icmp, strict)
+	if limiter != nil {
+		limiter.Iterator = iter
+		return limiter
+	}
+	return iter
 }
 
@@ -33,7 +33,14 @@ const (
 	recAddTable    = 7
 	recDelTables   = 10
 	recAddTables   = 11
+	recMemSeqNum   = 14
+	recMemSeqNum2  = 15
+	recAddBlob     = 16
+	recBlobGarbage = 17
+	recAddTableSeq  = 18
+	recAddTableSeq2 = 19
 	// 8 was used for large value refs
+	// 13 was used for column families
 	recPrevJournalNum = 9
 )
 
@@ -48,6 +55,7 @@ type atRecord struct {
 	size  int64
 	imin  internalKey
 	imax  internalKey
+	seq   uint64 // global sequence number of an ingested table
 }
 
 type dtRecord struct {
@@ -55,6 +63,17 @@ type dtRecord struct {
 	num   int64
 }
 
+type abRecord struct {
+	num        int64
+	size       int64
+	umin, umax []byte
+}
+
+type bgRecord struct {
+	num     int64
+	garbage int64
+}
+
 type sessionRecord struct {
 	hasRec         int
 	comparer       string
@@ -62,12 +81,16 @@ type sessionRecord struct {
 	prevJournalNum int64
 	nextFileNum    int64
 	seqNum         uint64 //seq
+	memSeqNum      uint64 // last mem compacted seq of the primary tree
+	memSeqNum2     uint64 // last mem compacted seq of the secondary tree
 	compPtrs       []cpRecord //level,min key
 	compPtrs2      []cpRecord //level,min key,应该是保存合并点用
 	addedTables    []atRecord //level,size,num,imin,imax //记录tfile？
 	addedTabless   []atRecord //使用同名方法添加数据
 	deletedTables  []dtRecord
 	deletedTabless []dtRecord
+	addedBlobs     []abRecord // blob files of the secondary tree
+	blobGarbage    []bgRecord // bytes of blob records no longer referenced
 	scratch        [binary.MaxVarintLen64]byte
 	err            error
 }
@@ -101,6 +124,16 @@ func (p *sessionRecord) setSeqNum(num uint64) {
 	p.seqNum = num
 }
 
+func (p *sessionRecord) setMemSeqNum(num uint64) {
+	p.hasRec |= 1 << recMemSeqNum
+	p.memSeqNum = num
+}
+
+func (p *sessionRecord) setMemSeqNum_s(num uint64) {
+	p.hasRec |= 1 << recMemSeqNum2
+	p.memSeqNum2 = num
+}
+
 func (p *sessionRecord) addCompPtr(level int, ikey internalKey) {
 	p.hasRec |= 1 << recCompPtr
 	p.compPtrs = append(p.compPtrs, cpRecord{level, ikey})
@@ -120,18 +153,29 @@ func (p *sessionRecord) resetCompPtrs_s() {
 }
 func (p *sessionRecord) addTable(level int, num, size int64, imin, imax internalKey) {
 	p.hasRec |= 1 << recAddTable
-	p.addedTables = append(p.addedTables, atRecord{level, num, size, imin, imax})
+	p.addedTables = append(p.addedTables, atRecord{level, num, size, imin, imax, 0})
 }
 func (p *sessionRecord) addTable_s(level int, num, size int64, imin, imax internalKey) {
 	p.hasRec |= 1 << recAddTables
-	p.addedTabless = append(p.addedTabless, atRecord{level, num, size, imin, imax})
+	p.addedTabless = append(p.addedTabless, atRecord{level, num, size, imin, imax, 0})
+}
+
+// Adds an ingested table, whose entries are all read as having the given
+// sequence number.
+func (p *sessionRecord) addTableSeq(level int, num, size int64, seq uint64, imin, imax internalKey) {
+	p.hasRec |= 1 << recAddTable
+	p.addedTables = append(p.addedTables, atRecord{level, num, size, imin, imax, seq})
+}
+func (p *sessionRecord) addTableSeq_s(level int, num, size int64, seq uint64, imin, imax internalKey) {
+	p.hasRec |= 1 << recAddTables
+	p.addedTabless = append(p.addedTabless, atRecord{level, num, size, imin, imax, seq})
 }
 
 func (p *sessionRecord) addTableFile(level int, t *tFile) { //用于tablecmpaction
-	p.addTable(level, t.fd.Num, t.size, t.imin, t.imax)
+	p.addTableSeq(level, t.fd.Num, t.size, t.seq, t.imin, t.imax)
 }
 func (p *sessionRecord) addTableFile_s(level int, t *sFile) {
-	p.addTable_s(level, t.fd.Num, t.size, t.imin, t.imax)
+	p.addTableSeq_s(level, t.fd.Num, t.size, t.seq, t.imin, t.imax)
 }
 
 func (p *sessionRecord) resetAddedTables() { //置空，用于recoverJ、recover()
@@ -159,6 +203,31 @@ func (p *sessionRecord) resetDeletedTables() {
 	p.deletedTables = p.deletedTables[:0]
 }
 
+func (p *sessionRecord) addBlob(num, size int64, umin, umax []byte) {
+	p.hasRec |= 1 << recAddBlob
+	p.addedBlobs = append(p.addedBlobs, abRecord{num, size, umin, umax})
+}
+
+func (p *sessionRecord) addBlobFile(f *bFile) {
+	p.addBlob(f.fd.Num, f.size, f.umin, f.umax)
+	if f.garbage > 0 {
+		p.addBlobGarbage(f.fd.Num, f.garbage)
+	}
+}
+
+// Records garbage bytes of the given blob file, adding up to what was
+// recorded before.
+func (p *sessionRecord) addBlobGarbage(num, garbage int64) {
+	p.hasRec |= 1 << recBlobGarbage
+	p.blobGarbage = append(p.blobGarbage, bgRecord{num, garbage})
+}
+
+func (p *sessionRecord) resetBlobs() {
+	p.hasRec &= ^(1<<recAddBlob | 1<<recBlobGarbage)
+	p.addedBlobs = p.addedBlobs[:0]
+	p.blobGarbage = p.blobGarbage[:0]
+}
+
 func (p *sessionRecord) putUvarint(w io.Writer, x uint64) {
 	if p.err != nil {
 		return
@@ -203,6 +272,14 @@ func (p *sessionRecord) encode(w io.Writer) error {
 		p.putUvarint(w, recSeqNum)
 		p.putUvarint(w, p.seqNum)
 	}
+	if p.has(recMemSeqNum) {
+		p.putUvarint(w, recMemSeqNum)
+		p.putUvarint(w, p.memSeqNum)
+	}
+	if p.has(recMemSeqNum2) {
+		p.putUvarint(w, recMemSeqNum2)
+		p.putUvarint(w, p.memSeqNum2)
+	}
 	for _, r := range p.compPtrs {
 		p.putUvarint(w, recCompPtr)
 		p.putUvarint(w, uint64(r.level))
@@ -224,21 +301,47 @@ func (p *sessionRecord) encode(w io.Writer) error {
 		p.putVarint(w, r.num)
 	}
 	for _, r := range p.addedTables {
-		p.putUvarint(w, recAddTable)
+		if r.seq != 0 {
+			p.putUvarint(w, recAddTableSeq)
+		} else {
+			p.putUvarint(w, recAddTable)
+		}
 		p.putUvarint(w, uint64(r.level))
 		p.putVarint(w, r.num)
 		p.putVarint(w, r.size)
+		if r.seq != 0 {
+			p.putUvarint(w, r.seq)
+		}
 		p.putBytes(w, r.imin)
 		p.putBytes(w, r.imax)
 	}
 	for _, r := range p.addedTabless {
-		p.putUvarint(w, recAddTables)
+		if r.seq != 0 {
+			p.putUvarint(w, recAddTableSeq2)
+		} else {
+			p.putUvarint(w, recAddTables)
+		}
 		p.putUvarint(w, uint64(r.level))
 		p.putVarint(w, r.num)
 		p.putVarint(w, r.size)
+		if r.seq != 0 {
+			p.putUvarint(w, r.seq)
+		}
 		p.putBytes(w, r.imin)
 		p.putBytes(w, r.imax)
 	}
+	for _, r := range p.addedBlobs {
+		p.putUvarint(w, recAddBlob)
+		p.putVarint(w, r.num)
+		p.putVarint(w, r.size)
+		p.putBytes(w, r.umin)
+		p.putBytes(w, r.umax)
+	}
+	for _, r := range p.blobGarbage {
+		p.putUvarint(w, recBlobGarbage)
+		p.putVarint(w, r.num)
+		p.putVarint(w, r.garbage)
+	}
 	return p.err
 }
 
@@ -342,6 +445,16 @@ func (p *sessionRecord) decode(r io.Reader) error {
 			if p.err == nil {
 				p.setSeqNum(x)
 			}
+		case recMemSeqNum:
+			x := p.readUvarint("mem-seq-num", br)
+			if p.err == nil {
+				p.setMemSeqNum(x)
+			}
+		case recMemSeqNum2:
+			x := p.readUvarint("mem-seq-num", br)
+			if p.err == nil {
+				p.setMemSeqNum_s(x)
+			}
 		case recCompPtr:
 			level := p.readLevel("comp-ptr.level", br)
 			ikey := p.readBytes("comp-ptr.ikey", br)
@@ -372,6 +485,26 @@ func (p *sessionRecord) decode(r io.Reader) error {
 			if p.err == nil {
 				p.addTable_s(level, num, size, imin, imax)
 			}
+		case recAddTableSeq:
+			level := p.readLevel("add-table.level", br)
+			num := p.readVarint("add-table.num", br)
+			size := p.readVarint("add-table.size", br)
+			seq := p.readUvarint("add-table.seq", br)
+			imin := p.readBytes("add-table.imin", br)
+			imax := p.readBytes("add-table.imax", br)
+			if p.err == nil {
+				p.addTableSeq(level, num, size, seq, imin, imax)
+			}
+		case recAddTableSeq2:
+			level := p.readLevel("add-table.level", br)
+			num := p.readVarint("add-table.num", br)
+			size := p.readVarint("add-table.size", br)
+			seq := p.readUvarint("add-table.seq", br)
+			imin := p.readBytes("add-table.imin", br)
+			imax := p.readBytes("add-table.imax", br)
+			if p.err == nil {
+				p.addTableSeq_s(level, num, size, seq, imin, imax)
+			}
 		case recDelTable:
 			level := p.readLevel("del-table.level", br)
 			num := p.readVarint("del-table.num", br)
@@ -384,6 +517,20 @@ func (p *sessionRecord) decode(r io.Reader) error {
 			if p.err == nil {
 				p.delTable_s(level, num)
 			}
+		case recAddBlob:
+			num := p.readVarint("add-blob.num", br)
+			size := p.readVarint("add-blob.size", br)
+			umin := p.readBytes("add-blob.umin", br)
+			umax := p.readBytes("add-blob.umax", br)
+			if p.err == nil {
+				p.addBlob(num, size, umin, umax)
+			}
+		case recBlobGarbage:
+			num := p.readVarint("blob-garbage.num", br)
+			garbage := p.readVarint("blob-garbage.garbage", br)
+			if p.err == nil {
+				p.addBlobGarbage(num, garbage)
+			}
 		}
 	}
 
@@ -60,3 +60,32 @@ func TestSessionRecord_EncodeDecode(t *testing.T) {
 	v.setSeqNum(uint64(big + 1000))
 	test()
 }
+
+func TestSessionRecord_TableSeq(t *testing.T) {
+	v := &sessionRecord{}
+	imin := makeInternalKey(nil, []byte("foo"), 7, keyTypeVal)
+	imax := makeInternalKey(nil, []byte("zoo"), 7, keyTypeDel)
+	v.addTable(1, 10, 100, imin, imax)
+	v.addTableSeq(2, 11, 110, 7, imin, imax)
+	v.addTableSeq_s(3, 12, 120, 7, imin, imax)
+
+	b := new(bytes.Buffer)
+	if err := v.encode(b); err != nil {
+		t.Fatal("encode: got error: ", err)
+	}
+	v2 := &sessionRecord{}
+	if err := v2.decode(b); err != nil {
+		t.Fatal("decode: got error: ", err)
+	}
+	if len(v2.addedTables) != 2 || len(v2.addedTabless) != 1 {
+		t.Fatalf("got %d and %d added tables", len(v2.addedTables), len(v2.addedTabless))
+	}
+	for i, want := range []uint64{0, 7} {
+		if r := v2.addedTables[i]; r.seq != want || r.num != int64(10+i) || !bytes.Equal(r.imax, imax) {
+			t.Errorf("added table %d: got num=%d seq=%d imax=%q", i, r.num, r.seq, r.imax)
+		}
+	}
+	if r := v2.addedTabless[0]; r.seq != 7 || r.level != 3 || r.size != 120 {
+		t.Errorf("added secondary table: got level=%d size=%d seq=%d", r.level, r.size, r.seq)
+	}
+}
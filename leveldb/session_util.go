@@ -56,9 +56,10 @@ const (
 // and the currently specified version
 type vDelta struct {
 	vid     int64
-	added   []int64
-	deleted []int64
-
+	added     []int64
+	deleted   []int64
+	deleted_s []int64 // deleted tables of the secondary tree
+	deleted_b []int64 // dropped blob files
 }
 
 // vTask defines a version task for either reference or release.
@@ -66,6 +67,7 @@ type vTask struct {
 	vid     int64
 	files   []tFiles
 	sfiles  []sFiles
+	blobs   map[int64]*bFile
 	created time.Time
 }
 
@@ -110,6 +112,16 @@ func (s *session) refLoop() {
 				s.tops.remove(storage.FileDesc{Type: storage.TypeTable, Num: t})
 			}
 		}
+		for _, t := range d.deleted_s {
+			if addFileRef(t, -1) == 0 {
+				s.tops.remove_s(storage.FileDesc{Type: storage.TypeTable, Num: t})
+			}
+		}
+		for _, b := range d.deleted_b {
+			if addFileRef(b, -1) == 0 {
+				s.tops.removeBlob(storage.FileDesc{Type: storage.TypeBlob, Num: b})
+			}
+		}
 	}
 
 	timer := time.NewTimer(0)
@@ -159,6 +171,9 @@ func (s *session) refLoop() {
 					addFileRef(t.fd.Num, 1)
 				}
 			}
+			for num := range ref[next].blobs {
+				addFileRef(num, 1)
+			}
 			// Note, if some compactions take a long time, even more than 5 minutes,
 			// we may miss the corresponding delta information here.
 			// Fortunately it will not affect the correctness of the file reference,
@@ -221,18 +236,21 @@ func (s *session) refLoop() {
 					for _, t := range tt {
 						if addFileRef(t.fd.Num, -1) == 0 {
 							s.tops.remove(t.fd)
-							//s.tops2.remove(t.fd)
 						}
 					}
 				}
 				for _, tt := range t.sfiles {
 					for _, t := range tt {
 						if addFileRef(t.fd.Num, -1) == 0 {
-							s.tops.remove(t.fd)
-							//s.tops2.remove(t.fd)
+							s.tops.remove_s(t.fd)
 						}
 					}
 				}
+				for _, b := range t.blobs {
+					if addFileRef(b.fd.Num, -1) == 0 {
+						s.tops.removeBlob(b.fd)
+					}
+				}
 				delete(referenced, t.vid)
 				continue
 			}
@@ -295,7 +313,8 @@ func (s *session) setVersion(r *sessionRecord, v *version) {
 		if r != nil {
 			var (
 				added   = make([]int64, 0, len(r.addedTables)+len(r.addedTabless)) //增加的文件num
-				deleted = make([]int64, 0, len(r.deletedTables)+len(r.deletedTabless)) //删除的文件num
+				deleted   = make([]int64, 0, len(r.deletedTables)) //删除的文件num
+				deleted_s = make([]int64, 0, len(r.deletedTabless))
 			)
 			for _, t := range r.addedTables {
 				added = append(added, t.num)
@@ -307,10 +326,23 @@ func (s *session) setVersion(r *sessionRecord, v *version) {
 				deleted = append(deleted, t.num)
 			}
 			for _, t := range r.deletedTabless {
-				deleted = append(deleted, t.num)
+				deleted_s = append(deleted_s, t.num)
+			}
+			// Blob files are dropped implicitly, once all their records
+			// are garbage.
+			var deleted_b []int64
+			for num := range v.blobs {
+				if _, ok := s.stVersion.blobs[num]; !ok {
+					added = append(added, num)
+				}
+			}
+			for num := range s.stVersion.blobs {
+				if _, ok := v.blobs[num]; !ok {
+					deleted_b = append(deleted_b, num)
+				}
 			}
 			select {
-			case s.deltaCh <- &vDelta{vid: s.stVersion.id, added: added, deleted: deleted}://增加的文件号和删除的文件号
+			case s.deltaCh <- &vDelta{vid: s.stVersion.id, added: added, deleted: deleted, deleted_s: deleted_s, deleted_b: deleted_b}://增加的文件号和删除的文件号
 			case <-v.s.closeC:
 				s.log("reference loop already exist")
 			}
@@ -410,11 +442,24 @@ func (s *session) fillRecord(r *sessionRecord, snapshot bool) {
 			r.setSeqNum(s.stSeqNum)
 		}
 
+		if !r.has(recMemSeqNum) {
+			r.setMemSeqNum(s.stMemSeqNum)
+		}
+
+		if !r.has(recMemSeqNum2) {
+			r.setMemSeqNum_s(s.stMemSeqNum2)
+		}
+
 		for level, ik := range s.stCompPtrs { //compaction point
 			if ik != nil {
 				r.addCompPtr(level, ik)
 			}
 		}
+		for level, ik := range s.stCompPtrs2 {
+			if ik != nil {
+				r.addCompPtr_s(level, ik)
+			}
+		}
 
 		r.setComparer(s.icmp.uName())
 	}
@@ -451,10 +496,20 @@ func (s *session) recordCommited(rec *sessionRecord) {
 		s.stPrevJournalNum = rec.prevJournalNum
 	}
 
-	if rec.has(recSeqNum) {
+	// Both trees flush under the shared sequence, so never let one tree
+	// move it back below what the other already committed.
+	if rec.has(recSeqNum) && rec.seqNum > s.stSeqNum {
 		s.stSeqNum = rec.seqNum
 	}
 
+	if rec.has(recMemSeqNum) {
+		s.stMemSeqNum = rec.memSeqNum
+	}
+
+	if rec.has(recMemSeqNum2) {
+		s.stMemSeqNum2 = rec.memSeqNum2
+	}
+
 	for _, r := range rec.compPtrs {
 		s.setCompPtr(r.level, internalKey(r.ikey))
 	}
@@ -74,6 +74,7 @@ const logSizeThreshold = 1024 * 1024 // 1 MiB
 type fileStorage struct {
 	path     string
 	readOnly bool
+	logDir   string // directory of the LOG file, empty if not logging
 
 	mu      sync.Mutex
 	//mu2     sync.Mutex
@@ -136,6 +137,53 @@ func OpenFile(path string, readOnly bool) (Storage, error) {
 		logw:     logw,
 		logSize:  logSize,
 	}
+	if !readOnly {
+		fs.logDir = path
+	}
+	runtime.SetFinalizer(fs, (*fileStorage).Close)
+	return fs, nil
+}
+
+// OpenFileSecondary returns a new read-only filesystem-backed storage
+// implementation for the DB at path, which may be in use by a writer. The
+// storage doesn't lock the DB; instead it locks the directory at
+// secondaryPath, created if it doesn't exist, and keeps the LOG file there.
+//
+// The storage must be closed after use, by calling Close method.
+func OpenFileSecondary(path, secondaryPath string) (Storage, error) {
+	if fi, err := os.Stat(path); err != nil {
+		return nil, err
+	} else if !fi.IsDir() {
+		return nil, fmt.Errorf("leveldb/storage: open %s: not a directory", path)
+	}
+	if err := os.MkdirAll(secondaryPath, 0755); err != nil {
+		return nil, err
+	}
+
+	flock, err := newFileLock(filepath.Join(secondaryPath, "LOCK"), false)
+	if err != nil {
+		return nil, err
+	}
+	logw, err := os.OpenFile(filepath.Join(secondaryPath, "LOG"), os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		flock.release()
+		return nil, err
+	}
+	logSize, err := logw.Seek(0, os.SEEK_END)
+	if err != nil {
+		logw.Close()
+		flock.release()
+		return nil, err
+	}
+
+	fs := &fileStorage{
+		path:     path,
+		readOnly: true,
+		logDir:   secondaryPath,
+		flock:    flock,
+		logw:     logw,
+		logSize:  logSize,
+	}
 	runtime.SetFinalizer(fs, (*fileStorage).Close)
 	return fs, nil
 }
@@ -187,11 +235,11 @@ func (fs *fileStorage) doLog(t time.Time, str string) {
 		fs.logw.Close()
 		fs.logw = nil
 		fs.logSize = 0
-		rename(filepath.Join(fs.path, "LOG"), filepath.Join(fs.path, "LOG.old"))
+		rename(filepath.Join(fs.logDir, "LOG"), filepath.Join(fs.logDir, "LOG.old"))
 	}
 	if fs.logw == nil {
 		var err error
-		fs.logw, err = os.OpenFile(filepath.Join(fs.path, "LOG"), os.O_WRONLY|os.O_CREATE, 0644)
+		fs.logw, err = os.OpenFile(filepath.Join(fs.logDir, "LOG"), os.O_WRONLY|os.O_CREATE, 0644)
 		if err != nil {
 			return
 		}
@@ -218,7 +266,7 @@ func (fs *fileStorage) doLog(t time.Time, str string) {
 }
 
 func (fs *fileStorage) Log(str string) {
-	if !fs.readOnly {
+	if fs.logDir != "" {
 		t := time.Now()
 		fs.mu.Lock()
 		defer fs.mu.Unlock()
@@ -230,7 +278,7 @@ func (fs *fileStorage) Log(str string) {
 }
 
 func (fs *fileStorage) log(str string) {
-	if !fs.readOnly {
+	if fs.logDir != "" {
 		fs.doLog(time.Now(), str)
 	}
 }
@@ -573,6 +621,19 @@ func (fs *fileStorage) Rename(oldfd, newfd FileDesc) error {
 	return rename(filepath.Join(fs.path, fsGenName(oldfd)), filepath.Join(fs.path, fsGenName(newfd)))
 }
 
+func (fs *fileStorage) Link(fd FileDesc, dir string) error {
+	if !FileDescOk(fd) {
+		return ErrInvalidFile
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.open < 0 {
+		return ErrClosed
+	}
+	return os.Link(filepath.Join(fs.path, fsGenName(fd)), filepath.Join(dir, fsGenName(fd)))
+}
+
 func (fs *fileStorage) Close() error {
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
@@ -641,6 +702,8 @@ func fsGenName(fd FileDesc) string {
 		return fmt.Sprintf("%06d.ldb", fd.Num)
 	case TypeTemp:
 		return fmt.Sprintf("%06d.tmp", fd.Num)
+	case TypeBlob:
+		return fmt.Sprintf("%06d.blob", fd.Num)
 	default:
 		panic("invalid file type")
 	}
@@ -671,6 +734,8 @@ func fsParseName(name string) (fd FileDesc, ok bool) {
 			fd.Type = TypeTable
 		case "tmp":
 			fd.Type = TypeTemp
+		case "blob":
+			fd.Type = TypeBlob
 		default:
 			return
 		}
@@ -29,6 +29,7 @@ var cases = []struct {
 	{nil, "MANIFEST-000007", TypeManifest, 7},
 	{nil, "9223372036854775807.log", TypeJournal, 9223372036854775807},
 	{nil, "000100.tmp", TypeTemp, 100},
+	{nil, "000100.blob", TypeBlob, 100},
 }
 
 var invalidCases = []string{
@@ -398,3 +399,87 @@ func TestFileStorage_ReadOnlyLocking(t *testing.T) {
 	p3.Close()
 	p4.Close()
 }
+
+func TestFileStorage_Link(t *testing.T) {
+	temp := tempDir(t)
+	defer os.RemoveAll(temp)
+	dir := filepath.Join(temp, "link")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal("Mkdir: got error: ", err)
+	}
+
+	fs, err := OpenFile(temp, false)
+	if err != nil {
+		t.Fatal("OpenFile: got error: ", err)
+	}
+	defer fs.Close()
+
+	fd := FileDesc{TypeTable, 1}
+	w, err := fs.Create(fd)
+	if err != nil {
+		t.Fatal("Create: got error: ", err)
+	}
+	w.Write([]byte("table"))
+	w.Close()
+
+	if err := fs.(Linker).Link(fd, dir); err != nil {
+		t.Fatal("Link: got error: ", err)
+	}
+	if err := fs.Remove(fd); err != nil {
+		t.Fatal("Remove: got error: ", err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, "000001.ldb"))
+	if err != nil || string(b) != "table" {
+		t.Fatalf("linked file: got %q, err=%v", b, err)
+	}
+}
+
+func TestFileStorage_Secondary(t *testing.T) {
+	temp := tempDir(t)
+	defer os.RemoveAll(temp)
+	secondary := filepath.Join(temp, "secondary")
+
+	p1, err := OpenFile(temp, false)
+	if err != nil {
+		t.Fatal("OpenFile: got error: ", err)
+	}
+	defer p1.Close()
+	fd := FileDesc{TypeTable, 1}
+	w, err := p1.Create(fd)
+	if err != nil {
+		t.Fatal("Create: got error: ", err)
+	}
+	w.Write([]byte("table"))
+	w.Close()
+
+	p2, err := OpenFileSecondary(temp, secondary)
+	if err != nil {
+		t.Fatal("OpenFileSecondary(1): got error: ", err)
+	}
+	if _, err := OpenFileSecondary(temp, secondary); err != nil {
+		t.Logf("OpenFileSecondary(2): got error: %s (expected)", err)
+	} else {
+		t.Fatal("OpenFileSecondary(2): expect error")
+	}
+
+	r, err := p2.Open(fd)
+	if err != nil {
+		t.Fatal("Open: got error: ", err)
+	}
+	b, err := ioutil.ReadAll(r)
+	r.Close()
+	if err != nil || string(b) != "table" {
+		t.Fatalf("Open: got %q, err=%v", b, err)
+	}
+	if _, err := p2.Create(FileDesc{TypeTable, 2}); err == nil {
+		t.Fatal("Create: expect error")
+	}
+	if err := p2.Remove(fd); err == nil {
+		t.Fatal("Remove: expect error")
+	}
+	p2.Log("secondary")
+	p2.Close()
+	if b, err := ioutil.ReadFile(filepath.Join(secondary, "LOG")); err != nil || !strings.Contains(string(b), "secondary") {
+		t.Fatalf("LOG: got %q, err=%v", b, err)
+	}
+}
@@ -23,8 +23,9 @@ const (
 	TypeJournals
 	TypeTable
 	TypeTemp
+	TypeBlob
 
-	TypeAll = TypeManifest | TypeJournal | TypeJournals |TypeTable | TypeTemp
+	TypeAll = TypeManifest | TypeJournal | TypeJournals |TypeTable | TypeTemp | TypeBlob
 )
 
 func (t FileType) String() string {
@@ -39,6 +40,8 @@ func (t FileType) String() string {
 		return "table"
 	case TypeTemp:
 		return "temp"
+	case TypeBlob:
+		return "blob"
 	}
 	return fmt.Sprintf("<unknown:%d>", t)
 }
@@ -117,6 +120,8 @@ func (fd FileDesc) String() string {
 		return fmt.Sprintf("%06d.ldb", fd.Num)
 	case TypeTemp:
 		return fmt.Sprintf("%06d.tmp", fd.Num)
+	case TypeBlob:
+		return fmt.Sprintf("%06d.blob", fd.Num)
 	default:
 		return fmt.Sprintf("%#x-%d", fd.Type, fd.Num)
 	}
@@ -135,12 +140,22 @@ func FileDescOk(fd FileDesc) bool {
 	case TypeJournals:
 	case TypeTable:
 	case TypeTemp:
+	case TypeBlob:
 	default:
 		return false
 	}
 	return fd.Num >= 0
 }
 
+// Linker is the interface that wraps basic Link method.
+//
+// Link creates a hard link of the file with the given 'file descriptor' in
+// the directory at dir, under the same name. It is implemented by the
+// file-system backed storage.
+type Linker interface {
+	Link(fd FileDesc, dir string) error
+}
+
 // Storage is the storage. A storage instance must be safe for concurrent use.
 type Storage interface {
 	// Lock locks the storage. Any subsequent attempt to call Lock will fail
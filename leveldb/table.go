@@ -9,12 +9,15 @@ package leveldb
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"sort"
 	"sync/atomic"
+	"time"
 
 	"github.com/rev3z/ledger_base/leveldb/cache"
 	"github.com/rev3z/ledger_base/leveldb/iterator"
 	"github.com/rev3z/ledger_base/leveldb/opt"
+	"github.com/rev3z/ledger_base/leveldb/ratelimit"
 	"github.com/rev3z/ledger_base/leveldb/storage"
 	"github.com/rev3z/ledger_base/leveldb/table"
 	"github.com/rev3z/ledger_base/leveldb/util"
@@ -26,12 +29,14 @@ type tFile struct {
 	seekLeft   int32
 	size       int64 //sst大小
 	imin, imax internalKey //最小key和最大key
+	seq        uint64 // 外部导入的table的全局seq，其余为0
 }
 type sFile struct {
 	fd         storage.FileDesc // FileDesc is a 'file descriptor'.
 	seekLeft   int32
 	size       int64 //sst大小
 	imin, imax internalKey //最小key和最大key
+	seq        uint64 // 外部导入的table的全局seq，其余为0
 }
 func (t *sFile) after(icmp *iComparer, ukey []byte) bool {
 	return ukey != nil && icmp.uCompare(ukey, t.imax.ukey()) > 0
@@ -107,10 +112,14 @@ func newTableFile_s(fd storage.FileDesc, size int64, imin, imax internalKey) *sF
 }
 
 func tableFileFromRecord(r atRecord) *tFile {
-	return newTableFile(storage.FileDesc{Type: storage.TypeTable, Num: r.num}, r.size, r.imin, r.imax)
+	f := newTableFile(storage.FileDesc{Type: storage.TypeTable, Num: r.num}, r.size, r.imin, r.imax)
+	f.seq = r.seq
+	return f
 }
 func tableFileFromRecord_s(r atRecord) *sFile {
-	return newTableFile_s(storage.FileDesc{Type: storage.TypeTable, Num: r.num}, r.size, r.imin, r.imax)
+	f := newTableFile_s(storage.FileDesc{Type: storage.TypeTable, Num: r.num}, r.size, r.imin, r.imax)
+	f.seq = r.seq
+	return f
 }
 
 // tFiles hold multiple tFile.
@@ -301,8 +310,23 @@ func (tf sFiles) getRange(icmp *iComparer) (imin, imax internalKey) {
 	return
 }
 
+// Returns the tables overlapping the given prefix range which may hold keys
+// of the prefix, in the same order.
+func (tf sFiles) prefixMatches(tops *tOps, icmp *iComparer, slice *util.Range, prefix []byte, ro *opt.ReadOptions) (dst sFiles) {
+	umin, umax := internalKey(slice.Start).ukey(), []byte(nil)
+	if slice.Limit != nil {
+		umax = internalKey(slice.Limit).ukey()
+	}
+	for _, t := range tf {
+		if t.overlaps(icmp, umin, umax) && tops.prefixMayMatch_s(t, slice, prefix, ro) {
+			dst = append(dst, t)
+		}
+	}
+	return
+}
+
 // Creates iterator index from tables.
-func (tf sFiles) newIndexIterator(tops *tOps, icmp *iComparer, slice *util.Range, ro *opt.ReadOptions) iterator.IteratorIndexer {
+func (tf sFiles) newIndexIterator(tops *tOps, icmp *iComparer, slice *util.Range, ro *opt.ReadOptions, limiter *rateLimitedIterator) iterator.IteratorIndexer {
 	if slice != nil {
 		var start, limit int
 		if slice.Start != nil {
@@ -316,11 +340,12 @@ func (tf sFiles) newIndexIterator(tops *tOps, icmp *iComparer, slice *util.Range
 		tf = tf[start:limit]
 	}
 	return iterator.NewArrayIndexer(&sFilesArrayIndexer{
-		sFiles: tf,
-		tops:   tops,
-		icmp:   icmp,
-		slice:  slice,
-		ro:     ro,
+		sFiles:  tf,
+		tops:    tops,
+		icmp:    icmp,
+		slice:   slice,
+		ro:      ro,
+		limiter: limiter,
 	})
 }
 
@@ -534,7 +559,7 @@ func (tf tFiles) getRange(icmp *iComparer) (imin, imax internalKey) {
 }
 
 // Creates iterator index from tables.
-func (tf tFiles) newIndexIterator(tops *tOps, icmp *iComparer, slice *util.Range, ro *opt.ReadOptions) iterator.IteratorIndexer {
+func (tf tFiles) newIndexIterator(tops *tOps, icmp *iComparer, slice *util.Range, ro *opt.ReadOptions, limiter *rateLimitedIterator) iterator.IteratorIndexer {
 	if slice != nil {
 		var start, limit int
 		if slice.Start != nil {
@@ -548,28 +573,46 @@ func (tf tFiles) newIndexIterator(tops *tOps, icmp *iComparer, slice *util.Range
 		tf = tf[start:limit]
 	}
 	return iterator.NewArrayIndexer(&tFilesArrayIndexer{
-		tFiles: tf,
-		tops:   tops,
-		icmp:   icmp,
-		slice:  slice,
-		ro:     ro,
+		tFiles:  tf,
+		tops:    tops,
+		icmp:    icmp,
+		slice:   slice,
+		ro:      ro,
+		limiter: limiter,
 	})
 }
 
+// Returns the tables overlapping the given prefix range which may hold keys
+// of the prefix, in the same order.
+func (tf tFiles) prefixMatches(tops *tOps, icmp *iComparer, slice *util.Range, prefix []byte, ro *opt.ReadOptions) (dst tFiles) {
+	umin, umax := internalKey(slice.Start).ukey(), []byte(nil)
+	if slice.Limit != nil {
+		umax = internalKey(slice.Limit).ukey()
+	}
+	for _, t := range tf {
+		if t.overlaps(icmp, umin, umax) && tops.prefixMayMatch(t, slice, prefix, ro) {
+			dst = append(dst, t)
+		}
+	}
+	return
+}
+
 // Tables iterator index.
 type tFilesArrayIndexer struct {
 	tFiles
-	tops  *tOps
-	icmp  *iComparer
-	slice *util.Range
-	ro    *opt.ReadOptions
+	tops    *tOps
+	icmp    *iComparer
+	slice   *util.Range
+	ro      *opt.ReadOptions
+	limiter *rateLimitedIterator
 }
 type sFilesArrayIndexer struct {
 	sFiles
-	tops  *tOps
-	icmp  *iComparer
-	slice *util.Range
-	ro    *opt.ReadOptions
+	tops    *tOps
+	icmp    *iComparer
+	slice   *util.Range
+	ro      *opt.ReadOptions
+	limiter *rateLimitedIterator
 }
 
 func (a sFilesArrayIndexer) Search(key []byte) int {
@@ -578,9 +621,9 @@ func (a sFilesArrayIndexer) Search(key []byte) int {
 
 func (a sFilesArrayIndexer) Get(i int) iterator.Iterator {
 	if i == 0 || i == a.Len()-1 {
-		return a.tops.newIterator_s(a.sFiles[i], a.slice, a.ro)
+		return a.tops.newLimitedIterator_s(a.sFiles[i], a.slice, a.ro, a.limiter)
 	}
-	return a.tops.newIterator_s(a.sFiles[i], nil, a.ro)
+	return a.tops.newLimitedIterator_s(a.sFiles[i], nil, a.ro, a.limiter)
 }
 
 func (a *tFilesArrayIndexer) Search(key []byte) int {
@@ -589,9 +632,9 @@ func (a *tFilesArrayIndexer) Search(key []byte) int {
 
 func (a *tFilesArrayIndexer) Get(i int) iterator.Iterator {
 	if i == 0 || i == a.Len()-1 {
-		return a.tops.newIterator(a.tFiles[i], a.slice, a.ro)
+		return a.tops.newLimitedIterator(a.tFiles[i], a.slice, a.ro, a.limiter)
 	}
-	return a.tops.newIterator(a.tFiles[i], nil, a.ro)
+	return a.tops.newLimitedIterator(a.tFiles[i], nil, a.ro, a.limiter)
 }
 
 // Helper type for sortByKey.
@@ -630,17 +673,132 @@ func (x *tFilesSortByNum) Less(i, j int) bool {
 
 // Table operations.
 type tOps struct {
+	// Requests throttled by the rate limiter, and time spent waiting.
+	throttleCnt  int64
+	throttleTime int64
+
 	s            *session //会话
 	noSync       bool
 	evictRemoved bool
 	cache        *cache.Cache
 	bcache       *cache.Cache
+	cache_s      *cache.Cache // 第二棵树的open files cache
+	bcache_s     *cache.Cache // 第二棵树的block cache，与bcache互不挤占
 	bpool        *util.BufferPool
+	limiter      ratelimit.Limiter
+}
+
+// Waits for the rate limiter to grant n bytes of I/O.
+func (t *tOps) requestIO(n int, pri ratelimit.Priority) {
+	if t.limiter == nil || n <= 0 {
+		return
+	}
+	if d := t.limiter.Request(n, pri); d > 0 {
+		atomic.AddInt64(&t.throttleCnt, 1)
+		atomic.AddInt64(&t.throttleTime, int64(d))
+	}
+}
+
+// Returns the number of throttled requests and the time spent waiting.
+func (t *tOps) throttleStats() (int64, time.Duration) {
+	return atomic.LoadInt64(&t.throttleCnt), time.Duration(atomic.LoadInt64(&t.throttleTime))
+}
+
+// Returns w throttled by the rate limiter, if any.
+func (t *tOps) limitWriter(w storage.Writer, pri ratelimit.Priority) io.Writer {
+	if t.limiter == nil {
+		return w
+	}
+	return &rateLimitedWriter{Writer: w, t: t, pri: pri}
+}
+
+// Returns an iterator throttling at low priority the data blocks read by the
+// table iterators created by newLimitedIterator, nil if there is no rate
+// limiter.
+// Its Iterator must be set to the iterator over them.
+func (t *tOps) newRateLimitedIterator() *rateLimitedIterator {
+	if t.limiter == nil {
+		return nil
+	}
+	return &rateLimitedIterator{t: t}
+}
+
+type rateLimitedWriter struct {
+	storage.Writer
+	t   *tOps
+	pri ratelimit.Priority
+}
+
+func (w *rateLimitedWriter) Write(p []byte) (int, error) {
+	w.t.requestIO(len(p), w.pri)
+	return w.Writer.Write(p)
+}
+
+// Read bytes are requested in chunks, rather than per block.
+const rateLimitChunk = 64 * opt.KiB
+
+type rateLimitedIterator struct {
+	iterator.Iterator
+	t *tOps
+	n int
+}
+
+func (i *rateLimitedIterator) read(n int) {
+	i.n += n
+}
+
+// Returns an iterator over the table cached by ch, which data block reads are
+// counted by i.
+func (i *rateLimitedIterator) newTableIterator(ch *cache.Handle, seq uint64, slice *util.Range, ro *opt.ReadOptions) iterator.Iterator {
+	iter := ch.Value().(*table.Reader).NewIteratorWithRead(slice, ro, i.read)
+	iter.SetReleaser(ch)
+	if seq != 0 {
+		return &seqIterator{Iterator: iter, seq: seq}
+	}
+	return iter
+}
+
+func (i *rateLimitedIterator) account(ok bool) bool {
+	if i.n >= rateLimitChunk {
+		i.t.requestIO(i.n, ratelimit.Low)
+		i.n = 0
+	}
+	return ok
+}
+
+func (i *rateLimitedIterator) First() bool {
+	return i.account(i.Iterator.First())
+}
+
+func (i *rateLimitedIterator) Last() bool {
+	return i.account(i.Iterator.Last())
 }
 
-// Creates an empty table and returns table writer.
+func (i *rateLimitedIterator) Seek(key []byte) bool {
+	return i.account(i.Iterator.Seek(key))
+}
+
+func (i *rateLimitedIterator) Next() bool {
+	return i.account(i.Iterator.Next())
+}
+
+func (i *rateLimitedIterator) Prev() bool {
+	return i.account(i.Iterator.Prev())
+}
+
+// Requests the bytes read since the last chunk.
+func (i *rateLimitedIterator) Release() {
+	if i.n > 0 {
+		i.t.requestIO(i.n, ratelimit.Low)
+		i.n = 0
+	}
+	i.Iterator.Release()
+}
+
+// Creates an empty table and returns table writer. Writes are throttled by
+// the rate limiter at the given priority.
 //莫非这里是新建一个real & empty 的sstable并返回twriter
-func (t *tOps) create() (*tWriter, error) {
+func (t *tOps) create(pri ratelimit.Priority) (*tWriter, error) {
 	fd := storage.FileDesc{Type: storage.TypeTable, Num: t.s.allocFileNum()} //得到文件类型和文件名
 	fw, err := t.s.stor.Create(fd) //storage.writer
 	if err != nil {
@@ -650,10 +808,12 @@ func (t *tOps) create() (*tWriter, error) {
 		t:  t, //tOps
 		fd: fd, //文件描述符
 		w:  fw, //storage.writer
-		tw: table.NewWriter(fw, t.s.o.Options), //*table.writer
+		tw: table.NewWriter(t.limitWriter(fw, pri), t.s.o.Options), //*table.writer
 	}, nil
 }
-func (t *tOps) create_s() (*tWriter, error) {
+// Values of at least Options.BlobThreshold bytes are written to a blob file,
+// see blobWriter.
+func (t *tOps) create_s(pri ratelimit.Priority) (*tWriter, error) {
 	fd := storage.FileDesc{Type: storage.TypeTable, Num: t.s.allocFileNum()} //得到文件类型和文件名
 	fw, err := t.s.stor.Create_s(fd) //storage.writer
 	if err != nil {
@@ -663,12 +823,15 @@ func (t *tOps) create_s() (*tWriter, error) {
 		t:  t, //tOps
 		fd: fd, //文件描述符
 		w:  fw, //storage.writer
-		tw: table.NewWriter(fw, t.s.o.Options), //*table.writer
+		tw: table.NewWriter(t.limitWriter(fw, pri), t.s.o.Options), //*table.writer
+
+		pri:           pri,
+		blobThreshold: t.s.o.GetBlobThreshold(),
 	}, nil
 }
 // Builds table from src iterator.createfrom函数的主要功能是创建新的文件，将frozenmemdb中的数据取出，然后刷新到磁盘。
 func (t *tOps) createFrom(src iterator.Iterator) (f *tFile, n int, err error) {
-	w, err := t.create() //w is type of *tWriter,封装了table writer
+	w, err := t.create(ratelimit.High) //w is type of *tWriter,封装了table writer
 	if err != nil {
 		return
 	}
@@ -694,8 +857,9 @@ func (t *tOps) createFrom(src iterator.Iterator) (f *tFile, n int, err error) {
 	f, err = w.finish()  //// Finalizes the table and returns table file.
 	return
 }
-func (t *tOps) createFrom_s(src iterator.Iterator) (f *sFile, n int, err error) {
-	w, err := t.create_s() //w is type of *tWriter,封装了table writer
+// The blob file holding the separated values is returned as well, nil if none.
+func (t *tOps) createFrom_s(src iterator.Iterator) (f *sFile, b *bFile, n int, err error) {
+	w, err := t.create_s(ratelimit.High) //w is type of *tWriter,封装了table writer
 	if err != nil {
 		return
 	}
@@ -718,6 +882,7 @@ func (t *tOps) createFrom_s(src iterator.Iterator) (f *sFile, n int, err error)
 
 	n = w.tw.EntriesLen() //// EntriesLen returns number of entries added so far.
 	f, err = w.finish_s()  //// Finalizes the table and returns table file.
+	b = w.blob
 	return
 }
 // Opens table. It returns a cache handle, which should
@@ -750,7 +915,7 @@ func (t *tOps) open(f *tFile) (ch *cache.Handle, err error) {
 	return
 }
 func (t *tOps) open_s(f *sFile) (ch *cache.Handle, err error) {
-	ch = t.cache.Get(0, uint64(f.fd.Num), func() (size int, value cache.Value) {
+	ch = t.cache_s.Get(0, uint64(f.fd.Num), func() (size int, value cache.Value) {
 		var r storage.Reader
 		r, err = t.s.stor.Open(f.fd)
 		if err != nil {
@@ -758,8 +923,8 @@ func (t *tOps) open_s(f *sFile) (ch *cache.Handle, err error) {
 		}
 
 		var bcache *cache.NamespaceGetter
-		if t.bcache != nil {
-			bcache = &cache.NamespaceGetter{Cache: t.bcache, NS: uint64(f.fd.Num)}
+		if t.bcache_s != nil {
+			bcache = &cache.NamespaceGetter{Cache: t.bcache_s, NS: uint64(f.fd.Num)}
 		}
 
 		var tr *table.Reader
@@ -784,7 +949,11 @@ func (t *tOps) find(f *tFile, key []byte, ro *opt.ReadOptions) (rkey, rvalue []b
 		return nil, nil, err
 	}
 	defer ch.Release()
-	return ch.Value().(*table.Reader).Find(key, true, ro)
+	rkey, rvalue, err = ch.Value().(*table.Reader).Find(key, true, ro)
+	if err == nil && f.seq != 0 {
+		rkey = withSeq(nil, rkey, f.seq)
+	}
+	return
 }
 func (t *tOps) find_s(f *sFile, key []byte, ro *opt.ReadOptions) (rkey, rvalue []byte, err error) {
 	ch, err := t.open_s(f)
@@ -792,7 +961,11 @@ func (t *tOps) find_s(f *sFile, key []byte, ro *opt.ReadOptions) (rkey, rvalue [
 		return nil, nil, err
 	}
 	defer ch.Release()
-	return ch.Value().(*table.Reader).Find(key, true, ro)
+	rkey, rvalue, err = ch.Value().(*table.Reader).Find(key, true, ro)
+	if err == nil && f.seq != 0 {
+		rkey = withSeq(nil, rkey, f.seq)
+	}
+	return
 }
 // Finds key that is greater than or equal to the given key.
 func (t *tOps) findKey(f *tFile, key []byte, ro *opt.ReadOptions) (rkey []byte, err error) {
@@ -801,7 +974,11 @@ func (t *tOps) findKey(f *tFile, key []byte, ro *opt.ReadOptions) (rkey []byte,
 		return nil, err
 	}
 	defer ch.Release()
-	return ch.Value().(*table.Reader).FindKey(key, true, ro)
+	rkey, err = ch.Value().(*table.Reader).FindKey(key, true, ro)
+	if err == nil && f.seq != 0 {
+		rkey = withSeq(nil, rkey, f.seq)
+	}
+	return
 }
 func (t *tOps) findKey_s(f *sFile, key []byte, ro *opt.ReadOptions) (rkey []byte, err error) {
 	ch, err := t.open_s(f)
@@ -809,7 +986,54 @@ func (t *tOps) findKey_s(f *sFile, key []byte, ro *opt.ReadOptions) (rkey []byte
 		return nil, err
 	}
 	defer ch.Release()
-	return ch.Value().(*table.Reader).FindKey(key, true, ro)
+	rkey, err = ch.Value().(*table.Reader).FindKey(key, true, ro)
+	if err == nil && f.seq != 0 {
+		rkey = withSeq(nil, rkey, f.seq)
+	}
+	return
+}
+// Finds, for each of the given sorted keys, the key/value pair whose key is
+// greater than or equal to it, see table.Reader.FindMulti.
+func (t *tOps) findMulti(f *tFile, keys [][]byte, ro *opt.ReadOptions) (rkeys, rvalues [][]byte, errs []error) {
+	ch, err := t.open(f)
+	if err != nil {
+		return nil, nil, fillErrors(len(keys), err)
+	}
+	defer ch.Release()
+	rkeys, rvalues, errs = ch.Value().(*table.Reader).FindMulti(keys, true, ro)
+	if f.seq != 0 {
+		for i, rkey := range rkeys {
+			if errs[i] == nil {
+				rkeys[i] = withSeq(nil, rkey, f.seq)
+			}
+		}
+	}
+	return
+}
+func (t *tOps) findMulti_s(f *sFile, keys [][]byte, ro *opt.ReadOptions) (rkeys, rvalues [][]byte, errs []error) {
+	ch, err := t.open_s(f)
+	if err != nil {
+		return nil, nil, fillErrors(len(keys), err)
+	}
+	defer ch.Release()
+	rkeys, rvalues, errs = ch.Value().(*table.Reader).FindMulti(keys, true, ro)
+	if f.seq != 0 {
+		for i, rkey := range rkeys {
+			if errs[i] == nil {
+				rkeys[i] = withSeq(nil, rkey, f.seq)
+			}
+		}
+	}
+	return
+}
+
+// Returns n copies of err.
+func fillErrors(n int, err error) []error {
+	errs := make([]error, n)
+	for i := range errs {
+		errs[i] = err
+	}
+	return errs
 }
 // Returns approximate offset of the given key.
 func (t *tOps) offsetOf(f *tFile, key []byte) (offset int64, err error) {
@@ -828,6 +1052,26 @@ func (t *tOps) offsetOf_s(f *sFile, key []byte) (offset int64, err error) {
 	defer ch.Release()
 	return ch.Value().(*table.Reader).OffsetOf(key)
 }
+// Returns false if the table holds no key of the given prefix range, see
+// table.Reader.PrefixMayMatch. Errors are left to the table iterator.
+func (t *tOps) prefixMayMatch(f *tFile, slice *util.Range, prefix []byte, ro *opt.ReadOptions) bool {
+	ch, err := t.open(f)
+	if err != nil {
+		return true
+	}
+	defer ch.Release()
+	ok, err := ch.Value().(*table.Reader).PrefixMayMatch(slice, prefix, ro)
+	return ok || err != nil
+}
+func (t *tOps) prefixMayMatch_s(f *sFile, slice *util.Range, prefix []byte, ro *opt.ReadOptions) bool {
+	ch, err := t.open_s(f)
+	if err != nil {
+		return true
+	}
+	defer ch.Release()
+	ok, err := ch.Value().(*table.Reader).PrefixMayMatch(slice, prefix, ro)
+	return ok || err != nil
+}
 // Creates an iterator from the given table.
 func (t *tOps) newIterator(f *tFile, slice *util.Range, ro *opt.ReadOptions) iterator.Iterator {
 	ch, err := t.open(f)
@@ -836,6 +1080,9 @@ func (t *tOps) newIterator(f *tFile, slice *util.Range, ro *opt.ReadOptions) ite
 	}
 	iter := ch.Value().(*table.Reader).NewIterator(slice, ro)
 	iter.SetReleaser(ch)
+	if f.seq != 0 {
+		return &seqIterator{Iterator: iter, seq: f.seq}
+	}
 	return iter
 }
 func (t *tOps) newIterator_s(f *sFile, slice *util.Range, ro *opt.ReadOptions) iterator.Iterator {
@@ -845,6 +1092,52 @@ func (t *tOps) newIterator_s(f *sFile, slice *util.Range, ro *opt.ReadOptions) i
 	}
 	iter := ch.Value().(*table.Reader).NewIterator(slice, ro)
 	iter.SetReleaser(ch)
+	if f.seq != 0 {
+		return &seqIterator{Iterator: iter, seq: f.seq}
+	}
+	return iter
+}
+
+// Like newIterator, but the data blocks read are charged to limiter, if not
+// nil.
+func (t *tOps) newLimitedIterator(f *tFile, slice *util.Range, ro *opt.ReadOptions, limiter *rateLimitedIterator) iterator.Iterator {
+	if limiter == nil {
+		return t.newIterator(f, slice, ro)
+	}
+	ch, err := t.open(f)
+	if err != nil {
+		return iterator.NewEmptyIterator(err)
+	}
+	return limiter.newTableIterator(ch, f.seq, slice, ro)
+}
+func (t *tOps) newLimitedIterator_s(f *sFile, slice *util.Range, ro *opt.ReadOptions, limiter *rateLimitedIterator) iterator.Iterator {
+	if limiter == nil {
+		return t.newIterator_s(f, slice, ro)
+	}
+	ch, err := t.open_s(f)
+	if err != nil {
+		return iterator.NewEmptyIterator(err)
+	}
+	return limiter.newTableIterator(ch, f.seq, slice, ro)
+}
+
+// Creates an iterator over range deletions of the given table.
+func (t *tOps) newRangeDelIterator(f *tFile) iterator.Iterator {
+	ch, err := t.open(f)
+	if err != nil {
+		return iterator.NewEmptyIterator(err)
+	}
+	iter := ch.Value().(*table.Reader).NewRangeDelIterator()
+	iter.SetReleaser(ch)
+	return iter
+}
+func (t *tOps) newRangeDelIterator_s(f *sFile) iterator.Iterator {
+	ch, err := t.open_s(f)
+	if err != nil {
+		return iterator.NewEmptyIterator(err)
+	}
+	iter := ch.Value().(*table.Reader).NewRangeDelIterator()
+	iter.SetReleaser(ch)
 	return iter
 }
 
@@ -865,33 +1158,62 @@ func (t *tOps) remove(fd storage.FileDesc) {
 	})
 }
 
+// Removes table of the secondary tree, see remove.
+func (t *tOps) remove_s(fd storage.FileDesc) {
+	t.cache_s.Delete(0, uint64(fd.Num), func() {
+		if err := t.s.stor.Remove(fd); err != nil {
+			t.s.logf("table@remove removing @%d %q", fd.Num, err)
+		} else {
+			t.s.logf("table@remove removed @%d", fd.Num)
+		}
+		if t.evictRemoved && t.bcache_s != nil {
+			t.bcache_s.EvictNS(uint64(fd.Num))
+		}
+		t.s.reuseFileNum(fd.Num)
+	})
+}
+
 // Closes the table ops instance. It will close all tables,
 // regadless still used or not.
 func (t *tOps) close() {
 	t.bpool.Close()
 	t.cache.Close()
+	t.cache_s.Close()
 	if t.bcache != nil {
 		t.bcache.CloseWeak()
 	}
+	if t.bcache_s != nil {
+		t.bcache_s.CloseWeak()
+	}
 }
 
 // Creates new initialized table ops instance.
 func newTableOps(s *session) *tOps {
 	var (
-		cacher cache.Cacher // 接口，Table/block？
-		bcache *cache.Cache // Cache
-		bpool  *util.BufferPool
+		cacher, cacher_s cache.Cacher // 接口，Table/block？
+		bcache, bcache_s *cache.Cache // Cache
+		bpool            *util.BufferPool
 	)
-	if s.o.GetOpenFilesCacheCapacity() > 0 {
-		cacher = cache.NewLRU(s.o.GetOpenFilesCacheCapacity()) // 500，lru的长度
+	if c := s.o.GetOpenFilesCacher(); c != nil {
+		if s.o.GetOpenFilesCacheCapacity() > 0 {
+			cacher = c.New(s.o.GetOpenFilesCacheCapacity()) // 500，lru的长度
+		}
+		if s.o.GetSecondaryOpenFilesCacheCapacity() > 0 {
+			cacher_s = c.New(s.o.GetSecondaryOpenFilesCacheCapacity())
+		}
 	}
 	if !s.o.GetDisableBlockCache() {
-		var bcacher cache.Cacher
-		if s.o.GetBlockCacheCapacity() > 0 {
-			bcacher = s.o.GetBlockCacher().New(s.o.GetBlockCacheCapacity()) // 8M，block Cache
+		var bcacher, bcacher_s cache.Cacher
+		if c := s.o.GetBlockCacher(); c != nil {
+			if s.o.GetBlockCacheCapacity() > 0 {
+				bcacher = c.New(s.o.GetBlockCacheCapacity()) // 8M，block Cache
+			}
+			if s.o.GetSecondaryBlockCacheCapacity() > 0 {
+				bcacher_s = c.New(s.o.GetSecondaryBlockCacheCapacity())
+			}
 		}
 		bcache = cache.NewCache(bcacher) // new Cache
-		//bcache.SetCapacity(100)
+		bcache_s = cache.NewCache(bcacher_s)
 	}
 	if !s.o.GetDisableBufferPool() {
 		bpool = util.NewBufferPool(s.o.GetBlockSize() + 5)
@@ -902,12 +1224,15 @@ func newTableOps(s *session) *tOps {
 		evictRemoved: s.o.GetBlockCacheEvictRemoved(),
 		cache:        cache.NewCache(cacher),
 		bcache:       bcache,
+		cache_s:      cache.NewCache(cacher_s),
+		bcache_s:     bcache_s,
 		bpool:        bpool,
+		limiter:      s.o.GetRateLimiter(),
 	}
 }
 func (s *session) SetC(){
 	s.tops.cache.SetCapacity(5)
-	s.tops.cache.SetCapacity(5)
+	s.tops.cache_s.SetCapacity(5)
 }
 //tWriter wraps the table writer. It keep track of file descriptor
 //and added key range.//封装了table writer，并跟踪文件描述符并添加key的范围
@@ -919,15 +1244,53 @@ type tWriter struct {
 	tw *table.Writer //内嵌的table writer
 
 	first, last []byte //sst中的最小和最大key
+	rangeDelMax internalKey // covers the limit of the largest range deletion
+
+	// Value separation of the secondary tree, see blobWriter. The blob file
+	// is set by finish_s.
+	pri           ratelimit.Priority
+	blobThreshold int
+	bw            *blobWriter
+	blob          *bFile
+	bScratch      []byte
+	kScratch      []byte
 }
 
 
 // Append key/value pair to the table.内存或者sst文件的迭代器
 //赋值最小key和最大key，然后调用Append
 func (w *tWriter) append(key, value []byte) error {
+	if w.blobThreshold > 0 && len(value) >= w.blobThreshold {
+		if ukey, seq, kt, err := parseInternalKey(key); err == nil && kt == keyTypeVal {
+			if w.bw == nil {
+				if w.bw, err = w.t.createBlob(w.pri); err != nil {
+					return err
+				}
+			}
+			bi, err := w.bw.append(ukey, value)
+			if err != nil {
+				return err
+			}
+			w.kScratch = makeInternalKey(w.kScratch, ukey, seq, keyTypeBlobIndex)
+			w.bScratch = bi.encode(w.bScratch)
+			key, value = w.kScratch, w.bScratch
+		}
+	}
 	if w.first == nil {
 		w.first = append([]byte{}, key...)
 	}
+	if _, _, kt, err := parseInternalKey(key); err == nil && kt == keyTypeRangeDel {
+		// Range deletions go to their own block; the table key range is
+		// extended to cover them so that reads and compactions of the
+		// deleted keys see the table.
+		if w.last == nil {
+			w.last = append([]byte{}, key...)
+		}
+		if w.rangeDelMax == nil || w.t.s.icmp.uCompare(value, w.rangeDelMax.ukey()) > 0 {
+			w.rangeDelMax = makeInternalKey(w.rangeDelMax, value, keyMaxSeq, keyTypeSeek)
+		}
+		return w.tw.AppendRangeDel(key, value)
+	}
 	w.last = append(w.last[:0], key...)
 	return w.tw.Append(key, value)
 	//不断利用迭代器读取需要写入的数据，并不断调用Append函数，直至所有的有效数据读取完毕，为sst附上元数据
@@ -940,6 +1303,28 @@ func (w *tWriter) empty() bool {
 	return w.first == nil
 }
 
+// Returns the bytes written to the blob file of the table so far.
+func (w *tWriter) blobSize() int64 {
+	if w.bw == nil {
+		return 0
+	}
+	return w.bw.offset
+}
+
+// Returns true if the given user key is past every range deletion added so
+// far, i.e. the table may end before ukey without splitting one.
+func (w *tWriter) afterRangeDels(ukey []byte) bool {
+	return w.rangeDelMax == nil || w.t.s.icmp.uCompare(ukey, w.rangeDelMax.ukey()) > 0
+}
+
+// Returns the largest key of the table, including range deletion limits.
+func (w *tWriter) max() internalKey {
+	if w.rangeDelMax != nil && w.t.s.icmp.Compare(w.rangeDelMax, w.last) > 0 {
+		return w.rangeDelMax
+	}
+	return internalKey(w.last)
+}
+
 // Closes the storage.Writer.
 func (w *tWriter) close() {
 	if w.w != nil {
@@ -962,11 +1347,16 @@ func (w *tWriter) finish() (f *tFile, err error) {
 		}
 	}
 	//返回table的basic information
-	f = newTableFile(w.fd, int64(w.tw.BytesLen()), internalKey(w.first), internalKey(w.last))
+	f = newTableFile(w.fd, int64(w.tw.BytesLen()), internalKey(w.first), w.max())
 	return
 }
 func (w *tWriter) finish_s() (f *sFile, err error) {
 	defer w.close()
+	if w.bw != nil {
+		if w.blob, err = w.bw.finish(); err != nil {
+			return
+		}
+	}
 	err = w.tw.Close()
 	if err != nil {
 		return
@@ -978,15 +1368,21 @@ func (w *tWriter) finish_s() (f *sFile, err error) {
 		}
 	}
 	//返回table的basic information
-	f = newTableFile_s(w.fd, int64(w.tw.BytesLen()), internalKey(w.first), internalKey(w.last))
+	f = newTableFile_s(w.fd, int64(w.tw.BytesLen()), internalKey(w.first), w.max())
 	return
 }
 // Drops the table.
 func (w *tWriter) drop() {
 	w.close()
+	if w.bw != nil {
+		w.bw.drop()
+		w.bw = nil
+		w.blob = nil
+	}
 	w.t.s.stor.Remove(w.fd)
 	w.t.s.reuseFileNum(w.fd.Num)
 	w.tw = nil
 	w.first = nil
 	w.last = nil
+	w.rangeDelMax = nil
 }
@@ -476,18 +476,46 @@ func (b *filterBlock) contains(filter filter.Filter, offset uint64, key []byte)
 	return true
 }
 
+func (b *filterBlock) containsPrefix(filter filter.PrefixFilter, offset uint64, prefix []byte) bool {
+	i := int(offset >> b.baseLg)
+	if i < b.filtersNum {
+		o := b.data[b.oOffset+i*4:]
+		n := int(binary.LittleEndian.Uint32(o))
+		m := int(binary.LittleEndian.Uint32(o[4:]))
+		if n < m && m <= b.oOffset {
+			return filter.ContainsPrefix(b.data[n:m], prefix)
+		} else if n == m {
+			return false
+		}
+	}
+	return true
+}
+
 func (b *filterBlock) Release() {
 	b.bpool.Put(b.data)
 	b.bpool = nil
 	b.data = nil
 }
 
+// Filter partition of a partitioned table, a single filter data.
+type filterPartition struct {
+	bpool *util.BufferPool
+	data  []byte
+}
+
+func (b *filterPartition) Release() {
+	b.bpool.Put(b.data)
+	b.bpool = nil
+	b.data = nil
+}
+
 type indexIter struct {
-	*blockIter
+	iterator.Iterator
 	tr    *Reader
 	slice *util.Range
 	// Options
 	fillCache bool
+	read      func(n int)
 }
 
 func (i *indexIter) Get() iterator.Iterator {
@@ -499,12 +527,59 @@ func (i *indexIter) Get() iterator.Iterator {
 	if n == 0 {
 		return iterator.NewEmptyIterator(i.tr.newErrCorruptedBH(i.tr.indexBH, "bad data block handle"))
 	}
+	if i.read != nil {
+		i.read(int(dataBH.length + blockTrailerLen))
+	}
+
+	var slice *util.Range
+	if i.slice != nil {
+		// Through index partitions the first and last blocks aren't
+		// known, every block is sliced then.
+		if bi, ok := i.Iterator.(*blockIter); !ok || bi.isFirst() || bi.isLast() {
+			slice = i.slice
+		}
+	}
+	return i.tr.getDataIterErr(dataBH, slice, i.tr.verifyChecksum, i.fillCache)
+}
+
+// Top-level index iterator of a partitioned table.
+type partitionIter struct {
+	*blockIter
+	tr    *Reader
+	slice *util.Range
+	// Whether the reader must be locked, i.e. the iterator outlives the
+	// call which created it.
+	lock bool
+	// Options
+	fillCache bool
+}
+
+func (i *partitionIter) Get() iterator.Iterator {
+	value := i.Value()
+	if value == nil {
+		return nil
+	}
+	partBH, n := decodeBlockHandle(value)
+	if n == 0 {
+		return iterator.NewEmptyIterator(i.tr.newErrCorruptedBH(i.tr.indexBH, "bad index partition handle"))
+	}
 
 	var slice *util.Range
 	if i.slice != nil && (i.blockIter.isFirst() || i.blockIter.isLast()) {
 		slice = i.slice
 	}
-	return i.tr.getDataIterErr(dataBH, slice, i.tr.verifyChecksum, i.fillCache)
+	if i.lock {
+		i.tr.mu.RLock()
+		defer i.tr.mu.RUnlock()
+		if i.tr.err != nil {
+			return iterator.NewEmptyIterator(i.tr.err)
+		}
+	}
+	b, rel, err := i.tr.readBlockCached(partBH, true, i.fillCache)
+	if err != nil {
+		return iterator.NewEmptyIterator(err)
+	}
+	return i.tr.newBlockIter(b, rel, slice, true)
 }
 
 // Reader is a table reader.
@@ -523,8 +598,16 @@ type Reader struct {
 
 	dataEnd                   int64
 	metaBH, indexBH, filterBH blockHandle //Handle
+	rangeDelBH                blockHandle
 	indexBlock                *block
 	filterBlock               *filterBlock
+	rangeDelBlock             *block // always read, range deletions are consulted on every lookup
+
+	// Partitioned format, the index block is then the top-level index and
+	// filterBH the index of the filter partitions.
+	partitioned       bool
+	filterPartitioned bool
+	filterIndexBlock  *block
 }
 
 func (r *Reader) blockKind(bh blockHandle) string {
@@ -537,6 +620,10 @@ func (r *Reader) blockKind(bh blockHandle) string {
 		if r.filterBH.length > 0 {
 			return "filter-block"
 		}
+	case r.rangeDelBH.offset:
+		if r.rangeDelBH.length > 0 {
+			return "rangedel-block"
+		}
 	}
 	return "data-block"
 }
@@ -721,6 +808,128 @@ func (r *Reader) getFilterBlock(fillCache bool) (*filterBlock, util.Releaser, er
 	return r.filterBlock, util.NoopReleaser{}, nil
 }
 
+func (r *Reader) getFilterIndexBlock(fillCache bool) (*block, util.Releaser, error) {
+	if r.filterIndexBlock == nil {
+		return r.readBlockCached(r.filterBH, true, fillCache)
+	}
+	return r.filterIndexBlock, util.NoopReleaser{}, nil
+}
+
+func (r *Reader) readFilterPartitionCached(bh blockHandle, fillCache bool) (*filterPartition, util.Releaser, error) {
+	if r.cache != nil {
+		var (
+			err error
+			ch  *cache.Handle
+		)
+		if fillCache {
+			ch = r.cache.Get(bh.offset, func() (size int, value cache.Value) {
+				var data []byte
+				data, err = r.readRawBlock(bh, true)
+				if err != nil {
+					return 0, nil
+				}
+				return cap(data), &filterPartition{bpool: r.bpool, data: data}
+			})
+		} else {
+			ch = r.cache.Get(bh.offset, nil)
+		}
+		if ch != nil {
+			b, ok := ch.Value().(*filterPartition)
+			if !ok {
+				ch.Release()
+				return nil, nil, errors.New("leveldb/table: inconsistent block type")
+			}
+			return b, ch, err
+		} else if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	data, err := r.readRawBlock(bh, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	b := &filterPartition{bpool: r.bpool, data: data}
+	return b, b, nil
+}
+
+// Returns an iterator over the index entries of the data blocks, through the
+// index partitions if the table is partitioned. The caller must hold the
+// read lock; if lock is true the iterator may be used after it is released.
+func (r *Reader) newIndexIter(slice *util.Range, fillCache, strict, lock bool) iterator.Iterator {
+	indexBlock, rel, err := r.getIndexBlock(fillCache)
+	if err != nil {
+		return iterator.NewEmptyIterator(err)
+	}
+	index := r.newBlockIter(indexBlock, rel, slice, true)
+	if !r.partitioned {
+		return index
+	}
+	return iterator.NewIndexedIterator(&partitionIter{
+		blockIter: index,
+		tr:        r,
+		slice:     slice,
+		lock:      lock,
+		fillCache: fillCache,
+	}, strict)
+}
+
+// Returns false if the filter rules out key from the data block at the given
+// offset, which is the block the key would be in. A corrupted filter doesn't
+// rule out any key.
+func (r *Reader) mayContain(key []byte, offset uint64, fillCache bool) (bool, error) {
+	if !r.filterPartitioned {
+		filterBlock, rel, err := r.getFilterBlock(fillCache)
+		if err != nil {
+			if errors.IsCorrupted(err) {
+				return true, nil
+			}
+			return false, err
+		}
+		defer rel.Release()
+		return filterBlock.contains(r.filter, offset, key), nil
+	}
+
+	indexBlock, rel, err := r.getFilterIndexBlock(fillCache)
+	if err != nil {
+		if errors.IsCorrupted(err) {
+			return true, nil
+		}
+		return false, err
+	}
+	defer rel.Release()
+	index := r.newBlockIter(indexBlock, nil, nil, true)
+	defer index.Release()
+	if !index.Seek(key) {
+		if err := index.Error(); err != nil {
+			return true, nil
+		}
+		// After the last key of the table.
+		return false, nil
+	}
+	return r.partitionContains(index.Value(), fillCache, func(f filter.Filter, data []byte) bool {
+		return f.Contains(data, key)
+	})
+}
+
+// Reads the filter partition which handle is given and checks it with
+// contains; a corrupted partition doesn't rule out any key.
+func (r *Reader) partitionContains(handle []byte, fillCache bool, contains func(f filter.Filter, data []byte) bool) (bool, error) {
+	bh, n := decodeBlockHandle(handle)
+	if n == 0 {
+		return true, nil
+	}
+	b, rel, err := r.readFilterPartitionCached(bh, fillCache)
+	if err != nil {
+		if errors.IsCorrupted(err) {
+			return true, nil
+		}
+		return false, err
+	}
+	defer rel.Release()
+	return contains(r.filter, b.data), nil
+}
+
 func (r *Reader) newBlockIter(b *block, bReleaser util.Releaser, slice *util.Range, inclLimit bool) *blockIter {
 	bi := &blockIter{
 		tr:            r,
@@ -780,6 +989,25 @@ func (r *Reader) getDataIterErr(dataBH blockHandle, slice *util.Range, verifyChe
 	return r.getDataIter(dataBH, slice, verifyChecksum, fillCache)
 }
 
+// NewRangeDelIterator creates an iterator over the range deletion block of
+// the table, see Writer.AppendRangeDel. The iterator is empty if the table
+// has no range deletions.
+//
+// The returned iterator is not safe for concurrent use and should be released
+// after use.
+func (r *Reader) NewRangeDelIterator() iterator.Iterator {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.err != nil {
+		return iterator.NewEmptyIterator(r.err)
+	}
+	if r.rangeDelBlock == nil {
+		return iterator.NewEmptyIterator(nil)
+	}
+	return r.newBlockIter(r.rangeDelBlock, util.NoopReleaser{}, nil, true)
+}
+
 // NewIterator creates an iterator from the table.
 //
 // Slice allows slicing the iterator to only contains keys in the given
@@ -796,6 +1024,13 @@ func (r *Reader) getDataIterErr(dataBH blockHandle, slice *util.Range, verifyChe
 //
 // Also read Iterator documentation of the leveldb/iterator package.
 func (r *Reader) NewIterator(slice *util.Range, ro *opt.ReadOptions) iterator.Iterator {
+	return r.NewIteratorWithRead(slice, ro, nil)
+}
+
+// NewIteratorWithRead is like NewIterator, read if not nil is called with the
+// stored size of each data block the iterator loads, e.g. to rate limit
+// compaction reads.
+func (r *Reader) NewIteratorWithRead(slice *util.Range, ro *opt.ReadOptions, read func(n int)) iterator.Iterator {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
@@ -804,17 +1039,15 @@ func (r *Reader) NewIterator(slice *util.Range, ro *opt.ReadOptions) iterator.It
 	}
 
 	fillCache := !ro.GetDontFillCache()
-	indexBlock, rel, err := r.getIndexBlock(fillCache)
-	if err != nil {
-		return iterator.NewEmptyIterator(err)
-	}
+	strict := opt.GetStrict(r.o, ro, opt.StrictReader)
 	index := &indexIter{
-		blockIter: r.newBlockIter(indexBlock, rel, slice, true),
+		Iterator:  r.newIndexIter(slice, fillCache, strict, true),
 		tr:        r,
 		slice:     slice,
-		fillCache: !ro.GetDontFillCache(),
+		fillCache: fillCache,
+		read:      read,
 	}
-	return iterator.NewIndexedIterator(index, opt.GetStrict(r.o, ro, opt.StrictReader))
+	return iterator.NewIndexedIterator(index, strict)
 }
 
 func (r *Reader) find(key []byte, filtered bool, ro *opt.ReadOptions, noValue bool) (rkey, value []byte, err error) {
@@ -826,13 +1059,7 @@ func (r *Reader) find(key []byte, filtered bool, ro *opt.ReadOptions, noValue bo
 		return
 	}
 
-	indexBlock, rel, err := r.getIndexBlock(true)
-	if err != nil {
-		return
-	}
-	defer rel.Release()
-
-	index := r.newBlockIter(indexBlock, nil, nil, true)
+	index := r.newIndexIter(nil, true, true, false)
 	defer index.Release()
 
 	if !index.Seek(key) {
@@ -850,16 +1077,13 @@ func (r *Reader) find(key []byte, filtered bool, ro *opt.ReadOptions, noValue bo
 
 	// The filter should only used for exact match.
 	if filtered && r.filter != nil {
-		filterBlock, frel, ferr := r.getFilterBlock(true)
-		if ferr == nil {
-			if !filterBlock.contains(r.filter, dataBH.offset, key) {
-				frel.Release()
-				return nil, nil, ErrNotFound
-			}
-			frel.Release()
-		} else if !errors.IsCorrupted(ferr) {
+		ok, ferr := r.mayContain(key, dataBH.offset, true)
+		if ferr != nil {
 			return nil, nil, ferr
 		}
+		if !ok {
+			return nil, nil, ErrNotFound
+		}
 	}
 
 	data := r.getDataIter(dataBH, nil, r.verifyChecksum, !ro.GetDontFillCache())
@@ -936,6 +1160,237 @@ func (r *Reader) FindKey(key []byte, filtered bool, ro *opt.ReadOptions) (rkey [
 	return
 }
 
+// FindMulti is the batched Find: it finds, for each of the given keys, the
+// key/value pair whose key is greater than or equal to it. The keys must be
+// sorted in ascending order; keys that land in the same data block share a
+// single read of it, and the filter block is read once for all of them.
+// The results are in the order of the keys, and the error of a key is
+// ErrNotFound if the table doesn't contain such pair.
+//
+// The caller may modify the contents of the returned slices as they are
+// their own copy.
+// It is safe to modify the contents of the arguments after FindMulti
+// returns.
+func (r *Reader) FindMulti(keys [][]byte, filtered bool, ro *opt.ReadOptions) (rkeys, values [][]byte, errs []error) {
+	rkeys = make([][]byte, len(keys))
+	values = make([][]byte, len(keys))
+	errs = make([]error, len(keys))
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.err != nil {
+		for i := range errs {
+			errs[i] = r.err
+		}
+		return
+	}
+
+	fillCache := !ro.GetDontFillCache()
+	filtered = filtered && r.filter != nil
+	var filterBlock *filterBlock
+	if filtered && !r.filterPartitioned {
+		b, frel, err := r.getFilterBlock(true)
+		switch {
+		case err == nil:
+			defer frel.Release()
+			filterBlock = b
+		case errors.IsCorrupted(err):
+			// Doesn't rule out any key.
+			filtered = false
+		default:
+			for i := range errs {
+				errs[i] = err
+			}
+			return
+		}
+	}
+
+	index := r.newIndexIter(nil, true, true, false)
+	defer index.Release()
+
+	var (
+		data    iterator.Iterator
+		dataBH  blockHandle
+		inBlock bool // whether index and data are positioned at the block
+	)
+	defer func() {
+		if data != nil {
+			data.Release()
+		}
+	}()
+	setData := func(bh blockHandle) {
+		if data != nil {
+			data.Release()
+		}
+		dataBH = bh
+		data = r.getDataIter(bh, nil, r.verifyChecksum, fillCache)
+	}
+
+	for i, key := range keys {
+		// The keys before the index key of the current block are in it.
+		if !inBlock || r.cmp.Compare(key, index.Key()) > 0 {
+			inBlock = false
+			if !index.Seek(key) {
+				if errs[i] = index.Error(); errs[i] == nil {
+					errs[i] = ErrNotFound
+				}
+				continue
+			}
+			bh, n := decodeBlockHandle(index.Value())
+			if n == 0 {
+				r.err = r.newErrCorruptedBH(r.indexBH, "bad data block handle")
+				for j := i; j < len(keys); j++ {
+					errs[j] = r.err
+				}
+				return
+			}
+			// The data block is only read once a key may be in it.
+			dataBH = bh
+			if data != nil {
+				data.Release()
+				data = nil
+			}
+			inBlock = true
+		}
+
+		// The filter should only used for exact match.
+		if filtered {
+			var (
+				ok   bool
+				ferr error
+			)
+			if filterBlock != nil {
+				ok = filterBlock.contains(r.filter, dataBH.offset, key)
+			} else {
+				ok, ferr = r.mayContain(key, dataBH.offset, fillCache)
+			}
+			if ferr != nil {
+				errs[i] = ferr
+				continue
+			}
+			if !ok {
+				errs[i] = ErrNotFound
+				continue
+			}
+		}
+
+		if data == nil {
+			setData(dataBH)
+		}
+		if !data.Seek(key) {
+			if errs[i] = data.Error(); errs[i] != nil {
+				continue
+			}
+
+			// The nearest greater-than key is the first key of the next block.
+			inBlock = false
+			if !index.Next() {
+				if errs[i] = index.Error(); errs[i] == nil {
+					errs[i] = ErrNotFound
+				}
+				continue
+			}
+			bh, n := decodeBlockHandle(index.Value())
+			if n == 0 {
+				r.err = r.newErrCorruptedBH(r.indexBH, "bad data block handle")
+				for j := i; j < len(keys); j++ {
+					errs[j] = r.err
+				}
+				return
+			}
+			setData(bh)
+			if !data.Next() {
+				if errs[i] = data.Error(); errs[i] == nil {
+					errs[i] = ErrNotFound
+				}
+				continue
+			}
+			inBlock = true
+		}
+
+		// Unlike Find, the key is kept after the block iterator moves on.
+		rkeys[i] = append([]byte{}, data.Key()...)
+		values[i] = append([]byte{}, data.Value()...)
+	}
+	return
+}
+
+// PrefixMayMatch returns false if, according to the filter, the table
+// holds no key within the given key range having the given prefix. The
+// prefix is as returned by the prefix extractor of the filter, see
+// filter.PrefixFilter; every key of the range is expected to have it.
+// It returns true if the table has no prefix filter.
+//
+// It is safe to modify the contents of the arguments after PrefixMayMatch
+// returns.
+func (r *Reader) PrefixMayMatch(slice *util.Range, prefix []byte, ro *opt.ReadOptions) (bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.err != nil {
+		return false, r.err
+	}
+	pf, ok := r.filter.(filter.PrefixFilter)
+	if !ok {
+		return true, nil
+	}
+
+	fillCache := !ro.GetDontFillCache()
+	if r.filterPartitioned {
+		return r.partitionsPrefixMayMatch(pf, slice, prefix, fillCache)
+	}
+	filterBlock, frel, err := r.getFilterBlock(fillCache)
+	if err != nil {
+		if errors.IsCorrupted(err) {
+			return true, nil
+		}
+		return false, err
+	}
+	defer frel.Release()
+
+	// The blocks the range spans, the filter data covers block ranges.
+	index := r.newIndexIter(slice, fillCache, true, false)
+	defer index.Release()
+	for index.Next() {
+		dataBH, n := decodeBlockHandle(index.Value())
+		if n == 0 {
+			r.err = r.newErrCorruptedBH(r.indexBH, "bad data block handle")
+			return false, r.err
+		}
+		if filterBlock.containsPrefix(pf, dataBH.offset, prefix) {
+			return true, nil
+		}
+	}
+	return false, index.Error()
+}
+
+// Checks the filter partitions the range spans.
+func (r *Reader) partitionsPrefixMayMatch(pf filter.PrefixFilter, slice *util.Range, prefix []byte, fillCache bool) (bool, error) {
+	indexBlock, rel, err := r.getFilterIndexBlock(fillCache)
+	if err != nil {
+		if errors.IsCorrupted(err) {
+			return true, nil
+		}
+		return false, err
+	}
+	defer rel.Release()
+	index := r.newBlockIter(indexBlock, nil, slice, true)
+	defer index.Release()
+	for index.Next() {
+		ok, err := r.partitionContains(index.Value(), fillCache, func(_ filter.Filter, data []byte) bool {
+			return pf.ContainsPrefix(data, prefix)
+		})
+		if ok || err != nil {
+			return ok, err
+		}
+	}
+	if index.Error() != nil {
+		return true, nil
+	}
+	return false, nil
+}
+
 // Get gets the value for the given key. It returns errors.ErrNotFound
 // if the table does not contain the key.
 //
@@ -971,13 +1426,7 @@ func (r *Reader) OffsetOf(key []byte) (offset int64, err error) {
 		return
 	}
 
-	indexBlock, rel, err := r.readBlockCached(r.indexBH, true, true)
-	if err != nil {
-		return
-	}
-	defer rel.Release()
-
-	index := r.newBlockIter(indexBlock, nil, nil, true)
+	index := r.newIndexIter(nil, true, true, false)
 	defer index.Release()
 	if index.Seek(key) {
 		dataBH, n := decodeBlockHandle(index.Value())
@@ -1012,6 +1461,14 @@ func (r *Reader) Release() {
 		r.filterBlock.Release()
 		r.filterBlock = nil
 	}
+	if r.filterIndexBlock != nil {
+		r.filterIndexBlock.Release()
+		r.filterIndexBlock = nil
+	}
+	if r.rangeDelBlock != nil {
+		r.rangeDelBlock.Release()
+		r.rangeDelBlock = nil
+	}
 	r.reader = nil
 	r.cache = nil
 	r.bpool = nil
@@ -1084,10 +1541,33 @@ func NewReader(f io.ReaderAt, size int64, fd storage.FileDesc, cache *cache.Name
 	metaIter := r.newBlockIter(metaBlock, nil, nil, true)
 	for metaIter.Next() {
 		key := string(metaIter.Key())
-		if !strings.HasPrefix(key, "filter.") {
+		if key == rangeDelBlockName {
+			rangeDelBH, n := decodeBlockHandle(metaIter.Value())
+			if n == 0 {
+				continue
+			}
+			r.rangeDelBH = rangeDelBH
+			if int64(rangeDelBH.offset) < r.dataEnd {
+				r.dataEnd = int64(rangeDelBH.offset)
+			}
+			continue
+		}
+		if key == partIndexBlockName {
+			r.partitioned = true
+			continue
+		}
+		if r.filter != nil {
+			continue
+		}
+		var fn string
+		switch {
+		case strings.HasPrefix(key, filterBlockPrefix):
+			fn = key[len(filterBlockPrefix):]
+		case strings.HasPrefix(key, partFilterBlockPrefix):
+			fn = key[len(partFilterBlockPrefix):]
+		default:
 			continue
 		}
-		fn := key[7:]
 		if f0 := o.GetFilter(); f0 != nil && f0.Name() == fn {
 			r.filter = f0
 		} else {
@@ -1104,14 +1584,28 @@ func NewReader(f io.ReaderAt, size int64, fd storage.FileDesc, cache *cache.Name
 				continue
 			}
 			r.filterBH = filterBH
+			r.filterPartitioned = strings.HasPrefix(key, partFilterBlockPrefix)
 			// Update data end.
-			r.dataEnd = int64(filterBH.offset)
-			break
+			if int64(filterBH.offset) < r.dataEnd {
+				r.dataEnd = int64(filterBH.offset)
+			}
 		}
 	}
 	metaIter.Release()
 	metaBlock.Release()
 
+	// Range deletions are small and needed by every lookup, keep them.
+	if r.rangeDelBH.length > 0 {
+		r.rangeDelBlock, err = r.readBlock(r.rangeDelBH, true)
+		if err != nil {
+			if errors.IsCorrupted(err) {
+				r.err = err
+				return r, nil
+			}
+			return nil, err
+		}
+	}
+
 	// Cache index and filter block locally, since we don't have global cache.
 	if cache == nil {
 		r.indexBlock, err = r.readBlock(r.indexBH, true)
@@ -1123,7 +1617,11 @@ func NewReader(f io.ReaderAt, size int64, fd storage.FileDesc, cache *cache.Name
 			return nil, err
 		}
 		if r.filter != nil {
-			r.filterBlock, err = r.readFilterBlock(r.filterBH)
+			if r.filterPartitioned {
+				r.filterIndexBlock, err = r.readBlock(r.filterBH, true)
+			} else {
+				r.filterBlock, err = r.readFilterBlock(r.filterBH)
+			}
 			if err != nil {
 				if !errors.IsCorrupted(err) {
 					return nil, err
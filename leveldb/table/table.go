@@ -22,14 +22,16 @@ keep record of data blocks offset and length, index block use one as
 restart interval. The key used by index block are the last key of preceding
 block, shorter separator of adjacent blocks or shorter successor of the
 last key of the last block. Filter block is an optional block contains
-sequence of filter data generated by a filter generator.
+sequence of filter data generated by a filter generator. Range deletion
+block is an optional block with the same layout as a data block, it keeps
+the range deletions of the table under the "rangedel" metaindex key.
 
 Table data structure:
-                                                         + optional
-                                                        /
-    +--------------+--------------+--------------+------+-------+-----------------+-------------+--------+
-    | data block 1 |      ...     | data block n | filter block | metaindex block | index block | footer |
-    +--------------+--------------+--------------+--------------+-----------------+-------------+--------+
+                                                         + optional             + optional
+                                                        /                      /
+    +--------------+--------------+--------------+------+-------+--------------+---------+-----------------+-------------+--------+
+    | data block 1 |      ...     | data block n | filter block | range deletion block | metaindex block | index block | footer |
+    +--------------+--------------+--------------+--------------+----------------------+-----------------+-------------+--------+
 
     Each block followed by a 5-bytes trailer contains compression type and checksum.
 
@@ -55,6 +57,34 @@ Table footer:
 NOTE: All fixed-length integer are little-endian.
 */
 
+/*
+Partitioned table:
+
+A partitioned table, see opt.PartitionedTableFormat, splits its index into
+index partitions, each followed by the filter partition of the data blocks
+it indexes, written among the data blocks. Index partitions have the layout
+of an index block; a filter partition holds a single filter data. The index
+block of the footer is then a top-level index keeping the last key of each
+index partition and its block handle; the filter partitions index has the
+same layout and keys. The metaindex block keeps the filter partitions index
+under "partitionedfilter.<filter name>" and the top-level index block
+handle under "partitionedindex", which marks the table as partitioned.
+
+Partitioned table data structure:
+
+    +--------------+-----+--------------+-------------------+--------------------+-----+
+    | data block 1 | ... | data block k | index partition 1 | filter partition 1 | ... |
+    +--------------+-----+--------------+-------------------+--------------------+-----+
+
+             + optional                    + optional
+            /                             /
+    +-----+-----------------------------+----------------------+-----------------------+-----------------+--------+
+    | ... | filter partitions index     | range deletion block | top-level index block | metaindex block | footer |
+    +-----+-----------------------------+----------------------+-----------------------+-----------------+--------+
+
+    Filter partitions are only written if the table has a filter.
+*/
+
 /*
 Block:
 
@@ -155,6 +185,18 @@ const (
 	// Generate new filter every 2KB of data
 	filterBaseLg = 11
 	filterBase   = 1 << filterBaseLg
+
+	// Metaindex key of the range deletion block.
+	rangeDelBlockName = "rangedel"
+
+	// Metaindex key prefixes of the filter block, and of the index of
+	// the filter partitions; the filter name follows.
+	filterBlockPrefix     = "filter."
+	partFilterBlockPrefix = "partitionedfilter."
+
+	// Metaindex key marking the index block as the top-level index of a
+	// partitioned table.
+	partIndexBlockName = "partitionedindex"
 )
 
 type blockHandle struct {
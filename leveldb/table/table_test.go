@@ -12,6 +12,7 @@ import (
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
+	"github.com/rev3z/ledger_base/leveldb/filter"
 	"github.com/rev3z/ledger_base/leveldb/iterator"
 	"github.com/rev3z/ledger_base/leveldb/opt"
 	"github.com/rev3z/ledger_base/leveldb/storage"
@@ -117,7 +118,99 @@ var _ = testutil.Defer(func() {
 					Expect(err).To(BeNil())
 					Expect(indexBlock.restartsLen).Should(Equal(9))
 				})
+
+				It("should report the data blocks read by iterators", func() {
+					var blocks, size int
+					iter := r.NewIteratorWithRead(nil, nil, func(n int) {
+						blocks++
+						size += n
+					})
+					for iter.Next() {
+					}
+					Expect(iter.Error()).To(BeNil())
+					iter.Release()
+					Expect(blocks).Should(Equal(9))
+					Expect(size).Should(BeNumerically(">", 9*blockTrailerLen))
+					Expect(size).Should(BeNumerically("<=", r.indexBH.offset))
+				})
 			}))
 		})
+
+		Describe("partitioned read test", func() {
+			o := &opt.Options{
+				BlockSize:            64,
+				BlockRestartInterval: 3,
+				Filter:               filter.NewBloomFilter(10),
+				TableFormat:          opt.PartitionedTableFormat,
+			}
+			Build := func(kv testutil.KeyValue) testutil.DB {
+				buf := &bytes.Buffer{}
+
+				// Building the table.
+				tw := NewWriter(buf, o)
+				kv.Iterate(func(i int, key, value []byte) {
+					tw.Append(key, value)
+				})
+				tw.Close()
+
+				// Opening the table.
+				tr, _ := NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()), storage.FileDesc{}, nil, nil, o)
+				return tableWrapper{tr}
+			}
+
+			testutil.AllKeyValueTesting(nil, Build, nil, nil)
+			Describe("with many partitions", func() {
+				kv := testutil.KeyValue_Generate(nil, 300, 1, 1, 10, 10, 40)
+				It("should read through the index and filter partitions", func() {
+					r := Build(*kv).(tableWrapper).Reader
+					Expect(r.partitioned).Should(BeTrue())
+					Expect(r.filterPartitioned).Should(BeTrue())
+					indexBlock, err := r.readBlock(r.indexBH, true)
+					Expect(err).To(BeNil())
+					Expect(indexBlock.restartsLen).Should(BeNumerically(">", 10))
+
+					var offset int64
+					kv.Iterate(func(i int, key, value []byte) {
+						rkey, rvalue, err := r.Find(key, true, nil)
+						Expect(err).ShouldNot(HaveOccurred())
+						Expect(rkey).Should(Equal(key))
+						Expect(rvalue).Should(Equal(value))
+						o, err := r.OffsetOf(key)
+						Expect(err).ShouldNot(HaveOccurred())
+						Expect(o).Should(BeNumerically(">=", offset))
+						offset = o
+					})
+
+					// Absent keys are ruled out by the filter partitions.
+					filtered := 0
+					kv.Iterate(func(i int, key, value []byte) {
+						absent := append(append([]byte{}, key...), 0)
+						if kv.Search(absent) < kv.Len() && bytes.Equal(kv.KeyAt(kv.Search(absent)), absent) {
+							return
+						}
+						if _, _, err := r.Find(absent, true, nil); err == ErrNotFound {
+							filtered++
+						}
+					})
+					Expect(filtered).Should(BeNumerically(">", kv.Len()*9/10))
+				})
+
+				It("should find keys in batch as one at a time", func() {
+					r := Build(*kv).(tableWrapper).Reader
+					var keys [][]byte
+					kv.Iterate(func(i int, key, value []byte) {
+						keys = append(keys, key, append(append([]byte{}, key...), 0))
+					})
+					keys = append(keys, []byte{0xff})
+					rkeys, rvalues, errs := r.FindMulti(keys, true, nil)
+					for i, key := range keys {
+						rkey, rvalue, err := r.Find(key, true, nil)
+						Expect(errs[i] == err).Should(BeTrue(), "key %q: %v != %v", key, errs[i], err)
+						Expect(string(rkeys[i])).Should(Equal(string(rkey)))
+						Expect(string(rvalues[i])).Should(Equal(string(rvalue)))
+					}
+				})
+			})
+		})
 	})
 })
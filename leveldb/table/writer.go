@@ -135,6 +135,22 @@ func (w *filterWriter) generate() {
 	}
 }
 
+// Filter of a partitioned table, one filter per index partition.
+type partFilterWriter struct {
+	generator  filter.FilterGenerator
+	buf        util.Buffer
+	nKeys      int
+	indexBlock blockWriter // index of the filter partitions
+}
+
+func (w *partFilterWriter) add(key []byte) {
+	if w.generator == nil {
+		return
+	}
+	w.generator.Add(key)
+	w.nKeys++
+}
+
 // Writer is a table writer.
 type Writer struct { //这貌似就是一个table的结构
 	writer io.Writer
@@ -145,12 +161,19 @@ type Writer struct { //这貌似就是一个table的结构
 	compression opt.Compression
 	blockSize   int
 
-	dataBlock   blockWriter
-	indexBlock  blockWriter
-	filterBlock filterWriter
-	pendingBH   blockHandle
-	offset      uint64
-	nEntries    int
+	dataBlock     blockWriter
+	indexBlock    blockWriter // the current index partition if partitioned
+	filterBlock   filterWriter
+	rangeDelBlock blockWriter
+	pendingBH     blockHandle
+	offset        uint64
+	nEntries      int
+
+	// Partitioned format, see opt.PartitionedTableFormat.
+	partitioned   bool
+	topIndexBlock blockWriter
+	partFilter    partFilterWriter
+	nPartBlocks   int // blocks indexed by the written index partitions
 	// Scratch allocated enough for 5 uvarint. Block writer should not use
 	// first 20-bytes since it will be used to encode block handle, which
 	// then passed to the block writer itself.
@@ -216,6 +239,39 @@ func (w *Writer) flushPendingBH(key []byte) {
 	w.pendingBH = blockHandle{}
 }
 
+// Writes the current index partition and the filter of its data blocks, and
+// indexes both under the last key of the partition.
+func (w *Writer) finishPartition() error {
+	w.indexBlock.finish()
+	bh, err := w.writeBlock(&w.indexBlock.buf, w.compression)
+	if err != nil {
+		return err
+	}
+	key := w.indexBlock.prevKey
+	n := encodeBlockHandle(w.scratch[:20], bh)
+	w.topIndexBlock.append(key, w.scratch[:n])
+
+	if g := w.partFilter.generator; g != nil {
+		// Filters expect the memory they alloc to be zeroed, which a
+		// reset buffer doesn't guarantee.
+		w.partFilter.buf = util.Buffer{}
+		if w.partFilter.nKeys > 0 {
+			g.Generate(&w.partFilter.buf)
+			w.partFilter.nKeys = 0
+		}
+		bh, err = w.writeBlock(&w.partFilter.buf, opt.NoCompression)
+		if err != nil {
+			return err
+		}
+		n = encodeBlockHandle(w.scratch[:20], bh)
+		w.partFilter.indexBlock.append(key, w.scratch[:n])
+	}
+
+	w.nPartBlocks += w.indexBlock.nEntries
+	w.indexBlock.reset()
+	return nil
+}
+
 func (w *Writer) finishBlock() error {
 	w.dataBlock.finish()
 	bh, err := w.writeBlock(&w.dataBlock.buf, w.compression)
@@ -244,10 +300,19 @@ func (w *Writer) Append(key, value []byte) error {
 	}
 
 	w.flushPendingBH(key)
+	// Cut the index partition once large enough, the keys added to the
+	// filter so far are exactly those of the blocks it indexes.
+	if w.partitioned && w.indexBlock.bytesLen() >= w.blockSize {
+		if err := w.finishPartition(); err != nil {
+			w.err = err
+			return w.err
+		}
+	}
 	// Append key/value pair to the data block.
 	w.dataBlock.append(key, value)
 	// Add key to the filter block.
 	w.filterBlock.add(key)
+	w.partFilter.add(key)
 
 	// Finish the data block if block size target reached.
 	if w.dataBlock.bytesLen() >= w.blockSize {
@@ -260,9 +325,33 @@ func (w *Writer) Append(key, value []byte) error {
 	return nil
 }
 
+// AppendRangeDel appends key/value pair to the range deletion block of the
+// table. The block is kept apart from the data blocks and isn't covered by
+// the filter, it is read back with Reader.NewRangeDelIterator. The keys
+// passed must be in increasing order.
+//
+// It is safe to modify the contents of the arguments after AppendRangeDel
+// returns.
+func (w *Writer) AppendRangeDel(key, value []byte) error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.rangeDelBlock.nEntries > 0 && w.cmp.Compare(w.rangeDelBlock.prevKey, key) >= 0 {
+		w.err = fmt.Errorf("leveldb/table: Writer: range deletion keys are not in increasing order: %q, %q", w.rangeDelBlock.prevKey, key)
+		return w.err
+	}
+	w.rangeDelBlock.append(key, value)
+	return nil
+}
+
+// RangeDelsLen returns number of range deletions added so far.
+func (w *Writer) RangeDelsLen() int {
+	return w.rangeDelBlock.nEntries
+}
+
 // BlocksLen returns number of blocks written so far.
 func (w *Writer) BlocksLen() int {
-	n := w.indexBlock.nEntries
+	n := w.nPartBlocks + w.indexBlock.nEntries
 	if w.pendingBH.length > 0 {
 		// Includes the pending block.
 		n++
@@ -289,14 +378,21 @@ func (w *Writer) Close() error {
 	}
 
 	// Write the last data block. Or empty data block if there
-	// aren't any data blocks at all.
-	if w.dataBlock.nEntries > 0 || w.nEntries == 0 {
+	// aren't any data blocks at all, unless the table holds range
+	// deletions only.
+	if w.dataBlock.nEntries > 0 || (w.nEntries == 0 && w.rangeDelBlock.nEntries == 0) {
 		if err := w.finishBlock(); err != nil {
 			w.err = err
 			return w.err
 		}
 	}
 	w.flushPendingBH(nil)
+	if w.partitioned && w.indexBlock.nEntries > 0 {
+		if err := w.finishPartition(); err != nil {
+			w.err = err
+			return w.err
+		}
+	}
 
 	// Write the filter block.
 	var filterBH blockHandle
@@ -308,12 +404,56 @@ func (w *Writer) Close() error {
 		}
 	}
 
-	// Write the metaindex block.
+	// Write the index of the filter partitions.
+	var partFilterBH blockHandle
+	if w.partFilter.generator != nil && w.partFilter.indexBlock.nEntries > 0 {
+		w.partFilter.indexBlock.finish()
+		partFilterBH, w.err = w.writeBlock(&w.partFilter.indexBlock.buf, w.compression)
+		if w.err != nil {
+			return w.err
+		}
+	}
+
+	// Write the range deletion block.
+	var rangeDelBH blockHandle
+	if w.rangeDelBlock.nEntries > 0 {
+		w.rangeDelBlock.finish()
+		rangeDelBH, w.err = w.writeBlock(&w.rangeDelBlock.buf, w.compression)
+		if w.err != nil {
+			return w.err
+		}
+	}
+
+	// Write the top-level index block ahead of the metaindex, which
+	// marks it.
+	var indexBH blockHandle
+	if w.partitioned {
+		w.topIndexBlock.finish()
+		indexBH, w.err = w.writeBlock(&w.topIndexBlock.buf, w.compression)
+		if w.err != nil {
+			return w.err
+		}
+	}
+
+	// Write the metaindex block, keys are sorted.
 	if filterBH.length > 0 {
-		key := []byte("filter." + w.filter.Name())
+		key := []byte(filterBlockPrefix + w.filter.Name())
 		n := encodeBlockHandle(w.scratch[:20], filterBH)
 		w.dataBlock.append(key, w.scratch[:n])
 	}
+	if partFilterBH.length > 0 {
+		key := []byte(partFilterBlockPrefix + w.filter.Name())
+		n := encodeBlockHandle(w.scratch[:20], partFilterBH)
+		w.dataBlock.append(key, w.scratch[:n])
+	}
+	if w.partitioned {
+		n := encodeBlockHandle(w.scratch[:20], indexBH)
+		w.dataBlock.append([]byte(partIndexBlockName), w.scratch[:n])
+	}
+	if rangeDelBH.length > 0 {
+		n := encodeBlockHandle(w.scratch[:20], rangeDelBH)
+		w.dataBlock.append([]byte(rangeDelBlockName), w.scratch[:n])
+	}
 	w.dataBlock.finish()
 	metaindexBH, err := w.writeBlock(&w.dataBlock.buf, w.compression)
 	if err != nil {
@@ -322,11 +462,13 @@ func (w *Writer) Close() error {
 	}
 
 	// Write the index block.
-	w.indexBlock.finish()
-	indexBH, err := w.writeBlock(&w.indexBlock.buf, w.compression)
-	if err != nil {
-		w.err = err
-		return w.err
+	if !w.partitioned {
+		w.indexBlock.finish()
+		indexBH, err = w.writeBlock(&w.indexBlock.buf, w.compression)
+		if err != nil {
+			w.err = err
+			return w.err
+		}
 	}
 
 	// Write the table footer.
@@ -357,6 +499,7 @@ func NewWriter(f io.Writer, o *opt.Options) *Writer {
 		filter:          o.GetFilter(),
 		compression:     o.GetCompression(),
 		blockSize:       o.GetBlockSize(),
+		partitioned:     o.GetTableFormat() == opt.PartitionedTableFormat,
 		comparerScratch: make([]byte, 0),
 	}
 	// data block
@@ -366,10 +509,22 @@ func NewWriter(f io.Writer, o *opt.Options) *Writer {
 	// index block
 	w.indexBlock.restartInterval = 1
 	w.indexBlock.scratch = w.scratch[20:]
+	// range deletion block
+	w.rangeDelBlock.restartInterval = o.GetBlockRestartInterval()
+	w.rangeDelBlock.scratch = w.scratch[20:]
+	// top-level index block and partitioned filter
+	w.topIndexBlock.restartInterval = 1
+	w.topIndexBlock.scratch = w.scratch[20:]
+	w.partFilter.indexBlock.restartInterval = 1
+	w.partFilter.indexBlock.scratch = w.scratch[20:]
 	// filter block
 	if w.filter != nil {
-		w.filterBlock.generator = w.filter.NewGenerator()
-		w.filterBlock.flush(0)
+		if w.partitioned {
+			w.partFilter.generator = w.filter.NewGenerator()
+		} else {
+			w.filterBlock.generator = w.filter.NewGenerator()
+			w.filterBlock.flush(0)
+		}
 	}
 	return w
 }
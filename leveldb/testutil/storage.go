@@ -61,6 +61,7 @@ const (
 	typeJournals
 	typeTable
 	typeTemp
+	typeBlob
 
 	typeCount
 )
@@ -101,6 +102,8 @@ func flattenType(m StorageMode, t storage.FileType) int {
 		return x + typeTable
 	case storage.TypeTemp:
 		return x + typeTemp
+	case storage.TypeBlob:
+		return x + typeBlob
 	default:
 		panic("invalid file type")
 	}
@@ -121,6 +124,8 @@ func listFlattenType(m StorageMode, t storage.FileType) []int {
 			ret = append(ret, x+typeTable)
 		case t&storage.TypeTemp != 0:
 			ret = append(ret, x+typeTemp)
+		case t&storage.TypeBlob != 0:
+			ret = append(ret, x+typeBlob)
 		}
 	}
 	switch {
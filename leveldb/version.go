@@ -35,6 +35,9 @@ type version struct {
 	levels []tFiles //元数据
 	level_s []sFiles
 
+	// Blob files of the secondary tree by number, see bFile.
+	blobs map[int64]*bFile
+
 	// Level that should be compacted next and its compaction score.
 	// Score < 1 means compaction is not strictly needed. These fields
 	// are initialized by computeCompaction()
@@ -65,7 +68,7 @@ func (v *version) incref() {
 	v.ref++
 	if v.ref == 1 {
 		select {
-		case v.s.refCh <- &vTask{vid: v.id, files: v.levels, sfiles: v.level_s, created: time.Now()}:
+		case v.s.refCh <- &vTask{vid: v.id, files: v.levels, sfiles: v.level_s, blobs: v.blobs, created: time.Now()}:
 			// We can use v.levels and v,level_s directly here since it is immutable.
 		case <-v.s.closeC:
 			v.s.log("reference loop already exist")
@@ -81,7 +84,7 @@ func (v *version) releaseNB() {
 		panic("negative version ref")
 	}
 	select {
-	case v.s.relCh <- &vTask{vid: v.id, files: v.levels, sfiles: v.level_s, created: time.Now()}:
+	case v.s.relCh <- &vTask{vid: v.id, files: v.levels, sfiles: v.level_s, blobs: v.blobs, created: time.Now()}:
 		// We can use v.levels directly here since it is immutable.
 	case <-v.s.closeC:
 		v.s.log("reference loop already exist")
@@ -220,13 +223,19 @@ func (v *version) get(aux tFiles, ikey internalKey, ro *opt.ReadOptions, noValue
 		zseq   uint64
 		zkt    keyType //插入还是删除？
 		zval   []byte
+		zrdSeq uint64 // newest range deletion covering ukey
 	)
 
 	err = ErrNotFound
+	seq, _ := ikey.parseNum()
 
 	// Since entries never hop across level, finding key/value
 	// in smaller level make later levels irrelevant. walkoverlapping 是用来定位ikey位于哪个文件中的
 	v.walkOverlapping(aux, ikey, func(level int, t *tFile) bool { //把func作为参数
+		if t.seq > seq {
+			// Ingested after the snapshot.
+			return true
+		}
 		if sampleSeeks && level >= 0 && !tseek { //s为true，level为0
 			if tset == nil {
 				tset = &tSet{level, t} //0，tfile
@@ -247,11 +256,25 @@ func (v *version) get(aux tFiles, ikey internalKey, ro *opt.ReadOptions, noValue
 		switch ferr {
 		case nil:
 		case ErrNotFound:
-			return true
+			fikey = nil
 		default:
 			err = ferr
 			return false
 		}
+
+		// Range deletions in the table covering ukey.
+		trdSeq, rderr := rangeDelSeq(v.s.icmp, v.s.tops.newRangeDelIterator(t), ukey, seq)
+		if rderr != nil {
+			err = rderr
+			return false
+		}
+		if level <= 0 && trdSeq > zrdSeq {
+			zrdSeq = trdSeq
+		}
+		if fikey == nil {
+			// Entries of deeper levels are older than the tombstone.
+			return level <= 0 || trdSeq == 0
+		}
 		//这里是为了跟找到的文件中的key进行比较，确认最新的数据
 		if fukey, fseq, fkt, fkerr := parseInternalKey(fikey); fkerr == nil {
 			if v.s.icmp.uCompare(ukey, fukey) == 0 {
@@ -264,6 +287,9 @@ func (v *version) get(aux tFiles, ikey internalKey, ro *opt.ReadOptions, noValue
 						zval = fval
 					}
 				} else {
+					if fseq < trdSeq {
+						return false
+					}
 					switch fkt {
 					case keyTypeVal:
 						value = fval
@@ -274,6 +300,8 @@ func (v *version) get(aux tFiles, ikey internalKey, ro *opt.ReadOptions, noValue
 					}
 					return false
 				}
+			} else if level > 0 && trdSeq > 0 {
+				return false
 			}
 		} else {
 			err = fkerr
@@ -282,7 +310,7 @@ func (v *version) get(aux tFiles, ikey internalKey, ro *opt.ReadOptions, noValue
 
 		return true
 	}, func(level int) bool {
-		if zfound {
+		if zfound && zseq > zrdSeq {
 			switch zkt {
 			case keyTypeVal:
 				value = zval
@@ -293,6 +321,9 @@ func (v *version) get(aux tFiles, ikey internalKey, ro *opt.ReadOptions, noValue
 			}
 			return false
 		}
+		if zrdSeq > 0 {
+			return false
+		}
 
 		return true
 	})
@@ -322,12 +353,20 @@ func (v *version) get_s(aux sFiles, ikey internalKey, ro *opt.ReadOptions, noVal
 		zseq   uint64
 		zkt    keyType
 		zval   []byte
+		zrdSeq uint64 // newest range deletion covering ukey
+
+		vkt keyType // type of the found value
 	)
 
 	err = ErrNotFound
+	seq, _ := ikey.parseNum()
 	// Since entries never hop across level, finding key/value
 	// in smaller level make later levels irrelevant.意思是从上往下找
 	v.walkOverlapping_s(aux, ikey, func(level int, t *sFile) bool {
+		if t.seq > seq {
+			// Ingested after the snapshot.
+			return true
+		}
 		if sampleSeeks && level >= 0 && !tseek {
 			if tset == nil {
 				tset = &tSet_s{level,t}
@@ -348,12 +387,26 @@ func (v *version) get_s(aux sFiles, ikey internalKey, ro *opt.ReadOptions, noVal
 		switch ferr {
 		case nil:
 		case ErrNotFound:
-			return true
+			fikey = nil
 		default:
 			err = ferr
 			return false
 		}
 
+		// Range deletions in the table covering ukey.
+		trdSeq, rderr := rangeDelSeq(v.s.icmp, v.s.tops.newRangeDelIterator_s(t), ukey, seq)
+		if rderr != nil {
+			err = rderr
+			return false
+		}
+		if level <= 0 && trdSeq > zrdSeq {
+			zrdSeq = trdSeq
+		}
+		if fikey == nil {
+			// Entries of deeper levels are older than the tombstone.
+			return level <= 0 || trdSeq == 0
+		}
+
 		if fukey, fseq, fkt, fkerr := parseInternalKey(fikey); fkerr == nil {
 			if v.s.icmp.uCompare(ukey, fukey) == 0 {
 				// Level <= 0 may overlaps each-other.
@@ -365,9 +418,13 @@ func (v *version) get_s(aux sFiles, ikey internalKey, ro *opt.ReadOptions, noVal
 						zval = fval
 					}
 				} else {
+					if fseq < trdSeq {
+						return false
+					}
 					switch fkt {
-					case keyTypeVal:
+					case keyTypeVal, keyTypeBlobIndex:
 						value = fval
+						vkt = fkt
 						err = nil
 					case keyTypeDel:
 					default:
@@ -375,6 +432,8 @@ func (v *version) get_s(aux sFiles, ikey internalKey, ro *opt.ReadOptions, noVal
 					}
 					return false
 				}
+			} else if level > 0 && trdSeq > 0 {
+				return false
 			}
 		} else {
 			err = fkerr
@@ -383,10 +442,11 @@ func (v *version) get_s(aux sFiles, ikey internalKey, ro *opt.ReadOptions, noVal
 
 		return true
 	}, func(level int) bool {
-		if zfound {
+		if zfound && zseq > zrdSeq {
 			switch zkt {
-			case keyTypeVal:
+			case keyTypeVal, keyTypeBlobIndex:
 				value = zval
+				vkt = zkt
 				err = nil
 			case keyTypeDel:
 			default:
@@ -394,6 +454,9 @@ func (v *version) get_s(aux sFiles, ikey internalKey, ro *opt.ReadOptions, noVal
 			}
 			return false
 		}
+		if zrdSeq > 0 {
+			return false
+		}
 
 		return true
 	})
@@ -402,6 +465,405 @@ func (v *version) get_s(aux sFiles, ikey internalKey, ro *opt.ReadOptions, noVal
 		tcomp = atomic.CompareAndSwapPointer(&v.nSeek, nil, unsafe.Pointer(tset))
 	}
 
+	// The value is stored in a blob file.
+	if err == nil && vkt == keyTypeBlobIndex && !noValue {
+		value, err = v.s.tops.getBlob(value)
+	}
+
+	return
+}
+
+// State of a key of a batched lookup, it follows the rules of version.get
+// one table at a time.
+type multiGetState struct {
+	ikey internalKey
+	blob bool // whether blob indexes are values
+
+	value []byte
+	vkt   keyType
+	err   error
+	done  bool
+
+	tset   *tSet
+	tset_s *tSet_s
+	tseek  bool
+
+	// Level-0.
+	zfound bool
+	zseq   uint64
+	zkt    keyType
+	zval   []byte
+	zrdSeq uint64
+}
+
+func (st *multiGetState) isValue(kt keyType) bool {
+	return kt == keyTypeVal || (st.blob && kt == keyTypeBlobIndex)
+}
+
+// Accounts the lookup of the key in a table of the given level, it returns
+// false if later tables are irrelevant.
+func (st *multiGetState) visit(icmp *iComparer, level int, fikey, fval []byte, ferr error, trdSeq uint64) bool {
+	switch ferr {
+	case nil:
+	case ErrNotFound:
+		fikey = nil
+	default:
+		st.err = ferr
+		return false
+	}
+
+	if level <= 0 && trdSeq > st.zrdSeq {
+		st.zrdSeq = trdSeq
+	}
+	if fikey == nil {
+		// Entries of deeper levels are older than the tombstone.
+		return level <= 0 || trdSeq == 0
+	}
+
+	fukey, fseq, fkt, fkerr := parseInternalKey(fikey)
+	if fkerr != nil {
+		st.err = fkerr
+		return false
+	}
+	if icmp.uCompare(st.ikey.ukey(), fukey) == 0 {
+		// Level <= 0 may overlaps each-other.
+		if level <= 0 {
+			if fseq >= st.zseq {
+				st.zfound = true
+				st.zseq = fseq
+				st.zkt = fkt
+				st.zval = fval
+			}
+			return true
+		}
+		if fseq < trdSeq {
+			return false
+		}
+		switch {
+		case st.isValue(fkt):
+			st.value = fval
+			st.vkt = fkt
+			st.err = nil
+		case fkt == keyTypeDel:
+		default:
+			panic("leveldb: invalid internalKey type")
+		}
+		return false
+	}
+	return !(level > 0 && trdSeq > 0)
+}
+
+// Accounts the end of the given level, it returns false if later levels
+// are irrelevant.
+func (st *multiGetState) levelDone() bool {
+	if st.zfound && st.zseq > st.zrdSeq {
+		switch {
+		case st.isValue(st.zkt):
+			st.value = st.zval
+			st.vkt = st.zkt
+			st.err = nil
+		case st.zkt == keyTypeDel:
+		default:
+			panic("leveldb: invalid internalKey type")
+		}
+		return false
+	}
+	return st.zrdSeq == 0
+}
+
+func newMultiGetStates(ikeys []internalKey, blob bool) []multiGetState {
+	sts := make([]multiGetState, len(ikeys))
+	for i, ikey := range ikeys {
+		sts[i] = multiGetState{ikey: ikey, blob: blob, err: ErrNotFound}
+	}
+	return sts
+}
+
+// Batched version.get, the keys must be sorted. The lookups are grouped per
+// table: each table is opened once for all the keys which may be in it, and
+// its filter and data blocks are shared among them, see
+// table.Reader.FindMulti.
+func (v *version) multiGet(aux tFiles, ikeys []internalKey, ro *opt.ReadOptions) (values [][]byte, errs []error, tcomp bool) {
+	values = make([][]byte, len(ikeys))
+	if v.closing {
+		return values, fillErrors(len(ikeys), ErrClosed), false
+	}
+	sampleSeeks := !v.s.o.GetDisableSeeksCompaction()
+	sts := newMultiGetStates(ikeys, false)
+
+	// Looks up the pending keys of idx in the table.
+	lookup := func(level int, t *tFile, idx []int) {
+		keys := make([][]byte, 0, len(idx))
+		for _, i := range idx {
+			keys = append(keys, sts[i].ikey)
+		}
+		rkeys, rvalues, ferrs := v.s.tops.findMulti(t, keys, ro)
+		for j, i := range idx {
+			st := &sts[i]
+			if sampleSeeks && level >= 0 && !st.tseek {
+				if st.tset == nil {
+					st.tset = &tSet{level, t}
+				} else {
+					st.tseek = true
+				}
+			}
+			var fikey, fval []byte
+			if ferrs[j] == nil {
+				fikey, fval = rkeys[j], rvalues[j]
+			}
+			seq, _ := st.ikey.parseNum()
+			trdSeq, rderr := rangeDelSeq(v.s.icmp, v.s.tops.newRangeDelIterator(t), st.ikey.ukey(), seq)
+			if rderr != nil {
+				st.err = rderr
+				st.done = true
+				continue
+			}
+			if !st.visit(v.s.icmp, level, fikey, fval, ferrs[j], trdSeq) {
+				st.done = true
+			}
+		}
+	}
+	// Returns the pending keys overlapping the table.
+	overlapping := func(t *tFile) (idx []int) {
+		for i := range sts {
+			st := &sts[i]
+			if st.done {
+				continue
+			}
+			if seq, _ := st.ikey.parseNum(); t.seq > seq {
+				// Ingested after the snapshot.
+				continue
+			}
+			ukey := st.ikey.ukey()
+			if t.overlaps(v.s.icmp, ukey, ukey) {
+				idx = append(idx, i)
+			}
+		}
+		return
+	}
+	endLevel := func() (pending bool) {
+		for i := range sts {
+			if st := &sts[i]; !st.done {
+				if !st.levelDone() {
+					st.done = true
+				} else {
+					pending = true
+				}
+			}
+		}
+		return
+	}
+
+	pending := true
+	if aux != nil {
+		for _, t := range aux {
+			if idx := overlapping(t); len(idx) > 0 {
+				lookup(-1, t, idx)
+			}
+		}
+		pending = endLevel()
+	}
+	for level, tables := range v.levels {
+		if !pending {
+			break
+		}
+		if len(tables) == 0 {
+			continue
+		}
+
+		if level == 0 {
+			// Level-0 files may overlap each other.
+			for _, t := range tables {
+				if idx := overlapping(t); len(idx) > 0 {
+					lookup(level, t, idx)
+				}
+			}
+		} else {
+			// Sorted keys land in ascending tables.
+			var (
+				cur = -1
+				idx []int
+			)
+			for i := range sts {
+				st := &sts[i]
+				if st.done {
+					continue
+				}
+				j := tables.searchMax(v.s.icmp, st.ikey)
+				if j >= len(tables) || v.s.icmp.uCompare(st.ikey.ukey(), tables[j].imin.ukey()) < 0 {
+					continue
+				}
+				if seq, _ := st.ikey.parseNum(); tables[j].seq > seq {
+					continue
+				}
+				if j != cur && len(idx) > 0 {
+					lookup(level, tables[cur], idx)
+					idx = nil
+				}
+				cur = j
+				idx = append(idx, i)
+			}
+			if len(idx) > 0 {
+				lookup(level, tables[cur], idx)
+			}
+		}
+		pending = endLevel()
+	}
+
+	errs = make([]error, len(sts))
+	for i := range sts {
+		st := &sts[i]
+		values[i], errs[i] = st.value, st.err
+		if st.tseek && st.tset.table.consumeSeek() <= 0 {
+			if atomic.CompareAndSwapPointer(&v.cSeek, nil, unsafe.Pointer(st.tset)) {
+				tcomp = true
+			}
+		}
+	}
+	return
+}
+
+func (v *version) multiGet_s(aux sFiles, ikeys []internalKey, ro *opt.ReadOptions) (values [][]byte, errs []error, tcomp bool) {
+	values = make([][]byte, len(ikeys))
+	if v.closing {
+		return values, fillErrors(len(ikeys), ErrClosed), false
+	}
+	sampleSeeks := !v.s.o.GetDisableSeeksCompaction()
+	sts := newMultiGetStates(ikeys, true)
+
+	// Looks up the pending keys of idx in the table.
+	lookup := func(level int, t *sFile, idx []int) {
+		keys := make([][]byte, 0, len(idx))
+		for _, i := range idx {
+			keys = append(keys, sts[i].ikey)
+		}
+		rkeys, rvalues, ferrs := v.s.tops.findMulti_s(t, keys, ro)
+		for j, i := range idx {
+			st := &sts[i]
+			if sampleSeeks && level >= 0 && !st.tseek {
+				if st.tset_s == nil {
+					st.tset_s = &tSet_s{level, t}
+				} else {
+					st.tseek = true
+				}
+			}
+			var fikey, fval []byte
+			if ferrs[j] == nil {
+				fikey, fval = rkeys[j], rvalues[j]
+			}
+			seq, _ := st.ikey.parseNum()
+			trdSeq, rderr := rangeDelSeq(v.s.icmp, v.s.tops.newRangeDelIterator_s(t), st.ikey.ukey(), seq)
+			if rderr != nil {
+				st.err = rderr
+				st.done = true
+				continue
+			}
+			if !st.visit(v.s.icmp, level, fikey, fval, ferrs[j], trdSeq) {
+				st.done = true
+			}
+		}
+	}
+	// Returns the pending keys overlapping the table.
+	overlapping := func(t *sFile) (idx []int) {
+		for i := range sts {
+			st := &sts[i]
+			if st.done {
+				continue
+			}
+			if seq, _ := st.ikey.parseNum(); t.seq > seq {
+				// Ingested after the snapshot.
+				continue
+			}
+			ukey := st.ikey.ukey()
+			if t.overlaps(v.s.icmp, ukey, ukey) {
+				idx = append(idx, i)
+			}
+		}
+		return
+	}
+	endLevel := func() (pending bool) {
+		for i := range sts {
+			if st := &sts[i]; !st.done {
+				if !st.levelDone() {
+					st.done = true
+				} else {
+					pending = true
+				}
+			}
+		}
+		return
+	}
+
+	pending := true
+	if aux != nil {
+		for _, t := range aux {
+			if idx := overlapping(t); len(idx) > 0 {
+				lookup(-1, t, idx)
+			}
+		}
+		pending = endLevel()
+	}
+	for level, tables := range v.level_s {
+		if !pending {
+			break
+		}
+		if len(tables) == 0 {
+			continue
+		}
+
+		if level == 0 {
+			// Level-0 files may overlap each other.
+			for _, t := range tables {
+				if idx := overlapping(t); len(idx) > 0 {
+					lookup(level, t, idx)
+				}
+			}
+		} else {
+			// Sorted keys land in ascending tables.
+			var (
+				cur = -1
+				idx []int
+			)
+			for i := range sts {
+				st := &sts[i]
+				if st.done {
+					continue
+				}
+				j := tables.searchMax(v.s.icmp, st.ikey)
+				if j >= len(tables) || v.s.icmp.uCompare(st.ikey.ukey(), tables[j].imin.ukey()) < 0 {
+					continue
+				}
+				if seq, _ := st.ikey.parseNum(); tables[j].seq > seq {
+					continue
+				}
+				if j != cur && len(idx) > 0 {
+					lookup(level, tables[cur], idx)
+					idx = nil
+				}
+				cur = j
+				idx = append(idx, i)
+			}
+			if len(idx) > 0 {
+				lookup(level, tables[cur], idx)
+			}
+		}
+		pending = endLevel()
+	}
+
+	errs = make([]error, len(sts))
+	for i := range sts {
+		st := &sts[i]
+		values[i], errs[i] = st.value, st.err
+		if st.tseek && st.tset_s.table.consumeSeek() <= 0 {
+			if atomic.CompareAndSwapPointer(&v.nSeek, nil, unsafe.Pointer(st.tset_s)) {
+				tcomp = true
+			}
+		}
+		// The value is stored in a blob file.
+		if errs[i] == nil && st.vkt == keyTypeBlobIndex {
+			values[i], errs[i] = v.s.tops.getBlob(values[i])
+		}
+	}
 	return
 }
 
@@ -438,34 +900,70 @@ func (v *version) sampleSeek_s(ikey internalKey) (tcomp bool) {
 	return
 }
 
-func (v *version) getIterators(slice *util.Range, ro *opt.ReadOptions) (its []iterator.Iterator) {
+func (v *version) getIterators(slice *util.Range, prefix []byte, ro *opt.ReadOptions) (its []iterator.Iterator) {
 	strict := opt.GetStrict(v.s.o.Options, ro, opt.StrictReader)
 	for level, tables := range v.levels {
+		// Skip the tables which prefix filter rules out the prefix range.
+		if prefix != nil {
+			tables = tables.prefixMatches(v.s.tops, v.s.icmp, slice, prefix, ro)
+		}
 		if level == 0 {
 			// Merge all level zero files together since they may overlap.
 			for _, t := range tables {
 				its = append(its, v.s.tops.newIterator(t, slice, ro))
 			}
 		} else if len(tables) != 0 {
-			its = append(its, iterator.NewIndexedIterator(tables.newIndexIterator(v.s.tops, v.s.icmp, slice, ro), strict))
+			its = append(its, iterator.NewIndexedIterator(tables.newIndexIterator(v.s.tops, v.s.icmp, slice, ro, nil), strict))
 		}
 	}
 	return
 }
-func (v *version) getIterators_s(slice *util.Range, ro *opt.ReadOptions) (its []iterator.Iterator) {
+func (v *version) getIterators_s(slice *util.Range, prefix []byte, ro *opt.ReadOptions) (its []iterator.Iterator) {
 	strict := opt.GetStrict(v.s.o.Options, ro, opt.StrictReader)
 	for level, tables := range v.level_s {
+		// Skip the tables which prefix filter rules out the prefix range.
+		if prefix != nil {
+			tables = tables.prefixMatches(v.s.tops, v.s.icmp, slice, prefix, ro)
+		}
 		if level == 0 {
 			// Merge all level zero files together since they may overlap.
 			for _, t := range tables {
 				its = append(its, v.s.tops.newIterator_s(t, slice, ro))
 			}
 		} else if len(tables) != 0 {
-			its = append(its, iterator.NewIndexedIterator(tables.newIndexIterator(v.s.tops, v.s.icmp, slice, ro), strict))
+			its = append(its, iterator.NewIndexedIterator(tables.newIndexIterator(v.s.tops, v.s.icmp, slice, ro, nil), strict))
 		}
 	}
 	return
 }
+// Appends range tombstones visible at seq of the tables overlapping the user
+// key range [umin, umax].
+func (v *version) getRangeTombstones(dst []rangeTombstone, umin, umax []byte, seq uint64) (_ []rangeTombstone, err error) {
+	for _, tables := range v.levels {
+		for _, t := range tables {
+			if t.overlaps(v.s.icmp, umin, umax) {
+				if dst, err = readRangeTombstones(dst, v.s.tops.newRangeDelIterator(t), seq); err != nil {
+					return dst, err
+				}
+			}
+		}
+	}
+	return dst, nil
+}
+
+func (v *version) getRangeTombstones_s(dst []rangeTombstone, umin, umax []byte, seq uint64) (_ []rangeTombstone, err error) {
+	for _, tables := range v.level_s {
+		for _, t := range tables {
+			if t.overlaps(v.s.icmp, umin, umax) {
+				if dst, err = readRangeTombstones(dst, v.s.tops.newRangeDelIterator_s(t), seq); err != nil {
+					return dst, err
+				}
+			}
+		}
+	}
+	return dst, nil
+}
+
 func (v *version) newStaging() *versionStaging {
 	return &versionStaging{base: v}
 }
@@ -503,6 +1001,9 @@ func (v *version) fillRecord_s(r *sessionRecord) {
 			r.addTableFile_s(level, t)
 		}
 	}
+	for _, b := range v.blobs {
+		r.addBlobFile(b)
+	}
 }
 func (v *version) tLen(level int) int {
 	if level < len(v.levels) {
@@ -546,6 +1047,32 @@ func (v *version) offsetOf(ikey internalKey) (n int64, err error) {
 	return
 }
 
+func (v *version) offsetOf_s(ikey internalKey) (n int64, err error) {
+	for level, tables := range v.level_s {
+		for _, t := range tables {
+			if v.s.icmp.Compare(t.imax, ikey) <= 0 {
+				// Entire file is before "ikey", so just add the file size
+				n += t.size
+			} else if v.s.icmp.Compare(t.imin, ikey) > 0 {
+				// Entire file is after "ikey", so ignore
+				if level > 0 {
+					break
+				}
+			} else {
+				// "ikey" falls in the range for this table. Add the
+				// approximate offset of "ikey" within the table.
+				if m, err := v.s.tops.offsetOf_s(t, ikey); err == nil {
+					n += m
+				} else {
+					return 0, err
+				}
+			}
+		}
+	}
+
+	return
+}
+
 func (v *version) pickMemdbLevel(umin, umax []byte, maxLevel int) (level int) {
 	if maxLevel > 0 {
 		if len(v.levels) == 0 {
@@ -603,6 +1130,16 @@ func (v *version) computeCompaction() {
 	statSizes := make([]string, len(v.levels))
 	statScore := make([]string, len(v.levels))
 	statTotSize := int64(0)
+
+	// A tiered tree is scored on the number of its sorted runs.
+	tiered := v.s.o.GetCompactionStyle(opt.PrimaryTree) == opt.TieredCompaction
+	var sizes []int64
+	if tiered {
+		sizes = make([]int64, len(v.levels))
+		for level, tables := range v.levels {
+			sizes[level] = tables.size()
+		}
+	}
 	//遍历[]tfiles
 	for level, tables := range v.levels {
 		var score float64
@@ -620,7 +1157,14 @@ func (v *version) computeCompaction() {
 			// setting, or very high compression ratios, or lots of
 			// overwrites/deletions).
 			score = float64(len(tables)) / float64(v.s.o.GetCompactionL0Trigger()) // 文件个数/4
-		} else {
+			if tiered {
+				if runs := tieredRuns(sizes, len(tables)); runs >= 2 {
+					score = float64(runs) / float64(v.s.o.GetCompactionL0Trigger())
+				} else {
+					score = 0
+				}
+			}
+		} else if !tiered {
 			score = float64(size) / float64(v.s.o.GetCompactionTotalSize(level)) //文件的总大小/预设的每个level的文件大小总量
 		}
 
@@ -649,6 +1193,16 @@ func (v *version) computeCompaction_s() {
 	statSizes := make([]string, len(v.level_s))
 	statScore := make([]string, len(v.level_s))
 	statTotSize := int64(0)
+
+	// A tiered tree is scored on the number of its sorted runs.
+	tiered := v.s.o.GetCompactionStyle(opt.SecondaryTree) == opt.TieredCompaction
+	var sizes []int64
+	if tiered {
+		sizes = make([]int64, len(v.level_s))
+		for level, tables := range v.level_s {
+			sizes[level] = tables.size()
+		}
+	}
 	//遍历[]sfiles
 	for level, tables := range v.level_s {
 		var score float64
@@ -666,7 +1220,14 @@ func (v *version) computeCompaction_s() {
 			// setting, or very high compression ratios, or lots of
 			// overwrites/deletions).
 			score = float64(len(tables)) / float64(v.s.o.GetCompactionL0Trigger()) // 文件个数/4
-		} else {
+			if tiered {
+				if runs := tieredRuns(sizes, len(tables)); runs >= 2 {
+					score = float64(runs) / float64(v.s.o.GetCompactionL0Trigger())
+				} else {
+					score = 0
+				}
+			}
+		} else if !tiered {
 			score = float64(size) / float64(v.s.o.GetCompactionTotalSize(level)) //文件的总大小/预设的每个level的文件大小总量
 		}
 
@@ -688,9 +1249,15 @@ func (v *version) computeCompaction_s() {
 }
 //查看是否需要合并
 func (v *version) needCompaction() bool {
+	if v.s.o.GetCompactionStyle(opt.PrimaryTree) == opt.TieredCompaction {
+		return v.cScore >= 1
+	}
 	return v.cScore >= 1 || atomic.LoadPointer(&v.cSeek) != nil
 }
 func (v *version) needCompaction_s() bool {
+	if v.s.o.GetCompactionStyle(opt.SecondaryTree) == opt.TieredCompaction {
+		return v.cScores >= 1
+	}
 	return v.cScores >= 1 || atomic.LoadPointer(&v.nSeek) != nil
 }
 
@@ -706,6 +1273,9 @@ type versionStaging struct {
 	base   *version //存储旧版本的version
 	levels []tablesScratch //added addeds deleted deleteds
 	level_s []tablesScratch2 //added addeds deleted deleteds
+
+	addedBlobs  map[int64]abRecord
+	blobGarbage map[int64]int64
 }
 
 func (p *versionStaging) getScratch(level int) *tablesScratch {
@@ -772,6 +1342,7 @@ func (p *versionStaging) commit(r *sessionRecord) {
 		}
 
 	}
+	p.commitBlobs(r)
 }
 func (p *versionStaging) commit_1(r *sessionRecord) {
 	// Deleted tables.
@@ -799,6 +1370,20 @@ func (p *versionStaging) commit_1(r *sessionRecord) {
 		}
 	}
 }
+func (p *versionStaging) commitBlobs(r *sessionRecord) {
+	for _, r := range r.addedBlobs {
+		if p.addedBlobs == nil {
+			p.addedBlobs = make(map[int64]abRecord)
+		}
+		p.addedBlobs[r.num] = r
+	}
+	for _, r := range r.blobGarbage {
+		if p.blobGarbage == nil {
+			p.blobGarbage = make(map[int64]int64)
+		}
+		p.blobGarbage[r.num] += r.garbage
+	}
+}
 func (p *versionStaging) commit_2(r *sessionRecord) {
 	// Deleted tables.
 	for _, r := range r.deletedTabless {
@@ -825,7 +1410,38 @@ func (p *versionStaging) commit_2(r *sessionRecord) {
 			delete(scratch.deleted, r.num)
 		}
 	}
+	p.commitBlobs(r)
+}
+
+// Returns the blob files of the new version. Files whose records are all
+// garbage are dropped.
+func (p *versionStaging) finishBlobs() map[int64]*bFile {
+	if len(p.addedBlobs) == 0 && len(p.blobGarbage) == 0 {
+		return p.base.blobs
+	}
+	blobs := make(map[int64]*bFile, len(p.base.blobs)+len(p.addedBlobs))
+	for num, b := range p.base.blobs {
+		blobs[num] = b
+	}
+	for num, r := range p.addedBlobs {
+		blobs[num] = blobFileFromRecord(r)
+	}
+	for num, garbage := range p.blobGarbage {
+		b, ok := blobs[num]
+		if !ok {
+			continue
+		}
+		nb := *b
+		nb.garbage += garbage
+		if nb.garbage >= nb.size {
+			delete(blobs, num)
+		} else {
+			blobs[num] = &nb
+		}
+	}
+	return blobs
 }
+
 //version中的levels和level_s都是在finish这里被赋值的
 func (p *versionStaging) finish(trivial bool) *version {
 	// Build new version.
@@ -1026,6 +1642,7 @@ func (p *versionStaging) finish(trivial bool) *version {
 	}
 	nv.level_s = nv.level_s[:n2]
 	nv.computeCompaction_s()
+	nv.blobs = p.finishBlobs()
 	return nv
 }
 func (p *versionStaging) finish_1(trivial bool) *version {
@@ -1128,6 +1745,7 @@ func (p *versionStaging) finish_1(trivial bool) *version {
 	nv.levels = nv.levels[:n]
 	//fmt.Println("finish循环后:",nv.level_s,nv.levels)
 	nv.computeCompaction()
+	nv.blobs = p.base.blobs
 	return nv
 }
 func (p *versionStaging) finish_2(trivial bool) *version {
@@ -1234,6 +1852,7 @@ func (p *versionStaging) finish_2(trivial bool) *version {
 	nv.level_s = nv.level_s[:n]
 	//fmt.Println(nv.level_s,nv.levels)
 	nv.computeCompaction_s()
+	nv.blobs = p.finishBlobs()
 	return nv
 }
 
@@ -429,3 +429,34 @@ func benchmarkVersionStaging(b *testing.B, trivial bool, size int) {
 		vs.finish(trivial)
 	}
 }
+
+func TestPickTieredLevels(t *testing.T) {
+	tests := []struct {
+		sizes  []int64
+		nL0    int
+		inputs []int
+		out    int
+	}{
+		// Level-0 tables alone are merged to the bottom.
+		{[]int64{40}, 4, []int{0}, tieredBottomLevel},
+		// A single run joins the next one.
+		{[]int64{10, 0, 0, 0, 0, 0, 1000}, 1, []int{0, 6}, 6},
+		// Runs of similar size are merged above the next older run.
+		{[]int64{30, 0, 0, 0, 0, 30, 1000}, 3, []int{0, 5}, 5},
+		// Level-0 can't be written to.
+		{[]int64{40, 1000, 0, 0, 0, 0, 5000}, 4, []int{0, 1}, 5},
+		// Too much space held by the newer runs.
+		{[]int64{10, 0, 0, 0, 0, 3000, 1000}, 1, []int{0, 5, 6}, 6},
+		// A run deeper than the bottom level stays there.
+		{[]int64{10, 0, 0, 0, 0, 0, 0, 1000}, 1, []int{0, 7}, 7},
+		// Nothing to merge.
+		{[]int64{0, 0, 0, 100}, 0, nil, 0},
+		{[]int64{10}, 1, nil, 0},
+	}
+	for i, test := range tests {
+		inputs, out := pickTieredLevels(test.sizes, test.nL0)
+		if !reflect.DeepEqual(inputs, test.inputs) || out != test.out {
+			t.Errorf("#%d: got %v -> L%d, want %v -> L%d", i, inputs, out, test.inputs, test.out)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+// Package metrics exports database, block cache and trie metrics in the
+// OpenMetrics text format.
+//
+// An Exporter is an http.Handler, it is typically served next to pprof:
+//
+//	e := metrics.NewExporter()
+//	e.AddDB("chaindata", db)
+//	http.Handle("/metrics", e)
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/rev3z/ledger_base/leveldb"
+	"github.com/rev3z/ledger_base/trie"
+)
+
+// ContentType is the content type of the exposition served by Exporter.
+const ContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// Exporter serves metrics of the registered databases and of the trie
+// package. It is safe for concurrent use.
+type Exporter struct {
+	mu  sync.Mutex
+	dbs map[string]*leveldb.DB
+}
+
+// NewExporter returns an exporter without databases; the trie metrics are
+// always exported.
+func NewExporter() *Exporter {
+	return &Exporter{dbs: make(map[string]*leveldb.DB)}
+}
+
+// AddDB registers db, its metrics are labeled with db="name". Registering
+// a name again replaces the database.
+func (e *Exporter) AddDB(name string, db *leveldb.DB) {
+	e.mu.Lock()
+	e.dbs[name] = db
+	e.mu.Unlock()
+}
+
+// RemoveDB unregisters the database of the given name. It should be called
+// before the database is closed.
+func (e *Exporter) RemoveDB(name string) {
+	e.mu.Lock()
+	delete(e.dbs, name)
+	e.mu.Unlock()
+}
+
+// ServeHTTP writes the current metrics.
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	if err := e.WriteTo(&buf); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", ContentType)
+	w.Write(buf.Bytes())
+}
+
+// WriteTo writes the current metrics to buf, terminated by the # EOF line.
+func (e *Exporter) WriteTo(buf *bytes.Buffer) error {
+	e.mu.Lock()
+	names := make([]string, 0, len(e.dbs))
+	for name := range e.dbs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	stats := make([]leveldb.DBStats, len(names))
+	for i, name := range names {
+		if err := e.dbs[name].Stats(&stats[i]); err != nil {
+			e.mu.Unlock()
+			return fmt.Errorf("metrics: stats of %q: %v", name, err)
+		}
+	}
+	e.mu.Unlock()
+
+	fs := newFamilies()
+	for i, name := range names {
+		collectDB(fs, name, &stats[i])
+	}
+	fs.counter("trie_cache_misses", "Trie node cache misses since process start.").
+		add(float64(trie.CacheMisses()))
+	fs.counter("trie_cache_unloads", "Trie node cache unloads since process start.").
+		add(float64(trie.CacheUnloads()))
+
+	fs.write(buf)
+	return nil
+}
+
+// Adds the samples of one database.
+func collectDB(fs *families, name string, s *leveldb.DBStats) {
+	db := label("db", name)
+
+	trees := []struct {
+		tree      string
+		tables    []int
+		sizes     leveldb.Sizes
+		read      leveldb.Sizes
+		write     leveldb.Sizes
+		durations []float64
+		removed   []int64
+		changed   []int64
+		memdb     int
+		comps     []uint32
+		opened    int
+		bsize     int
+		hits      int64
+		misses    int64
+	}{
+		{"primary", s.LevelTablesCounts, s.LevelSizes, s.LevelRead, s.LevelWrite, seconds(s.LevelDurations),
+			s.LevelFilterRemoved, s.LevelFilterChanged, s.MemdbSize,
+			[]uint32{s.MemComp, s.Level0Comp, s.NonLevel0Comp, s.SeekComp},
+			s.OpenedTablesCount, s.BlockCacheSize, s.BlockCacheHits, s.BlockCacheMisses},
+		{"secondary", s.LevelTablesCounts_s, s.LevelSizes_s, s.LevelRead_s, s.LevelWrite_s, seconds(s.LevelDurations_s),
+			s.LevelFilterRemoved_s, s.LevelFilterChanged_s, s.MemdbSize_s,
+			[]uint32{s.MemComp_s, s.Level0Comp_s, s.NonLevel0Comp_s},
+			s.OpenedTablesCount_s, s.BlockCacheSize_s, s.BlockCacheHits_s, s.BlockCacheMisses_s},
+	}
+	compTypes := []string{"mem", "level0", "nonlevel0", "seek"}
+	for _, t := range trees {
+		tree := db + "," + label("tree", t.tree)
+		for level := range t.tables {
+			ls := tree + "," + label("level", strconv.Itoa(level))
+			fs.gauge("leveldb_level_tables", "Number of tables at the level.").
+				add(float64(t.tables[level]), ls)
+			fs.gauge("leveldb_level_size_bytes", "Total size of the tables at the level.").
+				add(float64(t.sizes[level]), ls)
+			fs.counter("leveldb_compaction_seconds", "Time spent compacting into the level.").
+				add(t.durations[level], ls)
+			fs.counter("leveldb_compaction_read_bytes", "Bytes read by compactions into the level.").
+				add(float64(t.read[level]), ls)
+			fs.counter("leveldb_compaction_write_bytes", "Bytes written by compactions into the level.").
+				add(float64(t.write[level]), ls)
+			fs.counter("leveldb_compaction_filter_removed", "Entries removed by the compaction filter.").
+				add(float64(t.removed[level]), ls)
+			fs.counter("leveldb_compaction_filter_changed", "Entries changed by the compaction filter.").
+				add(float64(t.changed[level]), ls)
+		}
+		for i, n := range t.comps {
+			fs.counter("leveldb_compactions", "Number of compactions by type.").
+				add(float64(n), tree+","+label("type", compTypes[i]))
+		}
+		fs.gauge("leveldb_memdb_size_bytes", "Size of the effective and frozen memdb.").
+			add(float64(t.memdb), tree)
+		fs.gauge("leveldb_opened_tables", "Number of tables in the open files cache.").
+			add(float64(t.opened), tree)
+		fs.gauge("leveldb_block_cache_size_bytes", "Size of the cached blocks.").
+			add(float64(t.bsize), tree)
+		fs.counter("leveldb_block_cache_hits", "Block cache hits.").
+			add(float64(t.hits), tree)
+		fs.counter("leveldb_block_cache_misses", "Block cache misses.").
+			add(float64(t.misses), tree)
+	}
+
+	fs.counter("leveldb_write_delays", "Number of writes delayed by compaction.").
+		add(float64(s.WriteDelayCount), db)
+	fs.counter("leveldb_write_delay_seconds", "Time writes were delayed by compaction.").
+		add(s.WriteDelayDuration.Seconds(), db)
+	fs.gauge("leveldb_write_paused", "Whether writes are paused by compaction.").
+		add(boolValue(s.WritePaused), db)
+	fs.counter("leveldb_io_read_bytes", "Bytes read from storage.").
+		add(float64(s.IORead), db)
+	fs.counter("leveldb_io_write_bytes", "Bytes written to storage.").
+		add(float64(s.IOWrite), db)
+	fs.counter("leveldb_io_throttles", "Number of compaction I/O requests throttled by the rate limiter.").
+		add(float64(s.IOThrottleCount), db)
+	fs.counter("leveldb_io_throttle_seconds", "Time compaction I/O was throttled by the rate limiter.").
+		add(s.IOThrottleDuration.Seconds(), db)
+	fs.gauge("leveldb_alive_snapshots", "Number of unreleased snapshots.").
+		add(float64(s.AliveSnapshots), db)
+	fs.gauge("leveldb_alive_iterators", "Number of unreleased iterators.").
+		add(float64(s.AliveIterators), db)
+}
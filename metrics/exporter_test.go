@@ -0,0 +1,83 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rev3z/ledger_base/leveldb"
+	"github.com/rev3z/ledger_base/leveldb/storage"
+)
+
+func TestExporter(t *testing.T) {
+	db, err := leveldb.Open(storage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatal("Open: got error: ", err)
+	}
+	defer db.Close()
+	if err := db.Put([]byte("foo"), []byte("v1"), nil); err != nil {
+		t.Fatal("Put: got error: ", err)
+	}
+	if err := db.Put_s([]byte("bar"), []byte("v1"), nil); err != nil {
+		t.Fatal("Put_s: got error: ", err)
+	}
+
+	e := NewExporter()
+	e.AddDB(`chain"data`, db)
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+
+	resp, err := srv.Client().Get(srv.URL)
+	if err != nil {
+		t.Fatal("Get: got error: ", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != ContentType {
+		t.Errorf("got content type %q, want %q", ct, ContentType)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal("ReadAll: got error: ", err)
+	}
+	text := string(body)
+
+	if !strings.HasSuffix(text, "# EOF\n") {
+		t.Error("exposition does not end with # EOF")
+	}
+	for _, want := range []string{
+		"# TYPE leveldb_compactions counter\n",
+		`leveldb_memdb_size_bytes{db="chain\"data",tree="secondary"} `,
+		`leveldb_compactions_total{db="chain\"data",tree="primary",type="mem"} 0` + "\n",
+		`leveldb_write_delays_total{db="chain\"data"} 0` + "\n",
+		"# TYPE leveldb_block_cache_hits counter\n",
+		`leveldb_block_cache_hits_total{db="chain\"data",tree="secondary"} `,
+		"# TYPE trie_cache_misses counter\n",
+		"trie_cache_unloads_total ",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("exposition does not contain %q", want)
+		}
+	}
+
+	// The samples of a family must not be interleaved with other families.
+	seen := make(map[string]bool)
+	var last string
+	for _, line := range strings.Split(strings.TrimSuffix(text, "\n"), "\n") {
+		if !strings.HasPrefix(line, "# TYPE ") {
+			continue
+		}
+		name := strings.Fields(line)[2]
+		if seen[name] && name != last {
+			t.Errorf("family %s is interleaved", name)
+		}
+		seen[name], last = true, name
+	}
+
+	e.RemoveDB(`chain"data`)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if strings.Contains(rec.Body.String(), "leveldb_") {
+		t.Error("removed database is still exported")
+	}
+}
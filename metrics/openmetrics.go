@@ -0,0 +1,115 @@
+package metrics
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	typeGauge   = "gauge"
+	typeCounter = "counter"
+)
+
+// A metric family with its samples, the samples of a family must be written
+// together.
+type family struct {
+	name, typ, help string
+	samples         []sample
+}
+
+type sample struct {
+	labels string
+	value  float64
+}
+
+func (f *family) add(value float64, labels ...string) {
+	f.samples = append(f.samples, sample{strings.Join(labels, ","), value})
+}
+
+// Families in order of first use.
+type families struct {
+	byName map[string]*family
+	order  []*family
+}
+
+func newFamilies() *families {
+	return &families{byName: make(map[string]*family)}
+}
+
+func (fs *families) get(name, typ, help string) *family {
+	f, ok := fs.byName[name]
+	if !ok {
+		f = &family{name: name, typ: typ, help: help}
+		fs.byName[name] = f
+		fs.order = append(fs.order, f)
+	}
+	return f
+}
+
+func (fs *families) gauge(name, help string) *family {
+	return fs.get(name, typeGauge, help)
+}
+
+// The samples of a counter are suffixed with _total.
+func (fs *families) counter(name, help string) *family {
+	return fs.get(name, typeCounter, help)
+}
+
+func (fs *families) write(buf *bytes.Buffer) {
+	for _, f := range fs.order {
+		buf.WriteString("# TYPE " + f.name + " " + f.typ + "\n")
+		buf.WriteString("# HELP " + f.name + " " + escapeHelp(f.help) + "\n")
+		name := f.name
+		if f.typ == typeCounter {
+			name += "_total"
+		}
+		for _, s := range f.samples {
+			buf.WriteString(name)
+			if s.labels != "" {
+				buf.WriteString("{" + s.labels + "}")
+			}
+			buf.WriteString(" " + formatValue(s.value) + "\n")
+		}
+	}
+	buf.WriteString("# EOF\n")
+}
+
+// Returns name="value" with value escaped.
+func label(name, value string) string {
+	value = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+	return name + `="` + value + `"`
+}
+
+func escapeHelp(help string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(help)
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func seconds(ds []time.Duration) []float64 {
+	s := make([]float64, len(ds))
+	for i, d := range ds {
+		s[i] = d.Seconds()
+	}
+	return s
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
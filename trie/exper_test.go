@@ -24,7 +24,6 @@ import (
 	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/rev3z/ledger_base/bench/exper"
 	"github.com/rev3z/ledger_base/leveldb"
-	"github.com/rev3z/ledger_base/leveldb/cache"
 	"github.com/rev3z/ledger_base/leveldb/ethdb"
 	"github.com/rev3z/ledger_base/leveldb/opt"
 	_ "github.com/rev3z/ledger_base/leveldb/opt"
@@ -275,6 +274,19 @@ var (
 	//root2 = []byte{240 ,215, 251, 217, 93, 11, 72, 181, 8, 163, 61 ,123, 113, 247, 127, 82, 177, 39, 173, 39, 27, 77, 242, 166, 83, 234, 9, 77, 143, 76, 232, 97, 250}
 )
 
+// blockCacheHitMiss returns the block cache hits and misses of db.
+func blockCacheHitMiss(db *ethdb.LDBDatabase) (hit, miss int64) {
+	var s leveldb.DBStats
+	db.LDB().Stats(&s)
+	return s.BlockCacheHits, s.BlockCacheMisses
+}
+
+// printBlockCacheHitRate prints the block cache hits, misses and hit rate of db.
+func printBlockCacheHitRate(db *ethdb.LDBDatabase) {
+	hit, miss := blockCacheHitMiss(db)
+	fmt.Println("命中率", hit, miss, float64(hit)/float64(hit+miss))
+}
+
 func TestMix(t *testing.T) {
 	//root:=[]byte{239, 104, 4, 219, 125, 134, 93, 238, 147, 175, 67, 122, 141, 12, 252, 148, 160, 72, 197, 46, 81, 57, 245, 6, 212, 190, 167, 146, 180, 95, 154, 228}
 	root := []byte{240, 114, 194, 194, 17, 14, 199, 231, 50, 103, 168, 144, 117, 47, 201, 67, 245, 137, 219, 7, 254, 234, 2, 157, 3, 151, 148, 51, 109, 16, 189, 157, 145}
@@ -362,9 +374,7 @@ func TestMix(t *testing.T) {
 	fmt.Println("nil计数", Count, Count2)
 	fmt.Println("kv数目,总时间，交易时间", ethdb.Count, ethdb.T, TimeTx, shijian)
 	fmt.Println("qps:", float64(1000000)/ethdb.T)
-	fmt.Println(cache.HitNumber, cache.MissNumber, float64(cache.HitNumber)/float64(cache.HitNumber+cache.MissNumber))
-	fmt.Println(cache.HitNumber2, cache.MissNumber2, float64(cache.HitNumber2)/float64(cache.HitNumber2+cache.MissNumber2))
-	fmt.Println("命中率", float64(cache.HitNumber+cache.HitNumber2)/float64(cache.HitNumber2+cache.MissNumber2+cache.HitNumber+cache.MissNumber))
+	printBlockCacheHitRate(db)
 	runtime.GC()
 }
 
@@ -418,8 +428,6 @@ func TestMixRandom(t *testing.T) {
 	//num := 1000000
 	var old_hit int64
 	var old_miss int64
-	var old_hit2 int64
-	var old_miss2 int64
 	init_time := time.Now()
 	for q := 0; q <= 10; q++ {
 		for i := 0; i < 100000; i++ {
@@ -444,15 +452,10 @@ func TestMixRandom(t *testing.T) {
 			//	log2.Println(i)
 			//}
 			if number%100000 == 0 {
-				hit := cache.HitNumber
-				miss := cache.MissNumber
-				hit2 := cache.HitNumber2
-				miss2 := cache.MissNumber2
-				log2.Println("The hit rate of", (number / 100000), "is:", float64(hit+hit2-old_hit-old_hit2)/float64(hit+miss+hit2+miss2-old_hit-old_miss-old_hit2-old_miss2))
+				hit, miss := blockCacheHitMiss(db)
+				log2.Println("The hit rate of", (number / 100000), "is:", float64(hit-old_hit)/float64(hit+miss-old_hit-old_miss))
 				old_miss = miss
 				old_hit = hit
-				old_miss2 = miss2
-				old_hit2 = hit2
 
 				ttt := time.Now()
 				tttt := ttt.Sub(init_time).Seconds()
@@ -468,9 +471,7 @@ func TestMixRandom(t *testing.T) {
 	fmt.Println(Count, Count2)
 	fmt.Println(ethdb.Count, ethdb.T, TimeTx, shijian)
 	fmt.Println("qps:", float64(9000000)/ethdb.T)
-	fmt.Println(cache.HitNumber, cache.MissNumber, float64(cache.HitNumber)/float64(cache.HitNumber+cache.MissNumber))
-	fmt.Println(cache.HitNumber2, cache.MissNumber2, float64(cache.HitNumber2)/float64(cache.HitNumber2+cache.MissNumber2))
-	fmt.Println("命中率", float64(cache.HitNumber+cache.HitNumber2)/float64(cache.HitNumber2+cache.MissNumber2+cache.HitNumber+cache.MissNumber))
+	printBlockCacheHitRate(db)
 	idle1, total1 := exper.GetCPUSample()
 	idleTicks := float64(idle1 - idle0)
 	totalTicks := float64(total1 - total0)
@@ -523,9 +524,7 @@ func TestAccZ(t *testing.T) {
 	fmt.Println("nil计数", Count, number)
 	fmt.Println("kv数目,总时间，交易时间", ethdb.Count, ethdb.T, TimeTx)
 	fmt.Println("qps:", float64(10000000)/ethdb.T)
-	fmt.Println(cache.HitNumber, cache.MissNumber, float64(cache.HitNumber)/float64(cache.HitNumber+cache.MissNumber))
-	fmt.Println(cache.HitNumber2, cache.MissNumber2, float64(cache.HitNumber2)/float64(cache.HitNumber2+cache.MissNumber2))
-	fmt.Println("命中率", float64(cache.HitNumber+cache.HitNumber2)/float64(cache.HitNumber2+cache.MissNumber2+cache.HitNumber+cache.MissNumber))
+	printBlockCacheHitRate(db)
 	runtime.GC()
 }
 
@@ -576,9 +575,7 @@ func TestAccR(t *testing.T) {
 	fmt.Println("nil计数", Count, number)
 	fmt.Println("kv数目,总时间，交易时间", ethdb.Count, ethdb.T, TimeTx, shijian)
 	fmt.Println("qps:", float64(num)/ethdb.T)
-	fmt.Println(cache.HitNumber, cache.MissNumber, float64(cache.HitNumber)/float64(cache.HitNumber+cache.MissNumber))
-	fmt.Println(cache.HitNumber2, cache.MissNumber2, float64(cache.HitNumber2)/float64(cache.HitNumber2+cache.MissNumber2))
-	fmt.Println("命中率", float64(cache.HitNumber+cache.HitNumber2)/float64(cache.HitNumber2+cache.MissNumber2+cache.HitNumber+cache.MissNumber))
+	printBlockCacheHitRate(db)
 	idle1, total1 := exper.GetCPUSample()
 	idleTicks := float64(idle1 - idle0)
 	totalTicks := float64(total1 - total0)
@@ -631,9 +628,7 @@ func TestTR(t *testing.T) {
 	fmt.Println(Count, Count2, Count_T)
 	fmt.Println(ethdb.Count, ethdb.T, TimeTx)
 	fmt.Println("qps:", float64(1000000)/ethdb.T)
-	fmt.Println(cache.HitNumber, cache.MissNumber, float64(cache.HitNumber)/float64(cache.HitNumber+cache.MissNumber))
-	fmt.Println(cache.HitNumber2, cache.MissNumber2, float64(cache.HitNumber2)/float64(cache.HitNumber2+cache.MissNumber2))
-	fmt.Println("命中率", float64(cache.HitNumber+cache.HitNumber2)/float64(cache.HitNumber2+cache.MissNumber2+cache.HitNumber+cache.MissNumber))
+	printBlockCacheHitRate(db)
 	idle1, total1 := exper.GetCPUSample()
 
 	idleTicks := float64(idle1 - idle0)
@@ -687,9 +682,7 @@ func TestTZ(t *testing.T) {
 	fmt.Println("nil计数", Count, number)
 	fmt.Println("kv数目,总时间，交易时间", ethdb.Count, ethdb.T, TimeTx)
 	fmt.Println("qps:", float64(10000000)/ethdb.T)
-	fmt.Println(cache.HitNumber, cache.MissNumber, float64(cache.HitNumber)/float64(cache.HitNumber+cache.MissNumber))
-	fmt.Println(cache.HitNumber2, cache.MissNumber2, float64(cache.HitNumber2)/float64(cache.HitNumber2+cache.MissNumber2))
-	fmt.Println("命中率", float64(cache.HitNumber+cache.HitNumber2)/float64(cache.HitNumber2+cache.MissNumber2+cache.HitNumber+cache.MissNumber))
+	printBlockCacheHitRate(db)
 	runtime.GC()
 }
 
@@ -823,9 +816,7 @@ func TestFile2(t *testing.T) {
 	fmt.Println("nil计数", index3)
 	fmt.Println("kv数目,总时间,", ethdb.Count, ethdb.T)
 	fmt.Println("qps:", float64(1000000)/ethdb.T)
-	fmt.Println(cache.HitNumber, cache.MissNumber, float64(cache.HitNumber)/float64(cache.HitNumber+cache.MissNumber))
-	fmt.Println(cache.HitNumber2, cache.MissNumber2, float64(cache.HitNumber2)/float64(cache.HitNumber2+cache.MissNumber2))
-	fmt.Println("命中率", float64(cache.HitNumber+cache.HitNumber2)/float64(cache.HitNumber2+cache.MissNumber2+cache.HitNumber+cache.MissNumber))
+	printBlockCacheHitRate(db)
 	idle1, total1 := exper.GetCPUSample()
 	idleTicks := float64(idle1 - idle0)
 	totalTicks := float64(total1 - total0)
@@ -934,9 +925,7 @@ func TestMixZipf(t *testing.T) {
 	fmt.Println("nil计数", Count, Count2, number)
 	fmt.Println("kv数目,总时间，交易时间", ethdb.Count, ethdb.T, TimeTx, shijian)
 	fmt.Println("qps:", float64(10000000)/ethdb.T)
-	fmt.Println(cache.HitNumber, cache.MissNumber, float64(cache.HitNumber)/float64(cache.HitNumber+cache.MissNumber))
-	fmt.Println(cache.HitNumber2, cache.MissNumber2, float64(cache.HitNumber2)/float64(cache.HitNumber2+cache.MissNumber2))
-	fmt.Println("命中率", float64(cache.HitNumber+cache.HitNumber2)/float64(cache.HitNumber2+cache.MissNumber2+cache.HitNumber+cache.MissNumber))
+	printBlockCacheHitRate(db)
 
 	idle1, total1 := exper.GetCPUSample()
 	idleTicks := float64(idle1 - idle0)
@@ -1045,9 +1034,7 @@ func TestMixZ3(t *testing.T) {
 	fmt.Println("nil计数", Count, Count2, number)
 	fmt.Println("kv数目,总时间，交易时间", ethdb.Count, ethdb.T, TimeTx, shijian)
 	fmt.Println("qps:", float64(10000000)/ethdb.T)
-	fmt.Println(cache.HitNumber, cache.MissNumber, float64(cache.HitNumber)/float64(cache.HitNumber+cache.MissNumber))
-	fmt.Println(cache.HitNumber2, cache.MissNumber2, float64(cache.HitNumber2)/float64(cache.HitNumber2+cache.MissNumber2))
-	fmt.Println("命中率", float64(cache.HitNumber+cache.HitNumber2)/float64(cache.HitNumber2+cache.MissNumber2+cache.HitNumber+cache.MissNumber))
+	printBlockCacheHitRate(db)
 	fmt.Println(count3, count7)
 	idle1, total1 := exper.GetCPUSample()
 	idleTicks := float64(idle1 - idle0)
@@ -1156,9 +1143,7 @@ func TestMixZ7(t *testing.T) {
 	fmt.Println("nil计数", Count, Count2, number)
 	fmt.Println("kv数目,总时间，交易时间", ethdb.Count, ethdb.T, TimeTx, shijian)
 	fmt.Println("qps:", float64(10000000)/ethdb.T)
-	fmt.Println(cache.HitNumber, cache.MissNumber, float64(cache.HitNumber)/float64(cache.HitNumber+cache.MissNumber))
-	fmt.Println(cache.HitNumber2, cache.MissNumber2, float64(cache.HitNumber2)/float64(cache.HitNumber2+cache.MissNumber2))
-	fmt.Println("命中率", float64(cache.HitNumber+cache.HitNumber2)/float64(cache.HitNumber2+cache.MissNumber2+cache.HitNumber+cache.MissNumber))
+	printBlockCacheHitRate(db)
 	fmt.Println(count3, count7)
 	idle1, total1 := exper.GetCPUSample()
 	idleTicks := float64(idle1 - idle0)
@@ -1240,8 +1225,6 @@ func TestCacheZipfian(t *testing.T) {
 	count7 := 0
 	var old_hit int64
 	var old_miss int64
-	var old_hit2 int64
-	var old_miss2 int64
 	var old_time float64
 	init_time := time.Now()
 	for q := 0; q <= 10; q++ {
@@ -1269,15 +1252,10 @@ func TestCacheZipfian(t *testing.T) {
 			//	log2.Println(i)
 			//}
 			if number%100000 == 0 {
-				hit := cache.HitNumber
-				miss := cache.MissNumber
-				hit2 := cache.HitNumber2
-				miss2 := cache.MissNumber2
-				log2.Println("The hit rate of", (number / 100000), "is:", float64(hit+hit2-old_hit-old_hit2)/float64(hit+miss+hit2+miss2-old_hit-old_miss-old_hit2-old_miss2))
+				hit, miss := blockCacheHitMiss(db)
+				log2.Println("The hit rate of", (number / 100000), "is:", float64(hit-old_hit)/float64(hit+miss-old_hit-old_miss))
 				old_miss = miss
 				old_hit = hit
-				old_miss2 = miss2
-				old_hit2 = hit2
 
 				ttt := time.Now()
 				tttt := ttt.Sub(init_time).Seconds()
@@ -1314,9 +1292,7 @@ func TestCacheZipfian(t *testing.T) {
 	fmt.Println("nil计数", Count, Count2, number)
 	fmt.Println("kv数目,总时间，交易时间", ethdb.Count, ethdb.T, TimeTx, shijian)
 	fmt.Println("qps:", float64(10000000)/ethdb.T)
-	fmt.Println(cache.HitNumber, cache.MissNumber, float64(cache.HitNumber)/float64(cache.HitNumber+cache.MissNumber))
-	fmt.Println(cache.HitNumber2, cache.MissNumber2, float64(cache.HitNumber2)/float64(cache.HitNumber2+cache.MissNumber2))
-	fmt.Println("命中率", float64(cache.HitNumber+cache.HitNumber2)/float64(cache.HitNumber2+cache.MissNumber2+cache.HitNumber+cache.MissNumber))
+	printBlockCacheHitRate(db)
 	fmt.Println(count3, count7)
 	idle1, total1 := exper.GetCPUSample()
 	idleTicks := float64(idle1 - idle0)
@@ -1398,9 +1374,7 @@ func TestMixR(t *testing.T) {
 	fmt.Println("nil计数", Count, Count2, number)
 	fmt.Println("kv数目,总时间，交易时间", ethdb.Count, ethdb.T, TimeTx, shijian)
 	fmt.Println("qps:", float64(10000000)/ethdb.T)
-	fmt.Println(cache.HitNumber, cache.MissNumber, float64(cache.HitNumber)/float64(cache.HitNumber+cache.MissNumber))
-	fmt.Println(cache.HitNumber2, cache.MissNumber2, float64(cache.HitNumber2)/float64(cache.HitNumber2+cache.MissNumber2))
-	fmt.Println("命中率", float64(cache.HitNumber+cache.HitNumber2)/float64(cache.HitNumber2+cache.MissNumber2+cache.HitNumber+cache.MissNumber))
+	printBlockCacheHitRate(db)
 	fmt.Println(countT, countA)
 	idle1, total1 := exper.GetCPUSample()
 	idleTicks := float64(idle1 - idle0)
@@ -1489,9 +1463,7 @@ func TestMixR3(t *testing.T) {
 	fmt.Println("nil计数", Count, Count2, number)
 	fmt.Println("kv数目,总时间，交易时间", ethdb.Count, ethdb.T, TimeTx, shijian)
 	fmt.Println("qps:", float64(10000000)/ethdb.T)
-	fmt.Println(cache.HitNumber, cache.MissNumber, float64(cache.HitNumber)/float64(cache.HitNumber+cache.MissNumber))
-	fmt.Println(cache.HitNumber2, cache.MissNumber2, float64(cache.HitNumber2)/float64(cache.HitNumber2+cache.MissNumber2))
-	fmt.Println("命中率", float64(cache.HitNumber+cache.HitNumber2)/float64(cache.HitNumber2+cache.MissNumber2+cache.HitNumber+cache.MissNumber))
+	printBlockCacheHitRate(db)
 	fmt.Println(countT, countA)
 	idle1, total1 := exper.GetCPUSample()
 	idleTicks := float64(idle1 - idle0)
@@ -1580,9 +1552,7 @@ func TestMixR7(t *testing.T) {
 	fmt.Println("nil计数", Count, Count2, number)
 	fmt.Println("kv数目,总时间，交易时间", ethdb.Count, ethdb.T, TimeTx, shijian)
 	fmt.Println("qps:", float64(10000000)/ethdb.T)
-	fmt.Println(cache.HitNumber, cache.MissNumber, float64(cache.HitNumber)/float64(cache.HitNumber+cache.MissNumber))
-	fmt.Println(cache.HitNumber2, cache.MissNumber2, float64(cache.HitNumber2)/float64(cache.HitNumber2+cache.MissNumber2))
-	fmt.Println("命中率", float64(cache.HitNumber+cache.HitNumber2)/float64(cache.HitNumber2+cache.MissNumber2+cache.HitNumber+cache.MissNumber))
+	printBlockCacheHitRate(db)
 	fmt.Println(countT, countA)
 	idle1, total1 := exper.GetCPUSample()
 	idleTicks := float64(idle1 - idle0)
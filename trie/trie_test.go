@@ -22,7 +22,6 @@ import (
 	"encoding/binary"
 	"encoding/hex"
 
-	"github.com/rev3z/ledger_base/leveldb/cache"
 	"github.com/rev3z/ledger_base/leveldb/ethdb"
 
 	//"github.com/rev3z/ledger_base/leveldb/cache"
@@ -311,9 +310,7 @@ func TestMyGet(t *testing.T) {
 	fmt.Println(index, ethdb.Count, ethdb.T)
 	fmt.Println("qps:", float64(1000000)/ethdb.T, float64(1000000)/shijian)
 	//fmt.Println(cache.Hit, cache.Miss,float64(cache.Hit)/float64(cache.Hit+cache.Miss))
-	fmt.Println(cache.HitNumber, cache.MissNumber, float64(cache.HitNumber)/float64(cache.HitNumber+cache.MissNumber))
-	fmt.Println(cache.HitNumber2, cache.MissNumber2, float64(cache.HitNumber2)/float64(cache.HitNumber2+cache.MissNumber2))
-	fmt.Println("命中率", float64(cache.HitNumber+cache.HitNumber2)/float64(cache.HitNumber2+cache.MissNumber2+cache.HitNumber+cache.MissNumber))
+	printBlockCacheHitRate(db)
 	runtime.GC()
 }
 